@@ -0,0 +1,69 @@
+package main
+
+import (
+	"log/slog"
+	"time"
+
+	"github.com/chamzzzzzz/github-repo-mirror/pkg/audit"
+	"github.com/chamzzzzzz/github-repo-mirror/pkg/report"
+	"github.com/chamzzzzzz/github-repo-mirror/pkg/state"
+)
+
+// runAdd mirrors the owner/name repos given as arguments right away,
+// without a full run, and records them in the state file. It takes only the
+// lock of each repo's mirror, not of the destination, so it works next to a
+// daemon or a run.
+func runAdd(args []string) int {
+	fs, g := newFlagSet("add")
+	source := fs.String("source", "", "name of the source to look up the repos with, by default the source of each repo's owner, else the first source")
+	config, mirrorer := setup(fs, g, args)
+	if fs.NArg() == 0 {
+		fatal("Usage: github-repo-mirror add [flags] owner/repo...")
+	}
+
+	store, err := state.Open(state.Path(config))
+	if err != nil {
+		fatal("Failed to open state", "error", err)
+	}
+	mirrorer.State = store
+	auditLog := openAudit(config)
+
+	start := time.Now()
+	code := ExitOK
+	var stats []*report.Stat
+	for _, name := range fs.Args() {
+		stat, err := mirrorer.MirrorRepo(name, *source)
+		if err != nil {
+			slog.Error("Failed to get repo", "repo", name, "error", err)
+			code = ExitError
+			continue
+		}
+		stats = append(stats, stat)
+		for _, result := range stat.Results {
+			switch {
+			case result.Outcome == report.OutcomeSkipped:
+				slog.Error("Skipped repo", "repo", result.Repo, "reason", result.Reason)
+				code = max(code, ExitPartial)
+			case result.Outcome.Failed():
+				code = max(code, ExitPartial)
+			default:
+				slog.Info("Added repo", "repo", result.Repo, "outcome", result.Outcome, "local", result.Local)
+			}
+		}
+	}
+	if len(stats) == 0 {
+		return code
+	}
+	err = store.RecordRun(start, stats, config.State)
+	if err == nil {
+		err = store.Save()
+	}
+	if err != nil {
+		slog.Error("Failed to record run", "error", err)
+	}
+	err = auditLog.Append(audit.Sync(stats)...)
+	if err != nil {
+		slog.Error("Failed to write audit log", "error", err)
+	}
+	return code
+}
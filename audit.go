@@ -0,0 +1,55 @@
+package main
+
+import (
+	"log/slog"
+	"time"
+
+	"github.com/chamzzzzzz/github-repo-mirror/pkg/audit"
+	"github.com/chamzzzzzz/github-repo-mirror/pkg/config"
+)
+
+// runAudit verifies the hash chain of the audit log and its rotated files.
+func runAudit(args []string) int {
+	fs, g := newFlagSet("audit")
+	config, _ := setup(fs, g, args)
+	if config.Audit.Path == "" {
+		fatal("No audit log configured")
+	}
+	n, err := audit.Verify(audit.Paths(&config.Audit)...)
+	if err != nil {
+		slog.Error("Audit log verification failed", "records", n, "error", err)
+		return ExitError
+	}
+	slog.Info("Audit log verified", "records", n)
+	return ExitOK
+}
+
+// openAudit opens the config's audit log, nil if it has none.
+func openAudit(config *config.Config) *audit.Log {
+	log, err := audit.Open(&config.Audit)
+	if err != nil {
+		fatal("Failed to open audit log", "error", err)
+	}
+	return log
+}
+
+// appendAudit records an operation on a repo that started at start and
+// failed with err, if not nil.
+func appendAudit(log *audit.Log, action, repo, local string, start time.Time, err error) {
+	record := &audit.Record{
+		Time:     start,
+		Action:   action,
+		Repo:     repo,
+		Local:    local,
+		Result:   "ok",
+		Duration: time.Since(start),
+	}
+	if err != nil {
+		record.Result = "failed"
+		record.Error = err.Error()
+	}
+	err = log.Append(record)
+	if err != nil {
+		slog.Error("Failed to write audit log", "error", err)
+	}
+}
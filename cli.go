@@ -0,0 +1,960 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"log/slog"
+	"net/http"
+	"os"
+	"os/signal"
+	"sync/atomic"
+	"syscall"
+	"text/tabwriter"
+	"time"
+
+	"github.com/chamzzzzzz/github-repo-mirror/pkg/audit"
+	"github.com/chamzzzzzz/github-repo-mirror/pkg/config"
+	"github.com/chamzzzzzz/github-repo-mirror/pkg/dashboard"
+	"github.com/chamzzzzzz/github-repo-mirror/pkg/gitmirror"
+	"github.com/chamzzzzzz/github-repo-mirror/pkg/notify"
+	"github.com/chamzzzzzz/github-repo-mirror/pkg/report"
+	"github.com/chamzzzzzz/github-repo-mirror/pkg/s3"
+	"github.com/chamzzzzzz/github-repo-mirror/pkg/serve"
+	"github.com/chamzzzzzz/github-repo-mirror/pkg/state"
+	"github.com/chamzzzzzz/github-repo-mirror/pkg/webhook"
+)
+
+type command struct {
+	name  string
+	usage string
+	run   func(args []string) int
+}
+
+var commands = []*command{
+	{"mirror", "mirror and update all repos (default)", runMirror},
+	{"list", "show which repos would be mirrored, updated or skipped, or export the inventory of local mirrors", runList},
+	{"explain", "show every filter rule evaluated for an owner/repo and whether it is mirrored", runExplain},
+	{"validate", "check the config, the source tokens and that the destinations are writable", runValidate},
+	{"status", "show the last sync time and recorded state of each local mirror", runStatus},
+	{"verify", "check the integrity of each local mirror", runVerify},
+	{"prune", "remove local mirrors whose repos no longer exist upstream", runPrune},
+	{"history", "show daily and weekly run rollups", runHistory},
+	{"maintain", "repack and write commit-graphs for mirrors with many loose objects or packs", runMaintain},
+	{"bundle", "export git bundles of all local mirrors into the bundle directory", runBundle},
+	{"decrypt", "decrypt an object uploaded with ObjectStorage.EncryptionKey from stdin to stdout", runDecrypt},
+	{"serve", "serve the mirrors read-only over git smart HTTP on config Serve.Address", runServe},
+	{"adopt", "take over existing bare repos under the destination as mirrors of discovered repos", runAdopt},
+	{"add", "mirror the given owner/repo repos now, without a full run", runAdd},
+	{"restore", "push local mirrors to a new origin for disaster recovery", runRestore},
+	{"audit", "check the hash chain of the audit log", runAudit},
+	{"login", "log in to GitHub in a browser and store the token for the sources without one", runLogin},
+	{"init", "create a config file interactively", runInit},
+	{"migrate", "upgrade the config file to the current format version, keeping a backup", runMigrate},
+	{"promote", "check a standby destination against a manifest and make it authoritative", runPromote},
+}
+
+func usage() {
+	fmt.Fprintf(os.Stderr, "usage: github-repo-mirror [command] [flags]\n\ncommands:\n")
+	for _, c := range commands {
+		fmt.Fprintf(os.Stderr, "  %-8s %s\n", c.name, c.usage)
+	}
+}
+
+type globalFlags struct {
+	config    *string
+	logFormat *string
+	logLevel  *string
+}
+
+func newFlagSet(name string) (*flag.FlagSet, *globalFlags) {
+	fs := flag.NewFlagSet(name, flag.ExitOnError)
+	g := &globalFlags{
+		config:    fs.String("config", "config.json", "config file path"),
+		logFormat: fs.String("log-format", "text", "log output format: text or json"),
+		logLevel:  fs.String("log-level", "info", "log level: debug, info, warn or error"),
+	}
+	fs.BoolVar(&assumeYes, "yes", false, "confirm destructive operations without prompting")
+	return fs, g
+}
+
+// setup parses args, configures logging and loads the config.
+func setup(fs *flag.FlagSet, g *globalFlags, args []string) (*config.Config, *gitmirror.Mirrorer) {
+	fs.Parse(args)
+	err := setupLogger(*g.logFormat, *g.logLevel)
+	if err != nil {
+		log.Fatal("Failed to setup logger: ", err)
+	}
+	config, err := config.Load(*g.config)
+	if err != nil {
+		fatal("Failed to load config", "error", err)
+	}
+	warnOutdated(config)
+	err = useStoredTokens(config)
+	if err != nil {
+		fatal("Failed to use stored tokens", "error", err)
+	}
+	config, _, err = gitmirror.ResolveTokens(config)
+	if err != nil {
+		fatal("Failed to resolve tokens", "error", err)
+	}
+	mirrorer, err := gitmirror.New(config)
+	if err != nil {
+		fatal("Failed to create mirrorer", "error", err)
+	}
+	return config, mirrorer
+}
+
+func runMirror(args []string) int {
+	fs, g := newFlagSet("mirror")
+	daemon := fs.Bool("daemon", false, "run continuously, mirroring every config Interval")
+	webhook := fs.Bool("webhook", false, "serve GitHub push webhooks on config Webhook.Address")
+	format := fs.String("report", "", "write a run report in the given format (json)")
+	reportFile := fs.String("report-file", "-", "run report destination file, - for stdout")
+	failOn := fs.String("fail-on", "source,failed,failed_mirror,failed_update", "comma separated outcomes that make the exit code nonzero, or none")
+	dryRun := fs.Bool("dry-run", false, "print what would be mirrored, updated, skipped or pruned without changing anything")
+	wait := fs.Bool("wait", false, "wait for another run holding the destination lock to finish instead of exiting")
+	progress := fs.Bool("progress", false, "show git transfer progress, as a status line with a repo counter in a terminal and as log lines otherwise; logged at debug level without this flag")
+	cached := fs.Bool("cached", false, "use the last cached repo list of sources that cannot be listed, e.g. while the API is down")
+	offline := fs.Bool("offline", false, "use only the cached repo lists and make no API requests, updating the mirrors git can still fetch")
+	onlySource := fs.String("only-source", "", "list and sync only the source with this name, e.g. to retry one whose discovery failed")
+	config, mirrorer := setup(fs, g, args)
+	mirrorer.DryRun = *dryRun
+	if *onlySource != "" {
+		if *daemon || *webhook {
+			fatal("Failed to restrict run to one source", "error", "-only-source applies to single runs only")
+		}
+		err := mirrorer.SetOnlySource(*onlySource)
+		if err != nil {
+			fatal("Failed to restrict run to one source", "error", err)
+		}
+	}
+	if *offline {
+		mirrorer.SetOffline()
+	} else if *cached {
+		mirrorer.Discovery = gitmirror.DiscoveryCached
+	}
+	if *progress {
+		mirrorer.SetProgress(gitmirror.NewProgress(true, slog.LevelInfo))
+	} else if slog.Default().Enabled(context.Background(), slog.LevelDebug) {
+		mirrorer.SetProgress(gitmirror.NewProgress(false, slog.LevelDebug))
+	}
+
+	unlock := func() {}
+	if !*dryRun {
+		var err error
+		unlock, err = mirrorer.Lock(*wait)
+		if errors.Is(err, gitmirror.ErrLocked) {
+			slog.Warn("Skipped run", "reason", err)
+			return ExitError
+		}
+		if err != nil {
+			fatal("Failed to lock destination", "error", err)
+		}
+		defer func() { unlock() }()
+	}
+
+	// The API may be down in the cached and offline modes.
+	if !config.SkipPreflight && mirrorer.Discovery == gitmirror.DiscoveryLive && !checkTokens(config, mirrorer) {
+		fatal("Failed token preflight, see the errors above or set SkipPreflight")
+	}
+
+	store, err := state.Open(state.Path(config))
+	if err != nil {
+		fatal("Failed to open state", "error", err)
+	}
+	mirrorer.State = store
+	r := &runner{
+		config:     config,
+		mirrorer:   mirrorer,
+		store:      store,
+		format:     *format,
+		reportFile: *reportFile,
+	}
+	err = r.setConfig(config, mirrorer)
+	if err != nil {
+		fatal("Failed to apply config", "error", err)
+	}
+	// The servers start once the mirrorer records to the state, and follow
+	// the runner to the mirrorers of reloaded configs.
+	if *webhook {
+		if !*daemon {
+			serveWebhook(config, r.current.Load)
+			return ExitOK
+		}
+		go serveWebhook(config, r.current.Load)
+	}
+	if *daemon && config.Serve.Address != "" {
+		go serveGit(config, r.current.Load)
+	}
+	if !*daemon {
+		stats, err := r.run()
+		if err != nil {
+			fatal("Failed to run", "error", err)
+		}
+		return exitCode(stats, *failOn)
+	}
+
+	intervals, err := parseIntervals(config)
+	if err != nil {
+		fatal("Failed to parse intervals", "error", err)
+	}
+	r.health = dashboard.NewHealth(intervals.run)
+	if config.Dashboard.Address != "" {
+		go serveDashboard(config, store, r.health)
+	}
+	go sdWatchdog(r.health)
+	sdNotify("READY=1")
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	var lastMaintenance, lastVerify time.Time
+	for {
+		stats, err := r.run()
+		if err != nil {
+			slog.Error("Failed to run", "error", err)
+		}
+		r.health.Record(stats, err)
+		sdNotify(healthText(r.health.Status()))
+		if intervals.maintenance > 0 && time.Since(lastMaintenance) >= intervals.maintenance {
+			maintained, failed, err := r.mirrorer.MaintainAll()
+			if err != nil {
+				slog.Error("Failed to maintain", "error", err)
+			} else {
+				slog.Info("Maintenance stats", "maintained", maintained, "failed", failed)
+			}
+			lastMaintenance = time.Now()
+		}
+		if intervals.verify > 0 && time.Since(lastVerify) >= intervals.verify {
+			// Nobody is asked to confirm in daemon mode, so recloning also
+			// needs AllowDestructive.
+			reclone := r.config.Verify.Reclone && r.config.AllowDestructive
+			corrupted, recloned, err := r.mirrorer.VerifyAll(reclone)
+			if err != nil {
+				slog.Error("Failed to verify", "error", err)
+			} else {
+				slog.Info("Verify stats", "corrupted", len(corrupted), "recloned", recloned)
+				if len(corrupted) > 0 && !reclone {
+					slog.Warn("Found corrupted mirrors, not recloning them without Verify.Reclone and AllowDestructive", "mirrors", corrupted)
+				}
+			}
+			lastVerify = time.Now()
+		}
+		slog.Info("Next run scheduled", "interval", intervals.run)
+		select {
+		case <-time.After(intervals.run):
+			err = r.rotateTokens(&unlock)
+			if err != nil {
+				slog.Error("Failed to resolve tokens, keeping the previous ones", "error", err)
+			}
+		case <-hup:
+			reloaded, err := r.reload(*g.config, &unlock)
+			if err != nil {
+				slog.Error("Failed to reload config, keeping the previous one", "error", err)
+				continue
+			}
+			intervals = reloaded
+			r.health.SetInterval(intervals.run)
+			slog.Info("Reloaded config")
+		}
+	}
+}
+
+type intervals struct {
+	run, maintenance, verify time.Duration
+}
+
+// parseIntervals parses the daemon's run, maintenance and verify intervals.
+func parseIntervals(config *config.Config) (*intervals, error) {
+	i := &intervals{run: time.Hour}
+	var err error
+	if config.Interval != "" {
+		i.run, err = time.ParseDuration(config.Interval)
+		if err != nil {
+			return nil, fmt.Errorf("interval: %w", err)
+		}
+	}
+	if config.Maintenance.Interval != "" {
+		i.maintenance, err = time.ParseDuration(config.Maintenance.Interval)
+		if err != nil {
+			return nil, fmt.Errorf("maintenance interval: %w", err)
+		}
+	}
+	if config.Verify.Interval != "" {
+		i.verify, err = time.ParseDuration(config.Verify.Interval)
+		if err != nil {
+			return nil, fmt.Errorf("verify interval: %w", err)
+		}
+	}
+	return i, nil
+}
+
+type runner struct {
+	config     *config.Config
+	mirrorer   *gitmirror.Mirrorer
+	notifier   *notify.Notifier
+	store      *state.Store
+	format     string
+	reportFile string
+	// maxStaleness is the config's parsed MaxStaleness.
+	maxStaleness time.Duration
+	// health tracks the run loop in daemon mode.
+	health *dashboard.Health
+	audit  *audit.Log
+	// current holds mirrorer for the webhook and git servers, which read
+	// it while the run loop switches configs.
+	current atomic.Pointer[gitmirror.Mirrorer]
+}
+
+// reload loads the config at path and switches the runner to it, moving
+// the destination locks held by *unlock to the new destinations. The
+// webhook and git servers switch to the new mirrorer, but they, the
+// dashboard server and the state store keep the settings they started
+// with.
+func (r *runner) reload(path string, unlock *func()) (*intervals, error) {
+	config, err := config.Load(path)
+	if err != nil {
+		return nil, err
+	}
+	warnOutdated(config)
+	intervals, err := parseIntervals(config)
+	if err != nil {
+		return nil, err
+	}
+	err = useStoredTokens(config)
+	if err != nil {
+		return nil, err
+	}
+	config, _, err = gitmirror.ResolveTokens(config)
+	if err != nil {
+		return nil, err
+	}
+	err = r.switchConfig(config, unlock)
+	if err != nil {
+		return nil, err
+	}
+	return intervals, nil
+}
+
+// rotateTokens resolves the tokens of the config again and, if any changed,
+// switches the runner to a copy of the config with the new tokens. The
+// config in use is never written, since the servers may still read it.
+func (r *runner) rotateTokens(unlock *func()) error {
+	config, changed, err := gitmirror.ResolveTokens(r.config)
+	if changed {
+		err = errors.Join(err, r.switchConfig(config, unlock))
+	}
+	return err
+}
+
+// switchConfig switches the runner to a new mirrorer of config, moving the
+// destination locks held by *unlock to the new destinations.
+func (r *runner) switchConfig(config *config.Config, unlock *func()) error {
+	mirrorer, err := gitmirror.New(config)
+	if err != nil {
+		return err
+	}
+	// The requests spent in the current hour still count.
+	budget := r.mirrorer.Client.Budget
+	if budget != nil && mirrorer.Client.Budget != nil {
+		mirrorer.Client.Budget = budget
+	}
+	mirrorer.State = r.store
+	mirrorer.DryRun = r.mirrorer.DryRun
+	if r.mirrorer.Discovery == gitmirror.DiscoveryOffline {
+		mirrorer.SetOffline()
+	} else {
+		mirrorer.Discovery = r.mirrorer.Discovery
+	}
+	if r.mirrorer.Progress != nil {
+		mirrorer.SetProgress(r.mirrorer.Progress)
+	}
+	if !mirrorer.DryRun {
+		(*unlock)()
+		next, err := mirrorer.Lock(false)
+		if err != nil {
+			mirrorer.Close()
+			previous, relockErr := r.mirrorer.Lock(false)
+			if relockErr != nil {
+				fatal("Failed to lock destination", "error", relockErr)
+			}
+			*unlock = previous
+			return err
+		}
+		*unlock = next
+	}
+	previous := r.mirrorer
+	err = r.setConfig(config, mirrorer)
+	if err != nil {
+		mirrorer.Close()
+		return err
+	}
+	if budget != nil && mirrorer.Client.Budget == budget {
+		budget.SetLimit(config.Backfill.APIBudgetPerHour)
+	}
+	// The servers may still be updating with the previous mirrorer.
+	err = previous.Close()
+	if err != nil {
+		slog.Warn("Failed to close previous mirrorer", "error", err)
+	}
+	return nil
+}
+
+// setConfig switches the runner to config and its mirrorer.
+func (r *runner) setConfig(config *config.Config, mirrorer *gitmirror.Mirrorer) error {
+	maxStaleness, err := parseMaxStaleness(config)
+	if err != nil {
+		return err
+	}
+	auditLog, err := audit.Open(&config.Audit)
+	if err != nil {
+		return err
+	}
+	r.config = config
+	r.mirrorer = mirrorer
+	r.current.Store(mirrorer)
+	r.maxStaleness = maxStaleness
+	r.audit = auditLog
+	r.notifier = notify.New(&config.Notifications, r.store)
+	r.notifier.MaxStaleness = maxStaleness
+	return nil
+}
+
+// parseMaxStaleness parses the config's MaxStaleness, zero if unset.
+func parseMaxStaleness(config *config.Config) (time.Duration, error) {
+	if config.MaxStaleness == "" {
+		return 0, nil
+	}
+	return time.ParseDuration(config.MaxStaleness)
+}
+
+// run mirrors once, then records the run, writes the report and notifies.
+func (r *runner) run() ([]*report.Stat, error) {
+	start := time.Now()
+	stats, err := r.mirrorer.Run()
+	if err != nil {
+		return nil, err
+	}
+	if r.mirrorer.DryRun {
+		prune, err := r.mirrorer.PruneCandidates(stats)
+		if err != nil {
+			slog.Warn("Skipped prune candidates", "error", err)
+		}
+		printPlan(stats, prune)
+		return stats, report.Write(r.format, r.reportFile, stats, nil)
+	}
+	diff := r.store.Diff(stats)
+	logDiff(diff)
+	err = r.store.RecordRun(start, stats, r.config.State)
+	if err == nil {
+		err = r.store.Save()
+	}
+	if err != nil {
+		slog.Error("Failed to record run", "error", err)
+	}
+	err = report.WriteFailures(r.mirrorer.FailuresPath(), stats, func(repo string) int {
+		if state, ok := r.store.Repo(repo); ok {
+			return state.ConsecutiveFailures
+		}
+		return 0
+	})
+	if err != nil {
+		slog.Error("Failed to write failures", "error", err)
+	}
+	err = report.ExportMetrics(&r.config.Metrics, start, stats)
+	if err != nil {
+		slog.Error("Failed to export metrics", "error", err)
+	}
+	err = r.mirrorer.WriteIndex(r.store.Repos())
+	if err != nil {
+		slog.Error("Failed to write index", "error", err)
+	}
+	err = r.mirrorer.ExportProfiles(stats)
+	if err != nil {
+		slog.Error("Failed to export profiles", "error", err)
+	}
+	err = r.audit.Append(audit.Sync(stats)...)
+	if err != nil {
+		slog.Error("Failed to write audit log", "error", err)
+	}
+	if r.maxStaleness > 0 {
+		r.store.MarkStale(stats, r.maxStaleness, time.Now())
+		var stale []string
+		for _, stat := range stats {
+			stale = append(stale, stat.Stale...)
+		}
+		if len(stale) > 0 {
+			slog.Warn("Stale repos", "count", len(stale), "max_staleness", r.maxStaleness, "repos", stale)
+		}
+	}
+	err = report.Write(r.format, r.reportFile, stats, diff)
+	if err != nil {
+		return stats, fmt.Errorf("write report: %w", err)
+	}
+	r.notifier.Notify(stats)
+	if r.config.Notifications.Summary != nil {
+		prune, err := r.mirrorer.PruneCandidates(stats)
+		if err != nil {
+			slog.Warn("Skipped prune candidates", "error", err)
+		}
+		err = r.notifier.Summarize(start, stats, prune)
+		if err != nil {
+			slog.Error("Failed to send summary", "error", err)
+		}
+	}
+	return stats, nil
+}
+
+// logDiff logs what changed since the previous run.
+func logDiff(diff *report.Diff) {
+	if diff.Empty() {
+		slog.Info("No changes since last run")
+		return
+	}
+	slog.Info("Changes since last run", "new", len(diff.New), "gone", len(diff.Gone), "failing", len(diff.Failing), "recovered", len(diff.Recovered), "size_delta", diff.SizeDelta)
+	if len(diff.New) > 0 {
+		slog.Info("New repos", "repos", diff.New)
+	}
+	if len(diff.Gone) > 0 {
+		slog.Warn("Repos gone upstream", "repos", diff.Gone)
+	}
+	if len(diff.Failing) > 0 {
+		slog.Warn("Repos started failing", "repos", diff.Failing)
+	}
+	if len(diff.Recovered) > 0 {
+		slog.Info("Repos recovered", "repos", diff.Recovered)
+	}
+}
+
+func runList(args []string) int {
+	fs, g := newFlagSet("list")
+	format := fs.String("format", "text", "output format: text for the plan of the next run, or csv, tsv or json for the inventory of local mirrors")
+	config, mirrorer := setup(fs, g, args)
+
+	if *format != "text" {
+		entries, err := inventory(config, mirrorer)
+		if err != nil {
+			fatal("Failed to list mirrors", "error", err)
+		}
+		err = writeInventory(os.Stdout, *format, entries)
+		if err != nil {
+			fatal("Failed to write inventory", "error", err)
+		}
+		return ExitOK
+	}
+	mirrorer.DryRun = true
+	stats, err := mirrorer.Run()
+	if err != nil {
+		fatal("Failed to run", "error", err)
+	}
+	printPlan(stats, nil)
+	for _, stat := range stats {
+		if stat.Error != "" {
+			return ExitError
+		}
+	}
+	return ExitOK
+}
+
+// printPlan prints the outcome of a dry run for every repo, followed by the
+// mirrors that would be pruned.
+func printPlan(stats []*report.Stat, prune []string) {
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "SOURCE\tREPO\tACTION\tREASON\tLOCAL")
+	for _, stat := range stats {
+		for _, result := range stat.Results {
+			action := "update"
+			switch result.Outcome {
+			case report.OutcomeSkipped:
+				action = "skip"
+			case report.OutcomeMirrored:
+				action = "mirror"
+			case report.OutcomeDeferred:
+				action = "defer"
+			case report.OutcomeUnchanged:
+				action = "unchanged"
+			case report.OutcomeQuarantined:
+				action = "quarantine"
+			case report.OutcomeFailed:
+				action = "error"
+				result.Reason = result.Error
+			}
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", stat.Name, result.Repo, action, result.Reason, result.Local)
+		}
+	}
+	for _, local := range prune {
+		fmt.Fprintf(w, "\t\tprune\tnot found upstream\t%s\n", local)
+	}
+	w.Flush()
+}
+
+func runStatus(args []string) int {
+	fs, g := newFlagSet("status")
+	config, mirrorer := setup(fs, g, args)
+
+	locals, err := mirrorer.LocalMirrors()
+	if err != nil {
+		fatal("Failed to scan destination", "error", err)
+	}
+	store, err := state.Open(state.Path(config))
+	if err != nil {
+		fatal("Failed to open state", "error", err)
+	}
+	repos := make(map[string]*state.Repo)
+	for _, repo := range store.Repos() {
+		repos[repo.Local] = repo
+	}
+	formatTime := func(t time.Time) string {
+		if t.IsZero() {
+			return "never"
+		}
+		return t.Format(time.RFC3339)
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "LOCAL\tLAST SYNC\tOUTCOME\tLAST SUCCESS\tFAILURES\tBYTES\tDURATION\tLAST ERROR")
+	// Repos that failed before their first clone have no local mirror yet.
+	for _, repo := range store.Repos() {
+		if _, err := os.Stat(repo.Local); os.IsNotExist(err) {
+			locals = append(locals, repo.Local)
+		}
+	}
+	for _, local := range locals {
+		t, _ := gitmirror.LastFetch(local)
+		repo, ok := repos[local]
+		if !ok {
+			fmt.Fprintf(w, "%s\t%s\t-\t-\t-\t-\t-\t\n", local, formatTime(t))
+			continue
+		}
+		lastError := ""
+		if repo.ConsecutiveFailures > 0 {
+			lastError = repo.LastError
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%d\t%d\t%s\t%s\n", local, formatTime(t), repo.Outcome, formatTime(repo.LastSuccess), repo.ConsecutiveFailures, repo.Bytes, repo.Duration.Round(time.Millisecond), lastError)
+	}
+	w.Flush()
+	return ExitOK
+}
+
+func runVerify(args []string) int {
+	fs, g := newFlagSet("verify")
+	strict := fs.Bool("strict", false, "run git fsck with --strict")
+	connectivityOnly := fs.Bool("connectivity-only", false, "only check that all objects are reachable")
+	reclone := fs.Bool("reclone", false, "quarantine corrupted mirrors and clone them again")
+	config, mirrorer := setup(fs, g, args)
+	config.Verify.Strict = config.Verify.Strict || *strict
+	config.Verify.ConnectivityOnly = config.Verify.ConnectivityOnly || *connectivityOnly
+
+	corrupted, _, err := mirrorer.VerifyAll(false)
+	if err != nil {
+		fatal("Failed to scan destination", "error", err)
+	}
+	slog.Info("Verify stats", "corrupted", len(corrupted))
+	if len(corrupted) == 0 {
+		return ExitOK
+	}
+	if !*reclone || !confirmDestructive(config, "reclone", fmt.Sprintf("remove and clone again %d mirrors", len(corrupted)), corrupted) {
+		return ExitPartial
+	}
+	auditLog := openAudit(config)
+	code := ExitOK
+	for _, local := range corrupted {
+		logger := slog.With("local", local, "operation", "reclone")
+		start := time.Now()
+		err := mirrorer.Reclone(local, logger)
+		if err != nil {
+			logger.Error("Failed reclone", "error", err)
+			code = ExitPartial
+		}
+		appendAudit(auditLog, "reclone", mirrorer.RepoName(local), local, start, err)
+	}
+	return code
+}
+
+func runAdopt(args []string) int {
+	fs, g := newFlagSet("adopt")
+	dryRun := fs.Bool("dry-run", false, "only list the bare repos that would be adopted or moved")
+	config, mirrorer := setup(fs, g, args)
+
+	stats := mirrorer.Discover()
+	for _, stat := range stats {
+		if stat.Error != "" {
+			slog.Warn("Source could not be listed, its bare repos are not adopted", "source", stat.Name)
+		}
+	}
+	adoptions, unknown, err := mirrorer.AdoptCandidates(stats)
+	if err != nil {
+		fatal("Failed to scan destination", "error", err)
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "REPO\tACTION\tLOCAL\tTARGET")
+	for _, a := range adoptions {
+		action := "adopt"
+		if a.Local != a.Target {
+			action = "move"
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", a.Repo.FullName, action, a.Local, a.Target)
+	}
+	for _, local := range unknown {
+		fmt.Fprintf(w, "\tunknown\t%s\t\n", local)
+	}
+	w.Flush()
+	if *dryRun {
+		return ExitOK
+	}
+
+	store, err := state.Open(state.Path(config))
+	if err != nil {
+		fatal("Failed to open state", "error", err)
+	}
+	auditLog := openAudit(config)
+	code := ExitOK
+	for _, a := range adoptions {
+		logger := slog.With("repo", a.Repo.FullName, "operation", "adopt", "local", a.Local)
+		start := time.Now()
+		err := mirrorer.Adopt(a, logger)
+		appendAudit(auditLog, "adopt", a.Repo.FullName, a.Target, start, err)
+		if err != nil {
+			logger.Error("Failed adopt", "error", err)
+			code = ExitPartial
+			continue
+		}
+		size, _ := gitmirror.Size(a.Target)
+		store.Adopt(a.Repo.FullName, a.Source.Label(), a.Target, size)
+		logger.Info("Successfully adopt", "target", a.Target)
+	}
+	err = store.Save()
+	if err != nil {
+		fatal("Failed to save state", "error", err)
+	}
+	return code
+}
+
+func runPrune(args []string) int {
+	fs, g := newFlagSet("prune")
+	dryRun := fs.Bool("dry-run", false, "only list the mirrors that would be pruned")
+	config, mirrorer := setup(fs, g, args)
+
+	prune, err := mirrorer.PruneCandidates(mirrorer.Discover())
+	if err != nil {
+		fatal("Failed to find prune candidates", "error", err)
+	}
+	if *dryRun {
+		printPlan(nil, prune)
+		return ExitOK
+	}
+	if !confirmDestructive(config, "prune", fmt.Sprintf("remove %d mirrors", len(prune)), prune) {
+		return ExitError
+	}
+	auditLog := openAudit(config)
+	code := ExitOK
+	for _, local := range prune {
+		// A run or add may be syncing the mirror.
+		unlock, owner, err := mirrorer.TryLockRepo(local)
+		if err != nil {
+			slog.Error("Failed prune", "local", local, "error", err)
+			code = ExitPartial
+			continue
+		}
+		if unlock == nil {
+			slog.Warn("Skipped prune of mirror being synced", "local", local, "owner", owner)
+			code = ExitPartial
+			continue
+		}
+		start := time.Now()
+		name := mirrorer.RepoName(local)
+		var bundle string
+		if config.PruneArchive != "" {
+			bundle, err = mirrorer.ArchivePruned(local)
+			if err != nil {
+				err = fmt.Errorf("archive: %w", err)
+			}
+		}
+		if err == nil {
+			err = gitmirror.Remove(local)
+		}
+		unlock()
+		appendAudit(auditLog, "prune", name, local, start, err)
+		if err != nil {
+			slog.Error("Failed prune", "local", local, "error", err)
+			code = ExitPartial
+			continue
+		}
+		slog.Info("Successfully prune", "local", local, "archive", bundle)
+	}
+	return code
+}
+
+func runHistory(args []string) int {
+	fs, g := newFlagSet("history")
+	weekly := fs.Bool("weekly", false, "show weekly instead of daily rollups")
+	config, _ := setup(fs, g, args)
+
+	store, err := state.Open(state.Path(config))
+	if err != nil {
+		fatal("Failed to open state", "error", err)
+	}
+	rollups := store.Daily()
+	if *weekly {
+		rollups = store.Weekly()
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "PERIOD\tRUNS\tDURATION\tMIRRORED\tUPDATED\tSKIPPED\tFAILED\tBYTES")
+	for _, r := range rollups {
+		failed := r.Failed + r.FailedMirror + r.FailedUpdate + r.FailedSource
+		fmt.Fprintf(w, "%s\t%d\t%s\t%d\t%d\t%d\t%d\t%d\n", r.Period, r.Runs, r.Duration.Round(time.Second), r.Mirrored, r.Updated, r.Skipped, failed, r.Bytes)
+	}
+	w.Flush()
+	return ExitOK
+}
+
+func runBundle(args []string) int {
+	fs, g := newFlagSet("bundle")
+	config, mirrorer := setup(fs, g, args)
+	if config.Bundles.Directory == "" {
+		fatal("Bundles.Directory is not configured")
+	}
+
+	created, failed, err := mirrorer.BundleAll()
+	if err != nil {
+		fatal("Failed to scan destination", "error", err)
+	}
+	slog.Info("Bundle stats", "created", created, "failed", failed)
+	if failed > 0 {
+		return ExitPartial
+	}
+	return ExitOK
+}
+
+func runDecrypt(args []string) int {
+	fs, g := newFlagSet("decrypt")
+	config, _ := setup(fs, g, args)
+	if config.ObjectStorage == nil || config.ObjectStorage.EncryptionKey == "" {
+		fatal("ObjectStorage.EncryptionKey is not configured")
+	}
+
+	key, err := s3.ParseKey(config.ObjectStorage.EncryptionKey)
+	if err != nil {
+		fatal("Failed to parse encryption key", "error", err)
+	}
+	err = s3.Decrypt(os.Stdout, os.Stdin, key)
+	if err != nil {
+		fatal("Failed to decrypt", "error", err)
+	}
+	return ExitOK
+}
+
+func runMaintain(args []string) int {
+	fs, g := newFlagSet("maintain")
+	_, mirrorer := setup(fs, g, args)
+
+	maintained, failed, err := mirrorer.MaintainAll()
+	if err != nil {
+		fatal("Failed to scan destination", "error", err)
+	}
+	slog.Info("Maintenance stats", "maintained", maintained, "failed", failed)
+	if failed > 0 {
+		return ExitPartial
+	}
+	return ExitOK
+}
+
+func runPromote(args []string) int {
+	fs, g := newFlagSet("promote")
+	manifestPath := fs.String("manifest", "", "manifest to check against, default the destination's own")
+	force := fs.Bool("force", false, "promote even if mirrors are missing or stale")
+	_, mirrorer := setup(fs, g, args)
+
+	if *manifestPath == "" {
+		*manifestPath = mirrorer.ManifestPath()
+	}
+	manifest, err := gitmirror.ReadManifest(*manifestPath)
+	if err != nil {
+		fatal("Failed to read manifest", "error", err)
+	}
+	problems := mirrorer.CheckManifest(manifest)
+	for _, problem := range problems {
+		fmt.Fprintln(os.Stderr, problem)
+	}
+	slog.Info("Checked manifest", "manifest", *manifestPath, "repos", len(manifest.Repos), "problems", len(problems))
+	if len(problems) > 0 && !*force {
+		slog.Error("Refusing to promote incomplete destination, use -force to override")
+		return ExitPartial
+	}
+	err = mirrorer.Promote(*manifestPath)
+	if err != nil {
+		fatal("Failed to promote", "error", err)
+	}
+	slog.Info("Successfully promote", "destination", mirrorer.Config.Destination)
+	return ExitOK
+}
+
+func runServe(args []string) int {
+	fs, g := newFlagSet("serve")
+	config, mirrorer := setup(fs, g, args)
+
+	serveGit(config, func() *gitmirror.Mirrorer { return mirrorer })
+	return ExitOK
+}
+
+// serveGit serves the mirrors of the mirrorer that mirrorer returns.
+func serveGit(config *config.Config, mirrorer func() *gitmirror.Mirrorer) {
+	if config.Serve.Address == "" {
+		fatal("Serve address is not configured")
+	}
+	ttl := 5 * time.Minute
+	if config.Serve.TTL != "" {
+		var err error
+		ttl, err = time.ParseDuration(config.Serve.TTL)
+		if err != nil {
+			fatal("Failed to parse serve TTL", "error", err)
+		}
+	}
+	handler := &serve.Handler{
+		Mirrorer: mirrorer,
+		Username: config.Serve.Username,
+		Password: config.Serve.Password,
+		Proxy:    config.Serve.Proxy,
+		TTL:      ttl,
+		GitPath:  config.GitPath,
+		GitArgs:  gitmirror.GitGlobalArgs(config),
+	}
+	slog.Info("Serving mirrors", "address", config.Serve.Address)
+	err := http.ListenAndServe(config.Serve.Address, handler)
+	if err != nil {
+		fatal("Failed to serve mirrors", "error", err)
+	}
+}
+
+func serveDashboard(config *config.Config, store *state.Store, health *dashboard.Health) {
+	maxStaleness, err := parseMaxStaleness(config)
+	if err != nil {
+		fatal("Failed to parse max staleness", "error", err)
+	}
+	handler := &dashboard.Handler{
+		Store:        store,
+		Health:       health,
+		MaxStaleness: maxStaleness,
+	}
+	slog.Info("Serving dashboard", "address", config.Dashboard.Address)
+	err = http.ListenAndServe(config.Dashboard.Address, handler)
+	if err != nil {
+		fatal("Failed to serve dashboard", "error", err)
+	}
+}
+
+// serveWebhook updates mirrors with the mirrorer that mirrorer returns.
+func serveWebhook(config *config.Config, mirrorer func() *gitmirror.Mirrorer) {
+	if config.Webhook.Address == "" {
+		fatal("Webhook address is not configured")
+	}
+	handler := &webhook.Handler{
+		Secret:   config.Webhook.Secret,
+		Mirrorer: mirrorer,
+	}
+	slog.Info("Serving webhooks", "address", config.Webhook.Address)
+	err := http.ListenAndServe(config.Webhook.Address, handler)
+	if err != nil {
+		fatal("Failed to serve webhooks", "error", err)
+	}
+}
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"log/slog"
+	"os"
+	"strings"
+
+	"github.com/chamzzzzzz/github-repo-mirror/pkg/config"
+)
+
+// assumeYes is set by the -yes flag.
+var assumeYes bool
+
+// confirmDestructive lists the targets an operation would destroy, e.g. the
+// mirrors it would remove, and reports whether the operation may proceed.
+// action says what it does to them, e.g. "remove 3 mirrors". It proceeds
+// when the config sets AllowDestructive or -yes was given, and otherwise
+// asks for confirmation if stdin is a terminal. Non-interactive runs without
+// either are refused.
+func confirmDestructive(config *config.Config, operation, action string, targets []string) bool {
+	if len(targets) == 0 {
+		return true
+	}
+	fmt.Fprintf(os.Stderr, "%s will %s:\n", operation, action)
+	for _, target := range targets {
+		fmt.Fprintf(os.Stderr, "  %s\n", target)
+	}
+	if config.AllowDestructive || assumeYes {
+		return true
+	}
+	fi, err := os.Stdin.Stat()
+	if err != nil || fi.Mode()&os.ModeCharDevice == 0 {
+		slog.Warn("Refusing destructive operation without confirmation, use -yes or AllowDestructive", "operation", operation, "targets", len(targets))
+		return false
+	}
+	fmt.Fprintf(os.Stderr, "Type 'yes' to continue: ")
+	answer, _ := bufio.NewReader(os.Stdin).ReadString('\n')
+	return strings.TrimSpace(answer) == "yes"
+}
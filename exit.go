@@ -0,0 +1,45 @@
+package main
+
+import (
+	"strings"
+
+	"github.com/chamzzzzzz/github-repo-mirror/pkg/report"
+)
+
+const (
+	// ExitOK means every repo was mirrored, updated or skipped.
+	ExitOK = 0
+	// ExitPartial means some repos failed to mirror or update, or with
+	// -fail-on cached, a source was listed from the discovery cache.
+	ExitPartial = 1
+	// ExitError means the config was invalid or a source could not be listed.
+	ExitError = 2
+)
+
+// exitCode maps run stats to an exit code. failOn is a comma separated list
+// of outcomes that count as failures, e.g. "archived" to also fail when a
+// repo could only be captured as a tarball; "source" stands for a source
+// whose repos could not be listed, "cached" for one listed from the
+// discovery cache because it could not be listed live, and "none" disables
+// failure exit codes.
+func exitCode(stats []*report.Stat, failOn string) int {
+	fail := make(map[string]bool)
+	for _, f := range strings.Split(failOn, ",") {
+		fail[strings.TrimSpace(f)] = true
+	}
+	code := ExitOK
+	for _, stat := range stats {
+		if stat.Error != "" && fail["source"] {
+			return ExitError
+		}
+		if stat.DiscoveryError != "" && fail["cached"] {
+			code = ExitPartial
+		}
+		for _, result := range stat.Results {
+			if fail[string(result.Outcome)] {
+				code = ExitPartial
+			}
+		}
+	}
+	return code
+}
@@ -0,0 +1,35 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"text/tabwriter"
+)
+
+// runExplain prints every filter rule evaluated for the owner/repo given as
+// argument and the final decision.
+func runExplain(args []string) int {
+	fs, g := newFlagSet("explain")
+	source := fs.String("source", "", "name of the source whose rules to evaluate, by default the source of the repo's owner, else the first source")
+	_, mirrorer := setup(fs, g, args)
+	if fs.NArg() != 1 {
+		fatal("Usage: github-repo-mirror explain [flags] owner/repo")
+	}
+	src, decision, err := mirrorer.ExplainRepo(fs.Arg(0), *source)
+	if err != nil {
+		fatal("Failed to explain repo", "repo", fs.Arg(0), "error", err)
+	}
+	fmt.Printf("source: %s\n", src.Label())
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "RULE\tRESULT\tDETAIL")
+	for _, rule := range decision.Rules {
+		fmt.Fprintf(w, "%s\t%s\t%s\n", rule.Rule, rule.Result, rule.Detail)
+	}
+	w.Flush()
+	if decision.Skip {
+		fmt.Printf("decision: skip, %s\n", decision.Reason)
+		return ExitOK
+	}
+	fmt.Println("decision: mirror")
+	return ExitOK
+}
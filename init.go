@@ -0,0 +1,270 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/chamzzzzzz/github-repo-mirror/pkg/config"
+	"github.com/chamzzzzzz/github-repo-mirror/pkg/credentials"
+	"github.com/chamzzzzzz/github-repo-mirror/pkg/github"
+)
+
+// initSource is a source written by init. Only the fields init sets are
+// written, the rest keep their defaults.
+type initSource struct {
+	Username     string
+	Token        string   `json:",omitempty"`
+	TokenSource  string   `json:",omitempty"`
+	Organization bool     `json:",omitempty"`
+	Affiliation  string   `json:",omitempty"`
+	Include      []string `json:",omitempty"`
+}
+
+// initConfig is the config written by init.
+type initConfig struct {
+	Version     int
+	Destination string
+	Sources     []*initSource
+}
+
+// wizard asks the questions of init on stdin.
+type wizard struct {
+	in *bufio.Reader
+}
+
+// ask prints question with its default, if any, and returns the answer, or
+// the default if the answer is empty.
+func (w *wizard) ask(question, def string) string {
+	if def != "" {
+		fmt.Fprintf(os.Stderr, "%s [%s]: ", question, def)
+	} else {
+		fmt.Fprintf(os.Stderr, "%s: ", question)
+	}
+	answer, err := w.in.ReadString('\n')
+	if err != nil && answer == "" {
+		fatal("Failed to read answer", "error", err)
+	}
+	answer = strings.TrimSpace(answer)
+	if answer == "" {
+		return def
+	}
+	return answer
+}
+
+// askSecret is ask without echoing the answer if stdin is a terminal.
+func (w *wizard) askSecret(question string) string {
+	fi, err := os.Stdin.Stat()
+	if err == nil && fi.Mode()&os.ModeCharDevice != 0 {
+		stty := func(arg string) {
+			cmd := exec.Command("stty", arg)
+			cmd.Stdin = os.Stdin
+			cmd.Run()
+		}
+		stty("-echo")
+		defer func() {
+			stty("echo")
+			fmt.Fprintln(os.Stderr)
+		}()
+	}
+	return w.ask(question, "")
+}
+
+// confirm asks a yes or no question.
+func (w *wizard) confirm(question string, def bool) bool {
+	d := "y/N"
+	if def {
+		d = "Y/n"
+	}
+	switch strings.ToLower(w.ask(question+" ("+d+")", "")) {
+	case "":
+		return def
+	case "y", "yes":
+		return true
+	default:
+		return false
+	}
+}
+
+// choose asks for a selection of the numbered items, e.g. "1,3-5", and
+// returns the indexes chosen.
+func (w *wizard) choose(question, def string, n int) []int {
+	for {
+		indexes, err := parseSelection(w.ask(question, def), n)
+		if err == nil && len(indexes) > 0 {
+			return indexes
+		}
+		fmt.Fprintf(os.Stderr, "Enter numbers from 1 to %d, e.g. 1,3-5\n", n)
+	}
+}
+
+// parseSelection parses comma separated numbers and ranges of 1 to n into
+// sorted 0-based indexes.
+func parseSelection(s string, n int) ([]int, error) {
+	chosen := make(map[int]bool)
+	for _, part := range strings.Split(s, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		first, last, isRange := strings.Cut(part, "-")
+		from, err := strconv.Atoi(strings.TrimSpace(first))
+		if err != nil {
+			return nil, err
+		}
+		to := from
+		if isRange {
+			to, err = strconv.Atoi(strings.TrimSpace(last))
+			if err != nil {
+				return nil, err
+			}
+		}
+		if from < 1 || to > n || from > to {
+			return nil, fmt.Errorf("%s is out of range", part)
+		}
+		for i := from; i <= to; i++ {
+			chosen[i-1] = true
+		}
+	}
+	var indexes []int
+	for i := range chosen {
+		indexes = append(indexes, i)
+	}
+	sort.Ints(indexes)
+	return indexes, nil
+}
+
+// runInit walks through creating a config: it checks a token, lets the user
+// pick the token's user and organizations as sources and the repos of each,
+// and writes the config with a destination.
+func runInit(args []string) int {
+	fs, g := newFlagSet("init")
+	fs.Parse(args)
+	err := setupLogger(*g.logFormat, *g.logLevel)
+	if err != nil {
+		log.Fatal("Failed to setup logger: ", err)
+	}
+	path := *g.config
+	w := &wizard{in: bufio.NewReader(os.Stdin)}
+	if _, err := os.Stat(path); err == nil && !w.confirm(fmt.Sprintf("%s exists, overwrite it?", path), false) {
+		return ExitOK
+	}
+	client := github.NewClient()
+
+	// A token stored by login is looked up at run time, by the sources'
+	// TokenSource, and not written.
+	var token, login string
+	var stored bool
+	var preflight *github.Preflight
+	for preflight == nil {
+		lookup := w.askSecret("GitHub token (empty to use the one stored by login)")
+		stored = lookup == ""
+		if stored {
+			store, err := credentials.Open("")
+			if err == nil {
+				lookup, err = store.Get(defaultCredentialAccount)
+			}
+			if errors.Is(err, credentials.ErrNotFound) {
+				fmt.Fprintln(os.Stderr, "No token stored, enter one or run github-repo-mirror login first")
+				continue
+			}
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Failed to get stored token: %s\n", err)
+				continue
+			}
+		}
+		login, err = client.User(&config.Source{Token: lookup})
+		if err == nil {
+			preflight, err = client.CheckToken(&config.Source{Username: login, Token: lookup})
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Token check failed: %s\n", err)
+			continue
+		}
+		token = lookup
+	}
+	fmt.Fprintf(os.Stderr, "Token of %s, %s", login, preflight.Kind)
+	if preflight.Scopes != nil {
+		fmt.Fprintf(os.Stderr, ", scopes %s", strings.Join(preflight.Scopes, ", "))
+	}
+	fmt.Fprintln(os.Stderr)
+	for _, warning := range preflight.Warnings {
+		fmt.Fprintf(os.Stderr, "Warning: %s\n", warning)
+	}
+
+	orgs, err := client.ListOrgs(&config.Source{Token: token})
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to list organizations: %s\n", err)
+	}
+	candidates := []*initSource{{Username: login, Affiliation: "owner"}}
+	for _, org := range orgs {
+		candidates = append(candidates, &initSource{Username: org, Organization: true})
+	}
+	fmt.Fprintln(os.Stderr, "\nSources the token can see:")
+	repos := make([][]*github.Repo, len(candidates))
+	for i, candidate := range candidates {
+		repos[i], err = client.ListRepos(&config.Source{Username: candidate.Username, Token: token, Organization: candidate.Organization, Affiliation: candidate.Affiliation})
+		kind := "user, own repos"
+		if candidate.Organization {
+			kind = "organization"
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "  %d. %s (%s, failed to list repos: %s)\n", i+1, candidate.Username, kind, err)
+			continue
+		}
+		fmt.Fprintf(os.Stderr, "  %d. %s (%s, %d repos)\n", i+1, candidate.Username, kind, len(repos[i]))
+	}
+	c := &initConfig{Version: config.CurrentVersion}
+	for _, i := range w.choose("Sources to mirror, e.g. 1,3-5", "1", len(candidates)) {
+		source := candidates[i]
+		if stored {
+			source.TokenSource = storedTokenSource
+		} else {
+			source.Token = token
+		}
+		if len(repos[i]) > 0 && !w.confirm(fmt.Sprintf("Mirror all %d repos of %s?", len(repos[i]), source.Username), true) {
+			names := make([]string, len(repos[i]))
+			for j, repo := range repos[i] {
+				names[j] = repo.FullName
+			}
+			sort.Strings(names)
+			for j, name := range names {
+				fmt.Fprintf(os.Stderr, "  %d. %s\n", j+1, name)
+			}
+			for _, j := range w.choose("Repos to mirror, e.g. 1,3-5", "", len(names)) {
+				source.Include = append(source.Include, names[j])
+			}
+		}
+		c.Sources = append(c.Sources, source)
+	}
+	destination, err := filepath.Abs(w.ask("Destination directory", "mirrors"))
+	if err != nil {
+		fatal("Failed to resolve destination", "error", err)
+	}
+	c.Destination = destination
+
+	b, err := json.MarshalIndent(c, "", "  ")
+	if err != nil {
+		fatal("Failed to encode config", "error", err)
+	}
+	b = append(b, '\n')
+	_, err = config.Parse(b)
+	if err != nil {
+		fatal("Failed to create valid config", "error", err)
+	}
+	// The config may hold the token.
+	err = os.WriteFile(path, b, 0600)
+	if err != nil {
+		fatal("Failed to write config", "error", err)
+	}
+	fmt.Fprintf(os.Stderr, "\nWrote %s. Check it with github-repo-mirror validate -config %s, then mirror with github-repo-mirror mirror -config %s\n", path, path, path)
+	return ExitOK
+}
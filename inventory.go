@@ -0,0 +1,97 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/chamzzzzzz/github-repo-mirror/pkg/config"
+	"github.com/chamzzzzzz/github-repo-mirror/pkg/gitmirror"
+	"github.com/chamzzzzzz/github-repo-mirror/pkg/state"
+)
+
+// InventoryEntry is a local mirror in the inventory written by list.
+type InventoryEntry struct {
+	Repo    string `json:"repo"`
+	Owner   string `json:"owner"`
+	Private bool   `json:"private"`
+	Local   string `json:"local"`
+	// Size is the size of the mirror on disk in bytes.
+	Size      int64     `json:"size"`
+	LastSync  time.Time `json:"last_sync"`
+	LastError string    `json:"last_error,omitempty"`
+}
+
+// inventory returns the local mirrors with their metadata and state.
+func inventory(config *config.Config, mirrorer *gitmirror.Mirrorer) ([]*InventoryEntry, error) {
+	locals, err := mirrorer.LocalMirrors()
+	if err != nil {
+		return nil, err
+	}
+	store, err := state.Open(state.Path(config))
+	if err != nil {
+		return nil, err
+	}
+	repos := make(map[string]*state.Repo)
+	for _, repo := range store.Repos() {
+		repos[repo.Local] = repo
+	}
+	var entries []*InventoryEntry
+	for _, local := range locals {
+		entry := &InventoryEntry{
+			Repo:  mirrorer.RepoName(local),
+			Local: local,
+		}
+		entry.Owner = entry.Repo
+		if i := strings.LastIndex(entry.Repo, "/"); i >= 0 {
+			entry.Owner = entry.Repo[:i]
+		}
+		if metadata, err := gitmirror.ReadMetadata(local); err == nil {
+			entry.Private = metadata.Private
+		}
+		entry.Size, err = gitmirror.Size(local)
+		if err != nil {
+			return nil, err
+		}
+		entry.LastSync, _ = gitmirror.LastFetch(local)
+		if repo := repos[local]; repo != nil && repo.ConsecutiveFailures > 0 {
+			entry.LastError = repo.LastError
+		}
+		entries = append(entries, entry)
+	}
+	return entries, nil
+}
+
+// writeInventory writes entries to w as csv, tsv or json.
+func writeInventory(w io.Writer, format string, entries []*InventoryEntry) error {
+	switch format {
+	case "json":
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		if entries == nil {
+			entries = []*InventoryEntry{}
+		}
+		return encoder.Encode(entries)
+	case "csv", "tsv":
+	default:
+		return fmt.Errorf("unknown list format %q", format)
+	}
+	writer := csv.NewWriter(w)
+	if format == "tsv" {
+		writer.Comma = '\t'
+	}
+	writer.Write([]string{"repo", "owner", "private", "local", "size", "last_sync", "last_error"})
+	for _, entry := range entries {
+		lastSync := ""
+		if !entry.LastSync.IsZero() {
+			lastSync = entry.LastSync.Format(time.RFC3339)
+		}
+		writer.Write([]string{entry.Repo, entry.Owner, strconv.FormatBool(entry.Private), entry.Local, strconv.FormatInt(entry.Size, 10), lastSync, entry.LastError})
+	}
+	writer.Flush()
+	return writer.Error()
+}
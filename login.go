@@ -0,0 +1,103 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"strings"
+
+	"github.com/chamzzzzzz/github-repo-mirror/pkg/config"
+	"github.com/chamzzzzzz/github-repo-mirror/pkg/credentials"
+	"github.com/chamzzzzzz/github-repo-mirror/pkg/github"
+	"github.com/chamzzzzzz/github-repo-mirror/pkg/gitmirror"
+)
+
+// defaultCredentialAccount is the account of the token login stores without
+// -source, used by the sources with storedTokenSource that have no token
+// stored for their username.
+const defaultCredentialAccount = "github.com"
+
+// storedTokenSource is the TokenSource of the GitHub sources that use the
+// token login stored for them. Other sources never get a stored token, so
+// the login's token is not sent anywhere the config does not ask for.
+const storedTokenSource = "keyring"
+
+// credentialAccount is the account the token of the source username is
+// stored under.
+func credentialAccount(username string) string {
+	return defaultCredentialAccount + "/" + username
+}
+
+// runLogin logs in with GitHub's device flow and stores the token in the
+// credential store.
+func runLogin(args []string) int {
+	fs, g := newFlagSet("login")
+	clientID := fs.String("client-id", "", "client ID of the OAuth or GitHub App to log in with, required")
+	scopes := fs.String("scopes", "repo,read:org", "comma separated OAuth scopes to request, ignored by GitHub Apps")
+	source := fs.String("source", "", "username of the source to store the token for, by default the token of the sources with TokenSource keyring that have none of their own")
+	_, mirrorer := setup(fs, g, args)
+	if *clientID == "" {
+		fatal("Usage: github-repo-mirror login -client-id ID [flags]")
+	}
+	store, err := credentials.Open(mirrorer.Config.CredentialStore)
+	if err != nil {
+		fatal("Failed to open credential store", "error", err)
+	}
+
+	code, err := mirrorer.Client.RequestDeviceCode(*clientID, strings.Split(*scopes, ","))
+	if err != nil {
+		fatal("Failed to request device code", "error", err)
+	}
+	fmt.Fprintf(os.Stderr, "Open %s and enter the code %s\n", code.VerificationURI, code.UserCode)
+	token, err := mirrorer.Client.PollDeviceToken(*clientID, code)
+	if err != nil {
+		fatal("Failed to log in", "error", err)
+	}
+	login, err := mirrorer.Client.User(&config.Source{Token: token})
+	if err != nil {
+		fatal("Failed to get user", "error", err)
+	}
+	account := defaultCredentialAccount
+	if *source != "" {
+		account = credentialAccount(*source)
+	}
+	err = store.Set(account, token)
+	if err != nil {
+		fatal("Failed to store token", "error", err)
+	}
+	slog.Info("Successfully logged in", "login", login, "account", account, "kind", github.TokenKind(token))
+	return ExitOK
+}
+
+// useStoredTokens points the TokenSource of the GitHub sources that opted in
+// with storedTokenSource at the account login stored their token under, or
+// else at the default account, so ResolveTokens reads it.
+func useStoredTokens(config *config.Config) error {
+	var store credentials.Store
+	for _, source := range config.Sources {
+		if source.TokenSource != storedTokenSource {
+			continue
+		}
+		if source.Type == gitmirror.SourceAzureDevOps {
+			return fmt.Errorf("source %s: login stores GitHub tokens only, set TokenSource to keyring:<account>", source.Label())
+		}
+		if store == nil {
+			var err error
+			store, err = credentials.Open(config.CredentialStore)
+			if err != nil {
+				return err
+			}
+		}
+		account := credentialAccount(source.Username)
+		_, err := store.Get(account)
+		if errors.Is(err, credentials.ErrNotFound) {
+			account = defaultCredentialAccount
+		} else if err != nil {
+			return fmt.Errorf("source %s: %w", source.Label(), err)
+		}
+		source.TokenSource = storedTokenSource + ":" + account
+		slog.Debug("Using stored token", "source", source.Label(), "account", account)
+	}
+	return nil
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"log/slog"
+	"os"
+
+	"github.com/chamzzzzzz/github-repo-mirror/pkg/config"
+)
+
+// runMigrate upgrades the config file to config.CurrentVersion, keeping the
+// old file next to it as <config>.v<version>.bak.
+func runMigrate(args []string) int {
+	fs, g := newFlagSet("migrate")
+	fs.Parse(args)
+	err := setupLogger(*g.logFormat, *g.logLevel)
+	if err != nil {
+		log.Fatal("Failed to setup logger: ", err)
+	}
+	b, err := os.ReadFile(*g.config)
+	if err != nil {
+		fatal("Failed to read config", "error", err)
+	}
+	migrated, version, deprecations, err := config.Migrate(b)
+	if err != nil {
+		fatal("Failed to migrate config", "error", err)
+	}
+	if version == config.CurrentVersion {
+		slog.Info("Config is current", "version", version)
+		return ExitOK
+	}
+	_, err = config.Parse(migrated)
+	if err != nil {
+		fatal("Failed to migrate config", "error", err)
+	}
+	fi, err := os.Stat(*g.config)
+	if err != nil {
+		fatal("Failed to stat config", "error", err)
+	}
+	backup := fmt.Sprintf("%s.v%d.bak", *g.config, version)
+	err = os.WriteFile(backup, b, fi.Mode().Perm())
+	if err == nil {
+		err = os.WriteFile(*g.config+".tmp", migrated, fi.Mode().Perm())
+	}
+	if err == nil {
+		err = os.Rename(*g.config+".tmp", *g.config)
+	}
+	if err != nil {
+		fatal("Failed to write config", "error", err)
+	}
+	for _, note := range deprecations {
+		slog.Warn("Migrated deprecated config field", "note", note)
+	}
+	slog.Info("Successfully migrate config", "from", version, "to", config.CurrentVersion, "backup", backup)
+	return ExitOK
+}
+
+// warnOutdated warns about a config loaded from an older version and its
+// deprecated fields.
+func warnOutdated(config *config.Config) {
+	if config.LoadedVersion() == config.Version {
+		return
+	}
+	slog.Warn("Config file is outdated, upgrade it with the migrate command", "version", config.LoadedVersion(), "current", config.Version)
+	for _, note := range config.Deprecations() {
+		slog.Warn("Deprecated config field", "note", note)
+	}
+}
@@ -0,0 +1,287 @@
+// Package audit appends a record of each operation on the mirrors to a JSON
+// Lines file. Each record holds the hash of the previous one, so records
+// edited, removed or reordered by accident, e.g. by a bad restore or a
+// truncated write, break the chain. The hashes are not keyed: whoever can
+// write the file can recompute the chain, so it is not evidence against
+// deliberate tampering.
+package audit
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/chamzzzzzz/github-repo-mirror/pkg/config"
+	"github.com/chamzzzzzz/github-repo-mirror/pkg/filelock"
+	"github.com/chamzzzzzz/github-repo-mirror/pkg/report"
+)
+
+type Record struct {
+	Time time.Time `json:"time"`
+	// Action is sync for the repos of a run, or prune, reclone, adopt or
+	// restore.
+	Action   string        `json:"action"`
+	Repo     string        `json:"repo,omitempty"`
+	Local    string        `json:"local,omitempty"`
+	Result   string        `json:"result"`
+	Duration time.Duration `json:"duration"`
+	Bytes    int64         `json:"bytes,omitempty"`
+	Error    string        `json:"error,omitempty"`
+	// Prev is the Hash of the previous record, Hash the SHA-256 of Prev and
+	// the record's JSON without Hash.
+	Prev string `json:"prev"`
+	Hash string `json:"hash"`
+}
+
+// Log appends records to Path, rotating it to Path.1, Path.2 and so on when
+// it exceeds MaxSizeMB (default 100) and keeping Keep (default 10) rotated
+// files. Appends hold the lock file Path+".lock", so processes sharing the
+// log, e.g. the daemon and the add command, extend one chain. A nil Log
+// records nothing.
+type Log struct {
+	config *config.Audit
+	mu     sync.Mutex
+}
+
+// Open opens the audit log of config, nil if it has no Path.
+func Open(config *config.Audit) (*Log, error) {
+	if config.Path == "" {
+		return nil, nil
+	}
+	l := &Log{config: config}
+	_, err := l.lastHash()
+	if err != nil {
+		return nil, err
+	}
+	return l, nil
+}
+
+// lastHash returns the Hash of the last record of the log, empty if it has
+// none.
+func (l *Log) lastHash() (string, error) {
+	for _, path := range []string{l.config.Path, l.config.Path + ".1"} {
+		record, err := lastRecord(path)
+		if err != nil {
+			return "", err
+		}
+		if record != nil {
+			return record.Hash, nil
+		}
+	}
+	return "", nil
+}
+
+// lastRecord returns the last record of the file at path, nil if it has
+// none.
+func lastRecord(path string) (*Record, error) {
+	b, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	b = bytes.TrimSpace(b)
+	if len(b) == 0 {
+		return nil, nil
+	}
+	if i := bytes.LastIndexByte(b, '\n'); i >= 0 {
+		b = b[i+1:]
+	}
+	var record Record
+	err = json.Unmarshal(b, &record)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return &record, nil
+}
+
+func hash(record *Record) (string, error) {
+	r := *record
+	r.Hash = ""
+	b, err := json.Marshal(&r)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// Append chains and appends records to the log.
+func (l *Log) Append(records ...*Record) error {
+	if l == nil || len(records) == 0 {
+		return nil
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	lock, err := filelock.Acquire(l.config.Path + ".lock")
+	if err != nil {
+		return err
+	}
+	defer lock.Release()
+	err = l.rotate()
+	if err != nil {
+		return err
+	}
+	// Another process may have appended since, so chain to the file.
+	last, err := l.lastHash()
+	if err != nil {
+		return err
+	}
+	var buf bytes.Buffer
+	for _, record := range records {
+		record.Prev = last
+		record.Hash, err = hash(record)
+		if err != nil {
+			return err
+		}
+		b, err := json.Marshal(record)
+		if err != nil {
+			return err
+		}
+		buf.Write(b)
+		buf.WriteByte('\n')
+		last = record.Hash
+	}
+	f, err := os.OpenFile(l.config.Path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(buf.Bytes())
+	if err == nil {
+		err = f.Sync()
+	}
+	if err1 := f.Close(); err == nil {
+		err = err1
+	}
+	return err
+}
+
+// rotate shifts the log files if the current one reached the maximum size.
+func (l *Log) rotate() error {
+	maxSize := int64(l.config.MaxSizeMB) * 1024 * 1024
+	if maxSize <= 0 {
+		maxSize = 100 * 1024 * 1024
+	}
+	keep := l.config.Keep
+	if keep <= 0 {
+		keep = 10
+	}
+	fi, err := os.Stat(l.config.Path)
+	if os.IsNotExist(err) || err == nil && fi.Size() < maxSize {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	os.Remove(fmt.Sprintf("%s.%d", l.config.Path, keep))
+	for i := keep - 1; i >= 1; i-- {
+		err := os.Rename(fmt.Sprintf("%s.%d", l.config.Path, i), fmt.Sprintf("%s.%d", l.config.Path, i+1))
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return os.Rename(l.config.Path, l.config.Path+".1")
+}
+
+// Sync returns the records of a run's results.
+func Sync(stats []*report.Stat) []*Record {
+	var records []*Record
+	now := time.Now()
+	for _, stat := range stats {
+		if stat.Error != "" {
+			records = append(records, &Record{
+				Time:   now,
+				Action: "discover",
+				Repo:   stat.Name,
+				Result: "failed",
+				Error:  stat.Error,
+			})
+		}
+		for _, result := range stat.Results {
+			records = append(records, &Record{
+				Time:     now,
+				Action:   "sync",
+				Repo:     result.Repo,
+				Local:    result.Local,
+				Result:   string(result.Outcome),
+				Duration: result.Duration,
+				Bytes:    result.Bytes,
+				Error:    result.Error,
+			})
+		}
+	}
+	return records
+}
+
+// Verify checks the hash chain of the log files at paths, oldest first, and
+// returns the number of records. The first record's Prev is trusted, since
+// older files may have been rotated away.
+func Verify(paths ...string) (int, error) {
+	n := 0
+	var last string
+	for _, path := range paths {
+		f, err := os.Open(path)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return n, err
+		}
+		scanner := bufio.NewScanner(f)
+		scanner.Buffer(nil, 1024*1024)
+		line := 0
+		for scanner.Scan() {
+			line++
+			if len(bytes.TrimSpace(scanner.Bytes())) == 0 {
+				continue
+			}
+			var record Record
+			err := json.Unmarshal(scanner.Bytes(), &record)
+			if err != nil {
+				f.Close()
+				return n, fmt.Errorf("%s:%d: %w", path, line, err)
+			}
+			sum, err := hash(&record)
+			if err != nil {
+				f.Close()
+				return n, err
+			}
+			if sum != record.Hash {
+				f.Close()
+				return n, fmt.Errorf("%s:%d: record does not match its hash", path, line)
+			}
+			if n > 0 && record.Prev != last {
+				f.Close()
+				return n, fmt.Errorf("%s:%d: chain broken, previous record missing or reordered", path, line)
+			}
+			last = record.Hash
+			n++
+		}
+		err = scanner.Err()
+		f.Close()
+		if err != nil {
+			return n, err
+		}
+	}
+	return n, nil
+}
+
+// Paths returns the files of the log of config, oldest first.
+func Paths(config *config.Audit) []string {
+	keep := config.Keep
+	if keep <= 0 {
+		keep = 10
+	}
+	var paths []string
+	for i := keep; i >= 1; i-- {
+		paths = append(paths, fmt.Sprintf("%s.%d", config.Path, i))
+	}
+	return append(paths, config.Path)
+}
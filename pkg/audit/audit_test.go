@@ -0,0 +1,117 @@
+package audit
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/chamzzzzzz/github-repo-mirror/pkg/config"
+)
+
+// writeLog appends n records to a new log and returns its config and lines.
+func writeLog(t *testing.T, n int) (*config.Audit, [][]byte) {
+	c := &config.Audit{Path: filepath.Join(t.TempDir(), "audit.jsonl")}
+	l, err := Open(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < n; i++ {
+		err := l.Append(&Record{Time: time.Unix(int64(i), 0), Action: "sync", Repo: "alice/proj", Result: "updated"})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	b, err := os.ReadFile(c.Path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c, bytes.SplitAfter(bytes.TrimSuffix(b, []byte("\n")), []byte("\n"))
+}
+
+func TestVerify(t *testing.T) {
+	c, lines := writeLog(t, 4)
+	edited := strings.Replace(string(lines[1]), `"updated"`, `"mirrored"`, 1)
+	tests := []struct {
+		name  string
+		lines []string
+		n     int
+		err   string
+	}{
+		{"intact", []string{string(lines[0]), string(lines[1]), string(lines[2]), string(lines[3])}, 4, ""},
+		{"empty", nil, 0, ""},
+		{"oldest rotated away", []string{string(lines[2]), string(lines[3])}, 2, ""},
+		{"edited", []string{string(lines[0]), edited, string(lines[2]), string(lines[3])}, 1, "does not match its hash"},
+		{"removed", []string{string(lines[0]), string(lines[2]), string(lines[3])}, 1, "chain broken"},
+		{"reordered", []string{string(lines[0]), string(lines[2]), string(lines[1]), string(lines[3])}, 1, "chain broken"},
+		{"not json", []string{string(lines[0]), "{\n"}, 1, "unexpected end"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			path := c.Path + "." + strings.ReplaceAll(test.name, " ", "-")
+			err := os.WriteFile(path, []byte(strings.Join(test.lines, "")), 0644)
+			if err != nil {
+				t.Fatal(err)
+			}
+			n, err := Verify(path)
+			if n != test.n {
+				t.Errorf("Verify() = %d records, want %d", n, test.n)
+			}
+			if test.err == "" && err != nil || test.err != "" && (err == nil || !strings.Contains(err.Error(), test.err)) {
+				t.Errorf("Verify() = %v, want error %q", err, test.err)
+			}
+		})
+	}
+}
+
+func TestVerifyAcrossRotation(t *testing.T) {
+	c, _ := writeLog(t, 3)
+	err := os.Rename(c.Path, c.Path+".1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// A new Log chains to the last record of the rotated file.
+	l, err := Open(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = l.Append(&Record{Action: "prune", Repo: "alice/old", Result: "removed"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	n, err := Verify(Paths(c)...)
+	if n != 4 || err != nil {
+		t.Errorf("Verify() = %d, %v, want 4 records", n, err)
+	}
+}
+
+func TestAppendConcurrent(t *testing.T) {
+	c := &config.Audit{Path: filepath.Join(t.TempDir(), "audit.jsonl")}
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		// Each Log stands for a process of its own.
+		l, err := Open(c)
+		if err != nil {
+			t.Fatal(err)
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 10; j++ {
+				err := l.Append(&Record{Action: "sync", Repo: "alice/proj", Result: "updated"})
+				if err != nil {
+					t.Error(err)
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	n, err := Verify(c.Path)
+	if n != 40 || err != nil {
+		t.Errorf("Verify() = %d, %v, want 40 records", n, err)
+	}
+}
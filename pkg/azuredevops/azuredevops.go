@@ -0,0 +1,169 @@
+// Package azuredevops lists the Git repos of Azure DevOps organizations
+// through the Azure DevOps REST API, as GitHub repos for the mirror
+// pipeline.
+package azuredevops
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	neturl "net/url"
+	"strings"
+
+	"github.com/chamzzzzzz/github-repo-mirror/pkg/config"
+	"github.com/chamzzzzzz/github-repo-mirror/pkg/github"
+)
+
+// Host is the host of Azure DevOps Services, and the Host of its repos.
+const Host = "dev.azure.com"
+
+// apiVersion is the REST API version requested.
+const apiVersion = "7.0"
+
+type Client struct {
+	HTTP *http.Client
+}
+
+func NewClient(httpClient *http.Client) *Client {
+	return &Client{
+		HTTP: httpClient,
+	}
+}
+
+type project struct {
+	Name       string `json:"name"`
+	Visibility string `json:"visibility"`
+}
+
+type repository struct {
+	Name          string  `json:"name"`
+	DefaultBranch string  `json:"defaultBranch"`
+	Size          int64   `json:"size"`
+	IsDisabled    bool    `json:"isDisabled"`
+	Project       project `json:"project"`
+}
+
+// ListRepos lists the repos of the projects of the organization
+// source.Username, or only of source.Projects if set. Their full names are
+// organization/project/repo.
+func (c *Client) ListRepos(source *config.Source) ([]*github.Repo, error) {
+	projects := source.Projects
+	if len(projects) == 0 {
+		var err error
+		projects, err = c.listProjects(source)
+		if err != nil {
+			return nil, err
+		}
+	}
+	var repos []*github.Repo
+	for _, name := range projects {
+		var list struct {
+			Value []*repository `json:"value"`
+		}
+		url := fmt.Sprintf("https://%s/%s/%s/_apis/git/repositories", Host, neturl.PathEscape(source.Username), neturl.PathEscape(name))
+		_, err := c.get(source, url, nil, &list)
+		if err != nil {
+			return nil, fmt.Errorf("project %s: %w", name, err)
+		}
+		for _, r := range list.Value {
+			if r.IsDisabled {
+				continue
+			}
+			repo := &github.Repo{
+				Name:          r.Name,
+				FullName:      source.Username + "/" + r.Project.Name + "/" + r.Name,
+				Private:       r.Project.Visibility != "public",
+				DefaultBranch: strings.TrimPrefix(r.DefaultBranch, "refs/heads/"),
+				Size:          r.Size / 1024,
+				Host:          Host,
+			}
+			repo.Owner.Login = source.Username
+			repos = append(repos, repo)
+		}
+	}
+	return repos, nil
+}
+
+// listProjects returns the names of the projects of the organization
+// source.Username, following continuation tokens.
+func (c *Client) listProjects(source *config.Source) ([]string, error) {
+	var names []string
+	continuation := ""
+	for {
+		query := neturl.Values{}
+		query.Set("$top", "100")
+		if continuation != "" {
+			query.Set("continuationToken", continuation)
+		}
+		var list struct {
+			Value []*project `json:"value"`
+		}
+		url := fmt.Sprintf("https://%s/%s/_apis/projects", Host, neturl.PathEscape(source.Username))
+		header, err := c.get(source, url, query, &list)
+		if err != nil {
+			return nil, err
+		}
+		for _, p := range list.Value {
+			names = append(names, p.Name)
+		}
+		continuation = header.Get("X-Ms-Continuationtoken")
+		if continuation == "" || len(list.Value) == 0 {
+			return names, nil
+		}
+	}
+}
+
+// User returns the display name of the user the source's token belongs to.
+func (c *Client) User(source *config.Source) (string, error) {
+	var data struct {
+		AuthenticatedUser struct {
+			ProviderDisplayName string `json:"providerDisplayName"`
+		} `json:"authenticatedUser"`
+	}
+	url := fmt.Sprintf("https://%s/%s/_apis/connectionData", Host, neturl.PathEscape(source.Username))
+	_, err := c.get(source, url, nil, &data)
+	if err != nil {
+		return "", err
+	}
+	return data.AuthenticatedUser.ProviderDisplayName, nil
+}
+
+// get requests url with query and the source's personal access token and
+// decodes the JSON response into out.
+func (c *Client) get(source *config.Source, url string, query neturl.Values, out any) (http.Header, error) {
+	if query == nil {
+		query = neturl.Values{}
+	}
+	query.Set("api-version", apiVersion)
+	req, err := http.NewRequest("GET", url+"?"+query.Encode(), nil)
+	if err != nil {
+		return nil, err
+	}
+	if token := source.GitToken(); token != "" {
+		req.SetBasicAuth("", token)
+	}
+	req.Header.Set("Accept", "application/json")
+	resp, err := c.HTTP.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	// Azure DevOps redirects unauthenticated API requests to a sign-in page.
+	if resp.StatusCode != http.StatusOK || !strings.HasPrefix(resp.Header.Get("Content-Type"), "application/json") {
+		return nil, fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	return resp.Header, json.NewDecoder(resp.Body).Decode(out)
+}
+
+// Remote returns the clone URL of the repo with full name
+// organization/project/repo.
+func Remote(fullName string) string {
+	parts := strings.SplitN(fullName, "/", 3)
+	for i := range parts {
+		parts[i] = neturl.PathEscape(parts[i])
+	}
+	if len(parts) != 3 {
+		return fmt.Sprintf("https://%s/%s.git", Host, strings.Join(parts, "/"))
+	}
+	return fmt.Sprintf("https://%s/%s/%s/_git/%s", Host, parts[0], parts[1], parts[2])
+}
@@ -0,0 +1,752 @@
+// Package config defines the github-repo-mirror configuration file.
+package config
+
+import (
+	"encoding/json"
+	"os"
+)
+
+// Source is a GitHub user or organization, or with Type "azuredevops" an
+// Azure DevOps organization whose Token is a personal access token.
+//
+// The filters of a source are evaluated in this order, and the first that
+// leaves a repo out decides: IncludeOwners, ExcludeOwners, Include, Exclude,
+// RequireTopics, ExcludeTopics and Languages; a PolicyCommand then has the
+// final say.
+// Include and Exclude are lists of patterns, matched ignoring case against
+// the full name, e.g. "owner/*", or against the clone URL if they contain
+// "://", as path.Match globs. The last matching pattern counts, and a "!"
+// before it negates it, e.g. Exclude ["owner/*", "!owner/keep"]. Without a
+// matching Include pattern a repo is left out, a matching Exclude pattern
+// leaves it out even if included. The explain command shows every rule
+// evaluated for a repo.
+type Source struct {
+	Username     string
+	Token        string
+	Organization bool
+	Exclude      []string
+	Include      []string
+	// Tokens are more tokens for the API. Requests use one token until its
+	// remaining rate limit drops below TokenMinRemaining (default 100), then
+	// move on to the token with the most left. Git uses Token, or the first
+	// of Tokens.
+	Tokens            []string
+	TokenMinRemaining int
+	// PolicyCommand overrides the config's PolicyCommand for this source.
+	PolicyCommand []string
+	// Destination and PathTemplate override the config's for this source.
+	Destination  string
+	PathTemplate string
+	// Refspecs overrides the config's Refspecs for this source.
+	Refspecs []string
+	// Affiliation and Visibility restrict the repos listed for a user with a
+	// token, e.g. "owner" to leave out the repos of organizations mirrored
+	// as their own sources. Affiliation is a comma separated list of owner,
+	// collaborator and organization_member; Visibility is all, public or
+	// private. Both default to GitHub's defaults.
+	Affiliation string
+	Visibility  string
+	// GraphQL lists the repos with the GraphQL API, 100 per request with all
+	// the fields mirroring needs. It requires a Token.
+	GraphQL bool
+	// IncludeOwners and ExcludeOwners restrict the repos to, or leave out,
+	// those of the listed users and organizations, e.g. of the many orgs
+	// /user/repos returns. They are checked before Include and Exclude.
+	IncludeOwners []string
+	ExcludeOwners []string
+	// RequireTopics leaves out repos without every listed topic, e.g.
+	// "backup", ExcludeTopics those with any listed topic, and Languages
+	// those whose primary language is not listed.
+	RequireTopics []string
+	ExcludeTopics []string
+	Languages     []string
+	// Teams are the slugs of teams of an organization source whose repos
+	// are listed with the team repos API. With TeamMode "intersect", the
+	// default, only the organization's repos some team can access are
+	// mirrored; with "union" the team repos are added to them.
+	Teams    []string
+	TeamMode string
+	// Type is the service of the source: empty for GitHub or azuredevops.
+	// Azure DevOps repos are mirrored from all projects of the organization
+	// Username, or only from Projects, to paths like
+	// dev.azure.com/<org>/<project>/<repo>.git. Submodule and dependency
+	// discovery, fork sharing and the add command only support GitHub.
+	Type     string
+	Projects []string
+	// TokenSource reads Token from a secret store at the start of every
+	// run, so rotated secrets are picked up without editing the config,
+	// e.g. "keyring:github.com/alice", "vault:kv/github#token",
+	// "aws:github-token" or "gcp:projects/p/secrets/github-token". The
+	// vault, aws and gcloud CLIs read the secrets as configured. "keyring"
+	// alone uses the token the login command stored for Username, or else
+	// the one it stored without -source; only GitHub sources can use it.
+	TokenSource string
+	// Name identifies the source in logs, stats, metrics, reports, the
+	// state and .Source of path templates, default Username. Sources need
+	// distinct names, so two sources of one user, e.g. with different
+	// filters, must set it.
+	Name string
+}
+
+type Config struct {
+	Sources     []*Source
+	Destination string
+	// PathTemplate is the text/template layout of mirrors under the
+	// destination, default "{{.Host}}/{{.Owner}}/{{.Name}}.git". It can use
+	// .Host, .Owner, .Name, .FullName and .Source, the source's Label.
+	PathTemplate string
+	// PathCase adapts mirror paths to case-insensitive filesystems, where
+	// Owner/Repo and owner/repo collide. "lower" lowercases paths and
+	// records which repo owns each in path-case.json in the destination,
+	// "hash" also lowercases them but appends a hash of the exact full
+	// name, e.g. repo-1a2b3c4d.git. Repos whose paths collide regardless
+	// fail instead of sharing a mirror.
+	PathCase string
+	// Refspecs are the fetch refspecs of mirrors, e.g. "+refs/*:refs/*" and
+	// "^refs/pull/*" to skip pull request refs, or "+refs/heads/*:refs/heads/*"
+	// and "+refs/tags/*:refs/tags/*" for branches and tags only. By default
+	// all refs are mirrored. Existing mirrors are updated to the refspecs and
+	// lose the refs they no longer match.
+	Refspecs []string
+	// Repos holds per-repo settings keyed by full name, e.g. "owner/repo".
+	Repos map[string]*RepoConfig
+	// Replicas are secondary destinations kept in sync with the primary.
+	Replicas      []*Replica
+	ObjectStorage *ObjectStorage
+	Interval      string
+	Backfill      Backfill
+	Rewrites      []*Rewrite
+	Webhook       Webhook
+	Serve         Serve
+	Dashboard     Dashboard
+	Tracing       Tracing
+	Browse        Browse
+	Notifications Notifications
+	// MaxStaleness is how long a repo may go without a successful sync,
+	// e.g. "72h", before it is reported and notified as stale. Empty
+	// disables the check.
+	MaxStaleness string
+	// CheckRefs compares the refs of each mirror with git ls-remote after it
+	// is fetched and reports the refs that are missing or differ.
+	CheckRefs bool
+	// SkipUnchanged does not fetch a mirror whose last sync succeeded if
+	// GitHub reports no push since, saving a fetch per idle repo.
+	SkipUnchanged bool
+	// ShareForkObjects clones new mirrors of forks with objects/info/alternates
+	// pointing at the mirror of their parent, if it has one, instead of
+	// fetching objects the parent already has. Parents are then never
+	// pruned by git, and prune and reclone keep them while forks depend on
+	// them.
+	ShareForkObjects bool
+	// CloneAttempts is how many times a new mirror's clone is tried.
+	CloneAttempts int
+	// ArchiveFallback downloads the default branch tarball when all clone
+	// attempts fail, so at least a snapshot of the current code is kept.
+	// GitHub repos only.
+	ArchiveFallback bool
+	Resources       Resources
+	Network         Network
+	Bandwidth       Bandwidth
+	// AllowDestructive permits destructive operations such as prune and
+	// reclone without confirmation, and the expiry of snapshot backups.
+	AllowDestructive bool
+	State            State
+	Audit            Audit
+	// PruneArchive is a directory where prune writes a final bundle of each
+	// mirror before removing it, as <path>/<time>.bundle with the mirror's
+	// metadata next to it. The bundles are never rotated.
+	PruneArchive string
+	// PolicyCommand is an external command deciding which repos are
+	// mirrored. It reads the repo as JSON on stdin and prints allow or deny.
+	PolicyCommand []string
+	Disk          Disk
+	Repack        Repack
+	Maintenance   Maintenance
+	Verify        Verify
+	Snapshots     Snapshots
+	Bundles       Bundles
+	Backoff       Backoff
+	Submodules    Submodules
+	Dependencies  Dependencies
+	// Order is the order repos are synced in, so the ones that matter most
+	// are done first when a run is cut short: pushed (most recently pushed
+	// first), smallest (smallest first), owner (round-robin between owners)
+	// or empty for the order repos are listed in. Repos with a higher
+	// RepoConfig Priority go first regardless.
+	Order string
+	// Backend selects the git implementation: exec (default) runs the git
+	// binary, go-git clones and fetches without it and needs a build with
+	// the gogit tag. go-git still runs the git binary for what it lacks,
+	// e.g. repacks, shallow fetches and proxies.
+	Backend string
+	Index   Index
+	// MaxRunDuration, if set, e.g. "6h", stops a run from starting more
+	// repos after that long and defers the rest, which the next run syncs
+	// first. Git commands still running MaxRunGrace (default "5m") later
+	// are killed and their repos deferred too.
+	MaxRunDuration string
+	MaxRunGrace    string
+	// FailuresPath is where the failed repos of each run are written with
+	// their error details, default <Destination>/failures.json.
+	FailuresPath string
+	Metrics      Metrics
+	// SkipPreflight skips checking at startup that each token is valid and
+	// can read the contents of its source's repos.
+	SkipPreflight bool
+	// CredentialStore is where the login command stores tokens, and where
+	// sources with a keyring TokenSource look theirs up: keyring, file
+	// for an encrypted file in the user's config directory, or by default
+	// the keyring if there is one, else the file.
+	CredentialStore string
+	// DiscoveryCachePath is where the last successful listing of each
+	// source is cached for runs with -cached or -offline, default
+	// <Destination>/discovery-cache.json.
+	DiscoveryCachePath string
+	Profiles           Profiles
+	// GitPath is the git binary to run, e.g. "/opt/git/bin/git", by default
+	// git from PATH. GitConfig are "key=value" settings passed with -c to
+	// every git command, e.g. "protocol.version=2", and GitFlags are more
+	// flags put before every git subcommand.
+	GitPath      string
+	GitConfig    []string
+	GitFlags     []string
+	Pools        Pools
+	Pages        Pages
+	Signatures   Signatures
+	CloneBundles CloneBundles
+	// Version is the version of the config format, see CurrentVersion.
+	// Older configs are upgraded when loaded; the migrate command upgrades
+	// the file.
+	Version int
+
+	// loadedVersion is the version of the file the config was loaded from.
+	loadedVersion int
+	// deprecations are notes on the deprecated fields of that file.
+	deprecations []string
+}
+
+// Disk limits the space used by mirrors. Before a new mirror is cloned, the
+// API-reported repo size plus SafetyMarginPercent (default 20) must fit in
+// the free space above MinFreeGB and in MaxTotalSizeGB, if set. Otherwise
+// the remaining new mirrors of the run are deferred; updates continue.
+type Disk struct {
+	MaxTotalSizeGB      float64
+	MinFreeGB           float64
+	SafetyMarginPercent int
+}
+
+// Maintenance consolidates existing mirrors whose loose objects exceed
+// MaxLooseObjects (default 1000) or packs exceed MaxPacks (default 50).
+// Strategy repack (the default) repacks with the Repack settings and writes a
+// commit-graph; strategy maintenance runs git maintenance, gc runs git gc and
+// none skips the pass. In daemon mode the pass runs every Interval, if set.
+//
+// GC is the policy of git's own automatic gc on fetch: disabled (the
+// default) sets gc.auto=0, leaving the mirrors to this pass; auto lets git gc
+// once there are GCAuto (default 6700) loose objects or GCAutoPackLimit
+// (default 50) packs.
+type Maintenance struct {
+	MaxLooseObjects int
+	MaxPacks        int
+	Strategy        string
+	Interval        string
+	GC              string
+	GCAuto          int
+	GCAutoPackLimit int
+}
+
+// Verify checks mirrors with git fsck, with --strict if Strict is set and
+// only reachability if ConnectivityOnly is set. In daemon mode the pass runs
+// every Interval, if set. With Reclone, corrupted mirrors are moved to
+// QuarantineDir (default .quarantine in the Destination) and cloned again;
+// the daemon only does so with AllowDestructive as well.
+type Verify struct {
+	Strict           bool
+	ConnectivityOnly bool
+	Interval         string
+	Reclone          bool
+	QuarantineDir    string
+}
+
+// Snapshots protects mirrors from upstream force-pushes and ref deletions.
+// If Enabled, refs that an update moves to a non-descendant or deletes are
+// kept under refs/backup/<time>/ and reported; refs/backup is excluded from
+// the fetch refspec so pruning keeps them. Backups older than
+// Retention, a duration like "2160h", are deleted with AllowDestructive and
+// only logged without it; by default they are kept.
+type Snapshots struct {
+	Enabled   bool
+	Retention string
+}
+
+// Submodules adds the GitHub repos that mirrored repos reference as
+// submodules on their default branch to the run, so that restored repos
+// build. Submodules of added repos are followed up to Depth (default 1)
+// levels. The filters and policy of the referencing repo's source still
+// apply.
+type Submodules struct {
+	Enabled bool
+	Depth   int
+}
+
+// Dependencies adds the GitHub repos that mirrored repos depend on to the
+// run, so an air-gapped rebuild has everything it needs: the github.com
+// modules of go.mod and the actions that workflows and composite actions
+// under .github use, whose pinned refs the mirrors then hold. Like
+// Submodules, it follows Depth (default 1) levels and applies the filters
+// and policy of the depending repo's source.
+type Dependencies struct {
+	Enabled bool
+	Depth   int
+}
+
+// Backoff quarantines repos that keep failing, e.g. because they were taken
+// down upstream. If Enabled, a repo that failed Threshold (default 3) runs in
+// a row is only retried Initial (default "1h") after its last failure, and
+// each further failure doubles the delay up to Max (default "168h").
+type Backoff struct {
+	Enabled   bool
+	Threshold int
+	Initial   string
+	Max       string
+}
+
+// Bundles exports a git bundle of each mirror into Directory after it is
+// mirrored or updated, if Directory is set. With Incremental, a bundle only
+// has the objects since the previous one, and every Keep-th bundle is full.
+// The last Keep (default 5) bundles of each repo are kept, plus the older
+// ones they depend on.
+type Bundles struct {
+	Directory   string
+	Incremental bool
+	Keep        int
+}
+
+// CloneBundles keeps a full bundle of each mirror in Directory for git's
+// bundle-uri, so clients bootstrap clones from a static file instead of a
+// full pack negotiation. A mirror's bundle is replaced after it is synced
+// once it is older than Interval (default "24h"), keeping the previous one
+// for downloads in flight. Next to the bundles of each mirror is a
+// bundle-list file for git clone --bundle-uri, e.g.
+// <BaseURL>/owner/repo/bundle-list. BaseURL is the URL Directory is served
+// at; without it the list has relative URIs, which need git 2.41 or later.
+// With it the mirrors also advertise their bundle to clients that fetch from
+// them over protocol v2 with transfer.bundleURI enabled.
+type CloneBundles struct {
+	Directory string
+	BaseURL   string
+	Interval  string
+}
+
+// Audit appends a JSON Lines record of every repo sync, prune, reclone,
+// adoption and restore to Path, if set. Each record carries the hash of the
+// previous one, which the audit command verifies; this detects accidental
+// corruption, not deliberate edits by someone who can write the file, who
+// can recompute the hashes. The file is rotated at
+// MaxSizeMB (default 100), keeping Keep (default 10) rotated files.
+type Audit struct {
+	Path      string
+	MaxSizeMB int
+	Keep      int
+}
+
+// State configures the state file and how long run history is kept. The
+// retentions are durations like "720h"; individual runs default to 30 days,
+// daily rollups to a year and weekly rollups are kept forever. RepoHistory
+// is how many of each repo's latest attempts are kept, 10 by default.
+type State struct {
+	Path            string
+	RunRetention    string
+	DailyRetention  string
+	WeeklyRetention string
+	RepoHistory     int
+}
+
+// RepoConfig overrides settings for a single repo.
+type RepoConfig struct {
+	// Path is the mirror's local path, overriding the destination and path
+	// template.
+	Path   string
+	Repack *Repack
+	// Maintenance overrides the config's Maintenance, except Interval.
+	Maintenance *Maintenance
+	// Refspecs overrides the source's and config's Refspecs.
+	Refspecs []string
+	// CloneMode is full (the default), blobless to fetch file contents only
+	// when needed, or shallow to keep only the last Depth (default 1)
+	// commits, also on updates. Shallow mirrors get no ref Snapshots.
+	CloneMode string
+	Depth     int
+	// MaxAge trims the mirror's history to the commits of the last MaxAge,
+	// e.g. "17520h" for two years, on the clone and every update. Older
+	// objects are dropped by the next repack or gc. Trimmed mirrors are
+	// marked as such in their metadata and description and get no ref
+	// Snapshots; MaxAge cannot be combined with CloneMode shallow.
+	MaxAge string
+	// Priority moves the repo ahead of repos with a lower priority in a
+	// run; the default is 0.
+	Priority int
+	// Refs pins the mirror to the listed refs, e.g. "refs/tags/v1.*" and
+	// "refs/heads/release/*", fetched as +ref:ref refspecs added to the
+	// repo's own Refspecs instead of the source's and config's. Refs the
+	// mirror no longer matches are deleted on its next update.
+	Refs []string
+	// VerifyTags lists patterns of the tags whose signatures are verified
+	// after each sync, e.g. "refs/tags/v*"; see Signatures.
+	VerifyTags []string
+}
+
+// Repack controls the repack after a new mirror is cloned, which splits
+// packs larger than ThresholdMB (default 95) into packs of at most
+// MaxPackSize (default "95m") using Flags (default "-A", "-d"), e.g. to stay
+// below the file size limits of backup storage.
+type Repack struct {
+	Disabled    bool
+	ThresholdMB int
+	MaxPackSize string
+	Flags       []string
+}
+
+// Args returns the git repack arguments.
+func (r *Repack) Args() []string {
+	return append([]string{"--max-pack-size=" + r.MaxPackSize}, r.Flags...)
+}
+
+// Replica is a secondary destination. Mirrors are first cloned from the
+// primary, then updated by fetching from the primary (Method fetch, the
+// default) or by pushing to the replica (Method push).
+type Replica struct {
+	Name        string
+	Destination string
+	Method      string
+}
+
+// ObjectStorage uploads mirrors to an S3-compatible bucket after they are
+// mirrored or updated. Mode bundle (the default) uploads the bundles written
+// by Bundles that were not uploaded yet; mode tar uploads a tar.gz of the
+// bare repo whenever its refs changed. Objects are stored under
+// Prefix/<mirror path>. Endpoint defaults to AWS S3 in Region and the
+// credentials to the AWS_ACCESS_KEY_ID, AWS_SECRET_ACCESS_KEY and
+// AWS_SESSION_TOKEN environment variables. ServerSideEncryption is sent as
+// x-amz-server-side-encryption, e.g. AES256 or aws:kms with KMSKeyID.
+// EncryptionKey, a base64 encoded 32 byte key, encrypts objects before
+// upload; decrypt them with the decrypt command. Objects are uploaded in a
+// single request, so they are limited to 5 GB on AWS.
+type ObjectStorage struct {
+	Endpoint             string
+	Region               string
+	Bucket               string
+	Prefix               string
+	PathStyle            bool
+	AccessKeyID          string
+	SecretAccessKey      string
+	SessionToken         string
+	Mode                 string
+	ServerSideEncryption string
+	KMSKeyID             string
+	EncryptionKey        string
+}
+
+// Rewrite replaces the From prefix of clone and fetch URLs with To, e.g. to
+// route git traffic through an internal smart proxy. Local paths are always
+// derived from the upstream repo name and are never rewritten.
+type Rewrite struct {
+	From string
+	To   string
+}
+
+// Backfill spreads the metadata exports across hours, so enabling them on a
+// large mirror does not exhaust the rate limit. Every run exports the Pages
+// of the mirrors never exported yet, e.g. unchanged since Pages was
+// enabled. With APIBudgetPerHour, the Pages exports of the synced repos
+// also wait until after the run, and then run those of mirrors never or
+// longest ago exported first, until the hour's budget is used up; the rest
+// wait for later runs of the daemon. The budget is kept across config
+// reloads. Profile exports wait for the budget. Listing repos and git
+// transfers do not count against it.
+type Backfill struct {
+	APIBudgetPerHour int
+}
+
+type Webhook struct {
+	Address string
+	Secret  string
+}
+
+// Browse writes the list of mirrors for a repo browser to Path after each
+// run, if set. Format cgit (the default) writes a file to include from
+// cgitrc with a section per source and each repo's description and owner;
+// format gitweb writes a $projects_list file with paths relative to the
+// Destination, which must be gitweb's $projectroot.
+type Browse struct {
+	Path   string
+	Format string
+}
+
+// Index writes a static index.html of the mirrors under the Destination
+// after each run, with their sizes, last update and upstream links, and a
+// page per owner under owners/, so that any web server serving the
+// Destination can browse them. Title defaults to "Mirrors".
+type Index struct {
+	Enabled bool
+	Title   string
+}
+
+// Profiles exports the profile data of each GitHub source after each run to
+// <Dir>/<source>.json, Dir defaulting to <Destination>/profiles: the user
+// or organization profile, an organization's members and teams, and each
+// repo's description and default branch. BranchProtection also reads the
+// protection of every repo's default branch, one API request per repo.
+// What the token may not read is listed as unreadable instead.
+type Profiles struct {
+	Enabled          bool
+	Dir              string
+	BranchProtection bool
+}
+
+// Pages exports the GitHub Pages configuration of each synced repo with
+// Pages, e.g. its source branch and path and custom domain, to
+// mirror-pages.json inside the mirror, so Pages can be re-enabled the same
+// way on a restored repo. A Pages source branch that pinned refs or refspecs
+// leave out is mirrored anyway. Environments also exports every repo's
+// deployment environments with their protection rules. Each export is one API
+// request per repo.
+type Pages struct {
+	Enabled      bool
+	Environments bool
+}
+
+// Signatures holds the keys trusted to sign the tags of the repos with
+// VerifyTags: the GPG keys in the keyring at GPGHome, by default the user's,
+// and the SSH keys in the AllowedSigners file, if set. Annotated tags must be
+// signed and lightweight tags must point at signed commits; tags that fail
+// verification are reported, but still mirrored.
+type Signatures struct {
+	GPGHome        string
+	AllowedSigners string
+}
+
+// Metrics exports the stats of each run in the Prometheus text format, for
+// runs from cron without a long-lived process to scrape: TextfilePath is a
+// .prom file in the node_exporter textfile collector directory, and
+// PushgatewayURL, e.g. "http://pushgateway:9091", a Pushgateway the metrics
+// are pushed to under Job (default github-repo-mirror).
+type Metrics struct {
+	TextfilePath   string
+	PushgatewayURL string
+	Job            string
+}
+
+// Tracing exports OpenTelemetry spans of each run, its source discoveries
+// and its repos' clones, fetches and repacks to the OTLP/HTTP collector at
+// Endpoint, e.g. "http://localhost:4318", with Headers added to the
+// requests. ServiceName defaults to github-repo-mirror.
+type Tracing struct {
+	Endpoint    string
+	Headers     map[string]string
+	ServiceName string
+}
+
+// Dashboard serves a status page and JSON API on Address in daemon mode, if
+// set, as well as /healthz, which fails when no run finished within two
+// intervals or the last run failed, and /readyz, which fails until the
+// first run finished.
+type Dashboard struct {
+	Address string
+}
+
+// Serve serves the mirrors read-only over git smart HTTP on Address, as
+// http://Address/owner/repo.git, with basic auth if Username is set. With
+// Proxy, a repo that is not mirrored yet is mirrored when it is first
+// requested, using the sources' tokens and filters, and a mirror older than
+// TTL (default "5m") is updated before a clone or fetch is served. Only
+// repos some source includes are mirrored on demand, and private ones only
+// with Username set, so anonymous clients cannot read them with the
+// sources' tokens.
+type Serve struct {
+	Address  string
+	Username string
+	Password string
+	Proxy    bool
+	TTL      string
+}
+
+type Notifications struct {
+	// Threshold is the number of failures in a run at which notifications
+	// are sent. Zero means any failure notifies.
+	Threshold int
+	Slack     *SlackNotification
+	Email     *EmailNotification
+	HTTP      *HTTPNotification
+	Summary   *SummaryNotification
+}
+
+type SlackNotification struct {
+	WebhookURL string
+}
+
+type EmailNotification struct {
+	Host     string
+	Port     int
+	Username string
+	Password string
+	From     string
+	To       []string
+}
+
+// SummaryNotification emails a summary of each run listing new mirrors,
+// updated repos, mirrors whose repos are gone upstream and failures with an
+// excerpt of their error, if anything but unchanged repos happened. With
+// Digest, e.g. "24h", runs are collected in the state file and sent together
+// once the oldest is that old. Template is a text/template file rendering
+// the email body from a report.Summary instead of the built-in text.
+type SummaryNotification struct {
+	Email    EmailNotification
+	Digest   string
+	Template string
+}
+
+type HTTPNotification struct {
+	URL     string
+	Headers map[string]string
+}
+
+// Network configures how the API client and git reach GitHub. Both honor
+// HTTPS_PROXY, HTTP_PROXY and NO_PROXY from the environment; Proxy and
+// NoProxy override them, e.g. "http://proxy:3128" or "socks5://127.0.0.1:1080"
+// with NoProxy "localhost,.internal". CAFile is a PEM bundle the API client
+// trusts in addition to the system roots; git uses it instead of its own
+// bundle, so for a TLS-intercepting proxy it should contain the system roots
+// too. InsecureSkipVerify disables certificate checks, e.g. for a GitHub
+// Enterprise Server with a self-signed certificate. LowSpeedLimit, in bytes
+// per second, makes git abort transfers slower than that for LowSpeedTime
+// (default "60s"), e.g. 1000 to catch stalled links; fetches aborted that way
+// are retried up to LowSpeedRetries (default 2) times, clones up to
+// CloneAttempts.
+type Network struct {
+	Proxy              string
+	NoProxy            string
+	CAFile             string
+	InsecureSkipVerify bool
+	API                APIClient
+	LowSpeedLimit      int
+	LowSpeedTime       string
+	LowSpeedRetries    int
+}
+
+// APIClient tunes the HTTP client of the API. Timeout bounds each request
+// including reading its response, default "2m", except archive downloads.
+// DialTimeout (default "30s"), TLSHandshakeTimeout (default "10s") and
+// ResponseHeaderTimeout (default "1m") bound those phases of any request.
+// Idle connections are kept for IdleConnTimeout (default "90s"), at most
+// MaxIdleConnsPerHost (default 10) of them per host; MaxConnsPerHost caps
+// the connections per host, 0 for no limit. Requests failing with a
+// connection error or a 5xx status are retried up to Retries times
+// (default 3, -1 for none), waiting RetryBackoff (default "1s") doubled
+// after each attempt. Rate limited requests wait for the time GitHub asks
+// for, up to MaxRateLimitWait (default "15m"), and are sent again.
+type APIClient struct {
+	Timeout               string
+	DialTimeout           string
+	TLSHandshakeTimeout   string
+	ResponseHeaderTimeout string
+	IdleConnTimeout       string
+	MaxIdleConnsPerHost   int
+	MaxConnsPerHost       int
+	Retries               int
+	RetryBackoff          string
+	MaxRateLimitWait      string
+}
+
+// Bandwidth caps the transfer rate with GitHub in KiB/s, 0 for no cap.
+// Global is shared by the API client and all git transfers, PerWorker caps
+// each git connection. Git is throttled through a local proxy, so only http
+// and https remotes are capped, and a Network.Proxy must be an http proxy.
+type Bandwidth struct {
+	Global    int
+	PerWorker int
+}
+
+// Pools mirrors repos concurrently so a few huge repos do not hold up the
+// rest: repos whose API-reported size is at least LargeSizeMB (default 1024)
+// go to a pool of Large (default 1) workers, the others to a pool of Small
+// workers, each pool in the run's order. Repos are mirrored one at a time
+// while Small is 0.
+type Pools struct {
+	Small       int
+	Large       int
+	LargeSizeMB int
+}
+
+// Resources lowers the priority of git subprocesses so that background
+// mirroring does not degrade interactive workloads on shared servers.
+type Resources struct {
+	// Nice is the niceness git runs with, 0 leaves it unchanged.
+	Nice int
+	// IONiceClass is the ionice scheduling class: idle, best-effort or
+	// realtime. Empty leaves it unchanged.
+	IONiceClass string
+	// IONiceLevel is the priority within best-effort and realtime classes.
+	IONiceLevel int
+	// Slice runs git in a transient scope of the given systemd slice, so a
+	// cgroup can cap its CPU, memory and IO.
+	Slice string
+}
+
+// Label returns the Name of the source, or its Username if it has none.
+func (s *Source) Label() string {
+	if s.Name != "" {
+		return s.Name
+	}
+	return s.Username
+}
+
+// GitToken returns the token git authenticates with.
+func (s *Source) GitToken() string {
+	if s.Token == "" && len(s.Tokens) > 0 {
+		return s.Tokens[0]
+	}
+	return s.Token
+}
+
+// Load loads the config file at path with Parse.
+func Load(path string) (*Config, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return Parse(b)
+}
+
+// Parse parses the JSON config b, upgrading it to CurrentVersion. Fields the
+// config does not have are an error, to catch typos.
+func Parse(b []byte) (*Config, error) {
+	b, version, deprecations, err := Migrate(b)
+	if err != nil {
+		return nil, err
+	}
+	err = checkFields(b)
+	if err != nil {
+		return nil, err
+	}
+	config := &Config{}
+	err = json.Unmarshal(b, config)
+	if err != nil {
+		return nil, err
+	}
+	config.loadedVersion = version
+	config.deprecations = deprecations
+	return config, nil
+}
+
+// LoadedVersion returns the version of the file the config was loaded
+// from, before it was upgraded.
+func (c *Config) LoadedVersion() int {
+	return c.loadedVersion
+}
+
+// Deprecations returns notes on the deprecated fields of the file the config
+// was loaded from.
+func (c *Config) Deprecations() []string {
+	return c.deprecations
+}
@@ -0,0 +1,182 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+// CurrentVersion is the version of the config format. Files without a
+// Version are version 0.
+const CurrentVersion = 1
+
+// migrations upgrade a config, decoded as JSON objects, from the version of
+// their index to the next. They return a note for each deprecated field
+// they rewrote.
+var migrations = []func(config map[string]any) []string{
+	// Version 1 only adds Version; unversioned files are otherwise current.
+	func(map[string]any) []string { return nil },
+}
+
+// Migrate upgrades the JSON config b to CurrentVersion. It returns the
+// upgraded config, the version b had and a note for each deprecated field
+// rewritten. A current config is returned as is.
+func Migrate(b []byte) ([]byte, int, []string, error) {
+	var config map[string]any
+	err := json.Unmarshal(b, &config)
+	if err != nil {
+		return nil, 0, nil, err
+	}
+	key := "Version"
+	for k := range config {
+		if strings.EqualFold(k, key) {
+			key = k
+		}
+	}
+	version := 0
+	if v, ok := config[key]; ok {
+		f, ok := v.(float64)
+		if !ok || f != float64(int(f)) || f < 0 {
+			return nil, 0, nil, fmt.Errorf("invalid config version %v", v)
+		}
+		version = int(f)
+	}
+	if version > CurrentVersion {
+		return nil, 0, nil, fmt.Errorf("config version %d is newer than %d, the latest this build reads", version, CurrentVersion)
+	}
+	if version == CurrentVersion {
+		return b, version, nil, nil
+	}
+	var notes []string
+	for v := version; v < CurrentVersion; v++ {
+		notes = append(notes, migrations[v](config)...)
+	}
+	delete(config, key)
+	config["Version"] = CurrentVersion
+	b, err = json.MarshalIndent(config, "", "  ")
+	if err != nil {
+		return nil, 0, nil, err
+	}
+	return append(b, '\n'), version, notes, nil
+}
+
+// checkFields returns an error naming the fields of the JSON config b that
+// Config does not have, e.g. "Sources[0].Exlude", with the closest field of
+// the same object if it looks like a typo.
+func checkFields(b []byte) error {
+	var config any
+	err := json.Unmarshal(b, &config)
+	if err != nil {
+		return err
+	}
+	var unknown []string
+	walkFields(config, reflect.TypeOf(Config{}), "", &unknown)
+	if len(unknown) == 0 {
+		return nil
+	}
+	sort.Strings(unknown)
+	return fmt.Errorf("unknown config fields: %s", strings.Join(unknown, ", "))
+}
+
+// walkFields appends the fields of v, a decoded JSON value at path, that
+// type t does not have to unknown.
+func walkFields(v any, t reflect.Type, path string, unknown *[]string) {
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	switch t.Kind() {
+	case reflect.Struct:
+		object, ok := v.(map[string]any)
+		if !ok {
+			return
+		}
+		for key, value := range object {
+			field, ok := lookupField(t, key)
+			name := key
+			if path != "" {
+				name = path + "." + key
+			}
+			if !ok {
+				if suggestion := closestField(t, key); suggestion != "" {
+					name += " (did you mean " + suggestion + "?)"
+				}
+				*unknown = append(*unknown, name)
+				continue
+			}
+			walkFields(value, field.Type, name, unknown)
+		}
+	case reflect.Slice:
+		items, ok := v.([]any)
+		if !ok {
+			return
+		}
+		for i, item := range items {
+			walkFields(item, t.Elem(), fmt.Sprintf("%s[%d]", path, i), unknown)
+		}
+	case reflect.Map:
+		object, ok := v.(map[string]any)
+		if !ok {
+			return
+		}
+		for key, value := range object {
+			walkFields(value, t.Elem(), fmt.Sprintf("%s[%q]", path, key), unknown)
+		}
+	}
+}
+
+// lookupField returns the exported field of t that encoding/json decodes
+// key into: the one named key, or else one whose name matches ignoring
+// case.
+func lookupField(t reflect.Type, key string) (reflect.StructField, bool) {
+	field, ok := t.FieldByName(key)
+	if ok && field.IsExported() {
+		return field, true
+	}
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if field.IsExported() && strings.EqualFold(field.Name, key) {
+			return field, true
+		}
+	}
+	return reflect.StructField{}, false
+}
+
+// closestField returns the field of t within two edits of key, ignoring
+// case, if there is one.
+func closestField(t reflect.Type, key string) string {
+	best, bestDistance := "", 3
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if !field.IsExported() {
+			continue
+		}
+		d := editDistance(strings.ToLower(key), strings.ToLower(field.Name))
+		if d < bestDistance {
+			best, bestDistance = field.Name, d
+		}
+	}
+	return best
+}
+
+// editDistance returns the Levenshtein distance of a and b.
+func editDistance(a, b string) int {
+	previous := make([]int, len(b)+1)
+	for j := range previous {
+		previous[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		current := make([]int, len(b)+1)
+		current[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			current[j] = min(previous[j]+1, current[j-1]+1, previous[j-1]+cost)
+		}
+		previous = current
+	}
+	return previous[len(b)]
+}
@@ -0,0 +1,96 @@
+package config
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestCheckFields(t *testing.T) {
+	tests := []struct {
+		name   string
+		config string
+		err    string
+	}{
+		{"known", `{"Destination": "/m", "Sources": [{"Username": "alice", "Exclude": ["alice/old"]}]}`, ""},
+		{"case insensitive", `{"destination": "/m", "sources": [{"username": "alice"}]}`, ""},
+		{"nested struct", `{"Network": {"Proxy": "http://proxy:3128"}}`, ""},
+		{"map values", `{"Repos": {"alice/proj": {"CloneMode": "blobless"}}}`, ""},
+		{"typo", `{"Destinaton": "/m"}`, "unknown config fields: Destinaton (did you mean Destination?)"},
+		{"typo in slice", `{"Sources": [{"Username": "alice"}, {"Exlude": []}]}`, "unknown config fields: Sources[1].Exlude (did you mean Exclude?)"},
+		{"typo in map", `{"Repos": {"alice/proj": {"CloneMod": "full"}}}`, `unknown config fields: Repos["alice/proj"].CloneMod (did you mean CloneMode?)`},
+		{"no suggestion", `{"Network": {"Frobnicate": true}}`, "unknown config fields: Network.Frobnicate"},
+		{"sorted", `{"Zzz": 1, "Aaa": 2}`, "unknown config fields: Aaa, Zzz"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := checkFields([]byte(test.config))
+			got := ""
+			if err != nil {
+				got = err.Error()
+			}
+			if got != test.err {
+				t.Errorf("checkFields() = %q, want %q", got, test.err)
+			}
+		})
+	}
+}
+
+func TestMigrate(t *testing.T) {
+	tests := []struct {
+		name    string
+		config  string
+		version int
+		err     string
+	}{
+		{"unversioned", `{"Destination": "/m"}`, 0, ""},
+		{"lower case version", `{"version": 0, "Destination": "/m"}`, 0, ""},
+		{"current", `{"Version": 1, "Destination": "/m"}`, 1, ""},
+		{"newer", `{"Version": 2}`, 0, "config version 2 is newer than 1, the latest this build reads"},
+		{"fraction", `{"Version": 0.5}`, 0, "invalid config version 0.5"},
+		{"negative", `{"Version": -1}`, 0, "invalid config version -1"},
+		{"string", `{"Version": "1"}`, 0, "invalid config version 1"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			b, version, _, err := Migrate([]byte(test.config))
+			got := ""
+			if err != nil {
+				got = err.Error()
+			}
+			if got != test.err {
+				t.Fatalf("Migrate() error = %q, want %q", got, test.err)
+			}
+			if err != nil {
+				return
+			}
+			if version != test.version {
+				t.Errorf("Migrate() version = %d, want %d", version, test.version)
+			}
+			var config map[string]any
+			err = json.Unmarshal(b, &config)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if config["Version"] != float64(CurrentVersion) || config["Destination"] != "/m" {
+				t.Errorf("Migrate() = %s, want the current version and the destination kept", b)
+			}
+			if _, ok := config["version"]; ok {
+				t.Errorf("Migrate() = %s, kept the lower case version", b)
+			}
+		})
+	}
+}
+
+func TestParse(t *testing.T) {
+	c, err := Parse([]byte(`{"Destination": "/m"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.Version != CurrentVersion || c.LoadedVersion() != 0 || c.Destination != "/m" {
+		t.Errorf("Parse() = version %d, loaded %d, destination %q", c.Version, c.LoadedVersion(), c.Destination)
+	}
+	_, err = Parse([]byte(`{"Version": 1, "Destinaton": "/m"}`))
+	if err == nil {
+		t.Errorf("Parse() of an unknown field succeeded")
+	}
+}
@@ -0,0 +1,337 @@
+// Package credentials stores tokens in the OS keyring, or in an encrypted
+// file where there is none.
+package credentials
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+)
+
+// Service is the name the tokens are stored under in the keyring.
+const Service = "github-repo-mirror"
+
+// KeyEnv is the environment variable with the passphrase of the encrypted
+// file. Without it a random key is kept in a file next to it.
+const KeyEnv = "GITHUB_REPO_MIRROR_CREDENTIALS_KEY"
+
+// Backends of Open.
+const (
+	BackendAuto    = ""
+	BackendKeyring = "keyring"
+	BackendFile    = "file"
+)
+
+// fileMagic starts the header of the encrypted file.
+const fileMagic = "GRMCRED1"
+
+const (
+	saltSize         = 16
+	pbkdf2Iterations = 600000
+	// minIterations and maxIterations bound the iterations a file may ask
+	// for, so a corrupted header cannot weaken the key or stall reading.
+	minIterations = 100000
+	maxIterations = 10000000
+)
+
+// ErrNotFound is returned by Get for an account without a stored secret.
+var ErrNotFound = errors.New("credential not found")
+
+// Store stores the secret of each account.
+type Store interface {
+	Get(account string) (string, error)
+	Set(account, secret string) error
+}
+
+// Open returns the store of backend. The auto backend is the keyring if
+// its command line tool is installed, secret-tool on Linux and security on
+// macOS, else the encrypted file.
+func Open(backend string) (Store, error) {
+	switch backend {
+	case BackendAuto:
+		if s := keyring(); s != nil {
+			return s, nil
+		}
+		return openFile()
+	case BackendKeyring:
+		if s := keyring(); s != nil {
+			return s, nil
+		}
+		return nil, fmt.Errorf("no keyring tool found on %s", runtime.GOOS)
+	case BackendFile:
+		return openFile()
+	}
+	return nil, fmt.Errorf("unknown credential store %q", backend)
+}
+
+func keyring() Store {
+	switch runtime.GOOS {
+	case "linux", "freebsd", "openbsd":
+		if _, err := exec.LookPath("secret-tool"); err == nil {
+			return secretTool{}
+		}
+	case "darwin":
+		if _, err := exec.LookPath("security"); err == nil {
+			return keychain{}
+		}
+	}
+	return nil
+}
+
+// secretTool stores secrets with libsecret's secret-tool, e.g. in the GNOME
+// keyring or KWallet.
+type secretTool struct{}
+
+func (secretTool) Get(account string) (string, error) {
+	var stdout, stderr bytes.Buffer
+	cmd := exec.Command("secret-tool", "lookup", "service", Service, "account", account)
+	cmd.Stdout, cmd.Stderr = &stdout, &stderr
+	err := cmd.Run()
+	if err != nil {
+		if stderr.Len() == 0 {
+			// secret-tool exits 1 silently if nothing matches.
+			return "", ErrNotFound
+		}
+		return "", fmt.Errorf("secret-tool lookup: %w: %s", err, strings.TrimSpace(stderr.String()))
+	}
+	return strings.TrimRight(stdout.String(), "\n"), nil
+}
+
+func (secretTool) Set(account, secret string) error {
+	cmd := exec.Command("secret-tool", "store", "--label", Service+" "+account, "service", Service, "account", account)
+	cmd.Stdin = strings.NewReader(secret)
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("secret-tool store: %w: %s", err, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
+
+// keychain stores secrets in the macOS login keychain.
+type keychain struct{}
+
+func (keychain) Get(account string) (string, error) {
+	out, err := exec.Command("security", "find-generic-password", "-s", Service, "-a", account, "-w").Output()
+	if err != nil {
+		var exitErr *exec.ExitError
+		// 44 is errSecItemNotFound.
+		if errors.As(err, &exitErr) && exitErr.ExitCode() == 44 {
+			return "", ErrNotFound
+		}
+		return "", fmt.Errorf("security find-generic-password: %w", err)
+	}
+	return strings.TrimRight(string(out), "\n"), nil
+}
+
+func (keychain) Set(account, secret string) error {
+	// Without a value, -w prompts for the secret and its confirmation, so
+	// it is not on the command line for other users to see.
+	cmd := exec.Command("security", "add-generic-password", "-U", "-s", Service, "-a", account, "-w")
+	cmd.Stdin = strings.NewReader(secret + "\n" + secret + "\n")
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("security add-generic-password: %w: %s", err, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
+
+// file stores the secrets as JSON encrypted with AES-256-GCM in the user's
+// config directory. The key is derived from the KeyEnv passphrase with
+// PBKDF2-HMAC-SHA256 and the salt in the file's header, or is a random key
+// in a file of its own, which keeps the secrets out of backups and copies of
+// the credentials file alone.
+//
+// The file is fileMagic, the big-endian PBKDF2 iterations, the salt, the
+// nonce and the sealed secrets. Files written before the header, with a key
+// of the bare SHA-256 of the passphrase, are still read and are rewritten in
+// the current format on the next Set.
+type file struct {
+	dir  string
+	path string
+}
+
+func openFile() (Store, error) {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return nil, err
+	}
+	dir = filepath.Join(dir, Service)
+	return &file{
+		dir:  dir,
+		path: filepath.Join(dir, "credentials"),
+	}, nil
+}
+
+func (f *file) Get(account string) (string, error) {
+	secrets, err := f.read()
+	if err != nil {
+		return "", err
+	}
+	secret, ok := secrets[account]
+	if !ok {
+		return "", ErrNotFound
+	}
+	return secret, nil
+}
+
+func (f *file) Set(account, secret string) error {
+	secrets, err := f.read()
+	if err != nil {
+		return err
+	}
+	secrets[account] = secret
+	b, err := json.Marshal(secrets)
+	if err != nil {
+		return err
+	}
+	header := make([]byte, len(fileMagic)+4+saltSize)
+	copy(header, fileMagic)
+	binary.BigEndian.PutUint32(header[len(fileMagic):], pbkdf2Iterations)
+	salt := header[len(fileMagic)+4:]
+	_, err = io.ReadFull(rand.Reader, salt)
+	if err != nil {
+		return err
+	}
+	gcm, err := f.gcm(salt, pbkdf2Iterations)
+	if err != nil {
+		return err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	_, err = io.ReadFull(rand.Reader, nonce)
+	if err != nil {
+		return err
+	}
+	err = os.MkdirAll(f.dir, 0700)
+	if err != nil {
+		return err
+	}
+	tmp := f.path + ".tmp"
+	err = os.WriteFile(tmp, gcm.Seal(append(header, nonce...), nonce, b, nil), 0600)
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmp, f.path)
+}
+
+func (f *file) read() (map[string]string, error) {
+	secrets := make(map[string]string)
+	b, err := os.ReadFile(f.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return secrets, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	// Without the header, the file predates the salt and its key is the bare
+	// SHA-256 of the passphrase.
+	var salt []byte
+	var iterations int
+	if bytes.HasPrefix(b, []byte(fileMagic)) {
+		if len(b) < len(fileMagic)+4+saltSize {
+			return nil, fmt.Errorf("invalid credentials file %s", f.path)
+		}
+		iterations = int(binary.BigEndian.Uint32(b[len(fileMagic):]))
+		if iterations < minIterations || iterations > maxIterations {
+			return nil, fmt.Errorf("invalid credentials file %s: %d key iterations, want %d to %d", f.path, iterations, minIterations, maxIterations)
+		}
+		salt = b[len(fileMagic)+4 : len(fileMagic)+4+saltSize]
+		b = b[len(fileMagic)+4+saltSize:]
+	}
+	gcm, err := f.gcm(salt, iterations)
+	if err != nil {
+		return nil, err
+	}
+	if len(b) < gcm.NonceSize() {
+		return nil, fmt.Errorf("invalid credentials file %s", f.path)
+	}
+	b, err = gcm.Open(nil, b[:gcm.NonceSize()], b[gcm.NonceSize():], nil)
+	if err != nil {
+		return nil, fmt.Errorf("decrypt %s, wrong %s?: %w", f.path, KeyEnv, err)
+	}
+	err = json.Unmarshal(b, &secrets)
+	if err != nil {
+		return nil, err
+	}
+	return secrets, nil
+}
+
+func (f *file) gcm(salt []byte, iterations int) (cipher.AEAD, error) {
+	key, err := f.key(salt, iterations)
+	if err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// key returns the key of the file, derived from the passphrase with salt,
+// or without a salt in the format before the header, else the random key,
+// created on first use.
+func (f *file) key(salt []byte, iterations int) ([]byte, error) {
+	if passphrase := os.Getenv(KeyEnv); passphrase != "" {
+		if salt == nil {
+			key := sha256.Sum256([]byte(passphrase))
+			return key[:], nil
+		}
+		return pbkdf2([]byte(passphrase), salt, iterations, 32), nil
+	}
+	path := filepath.Join(f.dir, "credentials.key")
+	key, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		key = make([]byte, 32)
+		_, err = rand.Read(key)
+		if err == nil {
+			err = os.MkdirAll(f.dir, 0700)
+		}
+		if err == nil {
+			err = os.WriteFile(path, key, 0600)
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	if len(key) != 32 {
+		return nil, fmt.Errorf("invalid key in %s", path)
+	}
+	return key, nil
+}
+
+// pbkdf2 derives a key of size bytes from password and salt with
+// PBKDF2-HMAC-SHA256, as in RFC 8018.
+func pbkdf2(password, salt []byte, iterations, size int) []byte {
+	prf := hmac.New(sha256.New, password)
+	var key []byte
+	for block := uint32(1); len(key) < size; block++ {
+		prf.Reset()
+		prf.Write(salt)
+		prf.Write(binary.BigEndian.AppendUint32(nil, block))
+		u := prf.Sum(nil)
+		t := append([]byte(nil), u...)
+		for i := 1; i < iterations; i++ {
+			prf.Reset()
+			prf.Write(u)
+			u = prf.Sum(u[:0])
+			for j := range t {
+				t[j] ^= u[j]
+			}
+		}
+		key = append(key, t...)
+	}
+	return key[:size]
+}
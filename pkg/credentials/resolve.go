@@ -0,0 +1,102 @@
+package credentials
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os/exec"
+	"strings"
+)
+
+// Resolve returns the secret a token source reference points to. A
+// reference is scheme:ref, optionally followed by #field:
+//
+//   - keyring:account reads the account from the credential store backend.
+//   - vault:path#field reads field (default "token") of the Vault KV secret
+//     at path with the vault CLI, e.g. vault:kv/github#token.
+//   - aws:secret-id reads the AWS Secrets Manager secret with the aws CLI.
+//   - gcp:secret reads the latest version of the GCP Secret Manager secret
+//     with the gcloud CLI; secret is a name or
+//     projects/<project>/secrets/<name>[/versions/<version>].
+//
+// The aws and gcp secrets are used as is, or with #field as JSON objects
+// holding the token in field. The CLIs authenticate as they are configured
+// to, e.g. with VAULT_ADDR and VAULT_TOKEN or an instance profile.
+func Resolve(ref, backend string) (string, error) {
+	scheme, rest, ok := strings.Cut(ref, ":")
+	if !ok {
+		return "", fmt.Errorf("invalid token source %q, want scheme:ref", ref)
+	}
+	path, field, _ := strings.Cut(rest, "#")
+	var secret string
+	var err error
+	switch scheme {
+	case "keyring":
+		var store Store
+		store, err = Open(backend)
+		if err == nil {
+			secret, err = store.Get(path)
+		}
+		field = ""
+	case "vault":
+		if field == "" {
+			field = "token"
+		}
+		secret, err = run("vault", "kv", "get", "-field="+field, path)
+		field = ""
+	case "aws":
+		secret, err = run("aws", "secretsmanager", "get-secret-value", "--secret-id", path, "--query", "SecretString", "--output", "text")
+	case "gcp":
+		secret, err = run("gcloud", gcpArgs(path)...)
+	default:
+		return "", fmt.Errorf("unknown token source scheme %q", scheme)
+	}
+	if err != nil {
+		return "", err
+	}
+	if field != "" {
+		var fields map[string]any
+		err = json.Unmarshal([]byte(secret), &fields)
+		if err != nil {
+			return "", fmt.Errorf("secret of %s is not a JSON object: %w", scheme, err)
+		}
+		value, ok := fields[field].(string)
+		if !ok {
+			return "", fmt.Errorf("secret of %s has no string field %q", scheme, field)
+		}
+		secret = value
+	}
+	secret = strings.TrimSpace(secret)
+	if secret == "" {
+		return "", fmt.Errorf("empty secret")
+	}
+	return secret, nil
+}
+
+// gcpArgs returns the gcloud arguments that access the version of secret.
+func gcpArgs(secret string) []string {
+	project, version := "", "latest"
+	if parts := strings.Split(secret, "/"); len(parts) >= 4 && parts[0] == "projects" && parts[2] == "secrets" {
+		project, secret = parts[1], parts[3]
+		if len(parts) == 6 && parts[4] == "versions" {
+			version = parts[5]
+		}
+	}
+	args := []string{"secrets", "versions", "access", version, "--secret", secret}
+	if project != "" {
+		args = append(args, "--project", project)
+	}
+	return args
+}
+
+// run runs a secret manager CLI and returns its output.
+func run(name string, args ...string) (string, error) {
+	var stdout, stderr bytes.Buffer
+	cmd := exec.Command(name, args...)
+	cmd.Stdout, cmd.Stderr = &stdout, &stderr
+	err := cmd.Run()
+	if err != nil {
+		return "", fmt.Errorf("%s: %w: %s", name, err, strings.TrimSpace(stderr.String()))
+	}
+	return stdout.String(), nil
+}
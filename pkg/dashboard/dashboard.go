@@ -0,0 +1,166 @@
+// Package dashboard serves a read-only status page and JSON API from the
+// state store.
+package dashboard
+
+import (
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/chamzzzzzz/github-repo-mirror/pkg/state"
+)
+
+// Handler serves the status page at / and the repos and sources at
+// /api/repos and /api/sources, and the state of each repo as Prometheus
+// metrics at /metrics. With Health, it serves the daemon's health at
+// /healthz and its readiness at /readyz.
+type Handler struct {
+	Store  *state.Store
+	Health *Health
+	// MaxStaleness is the age of the last success at which a repo is
+	// stale, zero to not report staleness.
+	MaxStaleness time.Duration
+}
+
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	switch r.URL.Path {
+	case "/":
+		h.page(w)
+	case "/api/repos":
+		writeJSON(w, h.Store.Repos())
+	case "/api/sources":
+		writeJSON(w, h.Store.Sources())
+	case "/metrics":
+		h.metrics(w)
+	case "/healthz":
+		if h.Health == nil {
+			http.NotFound(w, r)
+			return
+		}
+		h.Health.serveHealth(w, func(s *HealthStatus) bool { return s.Healthy })
+	case "/readyz":
+		if h.Health == nil {
+			http.NotFound(w, r)
+			return
+		}
+		h.Health.serveHealth(w, func(s *HealthStatus) bool { return s.Ready })
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func (h *Handler) page(w http.ResponseWriter) {
+	data := struct {
+		Time    time.Time
+		Sources []*state.Source
+		Repos   []*state.Repo
+	}{
+		Time:    time.Now(),
+		Sources: h.Store.Sources(),
+		Repos:   h.Store.Repos(),
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	err := page.Execute(w, data)
+	if err != nil {
+		slog.Error("Failed to render dashboard", "error", err)
+	}
+}
+
+func (h *Handler) metrics(w http.ResponseWriter) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	repos := h.Store.Repos()
+	gauge := func(name, help string, value func(repo *state.Repo) float64) {
+		fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s gauge\n", name, help, name)
+		for _, repo := range repos {
+			fmt.Fprintf(w, "%s{repo=%q,source=%q} %s\n", name, repo.Repo, repo.Source, strconv.FormatFloat(value(repo), 'f', -1, 64))
+		}
+	}
+	gauge("github_repo_mirror_repo_last_success_timestamp_seconds", "Time of the repo's last successful sync.", func(repo *state.Repo) float64 {
+		if repo.LastSuccess.IsZero() {
+			return 0
+		}
+		return float64(repo.LastSuccess.Unix())
+	})
+	gauge("github_repo_mirror_repo_consecutive_failures", "Failed attempts since the repo's last successful sync.", func(repo *state.Repo) float64 {
+		return float64(repo.ConsecutiveFailures)
+	})
+	gauge("github_repo_mirror_repo_size_bytes", "Size of the repo's mirror as of its last successful sync.", func(repo *state.Repo) float64 {
+		return float64(repo.Bytes)
+	})
+	gauge("github_repo_mirror_repo_duration_seconds", "Duration of the repo's last attempt.", func(repo *state.Repo) float64 {
+		return repo.Duration.Seconds()
+	})
+	if h.MaxStaleness > 0 {
+		now := time.Now()
+		gauge("github_repo_mirror_repo_stale", "Whether the repo has not synced successfully within the max staleness.", func(repo *state.Repo) float64 {
+			if stale, _ := repo.Stale(h.MaxStaleness, now); stale {
+				return 1
+			}
+			return 0
+		})
+	}
+}
+
+func writeJSON(w http.ResponseWriter, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	err := json.NewEncoder(w).Encode(v)
+	if err != nil {
+		slog.Error("Failed to write dashboard response", "error", err)
+	}
+}
+
+var page = template.Must(template.New("page").Funcs(template.FuncMap{
+	"time": func(t time.Time) string {
+		if t.IsZero() {
+			return "never"
+		}
+		return t.Format(time.RFC3339)
+	},
+	"size": func(n int64) string {
+		const unit = 1024
+		if n < unit {
+			return fmt.Sprintf("%d B", n)
+		}
+		div, exp := int64(unit), 0
+		for m := n / unit; m >= unit; m /= unit {
+			div *= unit
+			exp++
+		}
+		return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+	},
+}).Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>github-repo-mirror</title>
+<style>
+body { font-family: sans-serif; margin: 2em; }
+table { border-collapse: collapse; margin-bottom: 2em; }
+th, td { border: 1px solid #ccc; padding: 0.3em 0.6em; text-align: left; }
+.failed, .failed_mirror, .failed_update { color: #b00; }
+</style>
+</head>
+<body>
+<h1>github-repo-mirror</h1>
+<p>As of {{time .Time}}</p>
+<h2>Sources</h2>
+<table>
+<tr><th>Source</th><th>Last run</th><th>Repos</th><th>Mirrored</th><th>Updated</th><th>Skipped</th><th>Failed</th><th>Size</th><th>Error</th></tr>
+{{range .Sources}}<tr><td>{{.Name}}</td><td>{{time .LastRun}}</td><td>{{.Repos}}</td><td>{{.Mirrored}}</td><td>{{.Updated}}</td><td>{{.Skipped}}</td><td>{{.Failed}}/{{.FailedMirror}}/{{.FailedUpdate}}</td><td>{{size .Bytes}}</td><td class="failed">{{.Error}}</td></tr>
+{{end}}</table>
+<h2>Repos</h2>
+<table>
+<tr><th>Repo</th><th>Source</th><th>Outcome</th><th>Last success</th><th>Failures</th><th>Size</th><th>Duration</th><th>Last error</th></tr>
+{{range .Repos}}<tr><td>{{.Repo}}</td><td>{{.Source}}</td><td class="{{.Outcome}}">{{.Outcome}}</td><td>{{time .LastSuccess}}</td><td>{{.ConsecutiveFailures}}</td><td>{{size .Bytes}}</td><td>{{.Duration}}</td><td>{{if .LastError}}{{time .LastErrorTime}}: {{.LastError}}{{end}}</td></tr>
+{{end}}</table>
+</body>
+</html>
+`))
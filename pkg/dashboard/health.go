@@ -0,0 +1,112 @@
+package dashboard
+
+import (
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/chamzzzzzz/github-repo-mirror/pkg/report"
+)
+
+// Health tracks the daemon's run loop for /healthz and /readyz.
+type Health struct {
+	mu        sync.Mutex
+	interval  time.Duration
+	started   time.Time
+	lastRun   time.Time
+	lastError string
+	failed    int
+	stale     []string
+}
+
+// HealthStatus is the response of /healthz and /readyz.
+type HealthStatus struct {
+	// Alive means a run finished, or the daemon started, within two run
+	// intervals.
+	Alive bool `json:"alive"`
+	// Healthy means alive and the last run did not fail as a whole.
+	Healthy bool `json:"healthy"`
+	// Ready means a run finished since the daemon started.
+	Ready     bool      `json:"ready"`
+	Started   time.Time `json:"started"`
+	LastRun   time.Time `json:"last_run"`
+	LastError string    `json:"last_error,omitempty"`
+	// Failed counts the failed repos and sources of the last run.
+	Failed int `json:"failed"`
+	// Stale lists the repos without a successful sync within the max
+	// staleness, as of the last run.
+	Stale []string `json:"stale,omitempty"`
+}
+
+func NewHealth(interval time.Duration) *Health {
+	return &Health{
+		interval: interval,
+		started:  time.Now(),
+	}
+}
+
+// SetInterval changes the run interval the daemon is expected to keep.
+func (h *Health) SetInterval(interval time.Duration) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.interval = interval
+}
+
+// Record records the outcome of a run.
+func (h *Health) Record(stats []*report.Stat, err error) {
+	if h == nil {
+		return
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.lastRun = time.Now()
+	h.lastError = ""
+	if err != nil {
+		h.lastError = err.Error()
+	}
+	h.failed = 0
+	h.stale = nil
+	for _, stat := range stats {
+		if stat.Error != "" {
+			h.failed++
+		}
+		for _, result := range stat.Results {
+			if result.Outcome.Failed() {
+				h.failed++
+			}
+		}
+		h.stale = append(h.stale, stat.Stale...)
+	}
+}
+
+// Status returns the current health.
+func (h *Health) Status() *HealthStatus {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	since := h.lastRun
+	if since.IsZero() {
+		since = h.started
+	}
+	alive := time.Since(since) <= 2*h.interval
+	return &HealthStatus{
+		Alive:     alive,
+		Healthy:   alive && h.lastError == "",
+		Ready:     !h.lastRun.IsZero(),
+		Started:   h.started,
+		LastRun:   h.lastRun,
+		LastError: h.lastError,
+		Failed:    h.failed,
+		Stale:     append([]string(nil), h.stale...),
+	}
+}
+
+// serveHealth writes the health status, with status 503 unless ok reports
+// it as passing.
+func (h *Health) serveHealth(w http.ResponseWriter, ok func(*HealthStatus) bool) {
+	status := h.Status()
+	w.Header().Set("Content-Type", "application/json")
+	if !ok(status) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	writeJSON(w, status)
+}
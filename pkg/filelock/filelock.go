@@ -0,0 +1,43 @@
+// Package filelock provides exclusive locks on files across processes.
+package filelock
+
+import (
+	"fmt"
+	"os"
+	"time"
+)
+
+// Lock is a held lock on a file.
+type Lock struct {
+	path string
+	f    *os.File
+}
+
+// self describes this process in the lock files it holds.
+func self() string {
+	host, _ := os.Hostname()
+	return fmt.Sprintf("%d %s", os.Getpid(), host)
+}
+
+// Acquire takes the lock on path like TryAcquire, waiting while another
+// process holds it.
+func Acquire(path string) (*Lock, error) {
+	for {
+		l, _, err := TryAcquire(path)
+		if l != nil || err != nil {
+			return l, err
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+}
+
+// Release removes the lock file and releases the lock.
+func (l *Lock) Release() error {
+	err := os.Remove(l.path)
+	if l.f != nil {
+		if err1 := l.f.Close(); err == nil {
+			err = err1
+		}
+	}
+	return err
+}
@@ -0,0 +1,44 @@
+//go:build !linux && !darwin
+
+package filelock
+
+import (
+	"os"
+	"strings"
+)
+
+// TryAcquire takes the lock on path by creating the file, and writes the PID
+// and host name of this process into it. If another process holds the lock,
+// it returns nil and the holder's PID and host name. A lock left behind by a
+// process that crashed must be removed by hand.
+func TryAcquire(path string) (*Lock, string, error) {
+	for {
+		f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+		if err == nil {
+			_, err = f.WriteString(self() + "\n")
+			if err1 := f.Close(); err == nil {
+				err = err1
+			}
+			if err != nil {
+				os.Remove(path)
+				return nil, "", err
+			}
+			return &Lock{path: path}, "", nil
+		}
+		if !os.IsExist(err) {
+			return nil, "", err
+		}
+		b, err := os.ReadFile(path)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return nil, "", err
+		}
+		owner := strings.TrimSpace(string(b))
+		if owner == "" {
+			owner = "another process"
+		}
+		return nil, owner, nil
+	}
+}
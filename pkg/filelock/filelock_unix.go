@@ -0,0 +1,64 @@
+//go:build linux || darwin
+
+package filelock
+
+import (
+	"io"
+	"os"
+	"strings"
+	"syscall"
+)
+
+// TryAcquire takes the lock on path, creating the file, and writes the PID
+// and host name of this process into it. If another process holds the lock,
+// it returns nil and the holder's PID and host name. The lock is a flock,
+// which the kernel releases when its process exits, so a process that
+// crashed leaves no stale lock behind.
+func TryAcquire(path string) (*Lock, string, error) {
+	for {
+		f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0644)
+		if err != nil {
+			return nil, "", err
+		}
+		err = syscall.Flock(int(f.Fd()), syscall.LOCK_EX|syscall.LOCK_NB)
+		if err == syscall.EWOULDBLOCK {
+			b, _ := io.ReadAll(f)
+			f.Close()
+			owner := strings.TrimSpace(string(b))
+			if owner == "" {
+				owner = "another process"
+			}
+			return nil, owner, nil
+		}
+		if err != nil {
+			f.Close()
+			return nil, "", err
+		}
+		// The previous holder removes the file before releasing it, so the
+		// lock may be on a file no longer at path, which another process
+		// can create and lock anew.
+		locked, err := f.Stat()
+		if err != nil {
+			f.Close()
+			return nil, "", err
+		}
+		current, err := os.Stat(path)
+		if err != nil && !os.IsNotExist(err) {
+			f.Close()
+			return nil, "", err
+		}
+		if err != nil || !os.SameFile(locked, current) {
+			f.Close()
+			continue
+		}
+		err = f.Truncate(0)
+		if err == nil {
+			_, err = f.WriteAt([]byte(self()+"\n"), 0)
+		}
+		if err != nil {
+			f.Close()
+			return nil, "", err
+		}
+		return &Lock{path: path, f: f}, "", nil
+	}
+}
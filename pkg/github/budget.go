@@ -0,0 +1,75 @@
+package github
+
+import (
+	"log/slog"
+	"sync"
+	"time"
+)
+
+// Budget limits the number of API requests per time window. A nil Budget is
+// unlimited.
+type Budget struct {
+	mu     sync.Mutex
+	limit  int
+	window time.Duration
+	start  time.Time
+	used   int
+}
+
+func NewBudget(limit int, window time.Duration) *Budget {
+	return &Budget{
+		limit:  limit,
+		window: window,
+		start:  time.Now(),
+	}
+}
+
+// Wait blocks until a request fits in the current window's budget.
+func (b *Budget) Wait() {
+	if b == nil {
+		return
+	}
+	for {
+		d := b.take()
+		if d == 0 {
+			return
+		}
+		slog.Warn("API budget exhausted", "limit", b.limit, "wait", d.Round(time.Second))
+		time.Sleep(d)
+	}
+}
+
+// take counts a request if it fits in the current window's budget, else
+// returns how long until the next window.
+func (b *Budget) take() time.Duration {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	now := time.Now()
+	if now.Sub(b.start) >= b.window {
+		b.start = now
+		b.used = 0
+	}
+	if b.used < b.limit {
+		b.used++
+		return 0
+	}
+	return b.start.Add(b.window).Sub(now)
+}
+
+// Available returns the requests left in the current window's budget.
+func (b *Budget) Available() int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if time.Since(b.start) >= b.window {
+		return b.limit
+	}
+	return b.limit - b.used
+}
+
+// SetLimit changes the limit, keeping the requests counted in the current
+// window.
+func (b *Budget) SetLimit(limit int) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.limit = limit
+}
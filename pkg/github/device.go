@@ -0,0 +1,107 @@
+package github
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	neturl "net/url"
+	"strings"
+	"time"
+)
+
+// DeviceCode is the code the user enters at VerificationURI to authorize a
+// device flow login.
+type DeviceCode struct {
+	DeviceCode      string `json:"device_code"`
+	UserCode        string `json:"user_code"`
+	VerificationURI string `json:"verification_uri"`
+	ExpiresIn       int    `json:"expires_in"`
+	Interval        int    `json:"interval"`
+}
+
+// deviceURL is the base URL of the device flow endpoints.
+const deviceURL = "https://github.com/login"
+
+// RequestDeviceCode starts a device flow login of the OAuth or GitHub App
+// clientID for scopes, which GitHub Apps ignore.
+func (c *Client) RequestDeviceCode(clientID string, scopes []string) (*DeviceCode, error) {
+	var code DeviceCode
+	err := c.postForm(deviceURL+"/device/code", neturl.Values{
+		"client_id": {clientID},
+		"scope":     {strings.Join(scopes, " ")},
+	}, &code)
+	if err != nil {
+		return nil, err
+	}
+	if code.DeviceCode == "" {
+		return nil, fmt.Errorf("no device code in the response")
+	}
+	return &code, nil
+}
+
+// PollDeviceToken polls for the token of a device flow login at the
+// interval GitHub asks for until the user authorizes it, denies it or the
+// code expires.
+func (c *Client) PollDeviceToken(clientID string, code *DeviceCode) (string, error) {
+	interval := time.Duration(code.Interval) * time.Second
+	if interval <= 0 {
+		interval = 5 * time.Second
+	}
+	expires := time.Now().Add(time.Duration(code.ExpiresIn) * time.Second)
+	for {
+		time.Sleep(interval)
+		var token struct {
+			AccessToken      string `json:"access_token"`
+			Error            string `json:"error"`
+			ErrorDescription string `json:"error_description"`
+			Interval         int    `json:"interval"`
+		}
+		err := c.postForm(deviceURL+"/oauth/access_token", neturl.Values{
+			"client_id":   {clientID},
+			"device_code": {code.DeviceCode},
+			"grant_type":  {"urn:ietf:params:oauth:grant-type:device_code"},
+		}, &token)
+		if err != nil {
+			return "", err
+		}
+		switch token.Error {
+		case "":
+			if token.AccessToken == "" {
+				return "", fmt.Errorf("no access token in the response")
+			}
+			return token.AccessToken, nil
+		case "authorization_pending":
+		case "slow_down":
+			if token.Interval > 0 {
+				interval = time.Duration(token.Interval) * time.Second
+			} else {
+				interval += 5 * time.Second
+			}
+		default:
+			return "", fmt.Errorf("%s: %s", token.Error, token.ErrorDescription)
+		}
+		if code.ExpiresIn > 0 && time.Now().After(expires) {
+			return "", fmt.Errorf("device code expired")
+		}
+	}
+}
+
+// postForm posts the form values to url and decodes the JSON response into
+// out.
+func (c *Client) postForm(url string, values neturl.Values, out any) error {
+	req, err := http.NewRequest("POST", url, strings.NewReader(values.Encode()))
+	if err != nil {
+		return err
+	}
+	req.Header.Add("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Add("Accept", "application/json")
+	resp, err := c.do(c.HTTP, nil, req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return rateLimitError(resp)
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
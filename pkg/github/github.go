@@ -0,0 +1,401 @@
+// Package github lists repos and downloads archives through the GitHub REST
+// API.
+package github
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	neturl "net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/chamzzzzzz/github-repo-mirror/pkg/config"
+)
+
+type Repo struct {
+	Name     string `json:"name"`
+	FullName string `json:"full_name"`
+	Owner    struct {
+		Login string `json:"login"`
+	} `json:"owner"`
+	Private       bool     `json:"private"`
+	Description   string   `json:"description"`
+	Homepage      string   `json:"homepage"`
+	Topics        []string `json:"topics"`
+	DefaultBranch string   `json:"default_branch"`
+	Archived      bool     `json:"archived"`
+	Fork          bool     `json:"fork"`
+	// HasPages is set for repos with GitHub Pages. GraphQL listings do not
+	// include it.
+	HasPages bool `json:"has_pages"`
+	// Parent is the repo a fork was forked from. Only single repo lookups
+	// and GraphQL listings include it.
+	Parent *Repo `json:"parent,omitempty"`
+	// PushedAt is when a commit was last pushed to any branch.
+	PushedAt time.Time `json:"pushed_at"`
+	// Size is the repo size in KB as reported by GitHub.
+	Size int64 `json:"size"`
+	// Language is the repo's primary language, empty if GitHub detected
+	// none.
+	Language string `json:"language"`
+	// Host is the host of repos listed from other services, e.g. Azure
+	// DevOps, empty for github.com.
+	Host string `json:"host,omitempty"`
+}
+
+type Client struct {
+	HTTP *http.Client
+	// Budget limits the metadata requests of exports, e.g. Pages and
+	// profiles, to spread the backfill work of a long-running daemon across
+	// hours instead of exhausting the rate limit. Listing repos is not
+	// limited.
+	Budget *Budget
+	// AnonymousBudget paces unauthenticated API requests.
+	AnonymousBudget *Budget
+	// MaxRateLimitWait is the longest wait for a rate limit before a
+	// request fails, default 15 minutes.
+	MaxRateLimitWait time.Duration
+
+	// pools holds the token pool of each source.
+	pools sync.Map
+}
+
+func NewClient() *Client {
+	return &Client{
+		HTTP: &http.Client{},
+		// GitHub limits unauthenticated requests to 60 per hour per IP,
+		// leave some headroom for other clients on the host.
+		AnonymousBudget: NewBudget(50, time.Hour),
+	}
+}
+
+func (c *Client) get(source *config.Source, url string) (*http.Response, error) {
+	return c.getWith(c.HTTP, source, url)
+}
+
+// getWith is get with another HTTP client.
+func (c *Client) getWith(client *http.Client, source *config.Source, url string) (*http.Response, error) {
+	resp, err := c.send(client, source, url)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		return nil, rateLimitError(resp)
+	}
+	return resp, nil
+}
+
+// send requests url with client and returns the response of any status.
+func (c *Client) send(client *http.Client, source *config.Source, url string) (*http.Response, error) {
+	token := c.token(source)
+	if token == "" {
+		c.AnonymousBudget.Wait()
+	}
+	req, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		return nil, err
+	}
+	if token != "" {
+		req.Header.Add("Authorization", fmt.Sprintf("Bearer %s", token))
+	}
+	req.Header.Add("Accept", "application/vnd.github+json")
+	req.Header.Add("X-GitHub-Api-Version", "2022-11-28")
+	resp, err := c.do(client, source, req)
+	if err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
+
+// pageConcurrency bounds the pages of a listing fetched at the same time.
+const pageConcurrency = 4
+
+// ListRepos lists the repos of source. The first page's Link header tells
+// the number of pages, the remaining pages are fetched concurrently. The
+// repos of an organization with Teams are combined with those of the teams
+// by TeamMode.
+func (c *Client) ListRepos(source *config.Source) ([]*Repo, error) {
+	var repos []*Repo
+	var err error
+	if source.GraphQL {
+		repos, err = c.listReposGraphQL(source)
+	} else {
+		repos, err = c.listPages(source, reposURL(source))
+	}
+	if err != nil || !source.Organization || len(source.Teams) == 0 {
+		return repos, err
+	}
+	return c.combineTeamRepos(source, repos)
+}
+
+// reposURL returns the URL listing the repos of source.
+func reposURL(source *config.Source) string {
+	url := "https://api.github.com/user/repos"
+	if source.Organization {
+		url = "https://api.github.com/orgs/" + source.Username + "/repos"
+	} else if !hasToken(source) {
+		url = "https://api.github.com/users/" + source.Username + "/repos"
+	}
+	query := neturl.Values{}
+	if !source.Organization && hasToken(source) {
+		if source.Affiliation != "" {
+			query.Set("affiliation", source.Affiliation)
+		}
+		if source.Visibility != "" {
+			query.Set("visibility", source.Visibility)
+		}
+	}
+	if len(query) == 0 {
+		return url
+	}
+	return url + "?" + query.Encode()
+}
+
+// listPages lists the repos of all pages of the listing at url.
+func (c *Client) listPages(source *config.Source, url string) ([]*Repo, error) {
+	perPage := 100
+	repos, last, err := c.listRepoPage(source, url, 1, perPage)
+	if err != nil {
+		return nil, err
+	}
+	if last <= 1 {
+		return repos, nil
+	}
+	pages := make([][]*Repo, last+1)
+	errs := make([]error, last+1)
+	sem := make(chan struct{}, pageConcurrency)
+	var wg sync.WaitGroup
+	for page := 2; page <= last; page++ {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(page int) {
+			defer wg.Done()
+			defer func() { <-sem }()
+			pages[page], _, errs[page] = c.listRepoPage(source, url, page, perPage)
+		}(page)
+	}
+	wg.Wait()
+	for page := 2; page <= last; page++ {
+		if errs[page] != nil {
+			return nil, fmt.Errorf("page %d: %w", page, errs[page])
+		}
+		repos = append(repos, pages[page]...)
+	}
+	return repos, nil
+}
+
+// listRepoPage returns a page of the repos of the listing at url and the
+// number of the last page, from the Link header.
+func (c *Client) listRepoPage(source *config.Source, url string, page, perPage int) ([]*Repo, int, error) {
+	u, err := neturl.Parse(url)
+	if err != nil {
+		return nil, 0, err
+	}
+	query := u.Query()
+	query.Set("page", strconv.Itoa(page))
+	query.Set("per_page", strconv.Itoa(perPage))
+	u.RawQuery = query.Encode()
+	resp, err := c.get(source, u.String())
+	if err != nil {
+		return nil, 0, err
+	}
+	defer resp.Body.Close()
+
+	var repos []*Repo
+	err = json.NewDecoder(resp.Body).Decode(&repos)
+	if err != nil {
+		return nil, 0, err
+	}
+	return repos, lastPage(resp.Header.Get("Link"), page), nil
+}
+
+// combineTeamRepos lists the repos of the source's teams and combines them
+// with the organization's repos: by default only the organization's repos
+// that a team can access are kept, with TeamMode union the team repos are
+// added to them.
+func (c *Client) combineTeamRepos(source *config.Source, repos []*Repo) ([]*Repo, error) {
+	mode := source.TeamMode
+	if mode == "" {
+		mode = "intersect"
+	}
+	if mode != "intersect" && mode != "union" {
+		return nil, fmt.Errorf("unknown team mode %q", mode)
+	}
+	var teamRepos []*Repo
+	for _, team := range source.Teams {
+		url := fmt.Sprintf("https://api.github.com/orgs/%s/teams/%s/repos", source.Username, neturl.PathEscape(team))
+		list, err := c.listPages(source, url)
+		if err != nil {
+			return nil, fmt.Errorf("team %s: %w", team, err)
+		}
+		teamRepos = append(teamRepos, list...)
+	}
+	var combined []*Repo
+	if mode == "intersect" {
+		inTeam := make(map[string]bool)
+		for _, repo := range teamRepos {
+			inTeam[strings.ToLower(repo.FullName)] = true
+		}
+		for _, repo := range repos {
+			if inTeam[strings.ToLower(repo.FullName)] {
+				combined = append(combined, repo)
+			}
+		}
+		return combined, nil
+	}
+	seen := make(map[string]bool)
+	for _, repo := range append(repos, teamRepos...) {
+		name := strings.ToLower(repo.FullName)
+		if seen[name] {
+			continue
+		}
+		seen[name] = true
+		combined = append(combined, repo)
+	}
+	return combined, nil
+}
+
+// lastPage returns the page number of the rel="last" link of a Link header,
+// or page if there is none, as on the last page.
+func lastPage(link string, page int) int {
+	for _, part := range strings.Split(link, ",") {
+		target, params, ok := strings.Cut(strings.TrimSpace(part), ";")
+		if !ok || !strings.Contains(params, `rel="last"`) {
+			continue
+		}
+		u, err := neturl.Parse(strings.Trim(strings.TrimSpace(target), "<>"))
+		if err != nil {
+			continue
+		}
+		last, err := strconv.Atoi(u.Query().Get("page"))
+		if err == nil && last > page {
+			return last
+		}
+	}
+	return page
+}
+
+// GetRepo returns the repo with the given full name.
+func (c *Client) GetRepo(source *config.Source, fullName string) (*Repo, error) {
+	resp, err := c.get(source, "https://api.github.com/repos/"+fullName)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var repo Repo
+	err = json.NewDecoder(resp.Body).Decode(&repo)
+	if err != nil {
+		return nil, err
+	}
+	return &repo, nil
+}
+
+// User returns the login of the user the source's token authenticates as.
+func (c *Client) User(source *config.Source) (string, error) {
+	resp, err := c.get(source, "https://api.github.com/user")
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	var user struct {
+		Login string `json:"login"`
+	}
+	err = json.NewDecoder(resp.Body).Decode(&user)
+	if err != nil {
+		return "", err
+	}
+	return user.Login, nil
+}
+
+// CreateRepo creates the repo owner/name, in the organization owner or, if
+// there is no such organization, for the authenticated user. A repo that
+// already exists is not an error.
+func (c *Client) CreateRepo(source *config.Source, owner, name, description string, private bool) error {
+	body, err := json.Marshal(map[string]any{
+		"name":        name,
+		"description": description,
+		"private":     private,
+	})
+	if err != nil {
+		return err
+	}
+	status, reply, err := c.post(source, "https://api.github.com/orgs/"+owner+"/repos", body)
+	if err == nil && status == http.StatusNotFound {
+		status, reply, err = c.post(source, "https://api.github.com/user/repos", body)
+	}
+	if err != nil {
+		return err
+	}
+	switch {
+	case status == http.StatusCreated:
+		return nil
+	case status == http.StatusUnprocessableEntity && bytes.Contains(reply, []byte("name already exists")):
+		// 422 is also returned for other validation errors, e.g. an
+		// invalid name.
+		return nil
+	default:
+		return fmt.Errorf("unexpected status %d: %s", status, bytes.TrimSpace(reply))
+	}
+}
+
+// post sends body to url and returns the status and body of the response.
+func (c *Client) post(source *config.Source, url string, body []byte) (int, []byte, error) {
+	token := c.token(source)
+	req, err := http.NewRequest("POST", url, bytes.NewReader(body))
+	if err != nil {
+		return 0, nil, err
+	}
+	req.Header.Add("Authorization", fmt.Sprintf("Bearer %s", token))
+	req.Header.Add("Accept", "application/vnd.github+json")
+	req.Header.Add("X-GitHub-Api-Version", "2022-11-28")
+	req.Header.Add("Content-Type", "application/json")
+	resp, err := c.do(c.HTTP, source, req)
+	if err != nil {
+		return 0, nil, err
+	}
+	defer resp.Body.Close()
+	reply, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return 0, nil, err
+	}
+	return resp.StatusCode, reply, nil
+}
+
+// DownloadArchive writes the default branch tarball of the repo to path.
+func (c *Client) DownloadArchive(source *config.Source, fullName, path string) error {
+	// Archives of large repos take longer than API requests.
+	client := *c.HTTP
+	client.Timeout = 0
+	resp, err := c.getWith(&client, source, fmt.Sprintf("https://api.github.com/repos/%s/tarball", fullName))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	err = os.MkdirAll(filepath.Dir(path), 0755)
+	if err != nil {
+		return err
+	}
+	f, err := os.Create(path + ".tmp")
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(f, resp.Body)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(path + ".tmp")
+		return err
+	}
+	return os.Rename(path+".tmp", path)
+}
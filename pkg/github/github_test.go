@@ -0,0 +1,40 @@
+package github
+
+import "testing"
+
+func TestLastPage(t *testing.T) {
+	tests := []struct {
+		name string
+		link string
+		page int
+		want int
+	}{
+		{"no header", "", 1, 1},
+		{
+			"first page",
+			`<https://api.github.com/user/repos?per_page=100&page=2>; rel="next", <https://api.github.com/user/repos?per_page=100&page=7>; rel="last"`,
+			1, 7,
+		},
+		{
+			"last first",
+			`<https://api.github.com/orgs/o/repos?page=5>; rel="last", <https://api.github.com/orgs/o/repos?page=2>; rel="next"`,
+			1, 5,
+		},
+		{
+			"last page has no last link",
+			`<https://api.github.com/user/repos?page=1>; rel="first", <https://api.github.com/user/repos?page=6>; rel="prev"`,
+			7, 7,
+		},
+		{"last before page", `<https://api.github.com/user/repos?page=2>; rel="last"`, 3, 3},
+		{"no page parameter", `<https://api.github.com/user/repos>; rel="last"`, 1, 1},
+		{"invalid page", `<https://api.github.com/user/repos?page=x>; rel="last"`, 1, 1},
+		{"no params", `<https://api.github.com/user/repos?page=9>`, 1, 1},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := lastPage(test.link, test.page); got != test.want {
+				t.Errorf("lastPage() = %d, want %d", got, test.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,219 @@
+package github
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/chamzzzzzz/github-repo-mirror/pkg/config"
+)
+
+// repoFields are the fields of a repository node that make up a Repo.
+const repoFields = `
+		pageInfo { hasNextPage endCursor }
+		nodes {
+			name
+			nameWithOwner
+			owner { login }
+			isPrivate
+			isArchived
+			isFork
+			parent { name nameWithOwner owner { login } }
+			diskUsage
+			description
+			homepageUrl
+			pushedAt
+			defaultBranchRef { name }
+			repositoryTopics(first: 20) { nodes { topic { name } } }
+			primaryLanguage { name }
+		}`
+
+const orgReposQuery = `query($login: String!, $cursor: String) {
+	organization(login: $login) {
+		repositories(first: 100, after: $cursor) {` + repoFields + `
+		}
+	}
+}`
+
+const viewerReposQuery = `query($cursor: String, $affiliations: [RepositoryAffiliation], $privacy: RepositoryPrivacy) {
+	viewer {
+		repositories(first: 100, after: $cursor, ownerAffiliations: $affiliations, privacy: $privacy) {` + repoFields + `
+		}
+	}
+}`
+
+type repoConnection struct {
+	PageInfo struct {
+		HasNextPage bool   `json:"hasNextPage"`
+		EndCursor   string `json:"endCursor"`
+	} `json:"pageInfo"`
+	Nodes []struct {
+		Name          string `json:"name"`
+		NameWithOwner string `json:"nameWithOwner"`
+		Owner         struct {
+			Login string `json:"login"`
+		} `json:"owner"`
+		IsPrivate        bool      `json:"isPrivate"`
+		IsArchived       bool      `json:"isArchived"`
+		IsFork           bool      `json:"isFork"`
+		DiskUsage        int64     `json:"diskUsage"`
+		Description      string    `json:"description"`
+		HomepageURL      string    `json:"homepageUrl"`
+		PushedAt         time.Time `json:"pushedAt"`
+		DefaultBranchRef *struct {
+			Name string `json:"name"`
+		} `json:"defaultBranchRef"`
+		RepositoryTopics struct {
+			Nodes []struct {
+				Topic struct {
+					Name string `json:"name"`
+				} `json:"topic"`
+			} `json:"nodes"`
+		} `json:"repositoryTopics"`
+		Parent *struct {
+			Name          string `json:"name"`
+			NameWithOwner string `json:"nameWithOwner"`
+			Owner         struct {
+				Login string `json:"login"`
+			} `json:"owner"`
+		} `json:"parent"`
+		PrimaryLanguage *struct {
+			Name string `json:"name"`
+		} `json:"primaryLanguage"`
+	} `json:"nodes"`
+}
+
+// listReposGraphQL lists the repos of source like ListRepos, with the GraphQL
+// API.
+func (c *Client) listReposGraphQL(source *config.Source) ([]*Repo, error) {
+	if !hasToken(source) {
+		return nil, errors.New("GraphQL discovery requires a token")
+	}
+	query := viewerReposQuery
+	variables := map[string]any{}
+	if source.Organization {
+		query = orgReposQuery
+		variables["login"] = source.Username
+	} else {
+		affiliation := source.Affiliation
+		if affiliation == "" {
+			affiliation = "owner,collaborator,organization_member"
+		}
+		var affiliations []string
+		for _, a := range strings.Split(affiliation, ",") {
+			affiliations = append(affiliations, strings.ToUpper(strings.TrimSpace(a)))
+		}
+		variables["affiliations"] = affiliations
+		if source.Visibility != "" && source.Visibility != "all" {
+			variables["privacy"] = strings.ToUpper(source.Visibility)
+		}
+	}
+	var repos []*Repo
+	for {
+		var data struct {
+			Organization *struct {
+				Repositories repoConnection `json:"repositories"`
+			} `json:"organization"`
+			Viewer *struct {
+				Repositories repoConnection `json:"repositories"`
+			} `json:"viewer"`
+		}
+		err := c.graphql(source, query, variables, &data)
+		if err != nil {
+			return nil, err
+		}
+		var conn *repoConnection
+		switch {
+		case data.Organization != nil:
+			conn = &data.Organization.Repositories
+		case data.Viewer != nil:
+			conn = &data.Viewer.Repositories
+		default:
+			return nil, fmt.Errorf("%s not found", source.Username)
+		}
+		for _, node := range conn.Nodes {
+			repo := &Repo{
+				Name:        node.Name,
+				FullName:    node.NameWithOwner,
+				Private:     node.IsPrivate,
+				Description: node.Description,
+				Homepage:    node.HomepageURL,
+				Archived:    node.IsArchived,
+				Fork:        node.IsFork,
+				PushedAt:    node.PushedAt,
+				Size:        node.DiskUsage,
+			}
+			repo.Owner.Login = node.Owner.Login
+			if node.Parent != nil {
+				repo.Parent = &Repo{
+					Name:     node.Parent.Name,
+					FullName: node.Parent.NameWithOwner,
+				}
+				repo.Parent.Owner.Login = node.Parent.Owner.Login
+			}
+			if node.DefaultBranchRef != nil {
+				repo.DefaultBranch = node.DefaultBranchRef.Name
+			}
+			if node.PrimaryLanguage != nil {
+				repo.Language = node.PrimaryLanguage.Name
+			}
+			for _, topic := range node.RepositoryTopics.Nodes {
+				repo.Topics = append(repo.Topics, topic.Topic.Name)
+			}
+			repos = append(repos, repo)
+		}
+		if !conn.PageInfo.HasNextPage {
+			return repos, nil
+		}
+		variables["cursor"] = conn.PageInfo.EndCursor
+	}
+}
+
+// graphql runs query with variables and decodes the response's data into
+// out.
+func (c *Client) graphql(source *config.Source, query string, variables map[string]any, out any) error {
+	body, err := json.Marshal(map[string]any{
+		"query":     query,
+		"variables": variables,
+	})
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest("POST", "https://api.github.com/graphql", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	token := c.token(source)
+	req.Header.Add("Authorization", fmt.Sprintf("Bearer %s", token))
+	req.Header.Add("Content-Type", "application/json")
+	resp, err := c.do(c.HTTP, source, req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return rateLimitError(resp)
+	}
+	var result struct {
+		Data   json.RawMessage `json:"data"`
+		Errors []struct {
+			Message string `json:"message"`
+		} `json:"errors"`
+	}
+	err = json.NewDecoder(resp.Body).Decode(&result)
+	if err != nil {
+		return err
+	}
+	if len(result.Errors) > 0 {
+		var messages []string
+		for _, e := range result.Errors {
+			messages = append(messages, e.Message)
+		}
+		return fmt.Errorf("graphql: %s", strings.Join(messages, "; "))
+	}
+	return json.Unmarshal(result.Data, out)
+}
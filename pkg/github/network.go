@@ -0,0 +1,185 @@
+package github
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/chamzzzzzz/github-repo-mirror/pkg/config"
+)
+
+// NewHTTPClient returns an HTTP client for the API that uses the proxy, TLS,
+// timeout and connection settings of network and retries failed requests.
+// Its Transport is a *RetryTransport over an *http.Transport.
+func NewHTTPClient(network config.Network) (*http.Client, error) {
+	api := network.API
+	var timeouts [6]time.Duration
+	for i, t := range []struct {
+		name, value string
+		fallback    time.Duration
+	}{
+		{"timeout", api.Timeout, 2 * time.Minute},
+		{"dial timeout", api.DialTimeout, 30 * time.Second},
+		{"TLS handshake timeout", api.TLSHandshakeTimeout, 10 * time.Second},
+		{"response header timeout", api.ResponseHeaderTimeout, time.Minute},
+		{"idle connection timeout", api.IdleConnTimeout, 90 * time.Second},
+		{"retry backoff", api.RetryBackoff, time.Second},
+	} {
+		timeouts[i] = t.fallback
+		if t.value == "" {
+			continue
+		}
+		d, err := time.ParseDuration(t.value)
+		if err != nil {
+			return nil, fmt.Errorf("api %s: %w", t.name, err)
+		}
+		timeouts[i] = d
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	dialer := &net.Dialer{
+		Timeout:   timeouts[1],
+		KeepAlive: 30 * time.Second,
+	}
+	transport.DialContext = dialer.DialContext
+	transport.TLSHandshakeTimeout = timeouts[2]
+	transport.ResponseHeaderTimeout = timeouts[3]
+	transport.IdleConnTimeout = timeouts[4]
+	transport.MaxIdleConnsPerHost = 10
+	if api.MaxIdleConnsPerHost > 0 {
+		transport.MaxIdleConnsPerHost = api.MaxIdleConnsPerHost
+	}
+	transport.MaxConnsPerHost = api.MaxConnsPerHost
+	if network.Proxy != "" {
+		proxy, err := url.Parse(network.Proxy)
+		if err != nil {
+			return nil, fmt.Errorf("proxy: %w", err)
+		}
+		transport.Proxy = func(req *http.Request) (*url.URL, error) {
+			if noProxy(network.NoProxy, req.URL.Host) {
+				return nil, nil
+			}
+			return proxy, nil
+		}
+	}
+	if network.CAFile != "" || network.InsecureSkipVerify {
+		transport.TLSClientConfig = &tls.Config{
+			InsecureSkipVerify: network.InsecureSkipVerify,
+		}
+	}
+	if network.CAFile != "" {
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			pool = x509.NewCertPool()
+		}
+		pem, err := os.ReadFile(network.CAFile)
+		if err != nil {
+			return nil, err
+		}
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates in %s", network.CAFile)
+		}
+		transport.TLSClientConfig.RootCAs = pool
+	}
+	retries := api.Retries
+	if retries == 0 {
+		retries = 3
+	}
+	return &http.Client{
+		Transport: &RetryTransport{
+			Base:    transport,
+			Retries: max(retries, 0),
+			Backoff: timeouts[5],
+		},
+		Timeout: timeouts[0],
+	}, nil
+}
+
+// RetryTransport retries requests that fail with a connection error or a
+// 5xx status, and logs each attempt at debug level. Requests with a body
+// are only retried if it can be replayed.
+type RetryTransport struct {
+	Base http.RoundTripper
+	// Retries is the number of retries after the first attempt.
+	Retries int
+	// Backoff is the wait before the first retry, doubled for each next.
+	Backoff time.Duration
+}
+
+func (t *RetryTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	backoff := t.Backoff
+	for attempt := 1; ; attempt++ {
+		start := time.Now()
+		resp, err := t.Base.RoundTrip(req)
+		logger := slog.With("method", req.Method, "url", req.URL.Redacted(), "attempt", attempt, "duration", time.Since(start))
+		if err != nil {
+			logger.Debug("API request failed", "error", err)
+		} else {
+			logger.Debug("API request", "status", resp.StatusCode)
+		}
+		if attempt > t.Retries || !retryable(resp, err) || req.Context().Err() != nil {
+			return resp, err
+		}
+		if req.Body != nil && req.Body != http.NoBody {
+			if req.GetBody == nil {
+				return resp, err
+			}
+			body, _err := req.GetBody()
+			if _err != nil {
+				return resp, err
+			}
+			req = req.Clone(req.Context())
+			req.Body = body
+		}
+		if resp != nil {
+			io.Copy(io.Discard, resp.Body)
+			resp.Body.Close()
+		}
+		select {
+		case <-time.After(backoff):
+		case <-req.Context().Done():
+			return nil, req.Context().Err()
+		}
+		backoff *= 2
+	}
+}
+
+// retryable reports whether a request that got resp or err may succeed if
+// sent again.
+func retryable(resp *http.Response, err error) bool {
+	if err != nil {
+		return true
+	}
+	return resp.StatusCode >= 500 && resp.StatusCode != http.StatusNotImplemented
+}
+
+// noProxy reports whether host, with an optional port, matches an entry of
+// the comma separated NO_PROXY style list: "*", a host name or a domain,
+// matching its subdomains too.
+func noProxy(list, host string) bool {
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	host = strings.ToLower(host)
+	for _, entry := range strings.Split(list, ",") {
+		entry = strings.ToLower(strings.TrimSpace(entry))
+		if h, _, err := net.SplitHostPort(entry); err == nil {
+			entry = h
+		}
+		entry = strings.TrimPrefix(entry, ".")
+		if entry == "" {
+			continue
+		}
+		if entry == "*" || host == entry || strings.HasSuffix(host, "."+entry) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,45 @@
+package github
+
+import (
+	"encoding/json"
+	"strconv"
+
+	"github.com/chamzzzzzz/github-repo-mirror/pkg/config"
+)
+
+// GetPages returns the GitHub Pages site of a repo as GitHub returns it,
+// nil if Pages is not enabled.
+func (c *Client) GetPages(source *config.Source, fullName string) (json.RawMessage, error) {
+	pages, err := c.readRaw(source, "https://api.github.com/repos/"+fullName+"/pages")
+	if err == errNotFound {
+		return nil, nil
+	}
+	return pages, err
+}
+
+// ListEnvironments returns the deployment environments of a repo, nil if it
+// has none.
+func (c *Client) ListEnvironments(source *config.Source, fullName string) ([]json.RawMessage, error) {
+	var environments []json.RawMessage
+	for page := 1; ; page++ {
+		b, err := c.readRaw(source, "https://api.github.com/repos/"+fullName+"/environments?per_page=100&page="+strconv.Itoa(page))
+		if err == errNotFound {
+			return nil, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		var list struct {
+			TotalCount   int               `json:"total_count"`
+			Environments []json.RawMessage `json:"environments"`
+		}
+		err = json.Unmarshal(b, &list)
+		if err != nil {
+			return nil, err
+		}
+		environments = append(environments, list.Environments...)
+		if len(list.Environments) < 100 || len(environments) >= list.TotalCount {
+			return environments, nil
+		}
+	}
+}
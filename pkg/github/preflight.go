@@ -0,0 +1,164 @@
+package github
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/chamzzzzzz/github-repo-mirror/pkg/config"
+)
+
+// Preflight is what a token can see, as found by CheckToken.
+type Preflight struct {
+	Login string
+	// Kind is the kind of the token, see TokenKind.
+	Kind string
+	// Expires is when the token expires, zero if it does not or GitHub did
+	// not tell.
+	Expires time.Time
+	// Scopes are the OAuth scopes of a classic token, nil for fine-grained
+	// tokens and GitHub App tokens, which have permissions instead.
+	Scopes []string
+	// Warnings describe limits of the token that may hide repos.
+	Warnings []string
+}
+
+// CheckToken checks that the single token of source is valid and can see
+// and read the source's repos. It fails if the token is invalid or cannot
+// read the contents of a repo it lists, e.g. a fine-grained token without
+// contents:read, and warns about limits that may leave repos out of the
+// listing.
+func (c *Client) CheckToken(source *config.Source) (*Preflight, error) {
+	resp, err := c.send(c.HTTP, source, "https://api.github.com/user")
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusUnauthorized {
+		return nil, fmt.Errorf("token is invalid or expired")
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("get user: %w", rateLimitError(resp))
+	}
+	var user struct {
+		Login string `json:"login"`
+	}
+	err = json.NewDecoder(resp.Body).Decode(&user)
+	if err != nil {
+		return nil, err
+	}
+	p := &Preflight{
+		Login: user.Login,
+		Kind:  TokenKind(c.token(source)),
+	}
+	if expires := resp.Header.Get("GitHub-Authentication-Token-Expiration"); expires != "" {
+		p.Expires = parseTokenExpiration(expires)
+		if !p.Expires.IsZero() && time.Until(p.Expires) < 7*24*time.Hour {
+			p.Warnings = append(p.Warnings, fmt.Sprintf("token expires at %s", p.Expires.Format(time.RFC3339)))
+		}
+	}
+	if header, ok := resp.Header["X-Oauth-Scopes"]; ok {
+		p.Scopes = []string{}
+		for _, scope := range strings.Split(strings.Join(header, ","), ",") {
+			if scope = strings.TrimSpace(scope); scope != "" {
+				p.Scopes = append(p.Scopes, scope)
+			}
+		}
+		if !containsString(p.Scopes, "repo") {
+			p.Warnings = append(p.Warnings, "classic token without the repo scope sees public repos only")
+		}
+	}
+	if source.Organization {
+		warning, err := c.checkMembership(source, p)
+		if err != nil {
+			return nil, err
+		}
+		if warning != "" {
+			p.Warnings = append(p.Warnings, warning)
+		}
+	}
+
+	repos, _, err := c.listRepoPage(source, reposURL(source), 1, 1)
+	if err != nil {
+		return nil, fmt.Errorf("list repos: %w", err)
+	}
+	if len(repos) == 0 {
+		p.Warnings = append(p.Warnings, fmt.Sprintf("token sees no repos of %s", source.Username))
+		return p, nil
+	}
+	// A fine-grained token lists the repos of the owner it was created for
+	// whatever the source is.
+	if p.Kind == TokenFineGrained && !strings.EqualFold(repos[0].Owner.Login, source.Username) {
+		p.Warnings = append(p.Warnings, fmt.Sprintf("fine-grained token was created for %s and sees only its repos, not those of %s", repos[0].Owner.Login, source.Username))
+	}
+	// Listings only need metadata access, cloning needs the contents.
+	resp, err = c.send(c.HTTP, source, fmt.Sprintf("https://api.github.com/repos/%s/commits?per_page=1", repos[0].FullName))
+	if err != nil {
+		return nil, err
+	}
+	resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusOK, http.StatusConflict:
+		// 409 is an empty repo.
+	case http.StatusForbidden, http.StatusNotFound:
+		return nil, fmt.Errorf("token cannot read the contents of %s, a fine-grained token needs the contents:read permission", repos[0].FullName)
+	default:
+		return nil, fmt.Errorf("read %s: %w", repos[0].FullName, rateLimitError(resp))
+	}
+	return p, nil
+}
+
+// checkMembership returns a warning if the token's user is not an active
+// member of the organization source, whose private repos it then cannot
+// see.
+func (c *Client) checkMembership(source *config.Source, p *Preflight) (string, error) {
+	resp, err := c.send(c.HTTP, source, "https://api.github.com/user/memberships/orgs/"+source.Username)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusForbidden, http.StatusNotFound:
+		if p.Scopes != nil && !containsString(p.Scopes, "read:org") && !containsString(p.Scopes, "admin:org") {
+			return fmt.Sprintf("classic token without the read:org scope cannot check membership of %s", source.Username), nil
+		}
+		return fmt.Sprintf("%s is not a member of %s or the token cannot read memberships, only its public repos and those shared with the token may be listed", p.Login, source.Username), nil
+	default:
+		return "", fmt.Errorf("get membership: %w", rateLimitError(resp))
+	}
+	var membership struct {
+		State string `json:"state"`
+	}
+	err = json.NewDecoder(resp.Body).Decode(&membership)
+	if err != nil {
+		return "", err
+	}
+	if membership.State != "active" {
+		return fmt.Sprintf("membership of %s in %s is %s", p.Login, source.Username, membership.State), nil
+	}
+	return "", nil
+}
+
+// parseTokenExpiration parses the GitHub-Authentication-Token-Expiration
+// header, e.g. "2024-03-01 00:00:00 UTC", returning zero if it is invalid.
+func parseTokenExpiration(s string) time.Time {
+	for _, layout := range []string{"2006-01-02 15:04:05 MST", "2006-01-02 15:04:05 -0700"} {
+		t, err := time.Parse(layout, s)
+		if err == nil {
+			return t
+		}
+	}
+	return time.Time{}
+}
+
+func containsString(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,119 @@
+package github
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	neturl "net/url"
+	"strconv"
+
+	"github.com/chamzzzzzz/github-repo-mirror/pkg/config"
+)
+
+// ErrUnreadable is returned for profile data the token may not read.
+var ErrUnreadable = errors.New("not readable with this token")
+
+// GetProfile returns the profile of the source's organization or user as
+// GitHub returns it.
+func (c *Client) GetProfile(source *config.Source) (json.RawMessage, error) {
+	url := "https://api.github.com/users/" + source.Username
+	if source.Organization {
+		url = "https://api.github.com/orgs/" + source.Username
+	}
+	return c.readRaw(source, url)
+}
+
+// ListMembers lists the members of the source's organization, only the
+// public ones unless the token's user is a member.
+func (c *Client) ListMembers(source *config.Source) ([]json.RawMessage, error) {
+	return c.listRaw(source, "https://api.github.com/orgs/"+source.Username+"/members")
+}
+
+// ListTeams lists the teams of the source's organization.
+func (c *Client) ListTeams(source *config.Source) ([]json.RawMessage, error) {
+	return c.listRaw(source, "https://api.github.com/orgs/"+source.Username+"/teams")
+}
+
+// ListOrgs returns the logins of the organizations the token's user is a
+// member of, as far as the token may see them.
+func (c *Client) ListOrgs(source *config.Source) ([]string, error) {
+	items, err := c.listRaw(source, "https://api.github.com/user/orgs")
+	if err != nil {
+		return nil, err
+	}
+	var logins []string
+	for _, item := range items {
+		var org struct {
+			Login string `json:"login"`
+		}
+		err := json.Unmarshal(item, &org)
+		if err != nil {
+			return nil, err
+		}
+		logins = append(logins, org.Login)
+	}
+	return logins, nil
+}
+
+// GetBranchProtection returns the protection of a branch, nil if it is not
+// protected.
+func (c *Client) GetBranchProtection(source *config.Source, fullName, branch string) (json.RawMessage, error) {
+	protection, err := c.readRaw(source, fmt.Sprintf("https://api.github.com/repos/%s/branches/%s/protection", fullName, neturl.PathEscape(branch)))
+	if err == errNotFound {
+		return nil, nil
+	}
+	return protection, err
+}
+
+// errNotFound is returned by readRaw for a 404.
+var errNotFound = errors.New("not found")
+
+// readRaw returns the JSON response of url, within the Budget. A 403
+// returns ErrUnreadable and a 404 errNotFound.
+func (c *Client) readRaw(source *config.Source, url string) (json.RawMessage, error) {
+	c.Budget.Wait()
+	resp, err := c.send(c.HTTP, source, url)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusForbidden:
+		return nil, ErrUnreadable
+	case http.StatusNotFound:
+		return nil, errNotFound
+	default:
+		return nil, rateLimitError(resp)
+	}
+	b, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	return json.RawMessage(b), nil
+}
+
+// listRaw returns the items of all pages of the listing at url, in order.
+func (c *Client) listRaw(source *config.Source, url string) ([]json.RawMessage, error) {
+	var items []json.RawMessage
+	for page := 1; ; page++ {
+		b, err := c.readRaw(source, url+"?per_page=100&page="+strconv.Itoa(page))
+		if err == errNotFound {
+			return nil, ErrUnreadable
+		}
+		if err != nil {
+			return nil, err
+		}
+		var list []json.RawMessage
+		err = json.Unmarshal(b, &list)
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, list...)
+		if len(list) < 100 {
+			return items, nil
+		}
+	}
+}
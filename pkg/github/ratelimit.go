@@ -0,0 +1,118 @@
+package github
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/chamzzzzzz/github-repo-mirror/pkg/config"
+)
+
+// defaultMaxRateLimitWait is the longest wait for a rate limit by default.
+const defaultMaxRateLimitWait = 15 * time.Minute
+
+// do sends req with client, waiting out primary and secondary rate limits, up to
+// MaxRateLimitWait each, and sending it again. A limit that needs a longer
+// wait returns its response. With source, the rate limit left is recorded
+// for the request's token, and a token that ran out gives way to another of
+// the source's tokens with some left, without waiting.
+func (c *Client) do(client *http.Client, source *config.Source, req *http.Request) (*http.Response, error) {
+	maxWait := c.MaxRateLimitWait
+	if maxWait == 0 {
+		maxWait = defaultMaxRateLimitWait
+	}
+	// Secondary limits without Retry-After need a wait of at least a
+	// minute, doubled while they persist.
+	backoff := time.Minute
+	for {
+		resp, err := client.Do(req)
+		if err != nil {
+			return nil, err
+		}
+		token := strings.TrimPrefix(req.Header.Get("Authorization"), "Bearer ")
+		if source != nil {
+			c.observe(source, token, resp.Header)
+		}
+		wait, limited := rateLimitWait(resp, &backoff)
+		if !limited {
+			return resp, nil
+		}
+		next := ""
+		if source != nil && token != "" && resp.Header.Get("X-RateLimit-Remaining") == "0" {
+			next = c.retryToken(source, token)
+		}
+		if next == "" && wait > maxWait {
+			return resp, nil
+		}
+		if req.Body != nil && req.Body != http.NoBody {
+			if req.GetBody == nil {
+				return resp, nil
+			}
+			body, err := req.GetBody()
+			if err != nil {
+				return resp, nil
+			}
+			req = req.Clone(req.Context())
+			req.Body = body
+		}
+		resp.Body.Close()
+		if next != "" {
+			req = req.Clone(req.Context())
+			req.Header.Set("Authorization", "Bearer "+next)
+			continue
+		}
+		slog.Warn("API rate limited, waiting", "url", req.URL.Redacted(), "status", resp.StatusCode, "wait", wait.Round(time.Second))
+		select {
+		case <-time.After(wait):
+		case <-req.Context().Done():
+			return nil, req.Context().Err()
+		}
+	}
+}
+
+// rateLimitWait returns how long to wait before retrying a rate limited
+// response, and whether it was rate limited. The body of a 403 response is
+// kept readable for the caller.
+func rateLimitWait(resp *http.Response, backoff *time.Duration) (time.Duration, bool) {
+	if resp.StatusCode != http.StatusForbidden && resp.StatusCode != http.StatusTooManyRequests {
+		return 0, false
+	}
+	if retryAfter := resp.Header.Get("Retry-After"); retryAfter != "" {
+		if seconds, err := strconv.Atoi(retryAfter); err == nil {
+			return time.Duration(seconds) * time.Second, true
+		}
+		if t, err := http.ParseTime(retryAfter); err == nil {
+			return max(time.Until(t), 0), true
+		}
+	}
+	if resp.Header.Get("X-RateLimit-Remaining") == "0" {
+		if reset, err := strconv.ParseInt(resp.Header.Get("X-RateLimit-Reset"), 10, 64); err == nil {
+			return max(time.Until(time.Unix(reset, 0)), 0) + time.Second, true
+		}
+	}
+	// Other 403s are permission errors, unless the message says otherwise.
+	b, _ := io.ReadAll(io.LimitReader(resp.Body, 64<<10))
+	resp.Body.Close()
+	resp.Body = io.NopCloser(bytes.NewReader(b))
+	if resp.StatusCode == http.StatusForbidden && !strings.Contains(strings.ToLower(string(b)), "rate limit") {
+		return 0, false
+	}
+	wait := *backoff
+	*backoff *= 2
+	return wait, true
+}
+
+// rateLimitError describes a response that is still rate limited.
+func rateLimitError(resp *http.Response) error {
+	if reset := resp.Header.Get("X-RateLimit-Reset"); resp.Header.Get("X-RateLimit-Remaining") == "0" && reset != "" {
+		if unix, err := strconv.ParseInt(reset, 10, 64); err == nil {
+			return fmt.Errorf("unexpected status %s, rate limit resets at %s", resp.Status, time.Unix(unix, 0).Format(time.RFC3339))
+		}
+	}
+	return fmt.Errorf("unexpected status %s", resp.Status)
+}
@@ -0,0 +1,82 @@
+package github
+
+import (
+	"io"
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/chamzzzzzz/github-repo-mirror/pkg/config"
+)
+
+// limitedAPI answers with the rate limit left of the request's token in
+// remaining, rate limited for an hour once it is 0.
+type limitedAPI struct {
+	mu        sync.Mutex
+	remaining map[string]int
+	tokens    []string
+}
+
+func (a *limitedAPI) RoundTrip(req *http.Request) (*http.Response, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	token := strings.TrimPrefix(req.Header.Get("Authorization"), "Bearer ")
+	a.tokens = append(a.tokens, token)
+	resp := &http.Response{
+		StatusCode: http.StatusOK,
+		Header:     make(http.Header),
+		Body:       io.NopCloser(strings.NewReader(`{}`)),
+		Request:    req,
+	}
+	remaining := a.remaining[token]
+	resp.Header.Set("X-RateLimit-Remaining", strconv.Itoa(remaining))
+	resp.Header.Set("X-RateLimit-Reset", strconv.FormatInt(time.Now().Add(time.Hour).Unix(), 10))
+	if remaining == 0 {
+		resp.StatusCode = http.StatusForbidden
+		resp.Status = "403 Forbidden"
+		resp.Body = io.NopCloser(strings.NewReader(`{"message": "API rate limit exceeded"}`))
+	}
+	return resp, nil
+}
+
+func TestRateLimitRotatesTokens(t *testing.T) {
+	tests := []struct {
+		name      string
+		tokens    []string
+		remaining map[string]int
+		ok        bool
+		sent      []string
+	}{
+		{"rotates", []string{"a", "b"}, map[string]int{"b": 4000}, true, []string{"a", "b"}},
+		{"skips exhausted", []string{"a", "b", "c"}, map[string]int{"c": 4000}, true, []string{"a", "b", "c"}},
+		{"single token", []string{"a"}, map[string]int{}, false, []string{"a"}},
+		{"all exhausted", []string{"a", "b"}, map[string]int{}, false, []string{"a", "b"}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			api := &limitedAPI{remaining: test.remaining}
+			c := NewClient()
+			c.HTTP = &http.Client{Transport: api}
+			source := &config.Source{Username: "alice", Token: test.tokens[0], Tokens: test.tokens[1:]}
+			resp, err := c.get(source, "https://api.github.com/repos/alice/proj")
+			if err == nil {
+				resp.Body.Close()
+			}
+			if (err == nil) != test.ok {
+				t.Errorf("get() = %v, want ok %v", err, test.ok)
+			}
+			if !reflect.DeepEqual(api.tokens, test.sent) {
+				t.Errorf("sent with tokens %v, want %v", api.tokens, test.sent)
+			}
+			for _, usage := range c.TokenUsage(source) {
+				if usage.Requests != 1 {
+					t.Errorf("token %s sent %d requests, want 1", usage.Token, usage.Requests)
+				}
+			}
+		})
+	}
+}
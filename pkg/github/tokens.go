@@ -0,0 +1,180 @@
+package github
+
+import (
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/chamzzzzzz/github-repo-mirror/pkg/config"
+)
+
+// TokenUsage is the API usage of one of a source's tokens.
+type TokenUsage struct {
+	// Token is the token's last four characters.
+	Token    string `json:"token"`
+	Requests int    `json:"requests"`
+	// Remaining is the rate limit left as of the last response, -1 if
+	// unknown.
+	Remaining int `json:"remaining"`
+}
+
+// tokenPool rotates the requests of a source between its tokens, moving on
+// from a token when its remaining rate limit drops below min.
+type tokenPool struct {
+	mu      sync.Mutex
+	tokens  []string
+	usage   []*TokenUsage
+	current int
+	min     int
+}
+
+func newTokenPool(source *config.Source) *tokenPool {
+	p := &tokenPool{
+		min: source.TokenMinRemaining,
+	}
+	if p.min <= 0 {
+		p.min = 100
+	}
+	for _, token := range append([]string{source.Token}, source.Tokens...) {
+		if token == "" {
+			continue
+		}
+		p.tokens = append(p.tokens, token)
+		p.usage = append(p.usage, &TokenUsage{
+			Token:     redact(token),
+			Remaining: -1,
+		})
+	}
+	return p
+}
+
+// hasToken reports whether source has any token.
+func hasToken(source *config.Source) bool {
+	return source.Token != "" || len(source.Tokens) > 0
+}
+
+func (c *Client) pool(source *config.Source) *tokenPool {
+	p, _ := c.pools.LoadOrStore(source, newTokenPool(source))
+	return p.(*tokenPool)
+}
+
+// token returns the token for the next request of source, "" if it has none.
+func (c *Client) token(source *config.Source) string {
+	p := c.pool(source)
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if len(p.tokens) == 0 {
+		return ""
+	}
+	p.usage[p.current].Requests++
+	return p.tokens[p.current]
+}
+
+// observe records the rate limit left for token from the headers of a
+// response, and rotates to the token with the most left if it is below the
+// pool's minimum.
+func (c *Client) observe(source *config.Source, token string, header http.Header) {
+	remaining, err := strconv.Atoi(header.Get("X-RateLimit-Remaining"))
+	if token == "" || err != nil {
+		return
+	}
+	p := c.pool(source)
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for i, t := range p.tokens {
+		if t == token {
+			p.usage[i].Remaining = remaining
+		}
+	}
+	if p.tokens[p.current] != token || remaining >= p.min || len(p.tokens) == 1 {
+		return
+	}
+	next := p.current
+	for i, usage := range p.usage {
+		if usage.Remaining == -1 || usage.Remaining > p.usage[next].Remaining {
+			next = i
+			if usage.Remaining == -1 {
+				break
+			}
+		}
+	}
+	if next != p.current {
+		slog.Info("Rotating API token", "source", source.Label(), "from", p.usage[p.current].Token, "to", p.usage[next].Token, "remaining", remaining)
+		p.current = next
+	}
+}
+
+// retryToken returns the token to send a request again with that token
+// was rate limited for, if observe rotated to another token with rate limit
+// left, else "".
+func (c *Client) retryToken(source *config.Source, token string) string {
+	p := c.pool(source)
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if len(p.tokens) == 0 || p.tokens[p.current] == token || p.usage[p.current].Remaining == 0 {
+		return ""
+	}
+	p.usage[p.current].Requests++
+	return p.tokens[p.current]
+}
+
+// TokenUsage returns the API usage of each of the source's tokens.
+func (c *Client) TokenUsage(source *config.Source) []*TokenUsage {
+	p := c.pool(source)
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	usage := make([]*TokenUsage, len(p.usage))
+	for i, u := range p.usage {
+		c := *u
+		usage[i] = &c
+	}
+	return usage
+}
+
+func redact(token string) string {
+	if len(token) <= 4 {
+		return "****"
+	}
+	return "..." + token[len(token)-4:]
+}
+
+// Kinds of GitHub tokens, told apart by their prefix.
+const (
+	TokenClassic      = "classic"
+	TokenFineGrained  = "fine-grained"
+	TokenOAuth        = "oauth"
+	TokenAppUser      = "app-user"
+	TokenInstallation = "installation"
+	TokenUnknown      = "unknown"
+)
+
+// TokenKind returns the kind of a GitHub token. Classic tokens have OAuth
+// scopes, the others permissions; a fine-grained token can only see the
+// repos of the one owner it was created for.
+func TokenKind(token string) string {
+	switch {
+	case strings.HasPrefix(token, "ghp_"):
+		return TokenClassic
+	case strings.HasPrefix(token, "github_pat_"):
+		return TokenFineGrained
+	case strings.HasPrefix(token, "gho_"):
+		return TokenOAuth
+	case strings.HasPrefix(token, "ghu_"):
+		return TokenAppUser
+	case strings.HasPrefix(token, "ghs_"):
+		return TokenInstallation
+	}
+	return TokenUnknown
+}
+
+// GitUsername returns the username git authenticates with token as.
+// Installation tokens only work as x-access-token, the other kinds with any
+// username, the source's by default.
+func GitUsername(source *config.Source, token string) string {
+	if TokenKind(token) == TokenInstallation {
+		return "x-access-token"
+	}
+	return source.Username
+}
@@ -0,0 +1,77 @@
+package gitmirror
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/chamzzzzzz/github-repo-mirror/pkg/config"
+	"github.com/chamzzzzzz/github-repo-mirror/pkg/github"
+	"github.com/chamzzzzzz/github-repo-mirror/pkg/report"
+)
+
+// MirrorRepo mirrors or updates the single repo with full name fullName,
+// outside of a run. The repo is looked up with the source named sourceName,
+// by default the source of the repo's owner, else the first source, whose
+// destination, auth and filters apply. It returns a Partial stat of the
+// source with just the repo's result.
+func (m *Mirrorer) MirrorRepo(fullName, sourceName string) (*report.Stat, error) {
+	owner, _, ok := strings.Cut(fullName, "/")
+	if !ok {
+		return nil, fmt.Errorf("repo %q is not owner/name", fullName)
+	}
+	source, err := m.repoSource(owner, sourceName)
+	if err != nil {
+		return nil, err
+	}
+	if source.Type == SourceAzureDevOps {
+		return nil, fmt.Errorf("source %s: adding repos of %s sources is not supported", source.Label(), source.Type)
+	}
+	repo, err := m.Client.GetRepo(source, fullName)
+	if err != nil {
+		return nil, err
+	}
+	return m.syncRepo(source, repo)
+}
+
+// syncRepo mirrors or updates the repo of source like a run does, and
+// returns a Partial stat of the source with just the repo's result.
+func (m *Mirrorer) syncRepo(source *config.Source, repo *github.Repo) (*report.Stat, error) {
+	stat := &report.Stat{
+		Source:  source,
+		Name:    source.Label(),
+		Repos:   []*github.Repo{repo},
+		Partial: true,
+	}
+	j := &job{stat, repo}
+	collided, err := m.collisions([]*job{j}, true)
+	if err != nil {
+		return nil, err
+	}
+	if owner, ok := collided[j]; ok {
+		stat.Add(m.collision(j, owner))
+		return stat, nil
+	}
+	logger := m.Logger.With("source", source.Label(), "repo", repo.FullName)
+	stat.Add(m.Mirror(source, repo, logger))
+	return stat, nil
+}
+
+// repoSource returns the source named name, or if name is empty the source
+// of owner, else the first source.
+func (m *Mirrorer) repoSource(owner, name string) (*config.Source, error) {
+	if len(m.Config.Sources) == 0 {
+		return nil, fmt.Errorf("no sources configured")
+	}
+	for _, source := range m.Config.Sources {
+		if name != "" && source.Label() == name {
+			return source, nil
+		}
+		if name == "" && strings.EqualFold(source.Username, owner) {
+			return source, nil
+		}
+	}
+	if name != "" {
+		return nil, fmt.Errorf("unknown source %q", name)
+	}
+	return m.Config.Sources[0], nil
+}
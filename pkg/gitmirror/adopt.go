@@ -0,0 +1,147 @@
+package gitmirror
+
+import (
+	"fmt"
+	"log/slog"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/chamzzzzzz/github-repo-mirror/pkg/config"
+	"github.com/chamzzzzzz/github-repo-mirror/pkg/github"
+	"github.com/chamzzzzzz/github-repo-mirror/pkg/report"
+)
+
+// Adoption is an existing bare repo matched to an upstream repo.
+type Adoption struct {
+	Source *config.Source
+	Repo   *github.Repo
+	// Local is where the bare repo is, Target where the repo is mirrored
+	// to. They differ if the bare repo was matched by its remote URL.
+	Local  string
+	Target string
+}
+
+// AdoptCandidates matches the bare repos under the destinations against the
+// repos in stats, first by path and then by their origin remote, which may
+// be a GitHub URL or a rewritten one. It returns the matches and the bare
+// repos that match no repo.
+func (m *Mirrorer) AdoptCandidates(stats []*report.Stat) ([]*Adoption, []string, error) {
+	locals, err := m.LocalMirrors()
+	if err != nil {
+		return nil, nil, err
+	}
+	byPath := make(map[string]*Adoption)
+	byName := make(map[string]*Adoption)
+	for _, stat := range stats {
+		for _, repo := range stat.Repos {
+			a := &Adoption{
+				Source: stat.Source,
+				Repo:   repo,
+				Target: m.LocalPath(stat.Source, repo),
+			}
+			byPath[a.Target] = a
+			byName[strings.ToLower(repo.FullName)] = a
+			byName[normalizeURL(Rewrite(m.Config.Rewrites, RepoRemote(repo)))] = a
+		}
+	}
+	var adoptions []*Adoption
+	var unknown []string
+	adopted := make(map[*Adoption]bool)
+	var unmatched []string
+	for _, local := range locals {
+		if a, ok := byPath[local]; ok {
+			a.Local = local
+			adopted[a] = true
+			adoptions = append(adoptions, a)
+			continue
+		}
+		unmatched = append(unmatched, local)
+	}
+	for _, local := range unmatched {
+		remote, _ := m.Git.ConfigValue(local, "remote.origin.url")
+		a, ok := byName[strings.ToLower(repoFromURL(remote))]
+		if !ok {
+			a, ok = byName[normalizeURL(remote)]
+		}
+		if !ok || adopted[a] {
+			unknown = append(unknown, local)
+			continue
+		}
+		a.Local = local
+		adopted[a] = true
+		adoptions = append(adoptions, a)
+	}
+	return adoptions, unknown, nil
+}
+
+// Adopt moves the bare repo of a to its target, unless a mirror already
+// exists there, and configures it like a mirror created by this tool.
+func (m *Mirrorer) Adopt(a *Adoption, logger *slog.Logger) error {
+	if a.Local != a.Target {
+		if _, err := os.Stat(a.Target); err == nil {
+			return fmt.Errorf("%s already exists", a.Target)
+		}
+		err := os.MkdirAll(filepath.Dir(a.Target), 0755)
+		if err != nil {
+			return err
+		}
+		err = os.Rename(a.Local, a.Target)
+		if err != nil {
+			return err
+		}
+		logger.Info("Moved", "from", a.Local, "to", a.Target)
+	}
+	local := a.Target
+	options, err := m.CloneOptions(a.Source, a.Repo.FullName)
+	if err != nil {
+		return err
+	}
+	err = m.configureGC(local, a.Repo.FullName)
+	if err != nil {
+		return err
+	}
+	err = m.Git.Config(local, "remote.origin.url", m.FetchURL(a.Source, a.Repo))
+	if err != nil {
+		return err
+	}
+	err = m.Git.Config(local, "remote.origin.mirror", "true")
+	if err != nil {
+		return err
+	}
+	err = m.applyRefspecs(local, options.Refspecs, logger)
+	if err != nil {
+		return err
+	}
+	err = touch(local)
+	if err != nil {
+		return err
+	}
+	m.writeMetadata(local, a.Repo, logger)
+	return nil
+}
+
+func normalizeURL(remote string) string {
+	return strings.ToLower(strings.TrimSuffix(strings.TrimSuffix(remote, "/"), ".git"))
+}
+
+// repoFromURL returns the "owner/repo" name of a GitHub clone URL, or "" if
+// url is not one.
+func repoFromURL(remote string) string {
+	var path string
+	if rest, ok := strings.CutPrefix(remote, "git@github.com:"); ok {
+		path = rest
+	} else {
+		u, err := url.Parse(remote)
+		if err != nil || !strings.EqualFold(u.Hostname(), "github.com") {
+			return ""
+		}
+		path = u.Path
+	}
+	path = strings.TrimSuffix(strings.Trim(path, "/"), ".git")
+	if strings.Count(path, "/") != 1 {
+		return ""
+	}
+	return path
+}
@@ -0,0 +1,103 @@
+package gitmirror
+
+import (
+	"bufio"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/chamzzzzzz/github-repo-mirror/pkg/config"
+	"github.com/chamzzzzzz/github-repo-mirror/pkg/github"
+)
+
+// alternatesFile lists the object directories a mirror borrows objects from.
+const alternatesFile = "objects/info/alternates"
+
+// parentMirror returns the local mirror of a fork's parent, which a new
+// mirror of the fork borrows objects from. It returns "" if the repo is not
+// a fork, ShareForkObjects is off or the parent has no mirror yet.
+func (m *Mirrorer) parentMirror(source *config.Source, repo *github.Repo) (string, error) {
+	if !m.Config.ShareForkObjects || !repo.Fork {
+		return "", nil
+	}
+	parent := repo.Parent
+	if parent == nil {
+		// Listings do not include the parent.
+		r, err := m.Client.GetRepo(source, repo.FullName)
+		if err != nil {
+			return "", err
+		}
+		parent = r.Parent
+	}
+	if parent == nil {
+		return "", nil
+	}
+	// The parent may belong to another source with another layout.
+	for _, s := range append([]*config.Source{source}, m.Config.Sources...) {
+		local := m.LocalPath(s, parent)
+		if isBare(local) {
+			return local, nil
+		}
+	}
+	return "", nil
+}
+
+// keepParentObjects sets gc.pruneExpire of the parent mirror to never, under
+// its lock, so the parent's own fetch or gc does not race the config write.
+func (m *Mirrorer) keepParentObjects(parent string) error {
+	if value, err := m.Git.ConfigValue(parent, "gc.pruneExpire"); err == nil && value == "never" {
+		return nil
+	}
+	unlock, err := m.LockRepo(parent)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	return m.Git.Config(parent, "gc.pruneExpire", "never")
+}
+
+// alternates returns the object directories the mirror at local borrows
+// objects from.
+func alternates(local string) ([]string, error) {
+	f, err := os.Open(filepath.Join(local, alternatesFile))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var dirs []string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if !filepath.IsAbs(line) {
+			line = filepath.Join(local, "objects", line)
+		}
+		dirs = append(dirs, filepath.Clean(line))
+	}
+	return dirs, scanner.Err()
+}
+
+// Dependents returns the mirrors among locals that borrow objects from the
+// mirror at local, and break if it is removed or moved.
+func Dependents(local string, locals []string) ([]string, error) {
+	objects := filepath.Clean(filepath.Join(local, "objects"))
+	var dependents []string
+	for _, l := range locals {
+		dirs, err := alternates(l)
+		if err != nil {
+			return nil, err
+		}
+		for _, dir := range dirs {
+			if dir == objects {
+				dependents = append(dependents, l)
+				break
+			}
+		}
+	}
+	return dependents, nil
+}
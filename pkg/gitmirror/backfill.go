@@ -0,0 +1,136 @@
+package gitmirror
+
+import (
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/chamzzzzzz/github-repo-mirror/pkg/config"
+	"github.com/chamzzzzzz/github-repo-mirror/pkg/github"
+	"github.com/chamzzzzzz/github-repo-mirror/pkg/report"
+)
+
+// backfillFile is touched inside each mirror whenever its metadata exports
+// ran, so the mirrors exported longest ago are backfilled first.
+const backfillFile = "mirror-backfill"
+
+// backfill is a metadata export of a synced mirror waiting for the API
+// budget.
+type backfill struct {
+	source  *config.Source
+	repo    *github.Repo
+	local   string
+	options *CloneOptions
+	logger  *slog.Logger
+}
+
+// exportMetadata runs the metadata exports of the repo synced at local. With
+// an API budget, they are queued instead and run by runBackfill after the
+// repos are synced.
+func (m *Mirrorer) exportMetadata(source *config.Source, repo *github.Repo, local string, options *CloneOptions, logger *slog.Logger) {
+	if m.Client.Budget == nil {
+		m.exportPages(source, repo, local, options, logger)
+		m.markBackfill(local, logger)
+		return
+	}
+	m.backfillMu.Lock()
+	defer m.backfillMu.Unlock()
+	if m.backfill == nil {
+		m.backfill = make(map[string]*backfill)
+	}
+	m.backfill[local] = &backfill{source, repo, local, options, logger}
+}
+
+// runBackfill runs the queued metadata exports and those of the mirrors of
+// the repos of stats never exported, e.g. since Pages was enabled, those
+// never exported first, then those exported longest ago. With an API
+// budget, it stops when the budget of the current window is used up; the
+// rest stay queued for the next run.
+func (m *Mirrorer) runBackfill(stats []*report.Stat) {
+	if !m.Config.Pages.Enabled || m.DryRun || m.Discovery == DiscoveryOffline {
+		return
+	}
+	m.backfillMu.Lock()
+	queued := make(map[string]*backfill)
+	for local, b := range m.backfill {
+		queued[local] = b
+	}
+	m.backfillMu.Unlock()
+	for _, stat := range stats {
+		for _, repo := range stat.Repos {
+			local := m.LocalPath(stat.Source, repo)
+			if stat.Source.Type == SourceAzureDevOps || queued[local] != nil || !isBare(local) || !lastBackfill(local).IsZero() {
+				continue
+			}
+			logger := m.Logger.With("source", stat.Name, "repo", repo.FullName)
+			options, err := m.CloneOptions(stat.Source, repo.FullName)
+			if err != nil {
+				logger.Warn("Failed to get clone options for backfill", "error", err)
+				continue
+			}
+			queued[local] = &backfill{stat.Source, repo, local, options, logger}
+		}
+	}
+	var queue []*backfill
+	last := make(map[string]time.Time)
+	for local, b := range queued {
+		queue = append(queue, b)
+		last[local] = lastBackfill(local)
+	}
+	sort.Slice(queue, func(i, j int) bool { return queue[i].local < queue[j].local })
+	sort.SliceStable(queue, func(i, j int) bool { return last[queue[i].local].Before(last[queue[j].local]) })
+	for i, b := range queue {
+		if m.Client.Budget != nil && m.Client.Budget.Available() <= 0 {
+			m.Logger.Info("Deferred metadata backfill to the next API budget window", "repos", len(queue)-i)
+			return
+		}
+		unlock, err := m.LockRepo(b.local)
+		if err != nil {
+			b.logger.Warn("Failed to lock mirror for backfill", "error", err)
+			continue
+		}
+		m.exportPages(b.source, b.repo, b.local, b.options, b.logger)
+		m.markBackfill(b.local, b.logger)
+		unlock()
+		m.backfillMu.Lock()
+		// An update may have queued the mirror again meanwhile.
+		if m.backfill[b.local] == b {
+			delete(m.backfill, b.local)
+		}
+		m.backfillMu.Unlock()
+	}
+}
+
+// markBackfill records that the metadata of the mirror at local was just
+// exported.
+func (m *Mirrorer) markBackfill(local string, logger *slog.Logger) {
+	if !m.Config.Pages.Enabled {
+		return
+	}
+	err := touchBackfill(local)
+	if err != nil {
+		logger.Warn("Failed to mark backfill", "error", err)
+	}
+}
+
+// lastBackfill returns when the metadata of the mirror at local was last
+// exported, zero if never.
+func lastBackfill(local string) time.Time {
+	fi, err := os.Stat(filepath.Join(local, backfillFile))
+	if err != nil {
+		return time.Time{}
+	}
+	return fi.ModTime()
+}
+
+func touchBackfill(local string) error {
+	path := filepath.Join(local, backfillFile)
+	now := time.Now()
+	err := os.Chtimes(path, now, now)
+	if os.IsNotExist(err) {
+		return os.WriteFile(path, nil, 0644)
+	}
+	return err
+}
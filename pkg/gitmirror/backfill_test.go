@@ -0,0 +1,96 @@
+package gitmirror
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/chamzzzzzz/github-repo-mirror/pkg/config"
+	"github.com/chamzzzzzz/github-repo-mirror/pkg/github"
+	"github.com/chamzzzzzz/github-repo-mirror/pkg/state"
+)
+
+func TestBackfill(t *testing.T) {
+	c := &config.Config{Sources: []*config.Source{{Username: "alice"}}, SkipUnchanged: true}
+	c.Pages.Enabled = true
+	m := newTestMirrorer(t, c)
+	store, err := state.Open(filepath.Join(t.TempDir(), "state.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	m.State = store
+	m.push(t, "alice/proj", nil, false)
+	stat, err := m.Update(c.Sources[0], m.api.add("alice/proj", time.Now(), true))
+	if err != nil {
+		t.Fatal(err)
+	}
+	marker := filepath.Join(stat.Results[0].Local, backfillFile)
+	exported := func() bool {
+		_, err := os.Stat(marker)
+		return err == nil
+	}
+	if !exported() {
+		t.Fatalf("Update() did not export the metadata without an API budget")
+	}
+	run := func() {
+		t.Helper()
+		_, err := m.Run()
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// The mirror is unchanged, so only the backfill exports it, e.g. after
+	// Pages was enabled.
+	os.Remove(marker)
+	m.Client.Budget = github.NewBudget(1, time.Hour)
+	m.Client.Budget.Wait()
+	run()
+	if exported() {
+		t.Errorf("Run() exported the metadata with the API budget used up")
+	}
+	m.Client.Budget = github.NewBudget(1, time.Hour)
+	run()
+	if !exported() {
+		t.Errorf("Run() did not export the metadata of the unchanged mirror in the next budget window")
+	}
+
+	// Runs without a budget backfill too.
+	os.Remove(marker)
+	m.Client.Budget = nil
+	run()
+	if !exported() {
+		t.Errorf("Run() did not export the metadata of the unchanged mirror without an API budget")
+	}
+}
+
+func TestBackfillKeepsDeferred(t *testing.T) {
+	c := &config.Config{Sources: []*config.Source{{Username: "alice"}}}
+	c.Pages.Enabled = true
+	m := newTestMirrorer(t, c)
+	m.Client.Budget = github.NewBudget(1, time.Hour)
+	m.Client.Budget.Wait()
+	for _, name := range []string{"alice/one", "alice/two"} {
+		m.push(t, name, nil, false)
+		m.api.add(name, time.Now(), true)
+	}
+	_, err := m.Run()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(m.backfill) != 2 {
+		t.Fatalf("queued %d backfills after the run, want the 2 deferred", len(m.backfill))
+	}
+	m.Client.Budget = github.NewBudget(10, time.Hour)
+	m.runBackfill(nil)
+	if len(m.backfill) != 0 {
+		t.Errorf("queued %d backfills, want none", len(m.backfill))
+	}
+	for _, name := range []string{"alice/one", "alice/two"} {
+		local, _ := m.FindLocal(name)
+		if lastBackfill(local).IsZero() {
+			t.Errorf("%s was not backfilled", name)
+		}
+	}
+}
@@ -0,0 +1,48 @@
+package gitmirror
+
+import (
+	"time"
+)
+
+// nextRetry returns when the repo with full name fullName is retried if it
+// is quarantined by Backoff, or the zero time otherwise.
+func (m *Mirrorer) nextRetry(fullName string) (time.Time, error) {
+	backoff := m.Config.Backoff
+	if !backoff.Enabled || m.State == nil {
+		return time.Time{}, nil
+	}
+	last, ok := m.State.Repo(fullName)
+	if !ok {
+		return time.Time{}, nil
+	}
+	threshold := backoff.Threshold
+	if threshold <= 0 {
+		threshold = 3
+	}
+	if last.ConsecutiveFailures < threshold {
+		return time.Time{}, nil
+	}
+	initial, err := parseDuration(backoff.Initial, time.Hour)
+	if err != nil {
+		return time.Time{}, err
+	}
+	max, err := parseDuration(backoff.Max, 7*24*time.Hour)
+	if err != nil {
+		return time.Time{}, err
+	}
+	delay := initial
+	for i := threshold; i < last.ConsecutiveFailures && delay < max; i++ {
+		delay *= 2
+	}
+	if delay > max {
+		delay = max
+	}
+	return last.LastErrorTime.Add(delay), nil
+}
+
+func parseDuration(s string, def time.Duration) (time.Duration, error) {
+	if s == "" {
+		return def, nil
+	}
+	return time.ParseDuration(s)
+}
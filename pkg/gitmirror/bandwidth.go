@@ -0,0 +1,253 @@
+package gitmirror
+
+import (
+	"bufio"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+)
+
+// limiter is a token bucket pacing transfers to rate bytes per second. A nil
+// limiter does not limit.
+type limiter struct {
+	mu     sync.Mutex
+	rate   float64
+	tokens float64
+	last   time.Time
+}
+
+func newLimiter(kibps int) *limiter {
+	if kibps <= 0 {
+		return nil
+	}
+	return &limiter{
+		rate: float64(kibps) * 1024,
+		last: time.Now(),
+	}
+}
+
+// wait blocks until n bytes fit in the rate.
+func (l *limiter) wait(n int) {
+	if l == nil {
+		return
+	}
+	l.mu.Lock()
+	now := time.Now()
+	// Allow bursts of up to a second's worth of bytes.
+	l.tokens = min(l.tokens+now.Sub(l.last).Seconds()*l.rate, l.rate)
+	l.last = now
+	l.tokens -= float64(n)
+	d := time.Duration(-l.tokens / l.rate * float64(time.Second))
+	l.mu.Unlock()
+	if d > 0 {
+		time.Sleep(d)
+	}
+}
+
+// throttledReader reads from r at the rate of all limiters.
+type throttledReader struct {
+	r        io.Reader
+	limiters []*limiter
+}
+
+func (t *throttledReader) Read(p []byte) (int, error) {
+	if len(p) > 32*1024 {
+		p = p[:32*1024]
+	}
+	n, err := t.r.Read(p)
+	for _, l := range t.limiters {
+		l.wait(n)
+	}
+	return n, err
+}
+
+type throttledBody struct {
+	io.Reader
+	io.Closer
+}
+
+// throttledTransport paces the response bodies of an API client.
+type throttledTransport struct {
+	base    http.RoundTripper
+	limiter *limiter
+}
+
+func (t *throttledTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	resp, err := t.base.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+	resp.Body = &throttledBody{
+		Reader: &throttledReader{r: resp.Body, limiters: []*limiter{t.limiter}},
+		Closer: resp.Body,
+	}
+	return resp, nil
+}
+
+// throttlingProxy is a local HTTP proxy for git that paces the tunneled
+// bytes, in both directions, to a global rate shared by all connections and
+// a rate per connection. It forwards to the upstream proxy returned by
+// upstream, if any. Only clients with the random credential of the proxy's
+// URL may use it, so other local users cannot use it as an open proxy.
+type throttlingProxy struct {
+	global    *limiter
+	perWorker int
+	upstream  func(*http.Request) (*url.URL, error)
+
+	// auth is the expected Proxy-Authorization header.
+	auth string
+	// transport forwards plain http requests.
+	transport *http.Transport
+}
+
+// startThrottlingProxy listens on a loopback port and returns the proxy's
+// URL, with the credential clients must send, and a function that stops
+// the proxy. Tunnels already open when it stops run until they end.
+func startThrottlingProxy(p *throttlingProxy) (string, func() error, error) {
+	secret := make([]byte, 16)
+	_, err := rand.Read(secret)
+	if err != nil {
+		return "", nil, err
+	}
+	password := hex.EncodeToString(secret)
+	p.auth = "Basic " + base64.StdEncoding.EncodeToString([]byte("git:"+password))
+	p.transport = &http.Transport{Proxy: p.upstream}
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return "", nil, err
+	}
+	server := &http.Server{Handler: p}
+	go func() {
+		err := server.Serve(listener)
+		if !errors.Is(err, http.ErrServerClosed) && !errors.Is(err, net.ErrClosed) {
+			slog.Error("Bandwidth proxy stopped", "error", err)
+		}
+	}()
+	stop := func() error {
+		err := server.Close()
+		p.transport.CloseIdleConnections()
+		return err
+	}
+	return "http://git:" + password + "@" + listener.Addr().String(), stop, nil
+}
+
+func (p *throttlingProxy) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if subtle.ConstantTimeCompare([]byte(r.Header.Get("Proxy-Authorization")), []byte(p.auth)) != 1 {
+		w.Header().Set("Proxy-Authenticate", `Basic realm="git"`)
+		http.Error(w, "proxy authentication required", http.StatusProxyAuthRequired)
+		return
+	}
+	if r.Method != http.MethodConnect {
+		p.forward(w, r)
+		return
+	}
+	upstream, err := p.dial(r.Host)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+	defer upstream.Close()
+	hijacker, ok := w.(http.Hijacker)
+	if !ok {
+		http.Error(w, "hijacking not supported", http.StatusInternalServerError)
+		return
+	}
+	client, buf, err := hijacker.Hijack()
+	if err != nil {
+		return
+	}
+	defer client.Close()
+	_, err = client.Write([]byte("HTTP/1.1 200 Connection established\r\n\r\n"))
+	if err != nil {
+		return
+	}
+	// Both directions share the connection's rate.
+	limiters := []*limiter{p.global, newLimiter(p.perWorker)}
+	done := make(chan struct{}, 2)
+	go func() {
+		io.Copy(upstream, &throttledReader{r: buf, limiters: limiters})
+		done <- struct{}{}
+	}()
+	go func() {
+		io.Copy(client, &throttledReader{r: upstream, limiters: limiters})
+		done <- struct{}{}
+	}()
+	<-done
+}
+
+// dial connects to host, through the upstream proxy if there is one.
+func (p *throttlingProxy) dial(host string) (net.Conn, error) {
+	proxy, err := p.upstream(&http.Request{URL: &url.URL{Scheme: "https", Host: host}})
+	if err != nil {
+		return nil, err
+	}
+	if proxy == nil {
+		return net.Dial("tcp", host)
+	}
+	if proxy.Scheme != "http" {
+		return nil, fmt.Errorf("bandwidth limits need an http proxy, not %s", proxy.Scheme)
+	}
+	conn, err := net.Dial("tcp", proxy.Host)
+	if err != nil {
+		return nil, err
+	}
+	req := &http.Request{
+		Method: http.MethodConnect,
+		URL:    &url.URL{Opaque: host},
+		Host:   host,
+		Header: make(http.Header),
+	}
+	if proxy.User != nil {
+		password, _ := proxy.User.Password()
+		req.SetBasicAuth(proxy.User.Username(), password)
+		req.Header.Set("Proxy-Authorization", req.Header.Get("Authorization"))
+		req.Header.Del("Authorization")
+	}
+	err = req.Write(conn)
+	if err == nil {
+		var resp *http.Response
+		resp, err = http.ReadResponse(bufio.NewReader(conn), req)
+		if err == nil && resp.StatusCode != http.StatusOK {
+			err = fmt.Errorf("upstream proxy: %s", resp.Status)
+		}
+	}
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return conn, nil
+}
+
+// forward proxies a plain http request.
+func (p *throttlingProxy) forward(w http.ResponseWriter, r *http.Request) {
+	if !r.URL.IsAbs() {
+		http.Error(w, "not a proxy request", http.StatusBadRequest)
+		return
+	}
+	limiters := []*limiter{p.global, newLimiter(p.perWorker)}
+	req := r.Clone(r.Context())
+	req.RequestURI = ""
+	req.Header.Del("Proxy-Authorization")
+	req.Body = &throttledBody{Reader: &throttledReader{r: r.Body, limiters: limiters}, Closer: r.Body}
+	resp, err := p.transport.RoundTrip(req)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+	defer resp.Body.Close()
+	for key, values := range resp.Header {
+		w.Header()[key] = values
+	}
+	w.WriteHeader(resp.StatusCode)
+	io.Copy(w, &throttledReader{r: resp.Body, limiters: limiters})
+}
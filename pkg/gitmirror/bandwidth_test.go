@@ -0,0 +1,89 @@
+package gitmirror
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/chamzzzzzz/github-repo-mirror/pkg/config"
+)
+
+func TestThrottlingProxy(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, "hello")
+	}))
+	defer upstream.Close()
+	proxy, stop, err := startThrottlingProxy(&throttlingProxy{
+		global:   newLimiter(1024),
+		upstream: func(*http.Request) (*url.URL, error) { return nil, nil },
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	proxyURL, err := url.Parse(proxy)
+	if err != nil {
+		t.Fatal(err)
+	}
+	get := func(u *url.URL) (*http.Response, error) {
+		client := &http.Client{Transport: &http.Transport{Proxy: http.ProxyURL(u)}, Timeout: 5 * time.Second}
+		return client.Get(upstream.URL)
+	}
+
+	resp, err := get(proxyURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || string(body) != "hello" {
+		t.Errorf("GET = %d %q, want %d %q", resp.StatusCode, body, http.StatusOK, "hello")
+	}
+
+	// Other local users do not know the credential.
+	anonymous := *proxyURL
+	anonymous.User = nil
+	resp, err = get(&anonymous)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusProxyAuthRequired {
+		t.Errorf("GET without credential = %d, want %d", resp.StatusCode, http.StatusProxyAuthRequired)
+	}
+
+	err = stop()
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = get(proxyURL)
+	if err == nil {
+		t.Errorf("GET after stop succeeded, want the proxy closed")
+	}
+}
+
+func TestClose(t *testing.T) {
+	c := &config.Config{Sources: []*config.Source{{Username: "alice"}}}
+	c.Bandwidth.Global = 1024
+	m := newTestMirrorer(t, c)
+	if m.stopProxy == nil {
+		t.Fatal("New() started no bandwidth proxy")
+	}
+	repo := m.api.add("alice/proj", time.Now(), true)
+	err := m.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = m.Update(c.Sources[0], repo)
+	if !errors.Is(err, ErrClosed) {
+		t.Errorf("Update() after Close() = %v, want %v", err, ErrClosed)
+	}
+	err = m.Close()
+	if err != nil {
+		t.Errorf("second Close() = %v", err)
+	}
+}
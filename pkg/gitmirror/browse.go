@@ -0,0 +1,106 @@
+package gitmirror
+
+import (
+	"bytes"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/chamzzzzzz/github-repo-mirror/pkg/report"
+)
+
+// WriteBrowse writes the repo list of the mirrors in stats for cgit or
+// gitweb. It is not written if a source could not be listed or the run had
+// an OnlySource, so repos do not disappear from the browser for a run.
+func (m *Mirrorer) WriteBrowse(stats []*report.Stat) error {
+	browse := m.Config.Browse
+	if browse.Path == "" {
+		return nil
+	}
+	if m.OnlySource != "" {
+		return fmt.Errorf("only source %s was listed, keeping the previous repo list", m.OnlySource)
+	}
+	for _, stat := range stats {
+		if stat.Error != "" {
+			return fmt.Errorf("source %s failed, keeping the previous repo list", stat.Name)
+		}
+	}
+	var b bytes.Buffer
+	switch browse.Format {
+	case "", "cgit":
+		writeCgit(&b, stats)
+	case "gitweb":
+		err := m.writeGitweb(&b, stats)
+		if err != nil {
+			return err
+		}
+	default:
+		return fmt.Errorf("unknown browse format %q", browse.Format)
+	}
+	tmp := browse.Path + ".tmp"
+	err := os.WriteFile(tmp, b.Bytes(), 0644)
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmp, browse.Path)
+}
+
+// mirrored calls fn for every result in stats with a local mirror.
+func mirrored(stats []*report.Stat, fn func(stat *report.Stat, result *report.Result)) {
+	for _, stat := range stats {
+		for _, result := range stat.Results {
+			if result.Outcome == report.OutcomeSkipped || result.Outcome == report.OutcomeArchived {
+				continue
+			}
+			if !isBare(result.Local) {
+				continue
+			}
+			fn(stat, result)
+		}
+	}
+}
+
+func writeCgit(b *bytes.Buffer, stats []*report.Stat) {
+	descriptions := make(map[string]string)
+	for _, stat := range stats {
+		for _, repo := range stat.Repos {
+			descriptions[repo.FullName] = repo.Description
+		}
+	}
+	section := ""
+	mirrored(stats, func(stat *report.Stat, result *report.Result) {
+		if stat.Name != section {
+			section = stat.Name
+			fmt.Fprintf(b, "section=%s\n\n", cgitValue(section))
+		}
+		owner, _, _ := strings.Cut(result.Repo, "/")
+		fmt.Fprintf(b, "repo.url=%s\n", cgitValue(result.Repo))
+		fmt.Fprintf(b, "repo.path=%s\n", cgitValue(result.Local))
+		fmt.Fprintf(b, "repo.owner=%s\n", cgitValue(owner))
+		if description := descriptions[result.Repo]; description != "" {
+			fmt.Fprintf(b, "repo.desc=%s\n", cgitValue(description))
+		}
+		b.WriteString("\n")
+	})
+}
+
+func (m *Mirrorer) writeGitweb(b *bytes.Buffer, stats []*report.Stat) error {
+	var err error
+	mirrored(stats, func(stat *report.Stat, result *report.Result) {
+		rel, _err := filepath.Rel(m.Config.Destination, result.Local)
+		if _err != nil || strings.HasPrefix(rel, "..") {
+			err = fmt.Errorf("%s is outside of the destination", result.Local)
+			return
+		}
+		owner, _, _ := strings.Cut(result.Repo, "/")
+		fmt.Fprintf(b, "%s %s\n", url.QueryEscape(filepath.ToSlash(rel)), url.QueryEscape(owner))
+	})
+	return err
+}
+
+// cgitValue keeps a value on a single cgitrc line.
+func cgitValue(s string) string {
+	return strings.NewReplacer("\r", " ", "\n", " ").Replace(s)
+}
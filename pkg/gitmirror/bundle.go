@@ -0,0 +1,189 @@
+package gitmirror
+
+import (
+	"fmt"
+	"log/slog"
+	"maps"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/chamzzzzzz/github-repo-mirror/pkg/report"
+)
+
+const (
+	fullBundleSuffix        = ".full.bundle"
+	incrementalBundleSuffix = ".incremental.bundle"
+	bundleTimeFormat        = "20060102T150405.000000Z"
+	// bundleRefsFile records the refs of the last bundle, since incremental
+	// bundles leave out the refs that did not change.
+	bundleRefsFile = "refs"
+)
+
+// BundleDir returns the directory holding the bundles of the mirror at local.
+func (m *Mirrorer) BundleDir(local string) (string, error) {
+	rel, err := m.relative(local)
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(m.Config.Bundles.Directory, strings.TrimSuffix(rel, ".git")), nil
+}
+
+// Bundles returns the bundle files of the mirror at local, oldest first.
+func (m *Mirrorer) Bundles(local string) ([]string, error) {
+	dir, err := m.BundleDir(local)
+	if err != nil {
+		return nil, err
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	var bundles []string
+	for _, entry := range entries {
+		if strings.HasSuffix(entry.Name(), fullBundleSuffix) || strings.HasSuffix(entry.Name(), incrementalBundleSuffix) {
+			bundles = append(bundles, filepath.Join(dir, entry.Name()))
+		}
+	}
+	sort.Strings(bundles)
+	return bundles, nil
+}
+
+// Bundle exports a bundle of the mirror at local and rotates the old ones. It
+// returns the new bundle, or an empty path if the refs did not change since
+// the last bundle.
+func (m *Mirrorer) Bundle(local string, logger *slog.Logger) (string, error) {
+	keep := m.Config.Bundles.Keep
+	if keep <= 0 {
+		keep = 5
+	}
+	bundles, err := m.Bundles(local)
+	if err != nil {
+		return "", err
+	}
+	dir, err := m.BundleDir(local)
+	if err != nil {
+		return "", err
+	}
+	refs, err := m.Git.Refs(local)
+	if err != nil {
+		return "", err
+	}
+	var exclude []string
+	if len(bundles) > 0 {
+		b, err := os.ReadFile(filepath.Join(dir, bundleRefsFile))
+		if err != nil && !os.IsNotExist(err) {
+			return "", err
+		}
+		last := parseRefs(string(b), " ")
+		if maps.Equal(last, refs) {
+			logger.Debug("Skipped bundle", "last", bundles[len(bundles)-1])
+			return "", nil
+		}
+		if m.Config.Bundles.Incremental && sinceFull(bundles) < keep {
+			for _, oid := range last {
+				exclude = append(exclude, oid)
+			}
+		}
+	}
+	err = os.MkdirAll(dir, 0755)
+	if err != nil {
+		return "", err
+	}
+	name := filepath.Join(dir, time.Now().UTC().Format(bundleTimeFormat))
+	start := time.Now()
+	incremental := len(exclude) > 0
+	bundle := name + fullBundleSuffix
+	if incremental {
+		bundle = name + incrementalBundleSuffix
+	}
+	err = m.Git.CreateBundle(local, bundle, exclude...)
+	if incremental && err != nil {
+		// Refs only moved to objects already bundled, e.g. after a deletion.
+		logger.Debug("Falling back to full bundle", "error", err)
+		os.Remove(bundle)
+		incremental = false
+		bundle = name + fullBundleSuffix
+		err = m.Git.CreateBundle(local, bundle)
+	}
+	if err != nil {
+		os.Remove(bundle)
+		return "", err
+	}
+	var b strings.Builder
+	for ref, oid := range refs {
+		fmt.Fprintf(&b, "%s %s\n", oid, ref)
+	}
+	err = os.WriteFile(filepath.Join(dir, bundleRefsFile), []byte(b.String()), 0644)
+	if err != nil {
+		return "", err
+	}
+	logger.Info("Successfully bundle", "bundle", bundle, "incremental", incremental, "duration", time.Since(start))
+	return bundle, rotateBundles(append(bundles, bundle), keep)
+}
+
+// exportBundle runs Bundle for a mirrored or updated repo, if bundles are
+// configured, and records the outcome in result.
+func (m *Mirrorer) exportBundle(local string, result *report.Result, logger *slog.Logger) {
+	if m.Config.Bundles.Directory == "" {
+		return
+	}
+	bundle, err := m.Bundle(local, logger)
+	if err != nil {
+		logger.Error("Failed bundle", "error", err)
+		result.BundleError = err.Error()
+		return
+	}
+	result.Bundle = bundle
+}
+
+// BundleAll runs Bundle on every local mirror and returns the number of
+// bundles created and mirrors failed.
+func (m *Mirrorer) BundleAll() (created, failed int, err error) {
+	locals, err := m.LocalMirrors()
+	if err != nil {
+		return 0, 0, err
+	}
+	for _, local := range locals {
+		logger := m.Logger.With("local", local, "operation", "bundle")
+		bundle, err := m.Bundle(local, logger)
+		if err != nil {
+			logger.Error("Failed bundle", "error", err)
+			failed++
+			continue
+		}
+		if bundle != "" {
+			created++
+		}
+	}
+	return created, failed, nil
+}
+
+// sinceFull returns how many bundles were created since the last full one,
+// including it.
+func sinceFull(bundles []string) int {
+	for i := len(bundles) - 1; i >= 0; i-- {
+		if strings.HasSuffix(bundles[i], fullBundleSuffix) {
+			return len(bundles) - i
+		}
+	}
+	return len(bundles)
+}
+
+// rotateBundles removes all but the last keep bundles, except older ones the
+// kept incremental bundles depend on.
+func rotateBundles(bundles []string, keep int) error {
+	n := len(bundles) - keep
+	for n > 0 && !strings.HasSuffix(bundles[n], fullBundleSuffix) {
+		n--
+	}
+	for _, bundle := range bundles[:max(n, 0)] {
+		err := os.Remove(bundle)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,145 @@
+package gitmirror
+
+import (
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	cloneBundleSuffix = ".bundle"
+	// bundleListFile is the bundle list of a mirror's clone bundles in
+	// git config format, read by git clone --bundle-uri.
+	bundleListFile = "bundle-list"
+)
+
+// CloneBundleDir returns the directory holding the clone bundles of the
+// mirror at local.
+func (m *Mirrorer) CloneBundleDir(local string) (string, error) {
+	rel, err := m.relative(local)
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(m.Config.CloneBundles.Directory, strings.TrimSuffix(rel, ".git")), nil
+}
+
+// cloneBundleInterval returns how long a clone bundle is used before it is
+// replaced.
+func cloneBundleInterval(interval string) (time.Duration, error) {
+	if interval == "" {
+		return 24 * time.Hour, nil
+	}
+	d, err := time.ParseDuration(interval)
+	if err != nil {
+		return 0, fmt.Errorf("clone bundle interval: %w", err)
+	}
+	return d, nil
+}
+
+// refreshCloneBundle replaces the clone bundle of the mirror at local if it
+// is older than the interval, writes its bundle list and advertises it.
+// Failures are logged but do not fail the sync.
+func (m *Mirrorer) refreshCloneBundle(local string, logger *slog.Logger) {
+	cb := m.Config.CloneBundles
+	if cb.Directory == "" {
+		return
+	}
+	err := m.CloneBundle(local, logger)
+	if err != nil {
+		logger.Error("Failed clone bundle", "error", err)
+	}
+}
+
+// CloneBundle replaces the clone bundle of the mirror at local if it is
+// older than the interval.
+func (m *Mirrorer) CloneBundle(local string, logger *slog.Logger) error {
+	cb := m.Config.CloneBundles
+	interval, err := cloneBundleInterval(cb.Interval)
+	if err != nil {
+		return err
+	}
+	dir, err := m.CloneBundleDir(local)
+	if err != nil {
+		return err
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	var bundles []string
+	for _, entry := range entries {
+		if strings.HasSuffix(entry.Name(), cloneBundleSuffix) {
+			bundles = append(bundles, entry.Name())
+		}
+	}
+	sort.Strings(bundles)
+	if len(bundles) > 0 {
+		created, err := time.Parse(bundleTimeFormat, strings.TrimSuffix(bundles[len(bundles)-1], cloneBundleSuffix))
+		if err == nil && time.Since(created) < interval {
+			return nil
+		}
+	}
+
+	err = os.MkdirAll(dir, 0755)
+	if err != nil {
+		return err
+	}
+	now := time.Now().UTC()
+	name := now.Format(bundleTimeFormat) + cloneBundleSuffix
+	start := time.Now()
+	// Clients must never see a partial bundle.
+	tmp := filepath.Join(dir, "."+name)
+	err = m.Git.CreateBundle(local, tmp)
+	if err == nil {
+		err = os.Rename(tmp, filepath.Join(dir, name))
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	// Relative bundle URIs need git 2.41 or later, so the list has absolute
+	// ones if the base URL is known.
+	uri := name
+	if cb.BaseURL != "" {
+		rel, err := filepath.Rel(cb.Directory, dir)
+		if err != nil {
+			return err
+		}
+		uri = strings.TrimSuffix(cb.BaseURL, "/") + "/" + filepath.ToSlash(rel) + "/" + name
+	}
+	list := fmt.Sprintf("[bundle]\n\tversion = 1\n\tmode = all\n\theuristic = creationToken\n[bundle \"latest\"]\n\turi = %s\n\tcreationToken = %d\n", uri, now.Unix())
+	err = writeFileIfChanged(filepath.Join(dir, bundleListFile), []byte(list))
+	if err != nil {
+		return err
+	}
+	logger.Info("Successfully clone bundle", "bundle", filepath.Join(dir, name), "duration", time.Since(start))
+
+	if cb.BaseURL != "" {
+		for _, kv := range [][2]string{
+			{"uploadpack.advertiseBundleURIs", "true"},
+			{"bundle.version", "1"},
+			{"bundle.mode", "all"},
+			{"bundle.heuristic", "creationToken"},
+			{"bundle.latest.uri", uri},
+			{"bundle.latest.creationToken", fmt.Sprint(now.Unix())},
+		} {
+			err := m.Git.Config(local, kv[0], kv[1])
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	// The previous bundle stays for clients still downloading it.
+	for _, old := range bundles[:max(len(bundles)-1, 0)] {
+		err := os.Remove(filepath.Join(dir, old))
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,81 @@
+package gitmirror
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/chamzzzzzz/github-repo-mirror/pkg/config"
+)
+
+// CloneOptions returns how a repo is cloned from its refspecs and clone
+// mode.
+func (m *Mirrorer) CloneOptions(source *config.Source, fullName string) (*CloneOptions, error) {
+	options := &CloneOptions{
+		Refspecs: m.Refspecs(source, fullName),
+	}
+	repo, ok := m.Config.Repos[fullName]
+	if !ok {
+		return options, nil
+	}
+	switch repo.CloneMode {
+	case "", "full":
+	case "blobless":
+		options.Filter = "blob:none"
+	case "shallow":
+		options.Depth = repo.Depth
+		if options.Depth <= 0 {
+			options.Depth = 1
+		}
+	default:
+		return nil, fmt.Errorf("unknown clone mode %q", repo.CloneMode)
+	}
+	since, err := historySince(repo)
+	if err != nil {
+		return nil, err
+	}
+	if !since.IsZero() && options.Depth > 0 {
+		return nil, fmt.Errorf("max age cannot be combined with clone mode %q", repo.CloneMode)
+	}
+	options.ShallowSince = since
+	return options, nil
+}
+
+// historySince returns the start of the trimmed history of a repo with a
+// MaxAge, zero for a full history. It is truncated to the day, so the
+// shallow boundary moves once a day.
+func historySince(repo *config.RepoConfig) (time.Time, error) {
+	if repo == nil || repo.MaxAge == "" {
+		return time.Time{}, nil
+	}
+	maxAge, err := time.ParseDuration(repo.MaxAge)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("parse max age: %w", err)
+	}
+	return time.Now().UTC().Add(-maxAge).Truncate(24 * time.Hour), nil
+}
+
+// fetch updates the mirror at local, keeping shallow mirrors shallow.
+// Partial clones keep their filter through the remote's config. Fetches
+// aborted by the low speed limit are retried.
+func (m *Mirrorer) fetch(local string, options *CloneOptions) error {
+	retries := m.Config.Network.LowSpeedRetries
+	if retries == 0 {
+		retries = 2
+	}
+	err := m.fetchOnce(local, options)
+	for attempt := 1; err != nil && stalled(err) && attempt <= retries; attempt++ {
+		m.Logger.Warn("Retrying stalled fetch", "local", local, "attempt", attempt, "error", err)
+		err = m.fetchOnce(local, options)
+	}
+	return err
+}
+
+func (m *Mirrorer) fetchOnce(local string, options *CloneOptions) error {
+	if options.Depth > 0 {
+		return m.Git.FetchDepth(local, options.Depth)
+	}
+	if !options.ShallowSince.IsZero() {
+		return m.Git.FetchSince(local, options.ShallowSince)
+	}
+	return m.Git.Fetch(local)
+}
@@ -0,0 +1,163 @@
+package gitmirror
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/chamzzzzzz/github-repo-mirror/pkg/report"
+)
+
+// pathCaseFile records the repo owning each lowercased path with PathCase
+// "lower".
+const pathCaseFile = "path-case.json"
+
+func checkPathCase(mode string) error {
+	switch mode {
+	case "", "lower", "hash":
+		return nil
+	}
+	return fmt.Errorf("unknown path case %q", mode)
+}
+
+// casePath applies the PathCase mode to the rendered path of the repo with
+// full name fullName.
+func casePath(mode, path, fullName string) string {
+	switch mode {
+	case "lower":
+		return strings.ToLower(path)
+	case "hash":
+		sum := sha256.Sum256([]byte(fullName))
+		suffix := "-" + hex.EncodeToString(sum[:4])
+		path = strings.ToLower(path)
+		if base, ok := strings.CutSuffix(path, ".git"); ok {
+			return base + suffix + ".git"
+		}
+		return path + suffix
+	}
+	return path
+}
+
+// PathCasePath returns the path of the file recording the owners of
+// lowercased paths.
+func (m *Mirrorer) PathCasePath() string {
+	return filepath.Join(m.Config.Destination, pathCaseFile)
+}
+
+// caseInsensitive reports whether the filesystem of dir ignores case in
+// names, probing its nearest existing directory with a temporary file.
+func caseInsensitive(dir string) bool {
+	for {
+		if _, err := os.Stat(dir); err == nil || filepath.Dir(dir) == dir {
+			break
+		}
+		dir = filepath.Dir(dir)
+	}
+	f, err := os.CreateTemp(dir, ".CaseProbe")
+	if err != nil {
+		return false
+	}
+	f.Close()
+	defer os.Remove(f.Name())
+	_, err = os.Stat(filepath.Join(dir, strings.ToLower(filepath.Base(f.Name()))))
+	return err == nil
+}
+
+// collisions returns the jobs whose mirror path equals the path of another
+// repo, ignoring case on case-insensitive filesystems, with the full name of
+// that repo. The repo whose mirror is at the path, or that the path case file
+// records, keeps it while it is among the jobs, else the first job's repo
+// takes it, as when a repo was renamed. With partial set, the jobs are not
+// the full listing and the other repo always keeps the path. Repos the
+// include and exclude lists leave out claim no path.
+func (m *Mirrorer) collisions(jobs []*job, partial bool) (map[*job]string, error) {
+	owners := make(map[string]string)
+	if m.Config.PathCase == "lower" {
+		b, err := os.ReadFile(m.PathCasePath())
+		if err != nil && !os.IsNotExist(err) {
+			return nil, err
+		}
+		if err == nil {
+			err = json.Unmarshal(b, &owners)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %w", m.PathCasePath(), err)
+			}
+		}
+	}
+	insensitive := make(map[string]bool)
+	for _, destination := range m.Destinations() {
+		insensitive[destination] = m.Config.PathCase != "" || caseInsensitive(destination)
+	}
+	listed := make(map[string]bool)
+	var candidates []*job
+	for _, j := range jobs {
+		if skip, _ := Skip(j.stat.Source, j.repo); !skip {
+			listed[j.repo.FullName] = true
+			candidates = append(candidates, j)
+		}
+	}
+	changed := false
+	claimed := make(map[string]bool)
+	collided := make(map[*job]string)
+	for _, j := range candidates {
+		name := j.repo.FullName
+		local := m.LocalPath(j.stat.Source, j.repo)
+		key := filepath.ToSlash(local)
+		if insensitive[m.Destination(j.stat.Source)] {
+			key = strings.ToLower(key)
+		}
+		if claimed[key] {
+			if owners[key] != name {
+				collided[j] = owners[key]
+			}
+			continue
+		}
+		claimed[key] = true
+		owner := owners[key]
+		if metadata, err := ReadMetadata(local); err == nil && metadata.Repo != "" {
+			owner = metadata.Repo
+		}
+		if owner == "" || owner != name && !partial && !listed[owner] {
+			owner = name
+		}
+		if owners[key] != owner {
+			owners[key] = owner
+			changed = true
+		}
+		if owner != name {
+			collided[j] = owner
+		}
+	}
+	if m.Config.PathCase == "lower" && changed && !m.DryRun {
+		b, err := json.MarshalIndent(owners, "", "  ")
+		if err != nil {
+			return nil, err
+		}
+		err = os.MkdirAll(m.Config.Destination, 0755)
+		if err != nil {
+			return nil, err
+		}
+		err = writeFileIfChanged(m.PathCasePath(), b)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return collided, nil
+}
+
+// collision is the result of a repo whose path collides with the mirror of
+// owner.
+func (m *Mirrorer) collision(j *job, owner string) *report.Result {
+	local := m.LocalPath(j.stat.Source, j.repo)
+	return &report.Result{
+		Repo:    j.repo.FullName,
+		Remote:  RepoRemote(j.repo),
+		Local:   local,
+		Outcome: report.OutcomeFailed,
+		Error:   fmt.Sprintf("path collision error:'%s is the mirror of %s'", local, owner),
+	}
+}
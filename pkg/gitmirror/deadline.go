@@ -0,0 +1,94 @@
+package gitmirror
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/chamzzzzzz/github-repo-mirror/pkg/report"
+	"github.com/chamzzzzzz/github-repo-mirror/pkg/state"
+)
+
+// runDeadline returns the time after which a run starting at start starts
+// no more repos, zero without a MaxRunDuration, and the grace period after
+// it at which running git commands are killed.
+func runDeadline(maxRunDuration, maxRunGrace string, start time.Time) (time.Time, time.Duration, error) {
+	if maxRunDuration == "" {
+		return time.Time{}, 0, nil
+	}
+	d, err := time.ParseDuration(maxRunDuration)
+	if err != nil {
+		return time.Time{}, 0, fmt.Errorf("parse max run duration: %w", err)
+	}
+	grace := 5 * time.Minute
+	if maxRunGrace != "" {
+		grace, err = time.ParseDuration(maxRunGrace)
+		if err != nil {
+			return time.Time{}, 0, fmt.Errorf("parse max run grace: %w", err)
+		}
+	}
+	return start.Add(d), grace, nil
+}
+
+// setGitContext makes the git backend kill the commands still running when
+// ctx is done, if it supports it.
+func (m *Mirrorer) setGitContext(ctx context.Context) {
+	if r, ok := m.Git.(interface{ SetContext(context.Context) }); ok {
+		r.SetContext(ctx)
+	}
+}
+
+// deferred is the result of a repo not attempted because the run reached
+// its deadline.
+func (m *Mirrorer) deferred(j *job, reason string) *report.Result {
+	return &report.Result{
+		Repo:    j.repo.FullName,
+		Remote:  RepoRemote(j.repo),
+		Local:   m.LocalPath(j.stat.Source, j.repo),
+		Outcome: report.OutcomeDeferred,
+		Reason:  reason,
+	}
+}
+
+// carriedOver reports whether the repo was deferred by the last run or not
+// attempted at all, which the next run syncs first.
+func (m *Mirrorer) carriedOver(repo string) bool {
+	if m.pending[repo] {
+		return true
+	}
+	if m.State == nil {
+		return false
+	}
+	r, ok := m.State.Repo(repo)
+	return ok && r.Outcome == report.OutcomeDeferred
+}
+
+// beginCarryover loads the repos the last run did not attempt and starts
+// the carryover journal of the run of jobs, if there is a state store.
+func (m *Mirrorer) beginCarryover(stats []*report.Stat) *state.Carryover {
+	m.pending = nil
+	if m.State == nil || m.DryRun {
+		return nil
+	}
+	carryover := m.State.Carryover()
+	pending, err := carryover.Pending()
+	if err != nil {
+		m.Logger.Warn("Failed to read carryover", "error", err)
+	}
+	if len(pending) > 0 {
+		m.Logger.Info("Carrying over repos from the last run", "repos", len(pending))
+	}
+	m.pending = pending
+	var repos []string
+	for _, stat := range stats {
+		for _, repo := range stat.Repos {
+			repos = append(repos, repo.FullName)
+		}
+	}
+	err = carryover.Begin(repos)
+	if err != nil {
+		m.Logger.Warn("Failed to write carryover", "error", err)
+		return nil
+	}
+	return carryover
+}
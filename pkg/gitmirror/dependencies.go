@@ -0,0 +1,103 @@
+package gitmirror
+
+import (
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/chamzzzzzz/github-repo-mirror/pkg/report"
+)
+
+// dependencyPaths are the files scanned for dependencies: go.mod at the
+// root and the workflows and actions under .github.
+var dependencyPaths = []string{"go.mod", ".github"}
+
+// usesPattern matches the uses: of workflow steps and jobs and of composite
+// actions, e.g. "uses: actions/checkout@v4" or "- uses: 'owner/repo/path@sha'".
+var usesPattern = regexp.MustCompile(`(?m)^[\s-]*uses:\s*["']?([^\s"'#]+)`)
+
+// mirrorDependencies mirrors the GitHub repos that the repos of stats depend
+// on, by their go.mod and GitHub Actions workflows, if not discovered, and
+// adds them to the stat of the depending repo's source. It follows
+// Dependencies.Depth levels.
+func (m *Mirrorer) mirrorDependencies(stats []*report.Stat) {
+	m.mirrorReferences(stats, m.Config.Dependencies.Depth, "dependency", m.dependencies)
+}
+
+// dependencies returns the GitHub repos the mirror at local of the repo
+// fullName depends on.
+func (m *Mirrorer) dependencies(fullName, local string) ([]string, error) {
+	files, err := m.Git.HeadFiles(local, dependencyPaths...)
+	if err != nil {
+		return nil, err
+	}
+	return dependencyRepos(fullName, files), nil
+}
+
+// dependencyRepos returns the "owner/repo" names of the GitHub repos, other
+// than fullName, that the go.mod, workflows and actions among files refer
+// to.
+func dependencyRepos(fullName string, files map[string][]byte) []string {
+	seen := make(map[string]bool)
+	var names []string
+	add := func(name string) {
+		if name == "" || strings.EqualFold(name, fullName) || seen[strings.ToLower(name)] {
+			return
+		}
+		seen[strings.ToLower(name)] = true
+		names = append(names, name)
+	}
+	for file, b := range files {
+		switch {
+		case file == "go.mod":
+			for _, name := range goModRepos(string(b)) {
+				add(name)
+			}
+		case strings.HasPrefix(file, ".github/") && (path.Ext(file) == ".yml" || path.Ext(file) == ".yaml"):
+			for _, match := range usesPattern.FindAllStringSubmatch(string(b), -1) {
+				add(actionRepo(match[1]))
+			}
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// goModRepos returns the repos of the github.com modules a go.mod requires
+// or replaces others with, leaving out the module itself.
+func goModRepos(gomod string) []string {
+	var names []string
+	for _, line := range strings.Split(gomod, "\n") {
+		line, _, _ = strings.Cut(line, "//")
+		// A replaced module is not fetched, its replacement is.
+		if _, replacement, ok := strings.Cut(line, "=>"); ok {
+			line = replacement
+		}
+		fields := strings.Fields(line)
+		if len(fields) == 0 || fields[0] == "module" {
+			continue
+		}
+		for _, field := range fields {
+			parts := strings.Split(strings.Trim(field, `"`), "/")
+			if len(parts) >= 3 && parts[0] == "github.com" {
+				names = append(names, parts[1]+"/"+parts[2])
+			}
+		}
+	}
+	return names
+}
+
+// actionRepo returns the repo of a uses: reference like owner/repo@ref or
+// owner/repo/path@ref, empty for local actions and docker images.
+func actionRepo(uses string) string {
+	ref, _, ok := strings.Cut(uses, "@")
+	if !ok || strings.HasPrefix(ref, ".") || strings.Contains(ref, ":") {
+		return ""
+	}
+	parts := strings.Split(ref, "/")
+	if len(parts) < 2 || parts[0] == "" || parts[1] == "" {
+		return ""
+	}
+	return parts[0] + "/" + parts[1]
+}
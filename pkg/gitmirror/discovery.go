@@ -0,0 +1,107 @@
+package gitmirror
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/chamzzzzzz/github-repo-mirror/pkg/config"
+	"github.com/chamzzzzzz/github-repo-mirror/pkg/github"
+)
+
+// Discovery modes of the Mirrorer.
+const (
+	// DiscoveryLive lists the repos of every source from its API.
+	DiscoveryLive = ""
+	// DiscoveryCached falls back to the cached listing of a source that
+	// cannot be listed, e.g. while the API is down.
+	DiscoveryCached = "cached"
+	// DiscoveryOffline uses the cached listings only, see SetOffline.
+	DiscoveryOffline = "offline"
+)
+
+// discoveryCache is the last successful listing of each source.
+type discoveryCache struct {
+	Sources map[string]*cachedListing `json:"sources"`
+}
+
+type cachedListing struct {
+	Time  time.Time      `json:"time"`
+	Repos []*github.Repo `json:"repos"`
+}
+
+// errOffline fails the API requests of an offline mirrorer.
+var errOffline = errors.New("offline")
+
+type offlineTransport struct{}
+
+func (offlineTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	return nil, errOffline
+}
+
+// SetOffline makes the mirrorer list repos from the discovery cache only
+// and fail every other API request right away, so a run while the API is
+// down only updates the mirrors git can still fetch.
+func (m *Mirrorer) SetOffline() {
+	m.Discovery = DiscoveryOffline
+	client := *m.Client.HTTP
+	client.Transport = offlineTransport{}
+	m.Client.HTTP = &client
+	client = *m.Azure.HTTP
+	client.Transport = offlineTransport{}
+	m.Azure.HTTP = &client
+}
+
+// DiscoveryCachePath returns the path of the discovery cache.
+func (m *Mirrorer) DiscoveryCachePath() string {
+	if m.Config.DiscoveryCachePath != "" {
+		return m.Config.DiscoveryCachePath
+	}
+	return filepath.Join(m.Config.Destination, "discovery-cache.json")
+}
+
+// discoveryKey is the key of a source in the discovery cache.
+func discoveryKey(source *config.Source) string {
+	if source.Type == "" {
+		return "github/" + source.Label()
+	}
+	return source.Type + "/" + source.Label()
+}
+
+// readDiscoveryCache reads the discovery cache, empty if there is none.
+func (m *Mirrorer) readDiscoveryCache() (*discoveryCache, error) {
+	cache := &discoveryCache{
+		Sources: make(map[string]*cachedListing),
+	}
+	b, err := os.ReadFile(m.DiscoveryCachePath())
+	if errors.Is(err, os.ErrNotExist) {
+		return cache, nil
+	}
+	if err != nil {
+		return cache, err
+	}
+	err = json.Unmarshal(b, cache)
+	if err != nil {
+		return cache, fmt.Errorf("parse %s: %w", m.DiscoveryCachePath(), err)
+	}
+	if cache.Sources == nil {
+		cache.Sources = make(map[string]*cachedListing)
+	}
+	return cache, nil
+}
+
+func (m *Mirrorer) writeDiscoveryCache(cache *discoveryCache) error {
+	b, err := json.Marshal(cache)
+	if err != nil {
+		return err
+	}
+	err = os.MkdirAll(filepath.Dir(m.DiscoveryCachePath()), 0755)
+	if err != nil {
+		return err
+	}
+	return writeFileIfChanged(m.DiscoveryCachePath(), b)
+}
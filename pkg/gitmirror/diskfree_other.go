@@ -0,0 +1,8 @@
+//go:build !linux && !darwin
+
+package gitmirror
+
+// diskFree reports unlimited space where free space cannot be queried.
+func diskFree(path string) (int64, error) {
+	return -1, nil
+}
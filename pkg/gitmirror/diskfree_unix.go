@@ -0,0 +1,18 @@
+//go:build linux || darwin
+
+package gitmirror
+
+import (
+	"syscall"
+)
+
+// diskFree returns the bytes available to unprivileged users on the file
+// system containing path.
+func diskFree(path string) (int64, error) {
+	var st syscall.Statfs_t
+	err := syscall.Statfs(path, &st)
+	if err != nil {
+		return 0, err
+	}
+	return int64(uint64(st.Bavail) * uint64(st.Bsize)), nil
+}
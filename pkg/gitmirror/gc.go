@@ -0,0 +1,75 @@
+package gitmirror
+
+import (
+	"fmt"
+	"strconv"
+
+	"github.com/chamzzzzzz/github-repo-mirror/pkg/config"
+)
+
+// MaintenanceConfig returns the maintenance settings of the repo with the
+// given full name, with defaults applied.
+func (m *Mirrorer) MaintenanceConfig(fullName string) *config.Maintenance {
+	maintenance := m.Config.Maintenance
+	if rc := m.Config.Repos[fullName]; rc != nil && rc.Maintenance != nil {
+		maintenance = *rc.Maintenance
+	}
+	if maintenance.MaxLooseObjects == 0 {
+		maintenance.MaxLooseObjects = 1000
+	}
+	if maintenance.MaxPacks == 0 {
+		maintenance.MaxPacks = 50
+	}
+	if maintenance.GCAuto == 0 {
+		maintenance.GCAuto = 6700
+	}
+	if maintenance.GCAutoPackLimit == 0 {
+		maintenance.GCAutoPackLimit = 50
+	}
+	return &maintenance
+}
+
+// checkMaintenance checks the strategies and gc policies of the config and
+// its repos.
+func checkMaintenance(c *config.Config) error {
+	check := func(maintenance *config.Maintenance) error {
+		switch maintenance.Strategy {
+		case "", "repack", "maintenance", "gc", "none":
+		default:
+			return fmt.Errorf("unknown maintenance strategy %q", maintenance.Strategy)
+		}
+		switch maintenance.GC {
+		case "", "disabled", "auto":
+		default:
+			return fmt.Errorf("unknown gc policy %q", maintenance.GC)
+		}
+		return nil
+	}
+	err := check(&c.Maintenance)
+	if err != nil {
+		return err
+	}
+	for name, rc := range c.Repos {
+		if rc.Maintenance == nil {
+			continue
+		}
+		err := check(rc.Maintenance)
+		if err != nil {
+			return fmt.Errorf("repo %s: %w", name, err)
+		}
+	}
+	return nil
+}
+
+// configureGC applies the repo's gc policy to the mirror at local.
+func (m *Mirrorer) configureGC(local, fullName string) error {
+	maintenance := m.MaintenanceConfig(fullName)
+	if maintenance.GC != "auto" {
+		return m.Git.Config(local, "gc.auto", "0")
+	}
+	err := m.Git.Config(local, "gc.auto", strconv.Itoa(maintenance.GCAuto))
+	if err != nil {
+		return err
+	}
+	return m.Git.Config(local, "gc.autoPackLimit", strconv.Itoa(maintenance.GCAutoPackLimit))
+}
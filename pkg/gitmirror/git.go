@@ -0,0 +1,785 @@
+package gitmirror
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/chamzzzzzz/github-repo-mirror/pkg/config"
+	"github.com/chamzzzzzz/github-repo-mirror/pkg/report"
+)
+
+// GitRunner performs the git operations of mirroring, so they can be
+// replaced by a fake in tests or by another git implementation.
+type GitRunner interface {
+	// Clone creates a bare mirror of url at local, restricted by options if
+	// not nil.
+	Clone(url, local string, options *CloneOptions) error
+	// SetRefspecs replaces the fetch refspecs of the mirror at local. Tags
+	// are only fetched if the refspecs match them.
+	SetRefspecs(local string, refspecs ...string) error
+	// Fetch updates the mirror at local from its remote.
+	Fetch(local string) error
+	// FetchDepth updates the shallow mirror at local, truncating the history
+	// to depth commits.
+	FetchDepth(local string, depth int) error
+	// FetchSince updates the shallow mirror at local, truncating the
+	// history to the commits after since.
+	FetchSince(local string, since time.Time) error
+	// Repack runs git repack with args in the mirror at local.
+	Repack(local string, args ...string) error
+	// Config sets a config key in the mirror at local.
+	Config(local, key, value string) error
+	// Fsck checks the integrity of the mirror at local, passing args to git
+	// fsck.
+	Fsck(local string, args ...string) error
+	// ConfigAdd adds value to a multi-valued config key in the mirror at
+	// local, unless the key already has it.
+	ConfigAdd(local, key, value string) error
+	// ConfigValue returns the value of a config key in the mirror at local.
+	ConfigValue(local, key string) (string, error)
+	// Refs returns the object names of the mirror's refs by ref name.
+	Refs(local string) (map[string]string, error)
+	// UpdateRef points ref to oid in the mirror at local, or deletes ref if
+	// oid is empty.
+	UpdateRef(local, ref, oid string) error
+	// SymbolicRef points HEAD of the mirror at local to ref.
+	SymbolicRef(local, ref string) error
+	// IsAncestor reports whether commit a is an ancestor of commit b.
+	IsAncestor(local, a, b string) (bool, error)
+	// LsRemote returns the object names of the refs of the remote url by ref
+	// name.
+	LsRemote(url string) (map[string]string, error)
+	// CreateBundle writes a bundle of all refs of the mirror at local to
+	// file, without the objects reachable from exclude.
+	CreateBundle(local, file string, exclude ...string) error
+	// BundleHeads returns the object names of the refs in a bundle by ref
+	// name.
+	BundleHeads(file string) (map[string]string, error)
+	// WriteCommitGraph writes a commit-graph for all reachable commits.
+	WriteCommitGraph(local string) error
+	// Maintenance runs git maintenance with the given tasks.
+	Maintenance(local string, tasks ...string) error
+	// GC runs git gc.
+	GC(local string) error
+	// CountObjects returns the object storage stats of the mirror at local.
+	CountObjects(local string) (*report.ObjectStats, error)
+	// PushMirror pushes all refs of the mirror at local to url, deleting
+	// refs that no longer exist locally. A token, if not empty, is sent as
+	// the password of https requests.
+	PushMirror(local, url, token string) error
+	// Push pushes the refs of the mirror at local matching refspecs to url,
+	// with token like PushMirror.
+	Push(local, url, token string, refspecs ...string) error
+	// SubmoduleURLs returns the submodule URLs in the .gitmodules of HEAD of
+	// the mirror at local, none if it has no .gitmodules.
+	SubmoduleURLs(local string) ([]string, error)
+	// HeadFiles returns the contents of the files at HEAD of the mirror at
+	// local that are, or are under, paths, by path. A mirror without HEAD
+	// has none.
+	HeadFiles(local string, paths ...string) (map[string][]byte, error)
+	// VerifySignature verifies the signature of the tag ref of the mirror
+	// at local, or of the commit it points at if it is a lightweight tag,
+	// against the trusted keys.
+	VerifySignature(local, ref string, keys config.Signatures) error
+}
+
+// CloneOptions restrict what a clone fetches.
+type CloneOptions struct {
+	// Refspecs are the fetch refspecs; all refs are fetched if empty.
+	Refspecs []string
+	// Filter is a partial clone filter, e.g. "blob:none".
+	Filter string
+	// Depth truncates the history to that many commits, if positive.
+	Depth int
+	// ShallowSince truncates the history to the commits after it, if not
+	// zero.
+	ShallowSince time.Time
+	// Reference is a local mirror whose objects the clone borrows through
+	// objects/info/alternates instead of fetching them.
+	Reference string
+	// ObjectFormat is the object format of the remote, "sha1" or "sha256",
+	// if known.
+	ObjectFormat string
+}
+
+// backends maps the config's Backend names to GitRunner constructors.
+var backends = map[string]func(*config.Config) GitRunner{
+	"exec": func(config *config.Config) GitRunner {
+		return &ExecRunner{
+			Resources:  config.Resources,
+			Network:    config.Network,
+			Path:       config.GitPath,
+			GlobalArgs: GitGlobalArgs(config),
+		}
+	},
+}
+
+// ExecRunner is the default GitRunner, running the git binary with the
+// configured resource limits and network settings.
+type ExecRunner struct {
+	Resources config.Resources
+	Network   config.Network
+	// Path is the git binary, git from PATH if empty.
+	Path string
+	// GlobalArgs are put before the subcommand of every command.
+	GlobalArgs []string
+	// Progress receives the progress output of clones and fetches, if set.
+	Progress io.Writer
+
+	// ctx kills the commands still running when it is done, if set.
+	ctx atomic.Pointer[context.Context]
+}
+
+var ioniceClasses = map[string]string{
+	"realtime":    "1",
+	"best-effort": "2",
+	"idle":        "3",
+}
+
+// Command returns a git command wrapped according to the resource limits.
+func (r *ExecRunner) Command(args ...string) *exec.Cmd {
+	var argv []string
+	if r.Resources.Slice != "" {
+		argv = append(argv, "systemd-run", "--quiet", "--scope", "--slice="+r.Resources.Slice)
+	}
+	if class, ok := ioniceClasses[r.Resources.IONiceClass]; ok {
+		argv = append(argv, "ionice", "-c", class)
+		if class != "3" {
+			argv = append(argv, "-n", fmt.Sprint(r.Resources.IONiceLevel))
+		}
+	}
+	if r.Resources.Nice != 0 {
+		argv = append(argv, "nice", "-n", fmt.Sprint(r.Resources.Nice))
+	}
+	path := r.Path
+	if path == "" {
+		path = "git"
+	}
+	argv = append(argv, path)
+	argv = append(argv, r.GlobalArgs...)
+	argv = append(argv, args...)
+	ctx := context.Background()
+	if c := r.ctx.Load(); c != nil {
+		ctx = *c
+	}
+	cmd := exec.CommandContext(ctx, argv[0], argv[1:]...)
+	if env := networkEnv(r.Network); len(env) > 0 {
+		cmd.Env = append(os.Environ(), env...)
+	}
+	return cmd
+}
+
+// GitGlobalArgs returns the config's GitConfig as -c flags followed by its
+// GitFlags.
+func GitGlobalArgs(config *config.Config) []string {
+	var args []string
+	for _, kv := range config.GitConfig {
+		args = append(args, "-c", kv)
+	}
+	return append(args, config.GitFlags...)
+}
+
+// networkEnv returns the environment that makes git, through curl, use the
+// network settings.
+func networkEnv(network config.Network) []string {
+	var env []string
+	if network.Proxy != "" {
+		for _, name := range []string{"https_proxy", "HTTPS_PROXY", "http_proxy", "HTTP_PROXY"} {
+			env = append(env, name+"="+network.Proxy)
+		}
+		env = append(env, "no_proxy="+network.NoProxy, "NO_PROXY="+network.NoProxy)
+	}
+	if network.CAFile != "" {
+		env = append(env, "GIT_SSL_CAINFO="+network.CAFile)
+	}
+	if network.InsecureSkipVerify {
+		env = append(env, "GIT_SSL_NO_VERIFY=1")
+	}
+	if network.LowSpeedLimit > 0 {
+		d, _ := lowSpeedTime(network)
+		env = append(env, fmt.Sprintf("GIT_HTTP_LOW_SPEED_LIMIT=%d", network.LowSpeedLimit), fmt.Sprintf("GIT_HTTP_LOW_SPEED_TIME=%d", int(d.Seconds())))
+	}
+	return env
+}
+
+// credentialEnv returns the environment that makes git send username and
+// token as basic authentication with its https requests.
+func credentialEnv(username, token string) []string {
+	auth := base64.StdEncoding.EncodeToString([]byte(username + ":" + token))
+	return []string{
+		"GIT_CONFIG_COUNT=1",
+		"GIT_CONFIG_KEY_0=http.extraHeader",
+		"GIT_CONFIG_VALUE_0=Authorization: Basic " + auth,
+	}
+}
+
+// lowSpeedTime returns the network's LowSpeedTime, default a minute.
+func lowSpeedTime(network config.Network) (time.Duration, error) {
+	if network.LowSpeedTime == "" {
+		return time.Minute, nil
+	}
+	d, err := time.ParseDuration(network.LowSpeedTime)
+	if err != nil {
+		return 0, fmt.Errorf("low speed time: %w", err)
+	}
+	if d < time.Second {
+		return 0, fmt.Errorf("low speed time %s is under a second", d)
+	}
+	return d, nil
+}
+
+// stalled reports whether a git command was aborted by the low speed limit.
+func stalled(err error) bool {
+	return strings.Contains(Stderr(err), "Operation too slow")
+}
+
+func (r *ExecRunner) run(args ...string) error {
+	cmd := r.Command(args...)
+	stderr := &tailBuffer{}
+	cmd.Stderr = stderr
+	return gitError(cmd.Run(), stderr)
+}
+
+// transfer runs a git command that transfers objects, streaming its
+// progress to r.Progress, if set.
+func (r *ExecRunner) transfer(args ...string) error {
+	cmd := r.Command(args...)
+	stderr := &tailBuffer{}
+	cmd.Stderr = stderr
+	if r.Progress != nil {
+		cmd.Stderr = io.MultiWriter(r.Progress, stderr)
+	}
+	return gitError(cmd.Run(), stderr)
+}
+
+// GitError is a failed git command with the end of its standard error.
+type GitError struct {
+	Err    error
+	Stderr string
+}
+
+func (e *GitError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *GitError) Unwrap() error {
+	return e.Err
+}
+
+// Stderr returns the standard error captured with err, if any.
+func Stderr(err error) string {
+	var gitErr *GitError
+	if errors.As(err, &gitErr) {
+		return gitErr.Stderr
+	}
+	return ""
+}
+
+// credentialsPattern matches the credentials of URLs in git's messages.
+var credentialsPattern = regexp.MustCompile(`://[^/@\s]+@`)
+
+// gitError wraps err with the standard error in stderr, nil if err is nil.
+func gitError(err error, stderr *tailBuffer) error {
+	if err == nil {
+		return nil
+	}
+	// Progress lines are rewritten in place with carriage returns, keep only
+	// their final state.
+	var lines []string
+	for _, line := range strings.Split(stderr.String(), "\n") {
+		if i := strings.LastIndex(line, "\r"); i >= 0 {
+			line = line[i+1:]
+		}
+		if line = strings.TrimSpace(line); line != "" {
+			lines = append(lines, line)
+		}
+	}
+	if len(lines) > 20 {
+		lines = lines[len(lines)-20:]
+	}
+	if len(lines) == 0 {
+		return err
+	}
+	return &GitError{
+		Err:    err,
+		Stderr: credentialsPattern.ReplaceAllString(strings.Join(lines, "\n"), "://"),
+	}
+}
+
+// tailBuffer keeps the last 8 KiB written to it.
+type tailBuffer struct {
+	b []byte
+}
+
+func (t *tailBuffer) Write(p []byte) (int, error) {
+	const size = 8 << 10
+	t.b = append(t.b, p...)
+	if len(t.b) > size {
+		t.b = t.b[len(t.b)-size:]
+	}
+	return len(p), nil
+}
+
+func (t *tailBuffer) String() string {
+	return string(t.b)
+}
+
+// SetContext kills the commands still running when ctx is done, or no
+// longer if ctx is nil.
+func (r *ExecRunner) SetContext(ctx context.Context) {
+	if ctx == nil {
+		r.ctx.Store(nil)
+		return
+	}
+	r.ctx.Store(&ctx)
+}
+
+// SetProgress streams the progress of clones and fetches to w.
+func (r *ExecRunner) SetProgress(w io.Writer) {
+	r.Progress = w
+}
+
+func (r *ExecRunner) Clone(url, local string, options *CloneOptions) error {
+	if options == nil {
+		options = &CloneOptions{}
+	}
+	if len(options.Refspecs) == 0 {
+		args := []string{"clone", "--mirror"}
+		if r.Progress != nil {
+			args = append(args, "--progress")
+		}
+		if options.Filter != "" {
+			args = append(args, "--filter="+options.Filter)
+		}
+		if options.Depth > 0 {
+			args = append(args, fmt.Sprintf("--depth=%d", options.Depth), "--no-single-branch")
+		}
+		if !options.ShallowSince.IsZero() {
+			args = append(args, "--shallow-since="+options.ShallowSince.Format(time.RFC3339), "--no-single-branch")
+		}
+		if options.Reference != "" {
+			args = append(args, "--reference", options.Reference)
+		}
+		return r.transfer(append(args, url, local)...)
+	}
+	// git clone always fetches all refs, so set up the remote by hand.
+	// Unlike git clone, git init cannot learn the object format from the
+	// remote.
+	args := []string{"init", "--quiet", "--bare"}
+	if options.ObjectFormat != "" {
+		args = append(args, "--object-format="+options.ObjectFormat)
+	}
+	err := r.run(append(args, local)...)
+	if err != nil {
+		return err
+	}
+	if options.Reference != "" {
+		objects, err := filepath.Abs(filepath.Join(options.Reference, "objects"))
+		if err != nil {
+			return err
+		}
+		err = os.WriteFile(filepath.Join(local, alternatesFile), []byte(objects+"\n"), 0644)
+		if err != nil {
+			return err
+		}
+	}
+	err = r.run("-C", local, "remote", "add", "--mirror=fetch", "origin", url)
+	if err != nil {
+		return err
+	}
+	err = r.SetRefspecs(local, options.Refspecs...)
+	if err != nil {
+		return err
+	}
+	if options.Filter != "" {
+		err = r.Config(local, "remote.origin.promisor", "true")
+		if err != nil {
+			return err
+		}
+		err = r.Config(local, "remote.origin.partialclonefilter", options.Filter)
+		if err != nil {
+			return err
+		}
+	}
+	if options.Depth > 0 {
+		err = r.FetchDepth(local, options.Depth)
+	} else if !options.ShallowSince.IsZero() {
+		err = r.FetchSince(local, options.ShallowSince)
+	} else {
+		err = r.Fetch(local)
+	}
+	if err != nil {
+		return err
+	}
+	out, err := r.Command("ls-remote", "--symref", url, "HEAD").Output()
+	if err != nil {
+		return err
+	}
+	head, _, ok := strings.Cut(strings.TrimPrefix(string(out), "ref: "), "\tHEAD")
+	if !ok || !strings.HasPrefix(head, "refs/") {
+		return nil
+	}
+	return r.SymbolicRef(local, head)
+}
+
+func (r *ExecRunner) SetRefspecs(local string, refspecs ...string) error {
+	err := r.run("-C", local, "config", "--local", "--unset-all", "remote.origin.fetch")
+	var exitErr *exec.ExitError
+	if err != nil && !(errors.As(err, &exitErr) && exitErr.ExitCode() == 5) {
+		return err
+	}
+	for _, refspec := range refspecs {
+		err := r.run("-C", local, "config", "--local", "--add", "remote.origin.fetch", refspec)
+		if err != nil {
+			return err
+		}
+	}
+	return r.Config(local, "remote.origin.tagOpt", "--no-tags")
+}
+
+func (r *ExecRunner) Fetch(local string) error {
+	if r.Progress != nil {
+		// git remote update has no --progress.
+		return r.transfer("-C", local, "fetch", "--progress", "--all")
+	}
+	return r.run("-C", local, "remote", "update")
+}
+
+func (r *ExecRunner) FetchDepth(local string, depth int) error {
+	args := []string{"-C", local, "fetch", fmt.Sprintf("--depth=%d", depth)}
+	if r.Progress != nil {
+		args = append(args, "--progress")
+	}
+	return r.transfer(append(args, "origin")...)
+}
+
+func (r *ExecRunner) FetchSince(local string, since time.Time) error {
+	args := []string{"-C", local, "fetch", "--shallow-since=" + since.Format(time.RFC3339)}
+	if r.Progress != nil {
+		args = append(args, "--progress")
+	}
+	return r.transfer(append(args, "origin")...)
+}
+
+func (r *ExecRunner) Repack(local string, args ...string) error {
+	return r.run(append([]string{"-C", local, "repack"}, args...)...)
+}
+
+func (r *ExecRunner) Config(local, key, value string) error {
+	return r.run("-C", local, "config", "--local", key, value)
+}
+
+func (r *ExecRunner) ConfigAdd(local, key, value string) error {
+	return r.run("-C", local, "config", "--local", "--replace-all", key, value, "^"+regexp.QuoteMeta(value)+"$")
+}
+
+func (r *ExecRunner) ConfigValue(local, key string) (string, error) {
+	out, err := r.Command("-C", local, "config", "--get", key).Output()
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(out)), nil
+}
+
+func (r *ExecRunner) Fsck(local string, args ...string) error {
+	out, err := r.Command(append([]string{"-C", local, "fsck", "--no-progress"}, args...)...).CombinedOutput()
+	if err != nil {
+		if msg, _, _ := strings.Cut(strings.TrimSpace(string(out)), "\n"); msg != "" {
+			return fmt.Errorf("%w: %s", err, msg)
+		}
+		return err
+	}
+	return nil
+}
+
+func (r *ExecRunner) Refs(local string) (map[string]string, error) {
+	out, err := r.Command("-C", local, "for-each-ref", "--format=%(objectname) %(refname)").Output()
+	if err != nil {
+		return nil, err
+	}
+	return parseRefs(string(out), " "), nil
+}
+
+func (r *ExecRunner) UpdateRef(local, ref, oid string) error {
+	if oid == "" {
+		return r.run("-C", local, "update-ref", "-d", ref)
+	}
+	return r.run("-C", local, "update-ref", ref, oid)
+}
+
+func (r *ExecRunner) SymbolicRef(local, ref string) error {
+	return r.run("-C", local, "symbolic-ref", "HEAD", ref)
+}
+
+func (r *ExecRunner) IsAncestor(local, a, b string) (bool, error) {
+	err := r.run("-C", local, "merge-base", "--is-ancestor", a, b)
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) && exitErr.ExitCode() == 1 {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+func (r *ExecRunner) SubmoduleURLs(local string) ([]string, error) {
+	err := r.run("-C", local, "rev-parse", "--verify", "--quiet", "HEAD:.gitmodules")
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	out, err := r.Command("-C", local, "config", "--blob", "HEAD:.gitmodules", "--get-regexp", `^submodule\..*\.url$`).Output()
+	if errors.As(err, &exitErr) && exitErr.ExitCode() == 1 {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var urls []string
+	for _, line := range strings.Split(strings.TrimSpace(string(out)), "\n") {
+		if _, url, ok := strings.Cut(line, " "); ok {
+			urls = append(urls, url)
+		}
+	}
+	return urls, nil
+}
+
+func (r *ExecRunner) HeadFiles(local string, paths ...string) (map[string][]byte, error) {
+	err := r.run("-C", local, "rev-parse", "--verify", "--quiet", "HEAD^{commit}")
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	args := append([]string{"-C", local, "ls-tree", "-r", "-z", "--name-only", "HEAD", "--"}, paths...)
+	out, err := r.Command(args...).Output()
+	if err != nil {
+		return nil, err
+	}
+	files := make(map[string][]byte)
+	for _, path := range strings.Split(string(out), "\x00") {
+		if path == "" {
+			continue
+		}
+		b, err := r.Command("-C", local, "cat-file", "blob", "HEAD:"+path).Output()
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+		files[path] = b
+	}
+	return files, nil
+}
+
+func (r *ExecRunner) VerifySignature(local, ref string, keys config.Signatures) error {
+	out, err := r.Command("-C", local, "cat-file", "-t", ref).Output()
+	if err != nil {
+		return err
+	}
+	verify := "verify-commit"
+	if strings.TrimSpace(string(out)) == "tag" {
+		verify = "verify-tag"
+	}
+	args := []string{"-C", local}
+	if keys.AllowedSigners != "" {
+		args = append(args, "-c", "gpg.ssh.allowedSignersFile="+keys.AllowedSigners)
+	}
+	cmd := r.Command(append(args, verify, ref)...)
+	if keys.GPGHome != "" {
+		if cmd.Env == nil {
+			cmd.Env = os.Environ()
+		}
+		cmd.Env = append(cmd.Env, "GNUPGHOME="+keys.GPGHome)
+	}
+	stderr := &tailBuffer{}
+	cmd.Stderr = stderr
+	return gitError(cmd.Run(), stderr)
+}
+
+func (r *ExecRunner) LsRemote(url string) (map[string]string, error) {
+	out, err := r.Command("ls-remote", url).Output()
+	if err != nil {
+		return nil, err
+	}
+	return parseRefs(string(out), "\t"), nil
+}
+
+// parseRefs parses lines of object names and ref names separated by sep.
+func parseRefs(out, sep string) map[string]string {
+	refs := make(map[string]string)
+	for _, line := range strings.Split(strings.TrimSpace(out), "\n") {
+		oid, ref, ok := strings.Cut(line, sep)
+		if ok {
+			refs[ref] = oid
+		}
+	}
+	return refs
+}
+
+func (r *ExecRunner) CreateBundle(local, file string, exclude ...string) error {
+	args := []string{"-C", local, "bundle", "create", "--quiet", file, "--all"}
+	if len(exclude) > 0 {
+		args = append(append(args, "--not"), exclude...)
+	}
+	return r.run(args...)
+}
+
+func (r *ExecRunner) BundleHeads(file string) (map[string]string, error) {
+	out, err := r.Command("bundle", "list-heads", file).Output()
+	if err != nil {
+		return nil, err
+	}
+	return parseRefs(string(out), " "), nil
+}
+
+func (r *ExecRunner) PushMirror(local, url, token string) error {
+	return r.push(token, "-C", local, "push", "--mirror", url)
+}
+
+func (r *ExecRunner) Push(local, url, token string, refspecs ...string) error {
+	return r.push(token, append([]string{"-C", local, "push", url}, refspecs...)...)
+}
+
+// push runs a git push, passing token through the environment so it is
+// neither on the command line nor in the URLs of git's errors.
+func (r *ExecRunner) push(token string, args ...string) error {
+	cmd := r.Command(args...)
+	if token != "" {
+		if cmd.Env == nil {
+			cmd.Env = os.Environ()
+		}
+		cmd.Env = append(cmd.Env, credentialEnv("x-access-token", token)...)
+	}
+	stderr := &tailBuffer{}
+	cmd.Stderr = stderr
+	return gitError(cmd.Run(), stderr)
+}
+
+func (r *ExecRunner) WriteCommitGraph(local string) error {
+	return r.run("-C", local, "commit-graph", "write", "--reachable")
+}
+
+func (r *ExecRunner) Maintenance(local string, tasks ...string) error {
+	args := []string{"-C", local, "maintenance", "run"}
+	for _, task := range tasks {
+		args = append(args, "--task="+task)
+	}
+	return r.run(args...)
+}
+
+func (r *ExecRunner) CountObjects(local string) (*report.ObjectStats, error) {
+	out, err := r.Command("-C", local, "count-objects", "-v").Output()
+	if err != nil {
+		return nil, err
+	}
+	values := make(map[string]int64)
+	for _, line := range strings.Split(string(out), "\n") {
+		key, value, ok := strings.Cut(line, ": ")
+		if !ok {
+			continue
+		}
+		n, err := strconv.ParseInt(strings.TrimSpace(value), 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("count-objects %s: %w", key, err)
+		}
+		values[key] = n
+	}
+	stats := &report.ObjectStats{
+		LooseObjects: values["count"],
+		LooseSize:    values["size"] * 1024,
+		InPack:       values["in-pack"],
+		Packs:        values["packs"],
+		PackSize:     values["size-pack"] * 1024,
+		GarbageSize:  values["size-garbage"] * 1024,
+	}
+	// count-objects has no per pack sizes, but the packs are few.
+	packs, err := filepath.Glob(filepath.Join(local, "objects", "pack", "*.pack"))
+	if err != nil {
+		return nil, err
+	}
+	for _, pack := range packs {
+		fi, err := os.Stat(pack)
+		if err != nil {
+			return nil, err
+		}
+		stats.LargestPack = max(stats.LargestPack, fi.Size())
+	}
+	return stats, nil
+}
+
+func (r *ExecRunner) GC(local string) error {
+	return r.run("-C", local, "gc", "--quiet")
+}
+
+// touch creates empty .gitkeep files in refs and objects, so the directories
+// survive tools that drop empty directories, e.g. when copying the mirror.
+func touch(local string) error {
+	now := time.Now()
+	for _, path := range []string{filepath.Join(local, "refs", ".gitkeep"), filepath.Join(local, "objects", ".gitkeep")} {
+		f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY, 0644)
+		if err != nil {
+			return err
+		}
+		err = f.Close()
+		if err != nil {
+			return err
+		}
+		err = os.Chtimes(path, now, now)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// LastFetch returns when the mirror at local was last fetched.
+func LastFetch(local string) (time.Time, error) {
+	fi, err := os.Stat(filepath.Join(local, "FETCH_HEAD"))
+	if err != nil {
+		return time.Time{}, err
+	}
+	return fi.ModTime(), nil
+}
+
+// objects returns the object storage stats of the mirror at local.
+func (m *Mirrorer) objects(local string) (*report.ObjectStats, error) {
+	return m.Git.CountObjects(local)
+}
+
+func Remove(local string) error {
+	return os.RemoveAll(local)
+}
+
+// Size returns the total size of the files under local.
+func Size(local string) (int64, error) {
+	var n int64
+	err := filepath.WalkDir(local, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			return nil
+		}
+		fi, err := d.Info()
+		if err != nil {
+			return err
+		}
+		n += fi.Size()
+		return nil
+	})
+	return n, err
+}
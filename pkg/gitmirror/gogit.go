@@ -0,0 +1,346 @@
+//go:build gogit
+
+package gitmirror
+
+import (
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/chamzzzzzz/github-repo-mirror/pkg/config"
+	"github.com/chamzzzzzz/github-repo-mirror/pkg/report"
+	"github.com/go-git/go-billy/v5"
+	"github.com/go-git/go-billy/v5/osfs"
+	gogit "github.com/go-git/go-git/v5"
+	gitconfig "github.com/go-git/go-git/v5/config"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/cache"
+	"github.com/go-git/go-git/v5/storage/filesystem"
+)
+
+// The go-git backend is only built with the gogit build tag, so the default
+// binary does not carry go-git:
+//
+//	go build -tags gogit
+func init() {
+	backends["go-git"] = func(config *config.Config) GitRunner {
+		return &GoGitRunner{
+			Exec: &ExecRunner{
+				Resources:  config.Resources,
+				Network:    config.Network,
+				Path:       config.GitPath,
+				GlobalArgs: GitGlobalArgs(config),
+			},
+		}
+	}
+}
+
+// GoGitRunner clones and fetches with go-git, and reads and writes the refs
+// and config of mirrors, so mirroring works on hosts without a git binary.
+// Other operations have no go-git equivalent and are delegated to Exec, as
+// are clones and fetches through a configured proxy, since go-git has no
+// NO_PROXY matching, and with GitConfig or GitFlags, which only git reads.
+type GoGitRunner struct {
+	Exec *ExecRunner
+}
+
+// execOnly reports whether transfers need the git binary for the settings
+// go-git lacks.
+func (r *GoGitRunner) execOnly() bool {
+	return r.Exec.Network.Proxy != "" || len(r.Exec.GlobalArgs) > 0
+}
+
+// context returns the context of the Exec commands, to cancel transfers
+// with them.
+func (r *GoGitRunner) context() context.Context {
+	if ctx := r.Exec.ctx.Load(); ctx != nil {
+		return *ctx
+	}
+	return context.Background()
+}
+
+// open opens the mirror at local with go-git.
+func open(local string) (*gogit.Repository, error) {
+	fs := gitkeepFilter{osfs.New(local)}
+	return gogit.Open(filesystem.NewStorage(fs, cache.NewObjectLRUDefault()), nil)
+}
+
+// gitkeepFilter hides the .gitkeep files that touch leaves in the refs
+// directory of mirrors, which go-git would read as refs.
+type gitkeepFilter struct {
+	billy.Filesystem
+}
+
+func (f gitkeepFilter) ReadDir(path string) ([]os.FileInfo, error) {
+	infos, err := f.Filesystem.ReadDir(path)
+	if err != nil {
+		return nil, err
+	}
+	kept := infos[:0]
+	for _, info := range infos {
+		if info.Name() != ".gitkeep" {
+			kept = append(kept, info)
+		}
+	}
+	return kept, nil
+}
+
+func (r *GoGitRunner) Clone(url, local string, options *CloneOptions) error {
+	if r.execOnly() || options != nil && (len(options.Refspecs) > 0 || options.Filter != "" || options.Reference != "" || !options.ShallowSince.IsZero() || options.ObjectFormat == "sha256") {
+		return r.Exec.Clone(url, local, options)
+	}
+	caBundle, err := r.caBundle()
+	if err != nil {
+		return err
+	}
+	cloneOptions := &gogit.CloneOptions{
+		URL:             url,
+		Mirror:          true,
+		CABundle:        caBundle,
+		InsecureSkipTLS: r.Exec.Network.InsecureSkipVerify,
+		Progress:        r.Exec.Progress,
+	}
+	if options != nil {
+		cloneOptions.Depth = options.Depth
+	}
+	_, err = gogit.PlainCloneContext(r.context(), local, true, cloneOptions)
+	if err != nil {
+		// A failed clone leaves a partial repo, which git does not.
+		os.RemoveAll(local)
+	}
+	return err
+}
+
+func (r *GoGitRunner) caBundle() ([]byte, error) {
+	if r.Exec.Network.CAFile == "" {
+		return nil, nil
+	}
+	return os.ReadFile(r.Exec.Network.CAFile)
+}
+
+func (r *GoGitRunner) Fetch(local string) error {
+	if r.execOnly() {
+		return r.Exec.Fetch(local)
+	}
+	caBundle, err := r.caBundle()
+	if err != nil {
+		return err
+	}
+	repo, err := open(local)
+	if err != nil {
+		return err
+	}
+	remote, err := repo.Remote("origin")
+	if err != nil {
+		return err
+	}
+	refspecs := remote.Config().Fetch
+	for _, refspec := range refspecs {
+		// go-git has no negative refspecs.
+		if strings.HasPrefix(string(refspec), "^") {
+			return r.Exec.Fetch(local)
+		}
+	}
+	err = repo.FetchContext(r.context(), &gogit.FetchOptions{
+		RemoteName:      "origin",
+		RefSpecs:        refspecs,
+		Tags:            gogit.NoTags,
+		Force:           true,
+		Prune:           true,
+		CABundle:        caBundle,
+		InsecureSkipTLS: r.Exec.Network.InsecureSkipVerify,
+		Progress:        r.Exec.Progress,
+	})
+	if err == gogit.NoErrAlreadyUpToDate {
+		err = nil
+	}
+	if err == nil {
+		err = touchFetchHead(local)
+	}
+	return err
+}
+
+// touchFetchHead updates the time of FETCH_HEAD, which go-git does not
+// write but LastFetch reads.
+func touchFetchHead(local string) error {
+	path := filepath.Join(local, "FETCH_HEAD")
+	now := time.Now()
+	err := os.Chtimes(path, now, now)
+	if os.IsNotExist(err) {
+		return os.WriteFile(path, nil, 0644)
+	}
+	return err
+}
+
+func (r *GoGitRunner) FetchDepth(local string, depth int) error {
+	return r.Exec.FetchDepth(local, depth)
+}
+
+func (r *GoGitRunner) FetchSince(local string, since time.Time) error {
+	return r.Exec.FetchSince(local, since)
+}
+
+func (r *GoGitRunner) SetRefspecs(local string, refspecs ...string) error {
+	repo, err := open(local)
+	if err != nil {
+		return err
+	}
+	cfg, err := repo.Config()
+	if err != nil {
+		return err
+	}
+	remote, ok := cfg.Remotes["origin"]
+	if !ok {
+		return gogit.ErrRemoteNotFound
+	}
+	remote.Fetch = nil
+	for _, refspec := range refspecs {
+		remote.Fetch = append(remote.Fetch, gitconfig.RefSpec(refspec))
+	}
+	cfg.Raw.Section("remote").Subsection("origin").SetOption("tagOpt", "--no-tags")
+	return repo.SetConfig(cfg)
+}
+
+func (r *GoGitRunner) Repack(local string, args ...string) error {
+	return r.Exec.Repack(local, args...)
+}
+
+func (r *GoGitRunner) Config(local, key, value string) error {
+	repo, err := open(local)
+	if err != nil {
+		return err
+	}
+	cfg, err := repo.Config()
+	if err != nil {
+		return err
+	}
+	section, name, _ := strings.Cut(key, ".")
+	subsection := ""
+	if i := strings.LastIndex(name, "."); i >= 0 {
+		subsection, name = name[:i], name[i+1:]
+	}
+	// Remotes are marshalled from cfg.Remotes, overriding raw sections.
+	if remote, ok := cfg.Remotes[subsection]; ok && section == "remote" && name == "url" {
+		remote.URLs = []string{value}
+	} else if subsection == "" {
+		cfg.Raw.Section(section).SetOption(name, value)
+	} else {
+		cfg.Raw.Section(section).Subsection(subsection).SetOption(name, value)
+	}
+	return repo.SetConfig(cfg)
+}
+
+func (r *GoGitRunner) Fsck(local string, args ...string) error {
+	return r.Exec.Fsck(local, args...)
+}
+
+func (r *GoGitRunner) ConfigAdd(local, key, value string) error {
+	return r.Exec.ConfigAdd(local, key, value)
+}
+
+func (r *GoGitRunner) ConfigValue(local, key string) (string, error) {
+	return r.Exec.ConfigValue(local, key)
+}
+
+func (r *GoGitRunner) Refs(local string) (map[string]string, error) {
+	repo, err := open(local)
+	if err != nil {
+		return nil, err
+	}
+	iter, err := repo.References()
+	if err != nil {
+		return nil, err
+	}
+	refs := make(map[string]string)
+	err = iter.ForEach(func(ref *plumbing.Reference) error {
+		// Like git for-each-ref, only the refs under refs/ that point at
+		// objects.
+		if ref.Type() == plumbing.HashReference && strings.HasPrefix(ref.Name().String(), "refs/") {
+			refs[ref.Name().String()] = ref.Hash().String()
+		}
+		return nil
+	})
+	return refs, err
+}
+
+func (r *GoGitRunner) UpdateRef(local, ref, oid string) error {
+	repo, err := open(local)
+	if err != nil {
+		return err
+	}
+	if oid == "" {
+		return repo.Storer.RemoveReference(plumbing.ReferenceName(ref))
+	}
+	return repo.Storer.SetReference(plumbing.NewHashReference(plumbing.ReferenceName(ref), plumbing.NewHash(oid)))
+}
+
+func (r *GoGitRunner) SymbolicRef(local, ref string) error {
+	repo, err := open(local)
+	if err != nil {
+		return err
+	}
+	return repo.Storer.SetReference(plumbing.NewSymbolicReference(plumbing.HEAD, plumbing.ReferenceName(ref)))
+}
+
+func (r *GoGitRunner) IsAncestor(local, a, b string) (bool, error) {
+	return r.Exec.IsAncestor(local, a, b)
+}
+
+func (r *GoGitRunner) LsRemote(url string) (map[string]string, error) {
+	return r.Exec.LsRemote(url)
+}
+
+func (r *GoGitRunner) CreateBundle(local, file string, exclude ...string) error {
+	return r.Exec.CreateBundle(local, file, exclude...)
+}
+
+func (r *GoGitRunner) BundleHeads(file string) (map[string]string, error) {
+	return r.Exec.BundleHeads(file)
+}
+
+func (r *GoGitRunner) PushMirror(local, url, token string) error {
+	return r.Exec.PushMirror(local, url, token)
+}
+
+func (r *GoGitRunner) Push(local, url, token string, refspecs ...string) error {
+	return r.Exec.Push(local, url, token, refspecs...)
+}
+
+func (r *GoGitRunner) WriteCommitGraph(local string) error {
+	return r.Exec.WriteCommitGraph(local)
+}
+
+func (r *GoGitRunner) Maintenance(local string, tasks ...string) error {
+	return r.Exec.Maintenance(local, tasks...)
+}
+
+func (r *GoGitRunner) SubmoduleURLs(local string) ([]string, error) {
+	return r.Exec.SubmoduleURLs(local)
+}
+
+func (r *GoGitRunner) CountObjects(local string) (*report.ObjectStats, error) {
+	return r.Exec.CountObjects(local)
+}
+
+func (r *GoGitRunner) GC(local string) error {
+	return r.Exec.GC(local)
+}
+
+func (r *GoGitRunner) HeadFiles(local string, paths ...string) (map[string][]byte, error) {
+	return r.Exec.HeadFiles(local, paths...)
+}
+
+func (r *GoGitRunner) VerifySignature(local, ref string, keys config.Signatures) error {
+	return r.Exec.VerifySignature(local, ref, keys)
+}
+
+func (r *GoGitRunner) SetContext(ctx context.Context) {
+	r.Exec.SetContext(ctx)
+}
+
+func (r *GoGitRunner) SetProgress(w io.Writer) {
+	r.Exec.SetProgress(w)
+}
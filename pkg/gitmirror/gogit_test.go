@@ -0,0 +1,68 @@
+//go:build gogit
+
+package gitmirror
+
+import (
+	"testing"
+	"time"
+
+	"github.com/chamzzzzzz/github-repo-mirror/pkg/config"
+	"github.com/chamzzzzzz/github-repo-mirror/pkg/report"
+)
+
+func TestGoGitBackend(t *testing.T) {
+	c := &config.Config{Sources: []*config.Source{{Username: "alice"}}, Backend: "go-git"}
+	m := newTestMirrorer(t, c)
+	if _, ok := m.Git.(*GoGitRunner); !ok {
+		t.Fatalf("New() git runner = %T, want *GoGitRunner", m.Git)
+	}
+	m.push(t, "alice/proj", nil, false)
+	stat, err := m.Update(c.Sources[0], m.api.add("alice/proj", time.Now(), true))
+	if err != nil {
+		t.Fatal(err)
+	}
+	result := stat.Results[0]
+	if result.Outcome != report.OutcomeMirrored {
+		t.Fatalf("Update() = %s, %s, want mirrored", result.Outcome, result.Error)
+	}
+	local := result.Local
+
+	second := m.push(t, "alice/proj", map[string]string{"README": "second"}, false)
+	git(t, m.upstream+"/alice/proj.git", "tag", "v1", second)
+	stat, err = m.Update(c.Sources[0], m.api.add("alice/proj", time.Now().Add(time.Minute), true))
+	if err != nil {
+		t.Fatal(err)
+	}
+	result = stat.Results[0]
+	if result.Outcome != report.OutcomeUpdated {
+		t.Fatalf("Update() = %s, %s, want updated", result.Outcome, result.Error)
+	}
+	refs, err := m.Git.Refs(local)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if refs["refs/heads/main"] != second || refs["refs/tags/v1"] != second {
+		t.Errorf("Refs() = %v, want main and v1 at %s", refs, second)
+	}
+	if got := git(t, local, "symbolic-ref", "HEAD"); got != "refs/heads/main" {
+		t.Errorf("HEAD = %s, want refs/heads/main", got)
+	}
+	if _, err := LastFetch(local); err != nil {
+		t.Errorf("LastFetch() = %v, want the fetch recorded", err)
+	}
+
+	err = m.Git.UpdateRef(local, "refs/backup/test", second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := git(t, local, "rev-parse", "refs/backup/test"); got != second {
+		t.Errorf("refs/backup/test = %s, want %s", got, second)
+	}
+	err = m.Git.UpdateRef(local, "refs/backup/test", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := git(t, local, "for-each-ref", "refs/backup/"); got != "" {
+		t.Errorf("refs/backup = %q after delete, want none", got)
+	}
+}
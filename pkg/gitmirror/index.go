@@ -0,0 +1,212 @@
+package gitmirror
+
+import (
+	"bytes"
+	"fmt"
+	"html/template"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/chamzzzzzz/github-repo-mirror/pkg/state"
+)
+
+// indexOwnersDir is the directory of the per-owner pages of the index,
+// relative to the destination.
+const indexOwnersDir = "owners"
+
+// indexRepo is a mirror listed in the index.
+type indexRepo struct {
+	Repo        string
+	Owner       string
+	Path        string
+	Description string
+	Upstream    string
+	Bytes       int64
+	Updated     time.Time
+}
+
+// indexOwner is an owner listed in the index, with its page.
+type indexOwner struct {
+	Owner string
+	Page  string
+	Repos []*indexRepo
+	Bytes int64
+}
+
+// indexPage is the data of an index page. Root is the path from the page to
+// the destination.
+type indexPage struct {
+	Title  string
+	Time   time.Time
+	Root   string
+	Owner  *indexOwner
+	Owners []*indexOwner
+	Repos  []*indexRepo
+}
+
+// WriteIndex writes the static HTML index of the mirrors of repos that are
+// under the destination, if enabled.
+func (m *Mirrorer) WriteIndex(repos []*state.Repo) error {
+	index := m.Config.Index
+	if !index.Enabled {
+		return nil
+	}
+	title := index.Title
+	if title == "" {
+		title = "Mirrors"
+	}
+	owners := make(map[string]*indexOwner)
+	var list []*indexRepo
+	for _, repo := range repos {
+		if !isBare(repo.Local) {
+			continue
+		}
+		rel, err := filepath.Rel(m.Config.Destination, repo.Local)
+		if err != nil || strings.HasPrefix(rel, "..") {
+			continue
+		}
+		r := &indexRepo{
+			Repo:     repo.Repo,
+			Owner:    repo.Repo,
+			Path:     filepath.ToSlash(rel),
+			Upstream: Remote(repo.Repo),
+			Bytes:    repo.Bytes,
+			Updated:  repo.LastSuccess,
+		}
+		if i := strings.LastIndex(repo.Repo, "/"); i >= 0 {
+			r.Owner = repo.Repo[:i]
+		}
+		if metadata, err := ReadMetadata(repo.Local); err == nil {
+			r.Description = metadata.Description
+			r.Upstream = metadata.Remote
+		}
+		r.Upstream = strings.TrimSuffix(r.Upstream, ".git")
+		list = append(list, r)
+		owner := owners[r.Owner]
+		if owner == nil {
+			owner = &indexOwner{
+				Owner: r.Owner,
+				Page:  indexOwnersDir + "/" + strings.ReplaceAll(r.Owner, "/", "_") + ".html",
+			}
+			owners[r.Owner] = owner
+		}
+		owner.Repos = append(owner.Repos, r)
+		owner.Bytes += r.Bytes
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Repo < list[j].Repo })
+	var ownerList []*indexOwner
+	for _, owner := range owners {
+		ownerList = append(ownerList, owner)
+	}
+	sort.Slice(ownerList, func(i, j int) bool { return ownerList[i].Owner < ownerList[j].Owner })
+
+	now := time.Now()
+	err := writeIndexPage(filepath.Join(m.Config.Destination, "index.html"), &indexPage{
+		Title:  title,
+		Time:   now,
+		Owners: ownerList,
+		Repos:  list,
+	})
+	if err != nil {
+		return err
+	}
+	dir := filepath.Join(m.Config.Destination, indexOwnersDir)
+	err = os.MkdirAll(dir, 0755)
+	if err != nil {
+		return err
+	}
+	pages := make(map[string]bool)
+	for _, owner := range ownerList {
+		path := filepath.Join(m.Config.Destination, filepath.FromSlash(owner.Page))
+		pages[filepath.Base(path)] = true
+		err = writeIndexPage(path, &indexPage{
+			Title: title + " - " + owner.Owner,
+			Time:  now,
+			Root:  "../",
+			Owner: owner,
+			Repos: owner.Repos,
+		})
+		if err != nil {
+			return err
+		}
+	}
+	// Remove the pages of owners without mirrors anymore.
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		if !entry.IsDir() && strings.HasSuffix(entry.Name(), ".html") && !pages[entry.Name()] {
+			os.Remove(filepath.Join(dir, entry.Name()))
+		}
+	}
+	return nil
+}
+
+func writeIndexPage(path string, page *indexPage) error {
+	var b bytes.Buffer
+	err := indexTemplate.Execute(&b, page)
+	if err != nil {
+		return err
+	}
+	return writeFileIfChanged(path, b.Bytes())
+}
+
+var indexTemplate = template.Must(template.New("index").Funcs(template.FuncMap{
+	"time": func(t time.Time) string {
+		if t.IsZero() {
+			return "never"
+		}
+		return t.Format(time.RFC3339)
+	},
+	"size": func(n int64) string {
+		const unit = 1024
+		if n < unit {
+			return fmt.Sprintf("%d B", n)
+		}
+		div, exp := int64(unit), 0
+		for m := n / unit; m >= unit; m /= unit {
+			div *= unit
+			exp++
+		}
+		return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+	},
+	// link escapes each segment of a relative slash-separated path.
+	"link": func(path string) string {
+		segments := strings.Split(path, "/")
+		for i := range segments {
+			segments[i] = url.PathEscape(segments[i])
+		}
+		return strings.Join(segments, "/")
+	},
+}).Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>{{.Title}}</title>
+<style>
+body { font-family: sans-serif; margin: 2em; }
+table { border-collapse: collapse; margin-bottom: 2em; }
+th, td { border: 1px solid #ccc; padding: 0.3em 0.6em; text-align: left; }
+</style>
+</head>
+<body>
+<h1>{{.Title}}</h1>
+<p>As of {{time .Time}}{{if .Owner}} - <a href="{{.Root}}index.html">all owners</a>{{end}}</p>
+{{if .Owners}}<h2>Owners</h2>
+<table>
+<tr><th>Owner</th><th>Repos</th><th>Size</th></tr>
+{{range .Owners}}<tr><td><a href="{{link .Page}}">{{.Owner}}</a></td><td>{{len .Repos}}</td><td>{{size .Bytes}}</td></tr>
+{{end}}</table>
+{{end}}<h2>Repos</h2>
+<table>
+<tr><th>Repo</th><th>Description</th><th>Size</th><th>Last update</th><th>Upstream</th></tr>
+{{range .Repos}}<tr><td><a href="{{$.Root}}{{link .Path}}">{{.Repo}}</a></td><td>{{.Description}}</td><td>{{size .Bytes}}</td><td>{{time .Updated}}</td><td><a href="{{.Upstream}}">{{.Upstream}}</a></td></tr>
+{{end}}</table>
+</body>
+</html>
+`))
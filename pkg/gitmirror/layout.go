@@ -0,0 +1,208 @@
+package gitmirror
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/template"
+
+	"github.com/chamzzzzzz/github-repo-mirror/pkg/config"
+	"github.com/chamzzzzzz/github-repo-mirror/pkg/github"
+)
+
+// DefaultPathTemplate is the layout of mirrors under their destination.
+const DefaultPathTemplate = "{{.Host}}/{{.Owner}}/{{.Name}}.git"
+
+// PathData is the data PathTemplate is executed with.
+type PathData struct {
+	Host     string
+	Owner    string
+	Name     string
+	FullName string
+	Source   string
+}
+
+func (m *Mirrorer) parseTemplates() error {
+	m.templates = make(map[*config.Source]*template.Template)
+	for _, source := range m.Config.Sources {
+		text := source.PathTemplate
+		if text == "" {
+			text = m.Config.PathTemplate
+		}
+		if text == "" {
+			text = DefaultPathTemplate
+		}
+		t, err := template.New("path").Option("missingkey=error").Parse(text)
+		if err != nil {
+			return fmt.Errorf("source %s: path template: %w", source.Label(), err)
+		}
+		err = t.Execute(&strings.Builder{}, &PathData{})
+		if err != nil {
+			return fmt.Errorf("source %s: path template: %w", source.Label(), err)
+		}
+		m.templates[source] = t
+	}
+	return nil
+}
+
+// Destination returns the destination directory of the source.
+func (m *Mirrorer) Destination(source *config.Source) string {
+	if source.Destination != "" {
+		return source.Destination
+	}
+	return m.Config.Destination
+}
+
+// Destinations returns the distinct destination directories of all sources
+// and the parent directories of repos with an explicit path.
+func (m *Mirrorer) Destinations() []string {
+	destinations := []string{m.Config.Destination}
+	for _, source := range m.Config.Sources {
+		if !contains(destinations, m.Destination(source)) {
+			destinations = append(destinations, m.Destination(source))
+		}
+	}
+	for _, rc := range m.Config.Repos {
+		if rc.Path != "" && !contains(destinations, filepath.Dir(rc.Path)) {
+			destinations = append(destinations, filepath.Dir(rc.Path))
+		}
+	}
+	return destinations
+}
+
+// LocalPath returns the path of the repo's mirror.
+func (m *Mirrorer) LocalPath(source *config.Source, repo *github.Repo) string {
+	if rc := m.Config.Repos[repo.FullName]; rc != nil && rc.Path != "" {
+		return rc.Path
+	}
+	host := repo.Host
+	if host == "" {
+		host = "github.com"
+	}
+	// Azure DevOps repos are owned by organization/project.
+	owner, name := repo.FullName, ""
+	if i := strings.LastIndex(repo.FullName, "/"); i >= 0 {
+		owner, name = repo.FullName[:i], repo.FullName[i+1:]
+	}
+	data := &PathData{
+		Host:     host,
+		Owner:    owner,
+		Name:     name,
+		FullName: repo.FullName,
+		Source:   source.Label(),
+	}
+	var b strings.Builder
+	t := m.templates[source]
+	if t == nil || t.Execute(&b, data) != nil {
+		b.Reset()
+		template.Must(template.New("path").Parse(DefaultPathTemplate)).Execute(&b, data)
+	}
+	path := casePath(m.Config.PathCase, b.String(), repo.FullName)
+	return filepath.Join(m.Destination(source), filepath.FromSlash(path))
+}
+
+// FindLocal returns the path of an existing mirror of the repo with the given
+// full name in any source's layout.
+func (m *Mirrorer) FindLocal(fullName string) (string, bool) {
+	repo := &github.Repo{
+		FullName: fullName,
+	}
+	for _, source := range m.Config.Sources {
+		local := m.LocalPath(source, repo)
+		if _, err := os.Stat(local); err == nil {
+			return local, true
+		}
+	}
+	return "", false
+}
+
+// Lookup finds the repo with the given full name on GitHub, trying the
+// source of the repo's owner first and then the others, and returns it with
+// the first source that can access it and whose filters include it.
+func (m *Mirrorer) Lookup(fullName string) (*config.Source, *github.Repo, error) {
+	owner, _, _ := strings.Cut(fullName, "/")
+	sources := make([]*config.Source, 0, len(m.Config.Sources))
+	for _, source := range m.Config.Sources {
+		if source.Type == SourceAzureDevOps {
+			continue
+		}
+		if strings.EqualFold(source.Username, owner) {
+			sources = append([]*config.Source{source}, sources...)
+		} else {
+			sources = append(sources, source)
+		}
+	}
+	err := errors.New("no sources configured")
+	for _, source := range sources {
+		repo, getErr := m.Client.GetRepo(source, fullName)
+		if getErr != nil {
+			err = getErr
+			continue
+		}
+		excluded, reason, policyErr := m.Excluded(source, repo)
+		if policyErr != nil {
+			return nil, nil, policyErr
+		}
+		if !excluded {
+			return source, repo, nil
+		}
+		err = fmt.Errorf("excluded by source %s: %s", source.Label(), reason)
+	}
+	return nil, nil, err
+}
+
+// LocalMirrors returns the paths of all bare mirrors under the destinations,
+// skipping the quarantine.
+func (m *Mirrorer) LocalMirrors() ([]string, error) {
+	var locals []string
+	seen := make(map[string]bool)
+	quarantine := m.Config.Verify.QuarantineDir
+	for _, root := range m.Destinations() {
+		err := filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+			if err != nil {
+				if os.IsNotExist(err) && path == root {
+					return filepath.SkipDir
+				}
+				return err
+			}
+			if d.IsDir() && path != root && (d.Name() == quarantineDir || path == quarantine) {
+				return filepath.SkipDir
+			}
+			if d.IsDir() && isBare(path) {
+				if !seen[path] {
+					seen[path] = true
+					locals = append(locals, path)
+				}
+				return filepath.SkipDir
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	return locals, nil
+}
+
+func isBare(path string) bool {
+	if _, err := os.Stat(filepath.Join(path, "HEAD")); err != nil {
+		return false
+	}
+	fi, err := os.Stat(filepath.Join(path, "objects"))
+	return err == nil && fi.IsDir()
+}
+
+// fullName guesses the "owner/repo" name of the mirror at local from the
+// last two elements of its path, which holds for the default layout and
+// most path templates.
+func (m *Mirrorer) fullName(local string) string {
+	name := strings.TrimSuffix(filepath.ToSlash(local), ".git")
+	parts := strings.Split(name, "/")
+	if len(parts) < 2 {
+		return name
+	}
+	return parts[len(parts)-2] + "/" + parts[len(parts)-1]
+}
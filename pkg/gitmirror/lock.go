@@ -0,0 +1,111 @@
+package gitmirror
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/chamzzzzzz/github-repo-mirror/pkg/filelock"
+)
+
+// lockFile is created in each destination while a run holds it.
+const lockFile = "github-repo-mirror.lock"
+
+// ErrLocked is returned by Lock if another process holds a destination.
+var ErrLocked = errors.New("destination is locked by another run")
+
+// Lock acquires the run lock of every destination, so overlapping runs do
+// not sync the same mirrors. If wait is set, it waits for locks held by
+// other processes; otherwise it fails with ErrLocked. The locks are released
+// when their process exits, so a crashed run leaves none behind. The
+// returned function releases the locks.
+func (m *Mirrorer) Lock(wait bool) (func(), error) {
+	var held []*filelock.Lock
+	unlock := func() {
+		for _, l := range held {
+			l.Release()
+		}
+	}
+	for _, destination := range m.Destinations() {
+		err := os.MkdirAll(destination, 0755)
+		if err != nil {
+			unlock()
+			return nil, err
+		}
+		path := filepath.Join(destination, lockFile)
+		for {
+			l, owner, err := filelock.TryAcquire(path)
+			if err != nil {
+				unlock()
+				return nil, err
+			}
+			if l != nil {
+				held = append(held, l)
+				break
+			}
+			if !wait {
+				unlock()
+				return nil, fmt.Errorf("%w: %s held by %s", ErrLocked, path, owner)
+			}
+			m.Logger.Info("Waiting for lock", "lock", path, "owner", owner)
+			time.Sleep(10 * time.Second)
+		}
+	}
+	return unlock, nil
+}
+
+// LockRepo acquires the lock of the mirror at local, waiting while another
+// goroutine or process syncs it, e.g. a webhook update during a run. The lock
+// is a mutex within the process and a local+".lock" file across processes.
+// The returned function releases it.
+func (m *Mirrorer) LockRepo(local string) (func(), error) {
+	v, _ := m.repoLocks.LoadOrStore(local, &sync.Mutex{})
+	mu := v.(*sync.Mutex)
+	mu.Lock()
+	path := local + ".lock"
+	err := os.MkdirAll(filepath.Dir(path), 0755)
+	if err != nil {
+		mu.Unlock()
+		return nil, err
+	}
+	for logged := false; ; logged = true {
+		l, owner, err := filelock.TryAcquire(path)
+		if err != nil {
+			mu.Unlock()
+			return nil, err
+		}
+		if l != nil {
+			return func() {
+				l.Release()
+				mu.Unlock()
+			}, nil
+		}
+		if !logged {
+			m.Logger.Info("Waiting for repo lock", "lock", path, "owner", owner)
+		}
+		time.Sleep(time.Second)
+	}
+}
+
+// TryLockRepo acquires the lock of the mirror at local like LockRepo, but
+// does not wait: if the mirror is being synced, it returns a nil function
+// and who holds the lock.
+func (m *Mirrorer) TryLockRepo(local string) (func(), string, error) {
+	v, _ := m.repoLocks.LoadOrStore(local, &sync.Mutex{})
+	mu := v.(*sync.Mutex)
+	if !mu.TryLock() {
+		return nil, "this process", nil
+	}
+	l, owner, err := filelock.TryAcquire(local + ".lock")
+	if l == nil {
+		mu.Unlock()
+		return nil, owner, err
+	}
+	return func() {
+		l.Release()
+		mu.Unlock()
+	}, "", nil
+}
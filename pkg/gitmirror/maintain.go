@@ -0,0 +1,71 @@
+package gitmirror
+
+import (
+	"log/slog"
+	"time"
+)
+
+// Maintain consolidates the object storage of the mirror at local once it
+// exceeds the configured loose object or pack counts, since incremental
+// fetches only ever add loose objects and small packs. It reports whether
+// maintenance ran.
+func (m *Mirrorer) Maintain(local string, logger *slog.Logger) (bool, error) {
+	name := m.RepoName(local)
+	maintenance := m.MaintenanceConfig(name)
+	if maintenance.Strategy == "none" {
+		return false, nil
+	}
+	unlock, err := m.LockRepo(local)
+	if err != nil {
+		return false, err
+	}
+	defer unlock()
+	objects, err := m.objects(local)
+	if err != nil {
+		return false, err
+	}
+	if objects.LooseObjects <= int64(maintenance.MaxLooseObjects) && objects.Packs <= int64(maintenance.MaxPacks) {
+		logger.Debug("Skipped maintenance", "loose_objects", objects.LooseObjects, "packs", objects.Packs)
+		return false, nil
+	}
+	logger.Info("Maintaining", "loose_objects", objects.LooseObjects, "packs", objects.Packs)
+	start := time.Now()
+	switch maintenance.Strategy {
+	case "maintenance":
+		err = m.Git.Maintenance(local, "loose-objects", "incremental-repack", "commit-graph")
+	case "gc":
+		err = m.Git.GC(local)
+	default:
+		err = m.Git.Repack(local, m.RepackConfig(name).Args()...)
+		if err == nil {
+			err = m.Git.WriteCommitGraph(local)
+		}
+	}
+	if err != nil {
+		return false, err
+	}
+	logger.Info("Successfully maintain", "duration", time.Since(start))
+	return true, nil
+}
+
+// MaintainAll runs Maintain on every local mirror and returns the number of
+// mirrors maintained and failed.
+func (m *Mirrorer) MaintainAll() (maintained, failed int, err error) {
+	locals, err := m.LocalMirrors()
+	if err != nil {
+		return 0, 0, err
+	}
+	for _, local := range locals {
+		logger := m.Logger.With("local", local, "operation", "maintain")
+		ran, err := m.Maintain(local, logger)
+		if err != nil {
+			logger.Error("Failed maintain", "error", err)
+			failed++
+			continue
+		}
+		if ran {
+			maintained++
+		}
+	}
+	return maintained, failed, nil
+}
@@ -0,0 +1,169 @@
+package gitmirror
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/chamzzzzzz/github-repo-mirror/pkg/report"
+)
+
+// Manifest lists the mirrors of a destination with a digest of their refs,
+// so that a standby copy of the destination can be checked for completeness.
+type Manifest struct {
+	Time  time.Time        `json:"time"`
+	Repos []*ManifestEntry `json:"repos"`
+}
+
+type ManifestEntry struct {
+	Repo string `json:"repo"`
+	// Path is relative to the destination.
+	Path       string `json:"path"`
+	RefsDigest string `json:"refs_digest"`
+}
+
+// ManifestPath returns the path of the destination's manifest.
+func (m *Mirrorer) ManifestPath() string {
+	return filepath.Join(m.Config.Destination, "manifest.json")
+}
+
+// FailuresPath returns the path of the failures of the last run.
+func (m *Mirrorer) FailuresPath() string {
+	if m.Config.FailuresPath != "" {
+		return m.Config.FailuresPath
+	}
+	return filepath.Join(m.Config.Destination, "failures.json")
+}
+
+// WriteManifest writes the manifest of the mirrors synced in stats. Entries
+// of the previous manifest are kept for mirrors that still exist but were
+// not synced, e.g. because their source could not be listed.
+func (m *Mirrorer) WriteManifest(stats []*report.Stat) error {
+	manifest := &Manifest{
+		Time: time.Now(),
+	}
+	synced := make(map[string]bool)
+	for _, stat := range stats {
+		for _, result := range stat.Results {
+			if result.Outcome != report.OutcomeMirrored && result.Outcome != report.OutcomeUpdated {
+				continue
+			}
+			digest, err := m.RefsDigest(result.Local)
+			if err != nil {
+				return fmt.Errorf("%s: %w", result.Repo, err)
+			}
+			path, err := filepath.Rel(m.Config.Destination, result.Local)
+			if err != nil {
+				return err
+			}
+			manifest.Repos = append(manifest.Repos, &ManifestEntry{
+				Repo:       result.Repo,
+				Path:       filepath.ToSlash(path),
+				RefsDigest: digest,
+			})
+			synced[result.Repo] = true
+		}
+	}
+	previous, err := ReadManifest(m.ManifestPath())
+	if err == nil {
+		for _, entry := range previous.Repos {
+			if synced[entry.Repo] {
+				continue
+			}
+			if _, err := os.Stat(filepath.Join(m.Config.Destination, filepath.FromSlash(entry.Path))); err == nil {
+				manifest.Repos = append(manifest.Repos, entry)
+			}
+		}
+	}
+	sort.Slice(manifest.Repos, func(i, j int) bool { return manifest.Repos[i].Repo < manifest.Repos[j].Repo })
+	b, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return err
+	}
+	path := m.ManifestPath()
+	err = os.WriteFile(path+".tmp", b, 0644)
+	if err != nil {
+		return err
+	}
+	return os.Rename(path+".tmp", path)
+}
+
+// ReadManifest reads a manifest file.
+func ReadManifest(path string) (*Manifest, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	manifest := &Manifest{}
+	err = json.Unmarshal(b, manifest)
+	if err != nil {
+		return nil, err
+	}
+	return manifest, nil
+}
+
+// RefsDigest returns a digest of all refs of the mirror at local.
+func (m *Mirrorer) RefsDigest(local string) (string, error) {
+	refs, err := m.Git.Refs(local)
+	if err != nil {
+		return "", err
+	}
+	names := make([]string, 0, len(refs))
+	for name := range refs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	h := sha256.New()
+	for _, name := range names {
+		fmt.Fprintf(h, "%s %s\n", refs[name], name)
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// Authority marks a destination as the authoritative copy after a failover.
+type Authority struct {
+	Time     time.Time `json:"time"`
+	Host     string    `json:"host"`
+	Manifest string    `json:"manifest"`
+}
+
+// CheckManifest compares the destination's mirrors with a manifest, usually
+// the primary's, and returns a description of each missing or stale mirror.
+func (m *Mirrorer) CheckManifest(manifest *Manifest) []string {
+	var problems []string
+	for _, entry := range manifest.Repos {
+		local := filepath.Join(m.Config.Destination, filepath.FromSlash(entry.Path))
+		if _, err := os.Stat(local); err != nil {
+			problems = append(problems, fmt.Sprintf("%s: missing %s", entry.Repo, local))
+			continue
+		}
+		digest, err := m.RefsDigest(local)
+		if err != nil {
+			problems = append(problems, fmt.Sprintf("%s: %s", entry.Repo, err))
+			continue
+		}
+		if digest != entry.RefsDigest {
+			problems = append(problems, fmt.Sprintf("%s: refs differ from manifest", entry.Repo))
+		}
+	}
+	return problems
+}
+
+// Promote marks the destination as authoritative.
+func (m *Mirrorer) Promote(manifestPath string) error {
+	host, _ := os.Hostname()
+	b, err := json.MarshalIndent(&Authority{
+		Time:     time.Now(),
+		Host:     host,
+		Manifest: manifestPath,
+	}, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(m.Config.Destination, "authoritative.json"), b, 0644)
+}
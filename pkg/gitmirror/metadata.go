@@ -0,0 +1,128 @@
+package gitmirror
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/chamzzzzzz/github-repo-mirror/pkg/github"
+	"github.com/chamzzzzzz/github-repo-mirror/pkg/report"
+)
+
+// metadataFile is written inside each bare mirror with the upstream metadata
+// that git itself does not carry.
+const metadataFile = "mirror-metadata.json"
+
+// Metadata is the content of a mirror's mirror-metadata.json.
+type Metadata struct {
+	Repo          string   `json:"repo"`
+	Remote        string   `json:"remote"`
+	Description   string   `json:"description"`
+	Homepage      string   `json:"homepage"`
+	Topics        []string `json:"topics"`
+	DefaultBranch string   `json:"default_branch"`
+	Private       bool     `json:"private"`
+	// HistorySince is set for mirrors trimmed by MaxAge to the start of
+	// their history. They are not full backups.
+	HistorySince *time.Time `json:"history_since,omitempty"`
+	// ObjectFormat is the object format of the mirror, sha1 or sha256.
+	ObjectFormat string `json:"object_format,omitempty"`
+}
+
+// writeMetadata points HEAD of the mirror at local to the upstream default
+// branch and writes the repo's description to the description file read by
+// cgit and gitweb, and all of its metadata to mirror-metadata.json. It
+// returns the change of the default branch since the last metadata, if any.
+// Failures are logged but do not fail the sync.
+func (m *Mirrorer) writeMetadata(local string, repo *github.Repo, logger *slog.Logger) *report.BranchChange {
+	var change *report.BranchChange
+	if repo.DefaultBranch != "" {
+		err := m.Git.SymbolicRef(local, "refs/heads/"+repo.DefaultBranch)
+		if err != nil {
+			logger.Warn("Failed to set HEAD", "branch", repo.DefaultBranch, "error", err)
+		} else if previous, err := ReadMetadata(local); err == nil && previous.DefaultBranch != "" && previous.DefaultBranch != repo.DefaultBranch {
+			change = &report.BranchChange{
+				From: previous.DefaultBranch,
+				To:   repo.DefaultBranch,
+			}
+			logger.Info("Default branch changed, moved HEAD", "from", change.From, "to", change.To)
+		}
+	}
+	description := strings.NewReplacer("\r", " ", "\n", " ").Replace(repo.Description)
+	var since *time.Time
+	if t, err := historySince(m.Config.Repos[repo.FullName]); err == nil && !t.IsZero() {
+		since = &t
+		description = strings.TrimSpace(fmt.Sprintf("%s [trimmed: history since %s only]", description, t.Format("2006-01-02")))
+	}
+	err := writeFileIfChanged(filepath.Join(local, "description"), []byte(description+"\n"))
+	if err != nil {
+		logger.Warn("Failed to write description", "error", err)
+	}
+	format, err := m.ObjectFormat(local)
+	if err != nil {
+		logger.Warn("Failed to get object format", "error", err)
+	}
+	b, err := json.MarshalIndent(&Metadata{
+		Repo:          repo.FullName,
+		Remote:        RepoRemote(repo),
+		Description:   repo.Description,
+		Homepage:      repo.Homepage,
+		Topics:        repo.Topics,
+		DefaultBranch: repo.DefaultBranch,
+		Private:       repo.Private,
+		HistorySince:  since,
+		ObjectFormat:  format,
+	}, "", "  ")
+	if err == nil {
+		err = writeFileIfChanged(filepath.Join(local, metadataFile), b)
+	}
+	if err != nil {
+		logger.Warn("Failed to write metadata", "error", err)
+	}
+	return change
+}
+
+// ReadMetadata returns the metadata written into the mirror at local.
+func ReadMetadata(local string) (*Metadata, error) {
+	b, err := os.ReadFile(filepath.Join(local, metadataFile))
+	if err != nil {
+		return nil, err
+	}
+	metadata := &Metadata{}
+	err = json.Unmarshal(b, metadata)
+	if err != nil {
+		return nil, err
+	}
+	return metadata, nil
+}
+
+// RepoName returns the "owner/repo" name of the mirror at local from its
+// metadata, or guessed from its path for mirrors synced before metadata was
+// written.
+func (m *Mirrorer) RepoName(local string) string {
+	metadata, err := ReadMetadata(local)
+	if err == nil && metadata.Repo != "" {
+		return metadata.Repo
+	}
+	return m.fullName(local)
+}
+
+// writeFileIfChanged atomically replaces the file at path with b, unless it
+// already has that content.
+func writeFileIfChanged(path string, b []byte) error {
+	old, err := os.ReadFile(path)
+	if err == nil && bytes.Equal(old, b) {
+		return nil
+	}
+	tmp := path + ".tmp"
+	err = os.WriteFile(tmp, b, 0644)
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
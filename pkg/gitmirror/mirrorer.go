@@ -0,0 +1,910 @@
+// Package gitmirror mirrors GitHub repos into local bare repositories.
+package gitmirror
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+
+	"github.com/chamzzzzzz/github-repo-mirror/pkg/azuredevops"
+	"github.com/chamzzzzzz/github-repo-mirror/pkg/config"
+	"github.com/chamzzzzzz/github-repo-mirror/pkg/github"
+	"github.com/chamzzzzzz/github-repo-mirror/pkg/report"
+	"github.com/chamzzzzzz/github-repo-mirror/pkg/state"
+	"github.com/chamzzzzzz/github-repo-mirror/pkg/tracing"
+)
+
+// Mirrorer mirrors the repos of the configured sources into the destination.
+type Mirrorer struct {
+	Config   *config.Config
+	Client   *github.Client
+	Azure    *azuredevops.Client
+	Git      GitRunner
+	Replicas []Replica
+	Logger   *slog.Logger
+	// DryRun discovers and filters repos but runs no git commands.
+	DryRun bool
+	// Discovery is where repos are listed from, see DiscoveryCached and
+	// SetOffline.
+	Discovery string
+	// OnlySource, if set, is the name of the only source a run lists and
+	// syncs, see SetOnlySource.
+	OnlySource string
+	// State is the state of previous runs, if any, used to skip unchanged
+	// repos.
+	State *state.Store
+	// Progress reports the progress of git transfers, if set with
+	// SetProgress.
+	Progress *Progress
+	// Tracer records spans of runs, if tracing is configured.
+	Tracer *tracing.Tracer
+
+	templates map[*config.Source]*template.Template
+	// spaceMu guards used and exhausted while repos are mirrored in pools
+	// or by webhook and proxy updates.
+	spaceMu sync.Mutex
+	// used is the total size of local mirrors, -1 until computed.
+	used int64
+	// exhausted is why new mirrors are deferred for the rest of the run.
+	exhausted string
+	// span is the span of the current run.
+	span *tracing.Span
+	// repoLocks holds a mutex per mirror path, see LockRepo.
+	repoLocks sync.Map
+	// pending are the repos the last run did not attempt.
+	pending map[string]bool
+	// backfill queues the metadata exports waiting for the API budget by
+	// mirror path, guarded by backfillMu.
+	backfillMu sync.Mutex
+	backfill   map[string]*backfill
+	// closeMu is held for reading by updates, so Close waits for them.
+	closeMu sync.RWMutex
+	closed  bool
+	// stopProxy stops the bandwidth proxy, if there is one.
+	stopProxy func() error
+}
+
+func New(config *config.Config) (*Mirrorer, error) {
+	backend := config.Backend
+	if backend == "" {
+		backend = "exec"
+	}
+	newRunner, ok := backends[backend]
+	if !ok && backend == "go-git" {
+		return nil, fmt.Errorf("git backend %q requires a build with -tags gogit", backend)
+	}
+	if !ok {
+		return nil, fmt.Errorf("unknown git backend %q", backend)
+	}
+	if config.GitPath != "" {
+		_, err := exec.LookPath(config.GitPath)
+		if err != nil {
+			return nil, fmt.Errorf("git path: %w", err)
+		}
+	}
+	err := checkOrder(config.Order)
+	if err != nil {
+		return nil, err
+	}
+	_, err = lowSpeedTime(config.Network)
+	if err != nil {
+		return nil, err
+	}
+	err = checkPathCase(config.PathCase)
+	if err != nil {
+		return nil, err
+	}
+	err = checkMaintenance(config)
+	if err != nil {
+		return nil, err
+	}
+	err = checkSources(config.Sources)
+	if err != nil {
+		return nil, err
+	}
+	err = checkPatterns(config.Sources)
+	if err != nil {
+		return nil, err
+	}
+	err = checkPinnedRefs(config.Repos)
+	if err != nil {
+		return nil, err
+	}
+	err = checkPools(config.Pools)
+	if err != nil {
+		return nil, err
+	}
+	err = checkVerifyTags(config.Repos)
+	if err != nil {
+		return nil, err
+	}
+	_, err = cloneBundleInterval(config.CloneBundles.Interval)
+	if err != nil {
+		return nil, err
+	}
+	m := &Mirrorer{
+		Config: config,
+		Client: github.NewClient(),
+		Logger: slog.Default(),
+		Tracer: tracing.New(&config.Tracing),
+		used:   -1,
+	}
+	m.Client.HTTP, err = github.NewHTTPClient(config.Network)
+	if err != nil {
+		return nil, err
+	}
+	m.Azure = azuredevops.NewClient(m.Client.HTTP)
+	if config.Backfill.APIBudgetPerHour > 0 {
+		m.Client.Budget = github.NewBudget(config.Backfill.APIBudgetPerHour, time.Hour)
+	}
+	if wait := config.Network.API.MaxRateLimitWait; wait != "" {
+		m.Client.MaxRateLimitWait, err = time.ParseDuration(wait)
+		if err != nil {
+			return nil, fmt.Errorf("api max rate limit wait: %w", err)
+		}
+	}
+	gitConfig := config
+	if bandwidth := config.Bandwidth; bandwidth.Global > 0 || bandwidth.PerWorker > 0 {
+		global := newLimiter(bandwidth.Global)
+		retry := m.Client.HTTP.Transport.(*github.RetryTransport)
+		transport := retry.Base.(*http.Transport)
+		proxy, stop, err := startThrottlingProxy(&throttlingProxy{
+			global:    global,
+			perWorker: bandwidth.PerWorker,
+			upstream:  transport.Proxy,
+		})
+		if err != nil {
+			return nil, err
+		}
+		m.stopProxy = stop
+		retry.Base = &throttledTransport{
+			base:    transport,
+			limiter: global,
+		}
+		// Git reaches the network only through the throttling proxy, which
+		// applies Network's proxy settings itself.
+		c := *config
+		c.Network.Proxy = proxy
+		c.Network.NoProxy = ""
+		gitConfig = &c
+	}
+	m.Git = newRunner(gitConfig)
+	err = m.parseTemplates()
+	if err != nil {
+		m.Close()
+		return nil, err
+	}
+	for _, replica := range config.Replicas {
+		m.Replicas = append(m.Replicas, &LocalReplica{
+			Config:   replica,
+			Mirrorer: m,
+		})
+	}
+	if config.ObjectStorage != nil {
+		replica, err := NewObjectStorageReplica(config.ObjectStorage, m)
+		if err != nil {
+			m.Close()
+			return nil, err
+		}
+		m.Replicas = append(m.Replicas, replica)
+	}
+	return m, nil
+}
+
+// ErrClosed is returned by Update after Close.
+var ErrClosed = errors.New("mirrorer is closed")
+
+// Close stops the bandwidth proxy, once the updates in progress are done.
+// Git commands need the proxy to reach the network when bandwidth is
+// limited, so the mirrorer cannot sync anymore. Updates after Close fail.
+func (m *Mirrorer) Close() error {
+	m.closeMu.Lock()
+	defer m.closeMu.Unlock()
+	if m.closed {
+		return nil
+	}
+	m.closed = true
+	if m.stopProxy == nil {
+		return nil
+	}
+	return m.stopProxy()
+}
+
+// Discover lists the repos of every source, or of OnlySource. A source that
+// cannot be listed has its Stat.Error set, unless the Discovery mode allows
+// its cached listing, which then sets Stat.CachedAt; either way
+// Stat.DiscoveryError says why. Successful listings are cached.
+func (m *Mirrorer) Discover() []*report.Stat {
+	cache, err := m.readDiscoveryCache()
+	if err != nil {
+		m.Logger.Warn("Failed to read discovery cache", "error", err)
+	}
+	cached := false
+	var stats []*report.Stat
+	for _, source := range m.Config.Sources {
+		if m.OnlySource != "" && !strings.EqualFold(source.Label(), m.OnlySource) {
+			continue
+		}
+		stat := &report.Stat{
+			Source: source,
+			Name:   source.Label(),
+		}
+		stats = append(stats, stat)
+		logger := m.Logger.With("source", source.Label())
+		var repos []*github.Repo
+		err := errOffline
+		if m.Discovery != DiscoveryOffline {
+			span := m.span.Start("discover", "source", source.Label())
+			repos, err = m.listRepos(source)
+			span.Set("repos", len(repos))
+			span.End(err)
+		}
+		if err != nil {
+			if m.Discovery != DiscoveryOffline {
+				stat.DiscoveryError = err.Error()
+			}
+			if listing := cache.Sources[discoveryKey(source)]; listing != nil && m.Discovery != DiscoveryLive {
+				logger.Warn("Using cached source repos", "repos", len(listing.Repos), "cached_at", listing.Time, "reason", err)
+				stat.Repos = listing.Repos
+				stat.CachedAt = &listing.Time
+				continue
+			}
+			if m.Discovery == DiscoveryOffline {
+				err = fmt.Errorf("offline and no cached repos")
+			}
+			logger.Error("Failed to get source repos", "error", err)
+			stat.Error = err.Error()
+			stat.DiscoveryError = stat.Error
+			continue
+		}
+		stat.Repos = repos
+		logger.Info("Found source repos", "repos", len(repos))
+		cache.Sources[discoveryKey(source)] = &cachedListing{
+			Time:  time.Now(),
+			Repos: repos,
+		}
+		cached = true
+	}
+	if cached && !m.DryRun {
+		err = m.writeDiscoveryCache(cache)
+		if err != nil {
+			m.Logger.Warn("Failed to write discovery cache", "error", err)
+		}
+	}
+	return stats
+}
+
+// SetOnlySource restricts runs to the source named name, e.g. to retry a
+// source whose discovery failed without syncing the others.
+func (m *Mirrorer) SetOnlySource(name string) error {
+	for _, source := range m.Config.Sources {
+		if strings.EqualFold(source.Label(), name) {
+			m.OnlySource = source.Label()
+			return nil
+		}
+	}
+	return fmt.Errorf("no source %s", name)
+}
+
+// SourceAzureDevOps is the Type of Azure DevOps sources.
+const SourceAzureDevOps = "azuredevops"
+
+// checkSources checks the source types and that the sources have distinct
+// names, since their stats, state and cached listings are kept by name.
+func checkSources(sources []*config.Source) error {
+	names := make(map[string]bool)
+	for _, source := range sources {
+		switch source.Type {
+		case "", SourceAzureDevOps:
+		default:
+			return fmt.Errorf("source %s: unknown type %q", source.Label(), source.Type)
+		}
+		name := strings.ToLower(source.Label())
+		if names[name] {
+			return fmt.Errorf("source %s: more than one source has this name, set Name to tell them apart", source.Label())
+		}
+		names[name] = true
+	}
+	return nil
+}
+
+// listRepos lists the repos of the source from its service.
+func (m *Mirrorer) listRepos(source *config.Source) ([]*github.Repo, error) {
+	if source.Type == SourceAzureDevOps {
+		return m.Azure.ListRepos(source)
+	}
+	return m.Client.ListRepos(source)
+}
+
+// Run mirrors or updates every repo of every source. In dry-run mode it
+// only reports what it would do.
+func (m *Mirrorer) Run() ([]*report.Stat, error) {
+	if !m.DryRun {
+		for _, destination := range m.Destinations() {
+			err := os.MkdirAll(destination, 0755)
+			if err != nil {
+				if !os.IsExist(err) {
+					return nil, err
+				}
+			}
+		}
+	}
+
+	deadline, grace, err := runDeadline(m.Config.MaxRunDuration, m.Config.MaxRunGrace, time.Now())
+	if err != nil {
+		return nil, err
+	}
+	if !deadline.IsZero() && !m.DryRun {
+		ctx, cancel := context.WithDeadline(context.Background(), deadline.Add(grace))
+		m.setGitContext(ctx)
+		defer func() {
+			m.setGitContext(nil)
+			cancel()
+		}()
+	}
+
+	// Webhook and proxy updates may be mirroring repos meanwhile.
+	m.spaceMu.Lock()
+	m.used = -1
+	m.exhausted = ""
+	m.spaceMu.Unlock()
+	m.span = m.Tracer.Start(nil, "run", "dry_run", m.DryRun)
+	defer func() {
+		m.span.End(nil)
+		m.span = nil
+		err := m.Tracer.Flush()
+		if err != nil {
+			m.Logger.Warn("Failed to export traces", "error", err)
+		}
+	}()
+	stats := m.Discover()
+	carryover := m.beginCarryover(stats)
+	defer carryover.End()
+	jobs := m.jobs(stats)
+	collided, err := m.collisions(jobs, false)
+	if err != nil {
+		return nil, err
+	}
+	expired := func() bool {
+		return !deadline.IsZero() && !m.DryRun && time.Now().After(deadline)
+	}
+	// mu guards the stats and deferred while repos are mirrored in pools.
+	var mu sync.Mutex
+	deferred := 0
+	run := func(i int, j *job) {
+		logger := m.Logger.With("source", j.stat.Name, "repo", j.repo.FullName)
+		if owner, ok := collided[j]; ok {
+			logger.Error("Skipped repo whose path collides with another", "other", owner)
+			mu.Lock()
+			j.stat.Add(m.collision(j, owner))
+			mu.Unlock()
+			err := carryover.Done(j.repo.FullName)
+			if err != nil {
+				logger.Warn("Failed to write carryover", "error", err)
+			}
+			return
+		}
+		if expired() {
+			mu.Lock()
+			j.stat.Add(m.deferred(j, "run deadline reached"))
+			deferred++
+			mu.Unlock()
+			return
+		}
+		m.Progress.begin(i+1, len(jobs), j.repo.FullName)
+		result := m.Mirror(j.stat.Source, j.repo, logger)
+		mu.Lock()
+		if !deadline.IsZero() && result.Outcome.Failed() && time.Now().After(deadline.Add(grace)) {
+			// The git commands were killed at the end of the grace period.
+			result.Outcome = report.OutcomeDeferred
+			result.Reason = "killed after the run deadline: " + result.Error
+			result.Error = ""
+			deferred++
+		}
+		j.stat.Add(result)
+		mu.Unlock()
+		if result.Outcome != report.OutcomeDeferred {
+			err := carryover.Done(j.repo.FullName)
+			if err != nil {
+				logger.Warn("Failed to write carryover", "error", err)
+			}
+		}
+	}
+	if m.pooled() {
+		m.runPools(jobs, run)
+	} else {
+		for i, j := range jobs {
+			run(i, j)
+		}
+	}
+	if deferred > 0 {
+		m.Logger.Warn("Deferred repos at the run deadline", "repos", deferred, "deadline", deadline)
+	}
+	if !expired() {
+		m.runBackfill(stats)
+	}
+	if m.Config.Submodules.Enabled && !expired() {
+		m.mirrorSubmodules(stats)
+	}
+	if m.Config.Dependencies.Enabled && !expired() {
+		m.mirrorDependencies(stats)
+	}
+	if !m.DryRun {
+		err := m.WriteManifest(stats)
+		if err != nil {
+			m.Logger.Error("Failed to write manifest", "error", err)
+		}
+		err = m.WriteBrowse(stats)
+		if err != nil {
+			m.Logger.Error("Failed to write browse repo list", "error", err)
+		}
+	}
+	m.spaceMu.Lock()
+	exhausted := m.exhausted
+	m.spaceMu.Unlock()
+	if exhausted != "" {
+		m.Logger.Error("Deferred new mirrors", "reason", exhausted)
+	}
+	var quarantined []string
+	for _, stat := range stats {
+		stat.Tokens = m.Client.TokenUsage(stat.Source)
+		m.Logger.Info("Source stats", "source", stat.Name, "repos", len(stat.Repos), "skipped", stat.Skipped, "mirrored", stat.Mirrored, "updated", stat.Updated, "failed", stat.Failed, "failed_mirror", stat.FailedMirror, "failed_update", stat.FailedUpdate, "archived", stat.Archived, "deferred", stat.Deferred, "unchanged", stat.Unchanged, "quarantined", stat.Quarantined, "duration", stat.Duration, "transfer", stat.TransferDuration, "received", stat.Received, "bytes", stat.Bytes)
+		for _, result := range stat.Results {
+			if result.Outcome == report.OutcomeQuarantined {
+				quarantined = append(quarantined, result.Repo)
+			}
+		}
+	}
+	if len(quarantined) > 0 {
+		m.Logger.Warn("Quarantined repos", "count", len(quarantined), "repos", quarantined)
+	}
+	return stats, nil
+}
+
+// PruneCandidates returns the local mirrors whose repos were not discovered
+// upstream, keeping those a run adds as submodules or dependencies of
+// discovered repos. It refuses when a source could not be listed, since
+// every mirror of that source would look deleted, or was listed from the
+// discovery cache, which misses the repos created since, or when the run
+// left out all sources but OnlySource.
+func (m *Mirrorer) PruneCandidates(stats []*report.Stat) ([]string, error) {
+	if m.OnlySource != "" {
+		return nil, fmt.Errorf("only source %s was listed", m.OnlySource)
+	}
+	upstream := make(map[string]bool)
+	for _, stat := range stats {
+		if stat.Error != "" {
+			return nil, fmt.Errorf("source %s could not be listed: %s", stat.Name, stat.Error)
+		}
+		if stat.CachedAt != nil {
+			return nil, fmt.Errorf("source %s was listed from the discovery cache of %s", stat.Name, stat.CachedAt.Format(time.RFC3339))
+		}
+		for _, repo := range stat.Repos {
+			upstream[m.LocalPath(stat.Source, repo)] = true
+		}
+	}
+	referenced, err := m.referencedMirrors(stats)
+	if err != nil {
+		return nil, err
+	}
+	locals, err := m.LocalMirrors()
+	if err != nil {
+		return nil, err
+	}
+	var prune []string
+	pruned := make(map[string]bool)
+	for _, local := range locals {
+		if !upstream[local] && !referenced[strings.ToLower(local)] {
+			prune = append(prune, local)
+			pruned[local] = true
+		}
+	}
+	kept := prune[:0]
+	for _, local := range prune {
+		dependents, err := Dependents(local, locals)
+		if err != nil {
+			return nil, err
+		}
+		var remaining []string
+		for _, dependent := range dependents {
+			if !pruned[dependent] {
+				remaining = append(remaining, dependent)
+			}
+		}
+		if len(remaining) > 0 {
+			m.Logger.Warn("Kept mirror that forks share objects with", "local", local, "dependents", remaining)
+			continue
+		}
+		kept = append(kept, local)
+	}
+	return kept, nil
+}
+
+// Mirror clones the repo if it has no local mirror yet, and updates it
+// otherwise.
+func (m *Mirrorer) Mirror(source *config.Source, repo *github.Repo, logger *slog.Logger) *report.Result {
+	remote := RepoRemote(repo)
+	local := m.LocalPath(source, repo)
+	result := &report.Result{
+		Repo:   repo.FullName,
+		Remote: remote,
+		Local:  local,
+	}
+	span := m.Tracer.Start(m.span, "mirror", "repo", repo.FullName, "source", source.Label())
+	defer func() {
+		span.Set("outcome", string(result.Outcome), "bytes", result.Bytes, "received", result.Received)
+		var err error
+		if result.Outcome.Failed() {
+			err = errors.New(result.Error)
+		}
+		span.End(err)
+	}()
+	excluded, reason, err := m.Excluded(source, repo)
+	if err != nil {
+		logger.Error("Failed to evaluate policy", "error", err)
+		result.Outcome = report.OutcomeFailed
+		result.Error = err.Error()
+		return result
+	}
+	result.Reason = reason
+	if excluded {
+		logger.Debug("Skipped repo", "remote", remote, "reason", reason)
+		result.Outcome = report.OutcomeSkipped
+		return result
+	}
+	retry, err := m.nextRetry(repo.FullName)
+	if err != nil {
+		logger.Error("Failed to evaluate backoff", "error", err)
+		result.Outcome = report.OutcomeFailed
+		result.Error = err.Error()
+		return result
+	}
+	if time.Now().Before(retry) {
+		logger.Info("Skipped quarantined repo", "remote", remote, "retry", retry)
+		result.Outcome = report.OutcomeQuarantined
+		result.Reason = fmt.Sprintf("failing, next retry at %s", retry.Format(time.RFC3339))
+		return result
+	}
+	if m.unchanged(repo, local) {
+		logger.Debug("Skipped unchanged repo", "remote", remote, "pushed", repo.PushedAt)
+		result.Outcome = report.OutcomeUnchanged
+		if !m.DryRun {
+			result.DefaultBranchChange = m.writeMetadata(local, repo, logger)
+		}
+		return result
+	}
+	if m.DryRun {
+		result.DryRun = true
+		result.Outcome = report.OutcomeUpdated
+		if _, err := os.Stat(local); os.IsNotExist(err) {
+			result.Outcome = report.OutcomeMirrored
+		}
+		logger.Info("Would sync", "outcome", result.Outcome, "remote", remote, "local", local)
+		return result
+	}
+	unlock, err := m.LockRepo(local)
+	if err != nil {
+		logger.Error("Failed to lock repo", "error", err)
+		result.Outcome = report.OutcomeFailed
+		result.Error = fmt.Sprintf("lock error:'%s'", err)
+		return result
+	}
+	defer unlock()
+	start := time.Now()
+	// sizeBefore is the disk size of an existing mirror before the fetch.
+	var sizeBefore int64
+	defer func() {
+		result.Duration = time.Since(start)
+		if result.Outcome == report.OutcomeMirrored || result.Outcome == report.OutcomeUpdated {
+			objects, err := m.objects(local)
+			if err != nil {
+				logger.Warn("Failed to count objects", "error", err)
+				return
+			}
+			result.Objects = objects
+			result.Bytes = objects.Size()
+			result.Received = max(result.Bytes-sizeBefore, 0)
+		}
+	}()
+	_, err = os.Stat(local)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			logger.Error("Failed to stat local", "local", local, "error", err)
+			result.Outcome = report.OutcomeFailed
+			result.Error = err.Error()
+			return result
+		}
+		reason, err := m.checkSpace(source, repo)
+		if err != nil {
+			logger.Error("Failed to check disk space", "local", local, "error", err)
+			result.Outcome = report.OutcomeFailed
+			result.Error = err.Error()
+			return result
+		}
+		if reason != "" {
+			logger.Warn("Deferred mirror", "local", local, "reason", reason)
+			result.Outcome = report.OutcomeDeferred
+			result.Reason = reason
+			return result
+		}
+		url := m.FetchURL(source, repo)
+		logger := logger.With("operation", "mirror", "remote", remote, "local", local)
+		logger.Info("Mirroring")
+		fail := func(step string, err error) *report.Result {
+			logger.Error("Failed mirror", "step", step, "error", err, "stderr", Stderr(err))
+			Remove(local)
+			result.Outcome = report.OutcomeFailedMirror
+			result.Error = fmt.Sprintf("%s error:'%s'", step, err)
+			result.Step = step
+			result.Stderr = Stderr(err)
+			return result
+		}
+		options, err := m.CloneOptions(source, repo.FullName)
+		if err != nil {
+			return fail("clonemode", err)
+		}
+		options.ObjectFormat = m.remoteObjectFormat(url)
+		if options.ObjectFormat == ObjectFormatSHA256 {
+			logger.Info("Cloning sha256 repo")
+		}
+		parent, err := m.parentMirror(source, repo)
+		if err != nil {
+			logger.Warn("Failed to find parent mirror, cloning without sharing objects", "error", err)
+		}
+		if parent != "" {
+			// Objects the parent no longer references may still be
+			// needed by the fork, so the parent must never prune them.
+			err = m.keepParentObjects(parent)
+			if err != nil {
+				return fail("parentgc", err)
+			}
+			logger.Info("Sharing objects with parent mirror", "parent", parent)
+			options.Reference = parent
+		}
+		transferStart := time.Now()
+		cloneSpan := span.Start("clone")
+		err = m.Git.Clone(url, local, options)
+		result.Attempts = 1
+		for attempt := 2; err != nil && attempt <= m.Config.CloneAttempts; attempt++ {
+			logger.Warn("Retrying clone", "attempt", attempt, "error", err)
+			Remove(local)
+			err = m.Git.Clone(url, local, options)
+			result.Attempts = attempt
+		}
+		cloneSpan.End(err)
+		if err != nil {
+			if !m.Config.ArchiveFallback || repo.Host != "" {
+				return fail("clone", err)
+			}
+			Remove(local)
+			archive := strings.TrimSuffix(local, ".git") + ".tar.gz"
+			logger.Warn("Falling back to archive", "archive", archive, "error", err)
+			_err := m.Client.DownloadArchive(source, repo.FullName, archive)
+			if _err != nil {
+				return fail("archive", fmt.Errorf("%s, after clone error:'%s'", _err, err))
+			}
+			logger.Info("Successfully archive", "archive", archive, "duration", time.Since(start))
+			result.Outcome = report.OutcomeArchived
+			result.Archive = archive
+			result.Error = fmt.Sprintf("clone error:'%s'", err)
+			return result
+		}
+		result.TransferDuration = time.Since(transferStart)
+		err = m.configureGC(local, repo.FullName)
+		if err != nil {
+			return fail("gcconfig", err)
+		}
+		err = touch(local)
+		if err != nil {
+			return fail("touch", err)
+		}
+		repack := m.RepackConfig(repo.FullName)
+		if !repack.Disabled {
+			objects, err := m.objects(local)
+			if err != nil {
+				return fail("objects", err)
+			}
+			if objects.LargestPack > int64(repack.ThresholdMB)*1024*1024 {
+				logger.Info("Repacking", "largestsize", objects.LargestPack)
+				repackSpan := span.Start("repack", "largest_pack", objects.LargestPack)
+				err = m.Git.Repack(local, repack.Args()...)
+				repackSpan.End(err)
+				if err != nil {
+					return fail("repack", err)
+				}
+				logger.Info("Repack finished")
+			}
+		}
+		transferStart = time.Now()
+		fetchSpan := span.Start("fetch")
+		err = m.fetch(local, options)
+		fetchSpan.End(err)
+		if err != nil {
+			return fail("update", err)
+		}
+		result.TransferDuration += time.Since(transferStart)
+		logger.Info("Successfully mirror", "duration", time.Since(start), "transfer", result.TransferDuration)
+		result.RefMismatches = m.checkRefs(local, url, options.Refspecs, logger)
+		result.UnverifiedTags = m.verifyTags(local, repo.FullName, logger)
+		m.spaceMu.Lock()
+		if m.used >= 0 {
+			n, _ := Size(local)
+			m.used += n
+		}
+		m.spaceMu.Unlock()
+		result.Outcome = report.OutcomeMirrored
+		m.writeMetadata(local, repo, logger)
+		m.exportMetadata(source, repo, local, options, logger)
+		m.exportBundle(local, result, logger)
+		m.refreshCloneBundle(local, logger)
+		result.Replicas = replicate(m.Replicas, repo, local, logger)
+		return result
+	}
+	logger = logger.With("operation", "update", "remote", remote, "local", local)
+	logger.Info("Updating")
+	fail := func(step string, err error) *report.Result {
+		logger.Error("Failed update", "step", step, "error", err, "stderr", Stderr(err))
+		result.Outcome = report.OutcomeFailedUpdate
+		result.Error = fmt.Sprintf("%s error:'%s'", step, err)
+		result.Step = step
+		result.Stderr = Stderr(err)
+		return result
+	}
+	options, err := m.CloneOptions(source, repo.FullName)
+	if err != nil {
+		return fail("clonemode", err)
+	}
+	err = m.configureGC(local, repo.FullName)
+	if err != nil {
+		return fail("gcconfig", err)
+	}
+	url := m.FetchURL(source, repo)
+	err = m.Git.Config(local, "remote.origin.url", url)
+	if err != nil {
+		return fail("seturl", err)
+	}
+	options.Refspecs = m.pagesRefspecs(local, options.Refspecs)
+	err = m.applyRefspecs(local, options.Refspecs, logger)
+	if err != nil {
+		return fail("refspecs", err)
+	}
+	// Shallow history cannot tell force-pushes from truncation.
+	var before map[string]string
+	if options.Depth == 0 && options.ShallowSince.IsZero() {
+		before, err = m.snapshotRefs(local)
+		if err != nil {
+			return fail("snapshot", err)
+		}
+	}
+	objects, err := m.objects(local)
+	if err != nil {
+		return fail("objects", err)
+	}
+	sizeBefore = objects.Size()
+	transferStart := time.Now()
+	fetchSpan := span.Start("fetch")
+	err = m.fetch(local, options)
+	fetchSpan.End(err)
+	if err != nil {
+		return fail("update", err)
+	}
+	result.TransferDuration = time.Since(transferStart)
+	result.ForcedRefs, err = m.protectRefs(local, before, logger)
+	if err != nil {
+		return fail("protect", err)
+	}
+	logger.Info("Successfully update", "duration", time.Since(start), "transfer", result.TransferDuration)
+	result.RefMismatches = m.checkRefs(local, url, options.Refspecs, logger)
+	result.UnverifiedTags = m.verifyTags(local, repo.FullName, logger)
+	result.Outcome = report.OutcomeUpdated
+	result.DefaultBranchChange = m.writeMetadata(local, repo, logger)
+	m.exportMetadata(source, repo, local, options, logger)
+	m.exportBundle(local, result, logger)
+	m.refreshCloneBundle(local, logger)
+	result.Replicas = replicate(m.Replicas, repo, local, logger)
+	return result
+}
+
+// unchanged reports whether SkipUnchanged is set and the mirror of repo at
+// local last synced successfully with the same upstream push time.
+func (m *Mirrorer) unchanged(repo *github.Repo, local string) bool {
+	if !m.Config.SkipUnchanged || m.State == nil || repo.PushedAt.IsZero() {
+		return false
+	}
+	last, ok := m.State.Repo(repo.FullName)
+	if !ok || last.Local != local || last.ConsecutiveFailures > 0 || last.LastSuccess.IsZero() {
+		return false
+	}
+	return last.PushedAt.Equal(repo.PushedAt) && isBare(local)
+}
+
+// Update syncs the repo of source outside a run, e.g. when a push webhook
+// arrives, the same way a run does: with the source's filters and fetch
+// options, the force-push backups and the metadata. The result is recorded
+// in State, if set. It returns a Partial stat with just the repo's result.
+func (m *Mirrorer) Update(source *config.Source, repo *github.Repo) (*report.Stat, error) {
+	m.closeMu.RLock()
+	defer m.closeMu.RUnlock()
+	if m.closed {
+		return nil, ErrClosed
+	}
+	start := time.Now()
+	stat, err := m.syncRepo(source, repo)
+	if err != nil {
+		return nil, err
+	}
+	if m.State != nil && !m.DryRun {
+		err = m.State.RecordRun(start, []*report.Stat{stat}, m.Config.State)
+		if err == nil {
+			err = m.State.Save()
+		}
+		if err != nil {
+			return stat, fmt.Errorf("state: %w", err)
+		}
+	}
+	return stat, nil
+}
+
+// Remote returns the upstream clone URL of a repo.
+func Remote(fullName string) string {
+	return fmt.Sprintf("https://github.com/%s.git", fullName)
+}
+
+// RepoRemote returns the upstream clone URL of a repo of any service.
+func RepoRemote(repo *github.Repo) string {
+	if repo.Host == azuredevops.Host {
+		return azuredevops.Remote(repo.FullName)
+	}
+	return Remote(repo.FullName)
+}
+
+// FetchURL returns the URL git clones and fetches the repo from, with
+// rewrite rules applied and credentials added for private repos.
+func (m *Mirrorer) FetchURL(source *config.Source, repo *github.Repo) string {
+	url := Rewrite(m.Config.Rewrites, RepoRemote(repo))
+	if token := source.GitToken(); repo.Private && token != "" {
+		url = strings.Replace(url, "https://", fmt.Sprintf("https://%s:%s@", github.GitUsername(source, token), token), 1)
+	}
+	return url
+}
+
+// Rewrite applies the rewrite rule with the longest matching prefix to url.
+func Rewrite(rewrites []*config.Rewrite, url string) string {
+	var match *config.Rewrite
+	for _, r := range rewrites {
+		if strings.HasPrefix(url, r.From) && (match == nil || len(r.From) > len(match.From)) {
+			match = r
+		}
+	}
+	if match == nil {
+		return url
+	}
+	return match.To + strings.TrimPrefix(url, match.From)
+}
+
+func contains(s []string, e string) bool {
+	for _, v := range s {
+		if v == e {
+			return true
+		}
+	}
+	return false
+}
+
+// containsFold reports whether s contains e, ignoring case.
+func containsFold(s []string, e string) bool {
+	for _, v := range s {
+		if strings.EqualFold(v, e) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,212 @@
+package gitmirror
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/chamzzzzzz/github-repo-mirror/pkg/config"
+	"github.com/chamzzzzzz/github-repo-mirror/pkg/github"
+	"github.com/chamzzzzzz/github-repo-mirror/pkg/report"
+	"github.com/chamzzzzzz/github-repo-mirror/pkg/state"
+)
+
+// fakeAPI answers GitHub's single repo API from repos, by full name, and
+// its user repos API with the listed ones. It counts the requests.
+type fakeAPI struct {
+	mu       sync.Mutex
+	repos    map[string]*github.Repo
+	unlisted map[string]bool
+	requests int
+}
+
+func (a *fakeAPI) RoundTrip(req *http.Request) (*http.Response, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.requests++
+	resp := &http.Response{
+		StatusCode: http.StatusNotFound,
+		Header:     make(http.Header),
+		Body:       io.NopCloser(strings.NewReader(`{"message": "Not Found"}`)),
+		Request:    req,
+	}
+	var body any
+	path := req.URL.Path
+	if owner, ok := strings.CutPrefix(path, "/users/"); ok && strings.HasSuffix(owner, "/repos") {
+		owner = strings.TrimSuffix(owner, "/repos")
+		repos := []*github.Repo{}
+		for name, repo := range a.repos {
+			if repo.Owner.Login == owner && !a.unlisted[name] {
+				repos = append(repos, repo)
+			}
+		}
+		body = repos
+	} else if repo, ok := a.repos[strings.TrimPrefix(path, "/repos/")]; ok {
+		body = repo
+	}
+	if req.URL.Host == "api.github.com" && body != nil {
+		b, err := json.Marshal(body)
+		if err != nil {
+			return nil, err
+		}
+		resp.StatusCode = http.StatusOK
+		resp.Body = io.NopCloser(bytes.NewReader(b))
+	}
+	return resp, nil
+}
+
+// add adds the repo fullName to the API, pushed at pushed. Unless listed,
+// its owner's listing leaves it out, e.g. as a repo of another user.
+func (a *fakeAPI) add(fullName string, pushed time.Time, listed bool) *github.Repo {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	owner, name, _ := strings.Cut(fullName, "/")
+	repo := &github.Repo{Name: name, FullName: fullName, DefaultBranch: "main", PushedAt: pushed}
+	repo.Owner.Login = owner
+	a.repos[fullName] = repo
+	a.unlisted[fullName] = !listed
+	return repo
+}
+
+// testMirrorer is a mirrorer whose GitHub is fakeAPI and the bare repos
+// under upstream, as upstream/owner/name.git.
+type testMirrorer struct {
+	*Mirrorer
+	api      *fakeAPI
+	upstream string
+}
+
+// newTestMirrorer returns a test mirrorer of c, which gets a destination and
+// the rewrite to upstream.
+func newTestMirrorer(t *testing.T, c *config.Config) *testMirrorer {
+	t.Helper()
+	dir := t.TempDir()
+	upstream := filepath.Join(dir, "upstream")
+	c.Destination = filepath.Join(dir, "mirrors")
+	c.Rewrites = append(c.Rewrites, &config.Rewrite{From: "https://github.com/", To: "file://" + upstream + "/"})
+	m, err := New(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m.Logger = slog.New(slog.NewTextHandler(io.Discard, nil))
+	api := &fakeAPI{repos: make(map[string]*github.Repo), unlisted: make(map[string]bool)}
+	m.Client.HTTP = &http.Client{Transport: api}
+	return &testMirrorer{m, api, upstream}
+}
+
+// git runs git in dir and returns its trimmed output.
+func git(t *testing.T, dir string, args ...string) string {
+	t.Helper()
+	cmd := exec.Command("git", append([]string{"-c", "user.name=test", "-c", "user.email=test@example.com", "-c", "init.defaultBranch=main"}, args...)...)
+	cmd.Dir = dir
+	cmd.Env = append(os.Environ(), "GIT_CONFIG_GLOBAL=/dev/null", "GIT_CONFIG_NOSYSTEM=1")
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		t.Fatalf("git %s: %s: %s", strings.Join(args, " "), err, out)
+	}
+	return strings.TrimSpace(string(out))
+}
+
+// push commits files to the main branch of the upstream repo fullName,
+// creating the repo if needed, and returns the commit. With force, the
+// commit replaces the branch's history.
+func (m *testMirrorer) push(t *testing.T, fullName string, files map[string]string, force bool) string {
+	t.Helper()
+	bare := filepath.Join(m.upstream, fullName+".git")
+	if _, err := os.Stat(bare); os.IsNotExist(err) {
+		os.MkdirAll(bare, 0755)
+		git(t, bare, "init", "-q", "--bare")
+	}
+	work := t.TempDir()
+	git(t, work, "init", "-q")
+	if !force && git(t, work, "ls-remote", bare, "refs/heads/main") != "" {
+		git(t, work, "fetch", "-q", bare, "main")
+		git(t, work, "reset", "-q", "--hard", "FETCH_HEAD")
+	}
+	for name, content := range files {
+		path := filepath.Join(work, filepath.FromSlash(name))
+		os.MkdirAll(filepath.Dir(path), 0755)
+		err := os.WriteFile(path, []byte(content), 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	git(t, work, "add", "-A")
+	git(t, work, "commit", "-q", "--allow-empty", "-m", "commit")
+	git(t, work, "push", "-q", "--force", bare, "HEAD:refs/heads/main")
+	return git(t, work, "rev-parse", "HEAD")
+}
+
+func TestUpdate(t *testing.T) {
+	c := &config.Config{Sources: []*config.Source{{Username: "alice"}}}
+	c.Snapshots.Enabled = true
+	m := newTestMirrorer(t, c)
+	store, err := state.Open(filepath.Join(t.TempDir(), "state.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	m.State = store
+	first := m.push(t, "alice/proj", nil, false)
+	repo := m.api.add("alice/proj", time.Now(), true)
+	stat, err := m.Update(c.Sources[0], repo)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result := stat.Results[0]; result.Outcome != report.OutcomeMirrored || !stat.Partial {
+		t.Fatalf("Update() = %s, %s, want a partial stat of the mirrored repo", result.Outcome, result.Error)
+	}
+
+	// A webhook update keeps the history a force-push drops, like a run.
+	// The rewritten commit differs from the first even within a second.
+	second := m.push(t, "alice/proj", map[string]string{"README": "rewritten"}, true)
+	repo = m.api.add("alice/proj", time.Now().Add(time.Minute), true)
+	stat, err = m.Update(c.Sources[0], repo)
+	if err != nil {
+		t.Fatal(err)
+	}
+	result := stat.Results[0]
+	if result.Outcome != report.OutcomeUpdated || len(result.ForcedRefs) != 1 {
+		t.Fatalf("Update() = %s, forced %v, want the forced main", result.Outcome, result.ForcedRefs)
+	}
+	local := result.Local
+	if got := git(t, local, "rev-parse", "refs/heads/main"); got != second {
+		t.Errorf("main = %s, want %s", got, second)
+	}
+	backups := git(t, local, "for-each-ref", "--format=%(objectname)", backupPrefix)
+	if backups != first {
+		t.Errorf("backup refs = %q, want the old main %s", backups, first)
+	}
+	last, ok := store.Repo("alice/proj")
+	if !ok || last.Local != local || last.LastSuccess.IsZero() {
+		t.Errorf("State.Repo() = %+v, %v, want the update recorded", last, ok)
+	}
+	if len(store.Runs()) != 0 {
+		t.Errorf("State.Runs() = %d runs, want none", len(store.Runs()))
+	}
+}
+
+func TestUpdateExcluded(t *testing.T) {
+	c := &config.Config{Sources: []*config.Source{{Username: "alice", Exclude: []string{"alice/private-*"}}}}
+	m := newTestMirrorer(t, c)
+	m.push(t, "alice/private-notes", nil, false)
+	repo := m.api.add("alice/private-notes", time.Now(), true)
+	stat, err := m.Update(c.Sources[0], repo)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result := stat.Results[0]; result.Outcome != report.OutcomeSkipped {
+		t.Errorf("Update() = %s, want the excluded repo skipped", result.Outcome)
+	}
+	if _, ok := m.FindLocal("alice/private-notes"); ok {
+		t.Errorf("Update() mirrored an excluded repo")
+	}
+}
@@ -0,0 +1,44 @@
+package gitmirror
+
+import (
+	"errors"
+	"os/exec"
+)
+
+// Object formats of git repositories.
+const (
+	ObjectFormatSHA1   = "sha1"
+	ObjectFormatSHA256 = "sha256"
+)
+
+// remoteObjectFormat returns the object format of the remote url, judged by
+// the length of the object names it advertises, or "" if it has no refs or
+// cannot be listed.
+func (m *Mirrorer) remoteObjectFormat(url string) string {
+	refs, err := m.Git.LsRemote(url)
+	if err != nil {
+		return ""
+	}
+	for _, oid := range refs {
+		switch len(oid) {
+		case 40:
+			return ObjectFormatSHA1
+		case 64:
+			return ObjectFormatSHA256
+		}
+	}
+	return ""
+}
+
+// ObjectFormat returns the object format of the mirror at local.
+func (m *Mirrorer) ObjectFormat(local string) (string, error) {
+	format, err := m.Git.ConfigValue(local, "extensions.objectformat")
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) && exitErr.ExitCode() == 1 {
+		return ObjectFormatSHA1, nil
+	}
+	if err != nil {
+		return "", err
+	}
+	return format, nil
+}
@@ -0,0 +1,277 @@
+package gitmirror
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"sync"
+
+	"github.com/chamzzzzzz/github-repo-mirror/pkg/config"
+	"github.com/chamzzzzzz/github-repo-mirror/pkg/github"
+	"github.com/chamzzzzzz/github-repo-mirror/pkg/s3"
+)
+
+// ObjectStorageReplica uploads mirrors to S3-compatible object storage. It
+// records what was uploaded in an index next to the manifest, so unchanged
+// mirrors and bundles are not uploaded again.
+type ObjectStorageReplica struct {
+	Config   *config.ObjectStorage
+	Mirrorer *Mirrorer
+	Client   *s3.Client
+
+	key   []byte
+	mu    sync.Mutex
+	index map[string]string
+}
+
+// NewObjectStorageReplica returns an ObjectStorageReplica for config, taking
+// missing credentials from the environment.
+func NewObjectStorageReplica(config *config.ObjectStorage, m *Mirrorer) (*ObjectStorageReplica, error) {
+	switch config.Mode {
+	case "", "bundle":
+		if m.Config.Bundles.Directory == "" {
+			return nil, fmt.Errorf("object storage mode bundle requires Bundles.Directory")
+		}
+	case "tar":
+	default:
+		return nil, fmt.Errorf("unknown object storage mode %q", config.Mode)
+	}
+	r := &ObjectStorageReplica{
+		Config:   config,
+		Mirrorer: m,
+		Client: &s3.Client{
+			Endpoint:        config.Endpoint,
+			Region:          config.Region,
+			Bucket:          config.Bucket,
+			PathStyle:       config.PathStyle,
+			AccessKeyID:     config.AccessKeyID,
+			SecretAccessKey: config.SecretAccessKey,
+			SessionToken:    config.SessionToken,
+		},
+	}
+	if r.Client.AccessKeyID == "" {
+		r.Client.AccessKeyID = os.Getenv("AWS_ACCESS_KEY_ID")
+		r.Client.SecretAccessKey = os.Getenv("AWS_SECRET_ACCESS_KEY")
+		r.Client.SessionToken = os.Getenv("AWS_SESSION_TOKEN")
+	}
+	if config.EncryptionKey != "" {
+		key, err := s3.ParseKey(config.EncryptionKey)
+		if err != nil {
+			return nil, err
+		}
+		r.key = key
+	}
+	return r, nil
+}
+
+func (r *ObjectStorageReplica) Name() string {
+	return "s3://" + r.Config.Bucket
+}
+
+func (r *ObjectStorageReplica) Replicate(repo *github.Repo, local string) error {
+	err := r.loadIndex()
+	if err != nil {
+		return err
+	}
+	rel, err := r.Mirrorer.relative(local)
+	if err != nil {
+		return err
+	}
+	if r.Config.Mode == "tar" {
+		return r.uploadTar(rel, local)
+	}
+	return r.uploadBundles(local)
+}
+
+// uploadBundles uploads the bundles of the mirror at local that are not in
+// the index.
+func (r *ObjectStorageReplica) uploadBundles(local string) error {
+	bundles, err := r.Mirrorer.Bundles(local)
+	if err != nil {
+		return err
+	}
+	for _, bundle := range bundles {
+		rel, err := filepath.Rel(r.Mirrorer.Config.Bundles.Directory, bundle)
+		if err != nil {
+			return err
+		}
+		key := r.objectKey(rel)
+		if r.uploaded(key, "uploaded") {
+			continue
+		}
+		err = r.upload(key, bundle)
+		if err != nil {
+			return err
+		}
+		err = r.record(key, "uploaded")
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// uploadTar uploads a tar.gz of the mirror at local if its refs changed since
+// the last upload.
+func (r *ObjectStorageReplica) uploadTar(rel, local string) error {
+	digest, err := r.Mirrorer.RefsDigest(local)
+	if err != nil {
+		return err
+	}
+	key := r.objectKey(rel + ".tar.gz")
+	if r.uploaded(key, digest) {
+		return nil
+	}
+	f, err := os.CreateTemp("", "github-repo-mirror-*.tar.gz")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	defer f.Close()
+	err = writeTarGz(f, local)
+	if err != nil {
+		return err
+	}
+	err = r.upload(key, f.Name())
+	if err != nil {
+		return err
+	}
+	return r.record(key, digest)
+}
+
+// upload puts the file at path as key, encrypting it first if an encryption
+// key is configured.
+func (r *ObjectStorageReplica) upload(key, path string) error {
+	headers := make(map[string]string)
+	if r.Config.ServerSideEncryption != "" {
+		headers["x-amz-server-side-encryption"] = r.Config.ServerSideEncryption
+	}
+	if r.Config.KMSKeyID != "" {
+		headers["x-amz-server-side-encryption-aws-kms-key-id"] = r.Config.KMSKeyID
+	}
+	if r.key == nil {
+		return r.Client.PutFile(key, path, headers)
+	}
+	in, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.CreateTemp("", "github-repo-mirror-*.enc")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(out.Name())
+	defer out.Close()
+	err = s3.Encrypt(out, in, r.key)
+	if err != nil {
+		return err
+	}
+	return r.Client.PutFile(key+".enc", out.Name(), headers)
+}
+
+func (r *ObjectStorageReplica) objectKey(rel string) string {
+	return path.Join(r.Config.Prefix, filepath.ToSlash(rel))
+}
+
+// IndexPath returns the path of the index of uploaded objects.
+func (r *ObjectStorageReplica) IndexPath() string {
+	return filepath.Join(r.Mirrorer.Config.Destination, "uploads.json")
+}
+
+func (r *ObjectStorageReplica) loadIndex() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.index != nil {
+		return nil
+	}
+	r.index = make(map[string]string)
+	b, err := os.ReadFile(r.IndexPath())
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(b, &r.index)
+}
+
+func (r *ObjectStorageReplica) uploaded(key, value string) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.index[key] == value
+}
+
+// record marks key as uploaded with value and saves the index.
+func (r *ObjectStorageReplica) record(key, value string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.index[key] = value
+	b, err := json.MarshalIndent(r.index, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := r.IndexPath() + ".tmp"
+	err = os.WriteFile(tmp, b, 0644)
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmp, r.IndexPath())
+}
+
+// writeTarGz writes the files under dir to w as a gzipped tar with paths
+// relative to dir's parent.
+func writeTarGz(w io.Writer, dir string) error {
+	gz := gzip.NewWriter(w)
+	tw := tar.NewWriter(gz)
+	base := filepath.Dir(dir)
+	err := filepath.WalkDir(dir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		fi, err := d.Info()
+		if err != nil {
+			return err
+		}
+		if !fi.Mode().IsRegular() && !fi.IsDir() {
+			return nil
+		}
+		name, err := filepath.Rel(base, p)
+		if err != nil {
+			return err
+		}
+		header, err := tar.FileInfoHeader(fi, "")
+		if err != nil {
+			return err
+		}
+		header.Name = filepath.ToSlash(name)
+		if fi.IsDir() {
+			header.Name += "/"
+		}
+		err = tw.WriteHeader(header)
+		if err != nil || fi.IsDir() {
+			return err
+		}
+		f, err := os.Open(p)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		_, err = io.Copy(tw, f)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	err = tw.Close()
+	if err != nil {
+		return err
+	}
+	return gz.Close()
+}
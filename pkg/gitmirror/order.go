@@ -0,0 +1,85 @@
+package gitmirror
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/chamzzzzzz/github-repo-mirror/pkg/github"
+	"github.com/chamzzzzzz/github-repo-mirror/pkg/report"
+)
+
+// job is a repo to mirror and the stat of its source.
+type job struct {
+	stat *report.Stat
+	repo *github.Repo
+}
+
+// orders are the strategies of Config.Order.
+var orders = map[string]func([]*job){
+	"": func([]*job) {},
+	"pushed": func(jobs []*job) {
+		sort.SliceStable(jobs, func(i, j int) bool { return jobs[i].repo.PushedAt.After(jobs[j].repo.PushedAt) })
+	},
+	"smallest": func(jobs []*job) {
+		sort.SliceStable(jobs, func(i, j int) bool { return jobs[i].repo.Size < jobs[j].repo.Size })
+	},
+	"owner": roundRobinOwners,
+}
+
+// roundRobinOwners interleaves the jobs of each owner, keeping their order
+// within an owner.
+func roundRobinOwners(jobs []*job) {
+	var owners []string
+	byOwner := make(map[string][]*job)
+	for _, j := range jobs {
+		owner := j.repo.Owner.Login
+		if _, ok := byOwner[owner]; !ok {
+			owners = append(owners, owner)
+		}
+		byOwner[owner] = append(byOwner[owner], j)
+	}
+	jobs = jobs[:0]
+	for len(owners) > 0 {
+		var left []string
+		for _, owner := range owners {
+			jobs = append(jobs, byOwner[owner][0])
+			byOwner[owner] = byOwner[owner][1:]
+			if len(byOwner[owner]) > 0 {
+				left = append(left, owner)
+			}
+		}
+		owners = left
+	}
+}
+
+func checkOrder(order string) error {
+	if _, ok := orders[order]; !ok {
+		return fmt.Errorf("unknown order %q", order)
+	}
+	return nil
+}
+
+// jobs returns the repos of all sources in the order they are mirrored:
+// by descending Priority of their RepoConfig, then repos deferred or not
+// attempted by the last run first, then by Config.Order.
+func (m *Mirrorer) jobs(stats []*report.Stat) []*job {
+	var jobs []*job
+	for _, stat := range stats {
+		for _, repo := range stat.Repos {
+			jobs = append(jobs, &job{stat, repo})
+		}
+	}
+	orders[m.Config.Order](jobs)
+	sort.SliceStable(jobs, func(i, j int) bool {
+		return m.carriedOver(jobs[i].repo.FullName) && !m.carriedOver(jobs[j].repo.FullName)
+	})
+	sort.SliceStable(jobs, func(i, j int) bool { return m.priority(jobs[i].repo) > m.priority(jobs[j].repo) })
+	return jobs
+}
+
+func (m *Mirrorer) priority(repo *github.Repo) int {
+	if rc := m.Config.Repos[repo.FullName]; rc != nil {
+		return rc.Priority
+	}
+	return 0
+}
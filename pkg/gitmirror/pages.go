@@ -0,0 +1,132 @@
+package gitmirror
+
+import (
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"os"
+	"path/filepath"
+
+	"github.com/chamzzzzzz/github-repo-mirror/pkg/config"
+	"github.com/chamzzzzzz/github-repo-mirror/pkg/github"
+)
+
+// pagesFile is written inside each bare mirror of a repo with Pages or
+// deployment environments.
+const pagesFile = "mirror-pages.json"
+
+// PagesMetadata is the content of a mirror's mirror-pages.json. Pages and
+// Environments are as GitHub returns them.
+type PagesMetadata struct {
+	Pages        json.RawMessage   `json:"pages,omitempty"`
+	Environments []json.RawMessage `json:"environments,omitempty"`
+}
+
+// Branch returns the branch Pages is built from, empty if it is built by a
+// workflow or not enabled.
+func (p *PagesMetadata) Branch() string {
+	var pages struct {
+		BuildType string `json:"build_type"`
+		Source    *struct {
+			Branch string `json:"branch"`
+		} `json:"source"`
+	}
+	if p.Pages == nil || json.Unmarshal(p.Pages, &pages) != nil || pages.Source == nil || pages.BuildType == "workflow" {
+		return ""
+	}
+	return pages.Source.Branch
+}
+
+// ReadPages returns the Pages metadata written into the mirror at local.
+func ReadPages(local string) (*PagesMetadata, error) {
+	b, err := os.ReadFile(filepath.Join(local, pagesFile))
+	if err != nil {
+		return nil, err
+	}
+	pages := &PagesMetadata{}
+	err = json.Unmarshal(b, pages)
+	if err != nil {
+		return nil, err
+	}
+	return pages, nil
+}
+
+// pagesRefspecs returns refspecs plus the Pages branch last exported for
+// the mirror at local, if refspecs leave it out. Nil refspecs mirror all
+// refs and are returned as is.
+func (m *Mirrorer) pagesRefspecs(local string, refspecs []string) []string {
+	if !m.Config.Pages.Enabled || len(refspecs) == 0 {
+		return refspecs
+	}
+	pages, err := ReadPages(local)
+	if err != nil {
+		return refspecs
+	}
+	return withBranch(refspecs, pages.Branch())
+}
+
+// withBranch returns refspecs plus a refspec of branch, if they do not
+// match it.
+func withBranch(refspecs []string, branch string) []string {
+	ref := "refs/heads/" + branch
+	if branch == "" || len(refspecs) == 0 || matchRefspecs(refspecs, ref) {
+		return refspecs
+	}
+	return append(append([]string{}, refspecs...), "+"+ref+":"+ref)
+}
+
+// exportPages writes the Pages configuration and deployment environments of
+// the repo mirrored at local, if enabled, and fetches the Pages branch if
+// the mirror's refspecs left it out. Failures are logged but do not fail the
+// sync.
+func (m *Mirrorer) exportPages(source *config.Source, repo *github.Repo, local string, options *CloneOptions, logger *slog.Logger) {
+	if !m.Config.Pages.Enabled || source.Type == SourceAzureDevOps {
+		return
+	}
+	metadata := &PagesMetadata{}
+	var err error
+	if repo.HasPages || source.GraphQL {
+		metadata.Pages, err = m.Client.GetPages(source, repo.FullName)
+		if err != nil {
+			logger.Warn("Failed to get Pages", "error", err)
+			return
+		}
+	}
+	if m.Config.Pages.Environments {
+		metadata.Environments, err = m.Client.ListEnvironments(source, repo.FullName)
+		if err != nil {
+			logger.Warn("Failed to list environments", "error", err)
+			return
+		}
+	}
+	path := filepath.Join(local, pagesFile)
+	if metadata.Pages == nil && metadata.Environments == nil {
+		err = os.Remove(path)
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			logger.Warn("Failed to remove Pages metadata", "error", err)
+		}
+		return
+	}
+	b, err := json.MarshalIndent(metadata, "", "  ")
+	if err == nil {
+		err = writeFileIfChanged(path, b)
+	}
+	if err != nil {
+		logger.Warn("Failed to write Pages metadata", "error", err)
+		return
+	}
+
+	branch := metadata.Branch()
+	refspecs := withBranch(options.Refspecs, branch)
+	if len(refspecs) == len(options.Refspecs) {
+		return
+	}
+	logger.Info("Fetching Pages branch left out by refspecs", "branch", branch)
+	err = m.Git.SetRefspecs(local, refspecs...)
+	if err == nil {
+		err = m.fetch(local, options)
+	}
+	if err != nil {
+		logger.Warn("Failed to fetch Pages branch", "branch", branch, "error", err, "stderr", Stderr(err))
+	}
+}
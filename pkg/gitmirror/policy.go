@@ -0,0 +1,79 @@
+package gitmirror
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os/exec"
+	"strings"
+
+	"github.com/chamzzzzzz/github-repo-mirror/pkg/config"
+	"github.com/chamzzzzzz/github-repo-mirror/pkg/github"
+)
+
+// policyInput is written as JSON to the stdin of the policy command.
+type policyInput struct {
+	Source string       `json:"source"`
+	Repo   *github.Repo `json:"repo"`
+	Remote string       `json:"remote"`
+	// Default is the decision of the include and exclude lists.
+	Default string `json:"default"`
+}
+
+// Excluded reports whether the repo is left out of mirroring, and why. The
+// source's filters decide, unless a policy command is configured, which then
+// gets the final say by printing allow or deny.
+func (m *Mirrorer) Excluded(source *config.Source, repo *github.Repo) (bool, string, error) {
+	d, err := m.Explain(source, repo)
+	if err != nil {
+		return false, "", err
+	}
+	return d.Skip, d.Reason, nil
+}
+
+// Explain evaluates the source's filters and then the policy command, if
+// configured, for the repo.
+func (m *Mirrorer) Explain(source *config.Source, repo *github.Repo) (*Decision, error) {
+	d := Evaluate(source, repo)
+	command := source.PolicyCommand
+	if len(command) == 0 {
+		command = m.Config.PolicyCommand
+	}
+	if len(command) == 0 {
+		d.Rules = append(d.Rules, &RuleResult{Rule: "PolicyCommand", Result: RuleNotSet})
+		return d, nil
+	}
+	input := &policyInput{
+		Source: source.Label(),
+		Repo:   repo,
+		Remote: RepoRemote(repo),
+	}
+	input.Default = "allow"
+	if d.Skip {
+		input.Default = "deny"
+	}
+	b, err := json.Marshal(input)
+	if err != nil {
+		return nil, err
+	}
+	var stdout, stderr bytes.Buffer
+	cmd := exec.Command(command[0], command[1:]...)
+	cmd.Stdin = bytes.NewReader(b)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	err = cmd.Run()
+	if err != nil {
+		return nil, fmt.Errorf("policy command: %w: %s", err, strings.TrimSpace(stderr.String()))
+	}
+	switch decision := strings.TrimSpace(stdout.String()); decision {
+	case "allow":
+		d.Rules = append(d.Rules, &RuleResult{Rule: "PolicyCommand", Result: RulePass, Detail: "allow, default " + input.Default})
+		d.Skip, d.Reason = false, "allowed by policy command"
+	case "deny":
+		d.Rules = append(d.Rules, &RuleResult{Rule: "PolicyCommand", Result: RuleSkip, Detail: "deny, default " + input.Default})
+		d.Skip, d.Reason = true, "denied by policy command"
+	default:
+		return nil, fmt.Errorf("policy command: unexpected decision %q", decision)
+	}
+	return d, nil
+}
@@ -0,0 +1,71 @@
+package gitmirror
+
+import (
+	"fmt"
+	"io"
+	"sync"
+
+	"github.com/chamzzzzzz/github-repo-mirror/pkg/config"
+)
+
+// pooled reports whether the run mirrors repos in the pools of
+// Config.Pools.
+func (m *Mirrorer) pooled() bool {
+	return m.Config.Pools.Small > 0
+}
+
+func checkPools(pools config.Pools) error {
+	if pools.Small < 0 || pools.Large < 0 || pools.LargeSizeMB < 0 {
+		return fmt.Errorf("pools: negative workers or size")
+	}
+	return nil
+}
+
+// runPools runs the jobs in the small and large pools, each job with
+// run(i, j) where i is its index in jobs. Git progress is not reported
+// meanwhile, since concurrent transfers would garble it.
+func (m *Mirrorer) runPools(jobs []*job, run func(i int, j *job)) {
+	pools := m.Config.Pools
+	large := pools.Large
+	if large == 0 {
+		large = 1
+	}
+	threshold := int64(pools.LargeSizeMB)
+	if threshold == 0 {
+		threshold = 1024
+	}
+	if r, ok := m.Git.(interface{ SetProgress(io.Writer) }); ok && m.Progress != nil {
+		r.SetProgress(nil)
+		defer r.SetProgress(m.Progress)
+	}
+
+	smallQueue := make(chan int, len(jobs))
+	largeQueue := make(chan int, len(jobs))
+	for i, j := range jobs {
+		// Size is in KB.
+		if j.repo.Size >= threshold*1024 {
+			largeQueue <- i
+		} else {
+			smallQueue <- i
+		}
+	}
+	close(smallQueue)
+	close(largeQueue)
+	m.Logger.Info("Mirroring in pools", "small", len(smallQueue), "small_workers", pools.Small, "large", len(largeQueue), "large_workers", large)
+
+	var wg sync.WaitGroup
+	work := func(queue chan int, workers int) {
+		for n := 0; n < workers; n++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for i := range queue {
+					run(i, jobs[i])
+				}
+			}()
+		}
+	}
+	work(smallQueue, pools.Small)
+	work(largeQueue, large)
+	wg.Wait()
+}
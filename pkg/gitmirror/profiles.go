@@ -0,0 +1,138 @@
+package gitmirror
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/chamzzzzzz/github-repo-mirror/pkg/config"
+	"github.com/chamzzzzzz/github-repo-mirror/pkg/github"
+	"github.com/chamzzzzzz/github-repo-mirror/pkg/report"
+)
+
+// SourceProfile is the profile data of a source exported by
+// ExportProfiles. Profile, Members and Teams are as GitHub returns them.
+type SourceProfile struct {
+	Source       string            `json:"source"`
+	Organization bool              `json:"organization"`
+	Time         time.Time         `json:"time"`
+	Profile      json.RawMessage   `json:"profile,omitempty"`
+	Members      []json.RawMessage `json:"members,omitempty"`
+	Teams        []json.RawMessage `json:"teams,omitempty"`
+	Repos        []*RepoProfile    `json:"repos"`
+	// Unreadable lists what the token may not read.
+	Unreadable []string `json:"unreadable,omitempty"`
+}
+
+// RepoProfile is the settings of a repo in a SourceProfile.
+type RepoProfile struct {
+	FullName      string   `json:"full_name"`
+	Description   string   `json:"description"`
+	Homepage      string   `json:"homepage,omitempty"`
+	Topics        []string `json:"topics,omitempty"`
+	DefaultBranch string   `json:"default_branch"`
+	Private       bool     `json:"private"`
+	Archived      bool     `json:"archived"`
+	Fork          bool     `json:"fork"`
+	// BranchProtection is the protection of the default branch, if
+	// Profiles.BranchProtection is set and it is protected.
+	BranchProtection json.RawMessage `json:"branch_protection,omitempty"`
+}
+
+// ExportProfiles writes the profile data of the GitHub sources listed in
+// stats, if enabled. Sources that could not be listed from the API are
+// skipped.
+func (m *Mirrorer) ExportProfiles(stats []*report.Stat) error {
+	profiles := m.Config.Profiles
+	if !profiles.Enabled || m.Discovery == DiscoveryOffline {
+		return nil
+	}
+	dir := profiles.Dir
+	if dir == "" {
+		dir = filepath.Join(m.Config.Destination, "profiles")
+	}
+	err := os.MkdirAll(dir, 0755)
+	if err != nil {
+		return err
+	}
+	var errs []error
+	for _, stat := range stats {
+		if stat.Source.Type != "" || stat.Error != "" || stat.CachedAt != nil {
+			continue
+		}
+		profile, err := m.sourceProfile(stat.Source, stat.Repos, profiles.BranchProtection)
+		if err == nil {
+			var b []byte
+			b, err = json.MarshalIndent(profile, "", "  ")
+			if err == nil {
+				err = writeFileIfChanged(filepath.Join(dir, stat.Name+".json"), b)
+			}
+		}
+		if err != nil {
+			errs = append(errs, fmt.Errorf("source %s: %w", stat.Name, err))
+			continue
+		}
+		m.Logger.Info("Exported profile", "source", stat.Name, "members", len(profile.Members), "teams", len(profile.Teams), "unreadable", len(profile.Unreadable))
+	}
+	return errors.Join(errs...)
+}
+
+func (m *Mirrorer) sourceProfile(source *config.Source, repos []*github.Repo, branchProtection bool) (*SourceProfile, error) {
+	profile := &SourceProfile{
+		Source:       source.Label(),
+		Organization: source.Organization,
+		Time:         time.Now(),
+		Repos:        []*RepoProfile{},
+	}
+	// unreadable records what the token may not read and passes on other
+	// errors.
+	unreadable := func(what string, err error) error {
+		if errors.Is(err, github.ErrUnreadable) {
+			profile.Unreadable = append(profile.Unreadable, what)
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("%s: %w", what, err)
+		}
+		return nil
+	}
+	var err error
+	profile.Profile, err = m.Client.GetProfile(source)
+	if err = unreadable("profile", err); err != nil {
+		return nil, err
+	}
+	if source.Organization {
+		profile.Members, err = m.Client.ListMembers(source)
+		if err = unreadable("members", err); err != nil {
+			return nil, err
+		}
+		profile.Teams, err = m.Client.ListTeams(source)
+		if err = unreadable("teams", err); err != nil {
+			return nil, err
+		}
+	}
+	for _, repo := range repos {
+		r := &RepoProfile{
+			FullName:      repo.FullName,
+			Description:   repo.Description,
+			Homepage:      repo.Homepage,
+			Topics:        repo.Topics,
+			DefaultBranch: repo.DefaultBranch,
+			Private:       repo.Private,
+			Archived:      repo.Archived,
+			Fork:          repo.Fork,
+		}
+		profile.Repos = append(profile.Repos, r)
+		if !branchProtection || repo.DefaultBranch == "" {
+			continue
+		}
+		r.BranchProtection, err = m.Client.GetBranchProtection(source, repo.FullName, repo.DefaultBranch)
+		if err = unreadable("branch protection of "+repo.FullName, err); err != nil {
+			return nil, err
+		}
+	}
+	return profile, nil
+}
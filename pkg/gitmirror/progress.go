@@ -0,0 +1,106 @@
+package gitmirror
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Progress reports the progress git prints while it transfers objects. In
+// a terminal it redraws a status line prefixed with the run's repo counter,
+// otherwise it logs the progress lines, at most one every few seconds per
+// phase.
+type Progress struct {
+	// Terminal draws status lines on Out instead of logging.
+	Terminal bool
+	Out      io.Writer
+	// Level is the level of logged progress lines.
+	Level  slog.Level
+	Logger *slog.Logger
+
+	mu           sync.Mutex
+	repo         string
+	index, total int
+	partial      []byte
+	logged       time.Time
+}
+
+// NewProgress returns a Progress that draws on stderr if interactive is
+// set and stderr is a terminal, and otherwise logs at level.
+func NewProgress(interactive bool, level slog.Level) *Progress {
+	fi, err := os.Stderr.Stat()
+	return &Progress{
+		Terminal: interactive && err == nil && fi.Mode()&os.ModeCharDevice != 0,
+		Out:      os.Stderr,
+		Level:    level,
+		Logger:   slog.Default(),
+	}
+}
+
+// SetProgress reports the progress of git transfers to p, if the git
+// backend supports it.
+func (m *Mirrorer) SetProgress(p *Progress) {
+	m.Progress = p
+	if r, ok := m.Git.(interface{ SetProgress(io.Writer) }); ok && p != nil {
+		r.SetProgress(p)
+	}
+}
+
+// begin attributes the following progress to repo, the index-th of total
+// repos of the run, or of no count if total is 0.
+func (p *Progress) begin(index, total int, repo string) {
+	if p == nil {
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.index, p.total, p.repo = index, total, repo
+	p.partial = p.partial[:0]
+}
+
+func (p *Progress) Write(b []byte) (int, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for _, c := range b {
+		if c != '\r' && c != '\n' {
+			p.partial = append(p.partial, c)
+			continue
+		}
+		if line := strings.TrimSpace(string(p.partial)); line != "" {
+			p.line(line, c == '\n')
+		}
+		p.partial = p.partial[:0]
+	}
+	return len(b), nil
+}
+
+// line reports a progress line, final if git finished the phase it
+// reports on.
+func (p *Progress) line(line string, final bool) {
+	if p.Terminal {
+		prefix := p.repo
+		if p.total > 0 {
+			prefix = fmt.Sprintf("[%d/%d] %s", p.index, p.total, p.repo)
+		}
+		end := ""
+		if final {
+			end = "\n"
+		}
+		fmt.Fprintf(p.Out, "\r\033[K%s: %s%s", prefix, line, end)
+		return
+	}
+	if !final && time.Since(p.logged) < 5*time.Second {
+		return
+	}
+	p.logged = time.Now()
+	args := []any{"repo", p.repo, "progress", line}
+	if p.total > 0 {
+		args = append(args, "index", p.index, "total", p.total)
+	}
+	p.Logger.Log(context.Background(), p.Level, "Git progress", args...)
+}
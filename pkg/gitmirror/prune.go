@@ -0,0 +1,45 @@
+package gitmirror
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// ArchivePruned writes a bundle of all refs of the mirror at local and a
+// copy of its metadata into the PruneArchive directory, and returns the
+// bundle. A mirror without refs has nothing to archive.
+func (m *Mirrorer) ArchivePruned(local string) (string, error) {
+	refs, err := m.Git.Refs(local)
+	if err != nil {
+		return "", err
+	}
+	if len(refs) == 0 {
+		return "", nil
+	}
+	rel, err := m.relative(local)
+	if err != nil {
+		return "", err
+	}
+	dir := filepath.Join(m.Config.PruneArchive, strings.TrimSuffix(rel, ".git"))
+	err = os.MkdirAll(dir, 0755)
+	if err != nil {
+		return "", err
+	}
+	name := filepath.Join(dir, time.Now().UTC().Format(bundleTimeFormat))
+	bundle := name + ".bundle"
+	err = m.Git.CreateBundle(local, bundle)
+	if err != nil {
+		os.Remove(bundle)
+		return "", err
+	}
+	b, err := os.ReadFile(filepath.Join(local, metadataFile))
+	if err == nil {
+		err = os.WriteFile(name+".json", b, 0644)
+	}
+	if err != nil && !os.IsNotExist(err) {
+		return "", err
+	}
+	return bundle, nil
+}
@@ -0,0 +1,111 @@
+package gitmirror
+
+import (
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/chamzzzzzz/github-repo-mirror/pkg/config"
+)
+
+func TestPruneAfterRun(t *testing.T) {
+	gitmodules := func(url string) map[string]string {
+		return map[string]string{".gitmodules": "[submodule \"lib\"]\n\tpath = lib\n\turl = " + url + "\n"}
+	}
+	tests := []struct {
+		name   string
+		config func(c *config.Config)
+		// files are pushed to alice/app, the only repo alice's listing has.
+		files map[string]string
+		// lib is whether the run mirrors bob/lib.
+		lib   bool
+		prune []string
+	}{
+		{
+			"submodule",
+			func(c *config.Config) { c.Submodules.Enabled = true },
+			gitmodules("https://github.com/bob/lib.git"),
+			true,
+			[]string{"alice/old"},
+		},
+		{
+			"submodule of a submodule",
+			func(c *config.Config) { c.Submodules.Enabled = true; c.Submodules.Depth = 2 },
+			gitmodules("../../bob/tool.git"),
+			true,
+			[]string{"alice/old"},
+		},
+		{
+			"dependency",
+			func(c *config.Config) { c.Dependencies.Enabled = true },
+			map[string]string{"go.mod": "module example.com/app\n\nrequire github.com/bob/lib v1.0.0\n"},
+			true,
+			[]string{"alice/old"},
+		},
+		{
+			"workflow dependency",
+			func(c *config.Config) { c.Dependencies.Enabled = true },
+			map[string]string{".github/workflows/ci.yml": "jobs:\n  test:\n    steps:\n      - uses: bob/lib@v1\n"},
+			true,
+			[]string{"alice/old"},
+		},
+		{
+			"dependency of a submodule",
+			func(c *config.Config) { c.Submodules.Enabled = true; c.Dependencies.Enabled = true },
+			gitmodules("https://github.com/bob/tool.git"),
+			true,
+			[]string{"alice/old"},
+		},
+		{
+			"submodules disabled",
+			func(c *config.Config) {},
+			gitmodules("https://github.com/bob/lib.git"),
+			false,
+			[]string{"alice/old"},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			c := &config.Config{Sources: []*config.Source{{Username: "alice"}}}
+			test.config(c)
+			m := newTestMirrorer(t, c)
+			m.push(t, "alice/app", test.files, false)
+			m.api.add("alice/app", time.Now(), true)
+			tool := gitmodules("https://github.com/bob/lib.git")
+			tool["go.mod"] = "module github.com/bob/tool\n\nrequire github.com/bob/lib v1.0.0\n"
+			m.push(t, "bob/tool", tool, false)
+			m.api.add("bob/tool", time.Now(), false)
+			m.push(t, "bob/lib", nil, false)
+			m.api.add("bob/lib", time.Now(), false)
+			// alice/old was mirrored before it was deleted upstream.
+			m.push(t, "alice/old", nil, false)
+			_, err := m.Update(c.Sources[0], m.api.add("alice/old", time.Now(), true))
+			if err != nil {
+				t.Fatal(err)
+			}
+			delete(m.api.repos, "alice/old")
+
+			_, err = m.Run()
+			if err != nil {
+				t.Fatal(err)
+			}
+			prune, err := m.PruneCandidates(m.Discover())
+			if err != nil {
+				t.Fatal(err)
+			}
+			var names []string
+			for _, local := range prune {
+				rel, _ := filepath.Rel(filepath.Join(c.Destination, "github.com"), local)
+				names = append(names, strings.TrimSuffix(filepath.ToSlash(rel), ".git"))
+			}
+			if !reflect.DeepEqual(names, test.prune) {
+				t.Errorf("PruneCandidates() = %v, want %v", names, test.prune)
+			}
+			if _, ok := m.FindLocal("bob/lib"); ok != test.lib {
+				t.Errorf("mirrored bob/lib = %v, want %v", ok, test.lib)
+			}
+		})
+	}
+}
@@ -0,0 +1,61 @@
+package gitmirror
+
+import (
+	"fmt"
+	"log/slog"
+	"sort"
+	"strings"
+)
+
+// checkRefs compares the refs of the mirror at local with those of url that
+// refspecs fetch, all if empty, if CheckRefs is set, and returns the refs
+// that are missing locally or point to a different object. Upstream may move
+// between the fetch and the check, so a single mismatch is not necessarily a
+// failed fetch.
+func (m *Mirrorer) checkRefs(local, url string, refspecs []string, logger *slog.Logger) []string {
+	if !m.Config.CheckRefs {
+		return nil
+	}
+	remote, err := m.Git.LsRemote(url)
+	if err != nil {
+		logger.Warn("Failed to list remote refs", "error", err)
+		return nil
+	}
+	if len(refspecs) > 0 {
+		for ref := range remote {
+			if !fetchedByRefspecs(refspecs, ref) {
+				delete(remote, ref)
+			}
+		}
+	}
+	refs, err := m.Git.Refs(local)
+	if err != nil {
+		logger.Warn("Failed to list local refs", "error", err)
+		return nil
+	}
+	mismatches := compareRefs(refs, remote)
+	if len(mismatches) > 0 {
+		logger.Warn("Ref mismatch", "count", len(mismatches), "refs", mismatches)
+	}
+	return mismatches
+}
+
+// compareRefs returns the refs of remote that are missing in local or point
+// to a different object, sorted by ref name. HEAD and peeled tags are
+// ignored.
+func compareRefs(local, remote map[string]string) []string {
+	var mismatches []string
+	for ref, oid := range remote {
+		if ref == "HEAD" || strings.HasSuffix(ref, "^{}") {
+			continue
+		}
+		loid, ok := local[ref]
+		if !ok {
+			mismatches = append(mismatches, fmt.Sprintf("missing %s", ref))
+		} else if loid != oid {
+			mismatches = append(mismatches, fmt.Sprintf("diverged %s local:%s remote:%s", ref, loid, oid))
+		}
+	}
+	sort.Strings(mismatches)
+	return mismatches
+}
@@ -0,0 +1,129 @@
+package gitmirror
+
+import (
+	"fmt"
+	"log/slog"
+	"strings"
+
+	"github.com/chamzzzzzz/github-repo-mirror/pkg/config"
+)
+
+// DefaultRefspecs mirror all refs.
+var DefaultRefspecs = []string{"+refs/*:refs/*"}
+
+// Refspecs returns the configured fetch refspecs of a repo, or nil if all
+// refs are mirrored. The pinned Refs of a repo are added to its own
+// Refspecs.
+func (m *Mirrorer) Refspecs(source *config.Source, fullName string) []string {
+	if repo, ok := m.Config.Repos[fullName]; ok {
+		if len(repo.Refs) > 0 {
+			refspecs := append([]string{}, repo.Refspecs...)
+			for _, ref := range repo.Refs {
+				refspecs = append(refspecs, "+"+ref+":"+ref)
+			}
+			return refspecs
+		}
+		if len(repo.Refspecs) > 0 {
+			return repo.Refspecs
+		}
+	}
+	if len(source.Refspecs) > 0 {
+		return source.Refspecs
+	}
+	return m.Config.Refspecs
+}
+
+// applyRefspecs sets the fetch refspecs of an existing mirror and deletes the
+// refs they no longer match, so changing the refspecs also shrinks existing
+// mirrors.
+func (m *Mirrorer) applyRefspecs(local string, refspecs []string, logger *slog.Logger) error {
+	if len(refspecs) == 0 {
+		refspecs = DefaultRefspecs
+	}
+	err := m.Git.SetRefspecs(local, refspecs...)
+	if err != nil {
+		return err
+	}
+	refs, err := m.Git.Refs(local)
+	if err != nil {
+		return err
+	}
+	var deleted int
+	for ref := range refs {
+		if strings.HasPrefix(ref, backupPrefix) || matchRefspecs(refspecs, ref) {
+			continue
+		}
+		err := m.Git.UpdateRef(local, ref, "")
+		if err != nil {
+			return err
+		}
+		deleted++
+	}
+	if deleted > 0 {
+		logger.Info("Deleted refs not matching refspecs", "count", deleted)
+	}
+	return nil
+}
+
+// checkPinnedRefs checks that the pinned Refs of each repo are full ref
+// names or patterns with at most one "*".
+func checkPinnedRefs(repos map[string]*config.RepoConfig) error {
+	for name, repo := range repos {
+		for _, ref := range repo.Refs {
+			if !strings.HasPrefix(ref, "refs/") || strings.Count(ref, "*") > 1 || strings.ContainsAny(ref, ":^ ") {
+				return fmt.Errorf("repo %s: invalid ref %q, want e.g. refs/tags/v1.*", name, ref)
+			}
+		}
+	}
+	return nil
+}
+
+// fetchedByRefspecs reports whether the remote ref is a source of refspecs:
+// it matches a refspec's source and no negative refspec.
+func fetchedByRefspecs(refspecs []string, ref string) bool {
+	var matched bool
+	for _, refspec := range refspecs {
+		if pattern, ok := strings.CutPrefix(refspec, "^"); ok {
+			if matchRef(pattern, ref) {
+				return false
+			}
+			continue
+		}
+		src, _, _ := strings.Cut(strings.TrimPrefix(refspec, "+"), ":")
+		if matchRef(src, ref) {
+			matched = true
+		}
+	}
+	return matched
+}
+
+// matchRefspecs reports whether the local ref is a destination of refspecs:
+// it matches a refspec's destination and no negative refspec.
+func matchRefspecs(refspecs []string, ref string) bool {
+	var matched bool
+	for _, refspec := range refspecs {
+		if pattern, ok := strings.CutPrefix(refspec, "^"); ok {
+			if matchRef(pattern, ref) {
+				return false
+			}
+			continue
+		}
+		src, dst, ok := strings.Cut(strings.TrimPrefix(refspec, "+"), ":")
+		if !ok {
+			dst = src
+		}
+		if matchRef(dst, ref) {
+			matched = true
+		}
+	}
+	return matched
+}
+
+// matchRef matches ref against a refspec side with at most one "*".
+func matchRef(pattern, ref string) bool {
+	prefix, suffix, ok := strings.Cut(pattern, "*")
+	if !ok {
+		return pattern == ref
+	}
+	return len(ref) >= len(prefix)+len(suffix) && strings.HasPrefix(ref, prefix) && strings.HasSuffix(ref, suffix)
+}
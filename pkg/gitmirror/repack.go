@@ -0,0 +1,24 @@
+package gitmirror
+
+import (
+	"github.com/chamzzzzzz/github-repo-mirror/pkg/config"
+)
+
+// RepackConfig returns the repack settings of a repo: the repo's own, else
+// the config's, with defaults for unset fields.
+func (m *Mirrorer) RepackConfig(fullName string) *config.Repack {
+	repack := m.Config.Repack
+	if rc := m.Config.Repos[fullName]; rc != nil && rc.Repack != nil {
+		repack = *rc.Repack
+	}
+	if repack.ThresholdMB == 0 {
+		repack.ThresholdMB = 95
+	}
+	if repack.MaxPackSize == "" {
+		repack.MaxPackSize = "95m"
+	}
+	if repack.Flags == nil {
+		repack.Flags = []string{"-A", "-d"}
+	}
+	return &repack
+}
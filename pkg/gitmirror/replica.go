@@ -0,0 +1,111 @@
+package gitmirror
+
+import (
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/chamzzzzzz/github-repo-mirror/pkg/config"
+	"github.com/chamzzzzzz/github-repo-mirror/pkg/github"
+	"github.com/chamzzzzzz/github-repo-mirror/pkg/report"
+)
+
+// Replica is a downstream copy of a mirror that is written after the primary
+// mirror has been synced successfully.
+type Replica interface {
+	Name() string
+	Replicate(repo *github.Repo, local string) error
+}
+
+// replicate writes local to all replicas concurrently, so each replica adds
+// to the per-repo time only as much as the slowest one. A failing replica
+// does not affect the others or the primary mirror's outcome.
+func replicate(replicas []Replica, repo *github.Repo, local string, logger *slog.Logger) []*report.ReplicaResult {
+	if len(replicas) == 0 {
+		return nil
+	}
+	results := make([]*report.ReplicaResult, len(replicas))
+	var wg sync.WaitGroup
+	for i, replica := range replicas {
+		wg.Add(1)
+		go func(i int, replica Replica) {
+			defer wg.Done()
+			start := time.Now()
+			result := &report.ReplicaResult{
+				Name: replica.Name(),
+			}
+			err := replica.Replicate(repo, local)
+			result.Duration = time.Since(start)
+			if err != nil {
+				logger.Error("Failed replicate", "replica", result.Name, "error", err)
+				result.Error = err.Error()
+			} else {
+				logger.Info("Successfully replicate", "replica", result.Name, "duration", result.Duration)
+			}
+			results[i] = result
+		}(i, replica)
+	}
+	wg.Wait()
+	return results
+}
+
+// LocalReplica keeps a copy of each mirror in another directory, e.g. on a
+// second disk, at the same relative path as under the primary destination.
+type LocalReplica struct {
+	Config   *config.Replica
+	Mirrorer *Mirrorer
+}
+
+func (r *LocalReplica) Name() string {
+	if r.Config.Name != "" {
+		return r.Config.Name
+	}
+	return r.Config.Destination
+}
+
+func (r *LocalReplica) Replicate(repo *github.Repo, local string) error {
+	rel, err := r.Mirrorer.relative(local)
+	if err != nil {
+		return err
+	}
+	target := filepath.Join(r.Config.Destination, rel)
+	_, err = os.Stat(target)
+	if os.IsNotExist(err) {
+		err = os.MkdirAll(filepath.Dir(target), 0755)
+		if err != nil {
+			return err
+		}
+		err = r.Mirrorer.Git.Clone(local, target, nil)
+		if err != nil {
+			Remove(target)
+			return err
+		}
+		return r.Mirrorer.Git.Config(target, "gc.auto", "0")
+	}
+	if err != nil {
+		return err
+	}
+	switch r.Config.Method {
+	case "", "fetch":
+		return r.Mirrorer.Git.Fetch(target)
+	case "push":
+		return r.Mirrorer.Git.PushMirror(local, target, "")
+	default:
+		return fmt.Errorf("unknown replica method %q", r.Config.Method)
+	}
+}
+
+// relative returns the path of local relative to its destination.
+func (m *Mirrorer) relative(local string) (string, error) {
+	for _, destination := range m.Destinations() {
+		rel, err := filepath.Rel(destination, local)
+		if err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+			return rel, nil
+		}
+	}
+	return "", fmt.Errorf("%s is outside of all destinations", local)
+}
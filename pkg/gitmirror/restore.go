@@ -0,0 +1,89 @@
+package gitmirror
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// RestoreRefspecs are the refs restore pushes by default. Other refs, e.g.
+// GitHub's read-only refs/pull, are usually rejected by a new origin.
+var RestoreRefspecs = []string{"+refs/heads/*:refs/heads/*", "+refs/tags/*:refs/tags/*"}
+
+// RestoreLog records which mirrors were pushed where, so an interrupted
+// restore can be resumed without pushing the finished repos again.
+type RestoreLog struct {
+	Path string
+
+	mu      sync.Mutex
+	entries map[string]*RestoreEntry
+}
+
+// RestoreEntry is the latest restore of a repo.
+type RestoreEntry struct {
+	URL        string    `json:"url"`
+	RefsDigest string    `json:"refs_digest"`
+	Time       time.Time `json:"time"`
+	Error      string    `json:"error,omitempty"`
+}
+
+// RestoreLogPath returns the default path of the restore log.
+func (m *Mirrorer) RestoreLogPath() string {
+	return filepath.Join(m.Config.Destination, "restore.json")
+}
+
+// OpenRestoreLog loads the restore log at path, which need not exist yet.
+func OpenRestoreLog(path string) (*RestoreLog, error) {
+	l := &RestoreLog{
+		Path:    path,
+		entries: make(map[string]*RestoreEntry),
+	}
+	b, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return l, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	err = json.Unmarshal(b, &l.entries)
+	if err != nil {
+		return nil, err
+	}
+	return l, nil
+}
+
+// Done reports whether repo was already pushed to url with the given refs.
+func (l *RestoreLog) Done(repo, url, digest string) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	e, ok := l.entries[repo]
+	return ok && e.Error == "" && e.URL == url && e.RefsDigest == digest
+}
+
+// Record stores the outcome of pushing repo and saves the log.
+func (l *RestoreLog) Record(repo string, entry *RestoreEntry) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.entries[repo] = entry
+	b, err := json.MarshalIndent(l.entries, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := l.Path + ".tmp"
+	err = os.WriteFile(tmp, b, 0644)
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmp, l.Path)
+}
+
+// Restore pushes the mirror at local to url with token, all of its refs if
+// all is set and RestoreRefspecs otherwise.
+func (m *Mirrorer) Restore(local, url, token string, all bool) error {
+	if all {
+		return m.Git.PushMirror(local, url, token)
+	}
+	return m.Git.Push(local, url, token, RestoreRefspecs...)
+}
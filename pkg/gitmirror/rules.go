@@ -0,0 +1,195 @@
+package gitmirror
+
+import (
+	"fmt"
+	"path"
+	"strings"
+
+	"github.com/chamzzzzzz/github-repo-mirror/pkg/config"
+	"github.com/chamzzzzzz/github-repo-mirror/pkg/github"
+)
+
+// Results of a filter rule.
+const (
+	RuleNotSet = "not set"
+	RulePass   = "pass"
+	RuleSkip   = "skip"
+)
+
+// RuleResult is the result of one filter rule for a repo.
+type RuleResult struct {
+	Rule   string
+	Result string
+	Detail string
+}
+
+// Decision is whether a repo is left out of mirroring and why, with the
+// result of every rule in order of precedence. The first rule that skips
+// the repo decides, except for the policy command, which has the final say.
+type Decision struct {
+	Skip   bool
+	Reason string
+	Rules  []*RuleResult
+}
+
+func (d *Decision) add(rule, result, detail, reason string) {
+	d.Rules = append(d.Rules, &RuleResult{
+		Rule:   rule,
+		Result: result,
+		Detail: detail,
+	})
+	if result == RuleSkip && !d.Skip {
+		d.Skip = true
+		d.Reason = reason
+	}
+}
+
+// Skip reports whether the source's filters leave out the repo, and why.
+func Skip(source *config.Source, repo *github.Repo) (bool, string) {
+	d := Evaluate(source, repo)
+	return d.Skip, d.Reason
+}
+
+// Evaluate evaluates every filter of the source for the repo: the owner
+// lists, then Include, Exclude, topics and language.
+func Evaluate(source *config.Source, repo *github.Repo) *Decision {
+	d := &Decision{}
+	owner, _, _ := strings.Cut(repo.FullName, "/")
+	switch {
+	case len(source.IncludeOwners) == 0:
+		d.add("IncludeOwners", RuleNotSet, "", "")
+	case containsFold(source.IncludeOwners, owner):
+		d.add("IncludeOwners", RulePass, fmt.Sprintf("lists %s", owner), "")
+	default:
+		d.add("IncludeOwners", RuleSkip, fmt.Sprintf("does not list %s", owner), "owner not in include owners")
+	}
+	switch {
+	case len(source.ExcludeOwners) == 0:
+		d.add("ExcludeOwners", RuleNotSet, "", "")
+	case containsFold(source.ExcludeOwners, owner):
+		d.add("ExcludeOwners", RuleSkip, fmt.Sprintf("lists %s", owner), "owner in exclude owners")
+	default:
+		d.add("ExcludeOwners", RulePass, fmt.Sprintf("does not list %s", owner), "")
+	}
+	pattern, negated := lastMatch(source.Include, repo)
+	switch {
+	case len(source.Include) == 0:
+		d.add("Include", RuleNotSet, "", "")
+	case pattern != "" && !negated:
+		d.add("Include", RulePass, fmt.Sprintf("last match %q", pattern), "")
+	case pattern != "":
+		d.add("Include", RuleSkip, fmt.Sprintf("last match %q", pattern), "not in include list")
+	default:
+		d.add("Include", RuleSkip, "no pattern matches", "not in include list")
+	}
+	pattern, negated = lastMatch(source.Exclude, repo)
+	switch {
+	case len(source.Exclude) == 0:
+		d.add("Exclude", RuleNotSet, "", "")
+	case pattern != "" && !negated:
+		d.add("Exclude", RuleSkip, fmt.Sprintf("last match %q", pattern), "in exclude list")
+	case pattern != "":
+		d.add("Exclude", RulePass, fmt.Sprintf("last match %q", pattern), "")
+	default:
+		d.add("Exclude", RulePass, "no pattern matches", "")
+	}
+	if len(source.RequireTopics) == 0 {
+		d.add("RequireTopics", RuleNotSet, "", "")
+	} else {
+		var missing []string
+		for _, topic := range source.RequireTopics {
+			if !containsFold(repo.Topics, topic) {
+				missing = append(missing, topic)
+			}
+		}
+		if len(missing) > 0 {
+			d.add("RequireTopics", RuleSkip, fmt.Sprintf("missing %s", strings.Join(missing, ", ")), fmt.Sprintf("missing topic %s", missing[0]))
+		} else {
+			d.add("RequireTopics", RulePass, "has all", "")
+		}
+	}
+	if len(source.ExcludeTopics) == 0 {
+		d.add("ExcludeTopics", RuleNotSet, "", "")
+	} else {
+		var excluded []string
+		for _, topic := range source.ExcludeTopics {
+			if containsFold(repo.Topics, topic) {
+				excluded = append(excluded, topic)
+			}
+		}
+		if len(excluded) > 0 {
+			d.add("ExcludeTopics", RuleSkip, fmt.Sprintf("has %s", strings.Join(excluded, ", ")), fmt.Sprintf("has excluded topic %s", excluded[0]))
+		} else {
+			d.add("ExcludeTopics", RulePass, "has none", "")
+		}
+	}
+	switch {
+	case len(source.Languages) == 0:
+		d.add("Languages", RuleNotSet, "", "")
+	case containsFold(source.Languages, repo.Language):
+		d.add("Languages", RulePass, fmt.Sprintf("lists %q", repo.Language), "")
+	default:
+		d.add("Languages", RuleSkip, fmt.Sprintf("does not list %q", repo.Language), "language not in languages")
+	}
+	return d
+}
+
+// lastMatch returns the last of patterns that matches the repo, and whether
+// it is negated with "!".
+func lastMatch(patterns []string, repo *github.Repo) (string, bool) {
+	for i := len(patterns) - 1; i >= 0; i-- {
+		pattern, negated := strings.CutPrefix(patterns[i], "!")
+		if matchRepoPattern(pattern, repo) {
+			return patterns[i], negated
+		}
+	}
+	return "", false
+}
+
+// matchRepoPattern reports whether an Include or Exclude pattern matches
+// the repo, ignoring case: a pattern with "://" matches its clone URL,
+// others its full name, both as path.Match globs, e.g. "owner/*".
+func matchRepoPattern(pattern string, repo *github.Repo) bool {
+	target := repo.FullName
+	if strings.Contains(pattern, "://") {
+		target = RepoRemote(repo)
+	}
+	ok, err := path.Match(strings.ToLower(pattern), strings.ToLower(target))
+	return err == nil && ok
+}
+
+// checkPatterns checks the Include and Exclude patterns of the sources.
+func checkPatterns(sources []*config.Source) error {
+	for _, source := range sources {
+		for _, pattern := range append(append([]string{}, source.Include...), source.Exclude...) {
+			_, err := path.Match(strings.TrimPrefix(pattern, "!"), "")
+			if err != nil {
+				return fmt.Errorf("source %s: pattern %q: %w", source.Label(), pattern, err)
+			}
+		}
+	}
+	return nil
+}
+
+// ExplainRepo evaluates the filters and policy command of the source named
+// sourceName, or else of the repo's owner or the first source, for the repo
+// as the API returns it.
+func (m *Mirrorer) ExplainRepo(fullName, sourceName string) (*config.Source, *Decision, error) {
+	owner, _, ok := strings.Cut(fullName, "/")
+	if !ok {
+		return nil, nil, fmt.Errorf("repo %q is not owner/name", fullName)
+	}
+	source, err := m.repoSource(owner, sourceName)
+	if err != nil {
+		return nil, nil, err
+	}
+	if source.Type == SourceAzureDevOps {
+		return nil, nil, fmt.Errorf("source %s: explaining repos of %s sources is not supported", source.Label(), source.Type)
+	}
+	repo, err := m.Client.GetRepo(source, fullName)
+	if err != nil {
+		return nil, nil, err
+	}
+	d, err := m.Explain(source, repo)
+	return source, d, err
+}
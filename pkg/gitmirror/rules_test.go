@@ -0,0 +1,92 @@
+package gitmirror
+
+import (
+	"testing"
+
+	"github.com/chamzzzzzz/github-repo-mirror/pkg/config"
+	"github.com/chamzzzzzz/github-repo-mirror/pkg/github"
+)
+
+func TestEvaluate(t *testing.T) {
+	repo := &github.Repo{
+		FullName: "Alice/Proj",
+		Topics:   []string{"go", "cli"},
+		Language: "Go",
+	}
+	tests := []struct {
+		name   string
+		source *config.Source
+		skip   bool
+		reason string
+	}{
+		{"no filters", &config.Source{}, false, ""},
+		{"include owners lists owner", &config.Source{IncludeOwners: []string{"alice"}}, false, ""},
+		{"include owners misses owner", &config.Source{IncludeOwners: []string{"bob"}}, true, "owner not in include owners"},
+		{"exclude owners lists owner", &config.Source{ExcludeOwners: []string{"ALICE"}}, true, "owner in exclude owners"},
+		{"include matches", &config.Source{Include: []string{"alice/*"}}, false, ""},
+		{"include matches none", &config.Source{Include: []string{"bob/*"}}, true, "not in include list"},
+		{"include last match negated", &config.Source{Include: []string{"alice/*", "!alice/proj"}}, true, "not in include list"},
+		{"include negation overridden", &config.Source{Include: []string{"!alice/proj", "alice/*"}}, false, ""},
+		{"exclude matches", &config.Source{Exclude: []string{"*/proj"}}, true, "in exclude list"},
+		{"exclude last match negated", &config.Source{Exclude: []string{"alice/*", "!alice/proj"}}, false, ""},
+		{"exclude by url", &config.Source{Exclude: []string{"https://github.com/alice/*"}}, true, "in exclude list"},
+		{"required topics present", &config.Source{RequireTopics: []string{"GO", "cli"}}, false, ""},
+		{"required topic missing", &config.Source{RequireTopics: []string{"go", "web"}}, true, "missing topic web"},
+		{"excluded topic present", &config.Source{ExcludeTopics: []string{"archive", "cli"}}, true, "has excluded topic cli"},
+		{"excluded topics absent", &config.Source{ExcludeTopics: []string{"archive"}}, false, ""},
+		{"language listed", &config.Source{Languages: []string{"go"}}, false, ""},
+		{"language not listed", &config.Source{Languages: []string{"Rust"}}, true, "language not in languages"},
+		{"first skip decides", &config.Source{IncludeOwners: []string{"bob"}, Exclude: []string{"*/*"}}, true, "owner not in include owners"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			d := Evaluate(test.source, repo)
+			if d.Skip != test.skip || d.Reason != test.reason {
+				t.Errorf("Evaluate() = %v %q, want %v %q", d.Skip, d.Reason, test.skip, test.reason)
+			}
+			if len(d.Rules) != 7 {
+				t.Errorf("Evaluate() has %d rules, want 7", len(d.Rules))
+			}
+		})
+	}
+}
+
+func TestEvaluateRules(t *testing.T) {
+	source := &config.Source{Include: []string{"alice/*"}, Exclude: []string{"alice/other"}}
+	d := Evaluate(source, &github.Repo{FullName: "alice/proj"})
+	want := []struct{ rule, result string }{
+		{"IncludeOwners", RuleNotSet},
+		{"ExcludeOwners", RuleNotSet},
+		{"Include", RulePass},
+		{"Exclude", RulePass},
+		{"RequireTopics", RuleNotSet},
+		{"ExcludeTopics", RuleNotSet},
+		{"Languages", RuleNotSet},
+	}
+	if len(d.Rules) != len(want) {
+		t.Fatalf("Evaluate() has %d rules, want %d", len(d.Rules), len(want))
+	}
+	for i, w := range want {
+		if d.Rules[i].Rule != w.rule || d.Rules[i].Result != w.result {
+			t.Errorf("rule %d = %s %s, want %s %s", i, d.Rules[i].Rule, d.Rules[i].Result, w.rule, w.result)
+		}
+	}
+}
+
+func TestCheckPatterns(t *testing.T) {
+	tests := []struct {
+		patterns []string
+		ok       bool
+	}{
+		{nil, true},
+		{[]string{"alice/*", "!alice/proj", "https://github.com/*"}, true},
+		{[]string{"alice/[a-"}, false},
+		{[]string{"!alice/[a-"}, false},
+	}
+	for _, test := range tests {
+		err := checkPatterns([]*config.Source{{Username: "alice", Include: test.patterns}})
+		if (err == nil) != test.ok {
+			t.Errorf("checkPatterns(%q) = %v, want ok %v", test.patterns, err, test.ok)
+		}
+	}
+}
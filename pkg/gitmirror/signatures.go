@@ -0,0 +1,61 @@
+package gitmirror
+
+import (
+	"fmt"
+	"log/slog"
+	"sort"
+	"strings"
+
+	"github.com/chamzzzzzz/github-repo-mirror/pkg/config"
+)
+
+// verifyTags verifies the signatures of the tags of the mirror at local
+// matching the repo's VerifyTags and returns the tags that failed.
+func (m *Mirrorer) verifyTags(local, fullName string, logger *slog.Logger) []string {
+	rc := m.Config.Repos[fullName]
+	if rc == nil || len(rc.VerifyTags) == 0 {
+		return nil
+	}
+	refs, err := m.Git.Refs(local)
+	if err != nil {
+		logger.Warn("Failed to list tags to verify", "error", err)
+		return nil
+	}
+	var tags []string
+	for ref := range refs {
+		for _, pattern := range rc.VerifyTags {
+			if matchRef(pattern, ref) {
+				tags = append(tags, ref)
+				break
+			}
+		}
+	}
+	sort.Strings(tags)
+	var unverified []string
+	for _, tag := range tags {
+		err := m.Git.VerifySignature(local, tag, m.Config.Signatures)
+		if err != nil {
+			logger.Debug("Failed to verify tag", "tag", tag, "error", err, "stderr", Stderr(err))
+			unverified = append(unverified, strings.TrimPrefix(tag, "refs/tags/"))
+		}
+	}
+	if len(unverified) > 0 {
+		logger.Warn("Tags failed signature verification", "count", len(unverified), "verified", len(tags)-len(unverified), "tags", unverified)
+	} else {
+		logger.Debug("Verified tag signatures", "count", len(tags))
+	}
+	return unverified
+}
+
+// checkVerifyTags checks that the VerifyTags of each repo are tag patterns
+// with at most one "*".
+func checkVerifyTags(repos map[string]*config.RepoConfig) error {
+	for name, repo := range repos {
+		for _, pattern := range repo.VerifyTags {
+			if !strings.HasPrefix(pattern, "refs/tags/") || strings.Count(pattern, "*") > 1 {
+				return fmt.Errorf("repo %s: invalid tag pattern %q, want e.g. refs/tags/v*", name, pattern)
+			}
+		}
+	}
+	return nil
+}
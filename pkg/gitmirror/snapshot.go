@@ -0,0 +1,119 @@
+package gitmirror
+
+import (
+	"fmt"
+	"log/slog"
+	"sort"
+	"strings"
+	"time"
+)
+
+// backupPrefix is the ref namespace that keeps the old targets of refs that
+// were force-updated or deleted upstream.
+const backupPrefix = "refs/backup/"
+
+const backupTimeFormat = "20060102T150405Z"
+
+// snapshotRefs returns the refs of the mirror at local before an update, or
+// nil if snapshots are disabled. It excludes the backup refs from the fetch
+// refspec, so a pruning fetch keeps them.
+func (m *Mirrorer) snapshotRefs(local string) (map[string]string, error) {
+	if !m.Config.Snapshots.Enabled {
+		return nil, nil
+	}
+	err := m.Git.ConfigAdd(local, "remote.origin.fetch", "^"+backupPrefix+"*")
+	if err != nil {
+		return nil, err
+	}
+	refs, err := m.Git.Refs(local)
+	if err != nil {
+		return nil, err
+	}
+	for ref := range refs {
+		if strings.HasPrefix(ref, backupPrefix) {
+			delete(refs, ref)
+		}
+	}
+	return refs, nil
+}
+
+// protectRefs compares the refs before an update with the current ones and
+// keeps the old target of every ref that was deleted or moved to a commit
+// that does not descend from it under refs/backup/<time>/. It returns the
+// forced changes.
+func (m *Mirrorer) protectRefs(local string, before map[string]string, logger *slog.Logger) ([]string, error) {
+	if before == nil {
+		return nil, nil
+	}
+	after, err := m.Git.Refs(local)
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now().UTC()
+	backup := backupPrefix + now.Format(backupTimeFormat) + "/"
+	var forced []string
+	for ref, oid := range before {
+		noid, ok := after[ref]
+		if ok && noid == oid {
+			continue
+		}
+		if ok {
+			ancestor, err := m.Git.IsAncestor(local, oid, noid)
+			if err == nil && ancestor {
+				continue
+			}
+			forced = append(forced, fmt.Sprintf("forced %s %s..%s", ref, oid, noid))
+		} else {
+			forced = append(forced, fmt.Sprintf("deleted %s %s", ref, oid))
+		}
+		err = m.Git.UpdateRef(local, backup+strings.TrimPrefix(ref, "refs/"), oid)
+		if err != nil {
+			return forced, err
+		}
+	}
+	sort.Strings(forced)
+	if len(forced) > 0 {
+		logger.Warn("Forced ref updates", "count", len(forced), "refs", forced, "backup", backup)
+	}
+	return forced, m.expireBackups(local, after, now, logger)
+}
+
+// expireBackups deletes the backup refs older than the snapshot retention,
+// if the config allows destructive operations; otherwise it logs them.
+func (m *Mirrorer) expireBackups(local string, refs map[string]string, now time.Time, logger *slog.Logger) error {
+	if m.Config.Snapshots.Retention == "" {
+		return nil
+	}
+	retention, err := time.ParseDuration(m.Config.Snapshots.Retention)
+	if err != nil {
+		return err
+	}
+	var expired []string
+	for ref := range refs {
+		stamp, _, ok := strings.Cut(strings.TrimPrefix(ref, backupPrefix), "/")
+		if !ok || !strings.HasPrefix(ref, backupPrefix) {
+			continue
+		}
+		t, err := time.Parse(backupTimeFormat, stamp)
+		if err != nil || now.Sub(t) < retention {
+			continue
+		}
+		expired = append(expired, ref)
+	}
+	if len(expired) == 0 {
+		return nil
+	}
+	sort.Strings(expired)
+	if !m.Config.AllowDestructive {
+		logger.Warn("Skipped expiring backup refs without AllowDestructive", "refs", expired)
+		return nil
+	}
+	for _, ref := range expired {
+		err = m.Git.UpdateRef(local, ref, "")
+		if err != nil {
+			return err
+		}
+	}
+	logger.Info("Expired backup refs", "refs", expired)
+	return nil
+}
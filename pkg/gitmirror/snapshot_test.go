@@ -0,0 +1,50 @@
+package gitmirror
+
+import (
+	"testing"
+	"time"
+
+	"github.com/chamzzzzzz/github-repo-mirror/pkg/config"
+)
+
+func TestExpireBackups(t *testing.T) {
+	const old = backupPrefix + "20200101T000000Z/heads/main"
+	tests := []struct {
+		name             string
+		allowDestructive bool
+		kept             bool
+	}{
+		{"allowed", true, false},
+		{"not allowed", false, true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			c := &config.Config{Sources: []*config.Source{{Username: "alice"}}, AllowDestructive: test.allowDestructive}
+			c.Snapshots.Enabled = true
+			c.Snapshots.Retention = "24h"
+			m := newTestMirrorer(t, c)
+			first := m.push(t, "alice/proj", nil, false)
+			stat, err := m.Update(c.Sources[0], m.api.add("alice/proj", time.Now(), true))
+			if err != nil {
+				t.Fatal(err)
+			}
+			local := stat.Results[0].Local
+			git(t, local, "update-ref", old, first)
+
+			m.push(t, "alice/proj", map[string]string{"README": "rewritten"}, true)
+			_, err = m.Update(c.Sources[0], m.api.add("alice/proj", time.Now().Add(time.Minute), true))
+			if err != nil {
+				t.Fatal(err)
+			}
+			kept := git(t, local, "for-each-ref", "--format=%(refname)", old) != ""
+			if kept != test.kept {
+				t.Errorf("kept %s = %v, want %v", old, kept, test.kept)
+			}
+			// The backup of this update is within the retention.
+			backups := git(t, local, "for-each-ref", "--format=%(objectname)", backupPrefix)
+			if !test.kept && backups != first {
+				t.Errorf("backup refs = %q, want the new backup of %s", backups, first)
+			}
+		})
+	}
+}
@@ -0,0 +1,85 @@
+package gitmirror
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/chamzzzzzz/github-repo-mirror/pkg/config"
+	"github.com/chamzzzzzz/github-repo-mirror/pkg/github"
+)
+
+const gb = 1 << 30
+
+// checkSpace reports why a new mirror of repo cannot be cloned, or
+// "" if it fits. Once space or the size budget is exhausted, every further
+// new mirror of the run is refused without checking again.
+func (m *Mirrorer) checkSpace(source *config.Source, repo *github.Repo) (string, error) {
+	m.spaceMu.Lock()
+	defer m.spaceMu.Unlock()
+	if m.exhausted != "" {
+		return m.exhausted, nil
+	}
+	disk := &m.Config.Disk
+	margin := disk.SafetyMarginPercent
+	if margin == 0 {
+		margin = 20
+	}
+	need := repo.Size * 1024 * int64(100+margin) / 100
+
+	if disk.MaxTotalSizeGB > 0 {
+		if m.used < 0 {
+			used, err := m.usedSpace()
+			if err != nil {
+				return "", err
+			}
+			m.used = used
+		}
+		budget := int64(disk.MaxTotalSizeGB * gb)
+		if m.used+need > budget {
+			m.exhausted = fmt.Sprintf("size budget of %.1f GB exhausted, %.1f GB used", disk.MaxTotalSizeGB, float64(m.used)/gb)
+			return m.exhausted, nil
+		}
+	}
+
+	free, err := diskFree(existingAncestor(m.Destination(source)))
+	if err != nil {
+		return "", err
+	}
+	if free >= 0 && free-need < int64(disk.MinFreeGB*gb) {
+		m.exhausted = fmt.Sprintf("disk space exhausted at %s, %.1f GB free, %.1f GB needed", m.Destination(source), float64(free)/gb, float64(need)/gb)
+		return m.exhausted, nil
+	}
+	return "", nil
+}
+
+// usedSpace returns the total size of all local mirrors.
+func (m *Mirrorer) usedSpace() (int64, error) {
+	locals, err := m.LocalMirrors()
+	if err != nil {
+		return 0, err
+	}
+	var used int64
+	for _, local := range locals {
+		n, err := Size(local)
+		if err != nil {
+			return 0, err
+		}
+		used += n
+	}
+	return used, nil
+}
+
+// existingAncestor returns path or its closest existing parent directory.
+func existingAncestor(path string) string {
+	for {
+		if _, err := os.Stat(path); err == nil {
+			return path
+		}
+		parent := filepath.Dir(path)
+		if parent == path {
+			return path
+		}
+		path = parent
+	}
+}
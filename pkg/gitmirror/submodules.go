@@ -0,0 +1,170 @@
+package gitmirror
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+
+	"github.com/chamzzzzzz/github-repo-mirror/pkg/github"
+	"github.com/chamzzzzzz/github-repo-mirror/pkg/report"
+)
+
+// referenceFinder returns the "owner/repo" names of the repos that the
+// mirror at local of the repo fullName references.
+type referenceFinder func(fullName, local string) ([]string, error)
+
+// mirrorSubmodules mirrors the GitHub repos referenced as submodules by the
+// repos of stats that were not discovered, and adds them to the stat of the
+// referencing repo's source. It follows Submodules.Depth levels.
+func (m *Mirrorer) mirrorSubmodules(stats []*report.Stat) {
+	m.mirrorReferences(stats, m.Config.Submodules.Depth, "submodule", m.submodules)
+}
+
+// submodules returns the GitHub repos the mirror at local of the repo
+// fullName references as submodules.
+func (m *Mirrorer) submodules(fullName, local string) ([]string, error) {
+	urls, err := m.Git.SubmoduleURLs(local)
+	if err != nil {
+		return nil, err
+	}
+	return submoduleRepos(fullName, urls), nil
+}
+
+// mirrorReferences mirrors the repos that find returns for the mirrors of
+// the repos of stats and have not been seen yet, adding them to the stat of
+// the referencing repo's source, then the repos those reference up to depth
+// (default 1) levels. kind names the references in logs.
+func (m *Mirrorer) mirrorReferences(stats []*report.Stat, depth int, kind string, find referenceFinder) {
+	if depth <= 0 {
+		depth = 1
+	}
+	known := make(map[string]bool)
+	level := m.jobs(stats)
+	for _, j := range level {
+		known[strings.ToLower(j.repo.FullName)] = true
+	}
+	for ; depth > 0 && len(level) > 0; depth-- {
+		var next []*job
+		for _, j := range level {
+			if j.stat.Source.Type == SourceAzureDevOps {
+				continue
+			}
+			local := m.LocalPath(j.stat.Source, j.repo)
+			if !isBare(local) {
+				continue
+			}
+			logger := m.Logger.With("source", j.stat.Name, "repo", j.repo.FullName)
+			names, err := find(j.repo.FullName, local)
+			if err != nil {
+				logger.Warn("Failed to read "+kind+"s", "local", local, "error", err)
+				continue
+			}
+			for _, name := range names {
+				if known[strings.ToLower(name)] {
+					continue
+				}
+				known[strings.ToLower(name)] = true
+				repo, err := m.Client.GetRepo(j.stat.Source, name)
+				if err != nil {
+					logger.Warn("Failed to get "+kind+" repo", kind, name, "error", err)
+					continue
+				}
+				logger.Info("Adding "+kind+" repo", kind, repo.FullName)
+				j.stat.Repos = append(j.stat.Repos, repo)
+				m.Progress.begin(0, 0, repo.FullName)
+				j.stat.Add(m.Mirror(j.stat.Source, repo, m.Logger.With("source", j.stat.Name, "repo", repo.FullName)))
+				next = append(next, &job{j.stat, repo})
+			}
+		}
+		level = next
+	}
+}
+
+// referencedMirrors returns the lowercased paths of the mirrors a run adds
+// because the mirrors of the repos of stats reference them, e.g. as
+// submodules or dependencies. Prune keeps them, though they are not
+// discovered. They are found like the run finds them, but in the mirrors on
+// disk and without API requests.
+func (m *Mirrorer) referencedMirrors(stats []*report.Stat) (map[string]bool, error) {
+	referenced := make(map[string]bool)
+	jobs := m.jobs(stats)
+	if m.Config.Submodules.Enabled {
+		found, err := m.findReferences(jobs, m.Config.Submodules.Depth, m.submodules, referenced)
+		if err != nil {
+			return nil, fmt.Errorf("submodules: %w", err)
+		}
+		// The run looks for dependencies in the submodules it added too.
+		jobs = append(jobs, found...)
+	}
+	if m.Config.Dependencies.Enabled {
+		_, err := m.findReferences(jobs, m.Config.Dependencies.Depth, m.dependencies, referenced)
+		if err != nil {
+			return nil, fmt.Errorf("dependencies: %w", err)
+		}
+	}
+	return referenced, nil
+}
+
+// findReferences adds the lowercased paths of the mirrors that find returns
+// for the mirrors of jobs to referenced, and then those they reference, up
+// to depth (default 1) levels like mirrorReferences. It returns the jobs of
+// the referenced repos.
+func (m *Mirrorer) findReferences(jobs []*job, depth int, find referenceFinder, referenced map[string]bool) ([]*job, error) {
+	if depth <= 0 {
+		depth = 1
+	}
+	known := make(map[string]bool)
+	for _, j := range jobs {
+		known[strings.ToLower(j.repo.FullName)] = true
+	}
+	var found []*job
+	for level := jobs; depth > 0 && len(level) > 0; depth-- {
+		var next []*job
+		for _, j := range level {
+			if j.stat.Source.Type == SourceAzureDevOps {
+				continue
+			}
+			local := m.LocalPath(j.stat.Source, j.repo)
+			if !isBare(local) {
+				continue
+			}
+			names, err := find(j.repo.FullName, local)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %w", local, err)
+			}
+			for _, name := range names {
+				if known[strings.ToLower(name)] {
+					continue
+				}
+				known[strings.ToLower(name)] = true
+				repo := &github.Repo{FullName: name}
+				referenced[strings.ToLower(m.LocalPath(j.stat.Source, repo))] = true
+				next = append(next, &job{j.stat, repo})
+			}
+		}
+		found = append(found, next...)
+		level = next
+	}
+	return found, nil
+}
+
+// submoduleRepos returns the "owner/repo" names of the GitHub repos among
+// the submodule urls of the repo fullName. Relative URLs are resolved
+// against the repo's URL, like git does.
+func submoduleRepos(fullName string, urls []string) []string {
+	base, _ := url.Parse("https://github.com/" + fullName + "/")
+	var names []string
+	for _, u := range urls {
+		if strings.HasPrefix(u, "./") || strings.HasPrefix(u, "../") {
+			ref, err := url.Parse(u)
+			if err != nil {
+				continue
+			}
+			u = base.ResolveReference(ref).String()
+		}
+		if name := repoFromURL(u); name != "" {
+			names = append(names, name)
+		}
+	}
+	return names
+}
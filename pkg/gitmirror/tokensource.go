@@ -0,0 +1,43 @@
+package gitmirror
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+
+	"github.com/chamzzzzzz/github-repo-mirror/pkg/config"
+	"github.com/chamzzzzzz/github-repo-mirror/pkg/credentials"
+)
+
+// ResolveTokens returns a copy of c whose sources with a TokenSource have
+// the Token read from their secret store, and whether any token changed. A
+// source whose secret cannot be read keeps its previous token, if any. c and
+// its sources are not written, so a daemon can swap in the copy while its
+// workers still read them.
+func ResolveTokens(c *config.Config) (*config.Config, bool, error) {
+	resolved := *c
+	resolved.Sources = make([]*config.Source, len(c.Sources))
+	changed := false
+	var errs []error
+	for i, source := range c.Sources {
+		s := *source
+		resolved.Sources[i] = &s
+		if s.TokenSource == "" {
+			continue
+		}
+		token, err := credentials.Resolve(s.TokenSource, c.CredentialStore)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("source %s: %w", s.Label(), err))
+			continue
+		}
+		if token == s.Token {
+			continue
+		}
+		if s.Token != "" {
+			slog.Info("Rotated token", "source", s.Label(), "token_source", s.TokenSource)
+		}
+		s.Token = token
+		changed = true
+	}
+	return &resolved, changed, errors.Join(errs...)
+}
@@ -0,0 +1,170 @@
+package gitmirror
+
+import (
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/chamzzzzzz/github-repo-mirror/pkg/config"
+)
+
+// quarantineDir is the default directory, under the destination, where
+// corrupted mirrors are moved before they are cloned again. LocalMirrors
+// skips it.
+const quarantineDir = ".quarantine"
+
+// Verify checks the integrity of the mirror at local with git fsck.
+func (m *Mirrorer) Verify(local string) error {
+	var args []string
+	if m.Config.Verify.Strict {
+		args = append(args, "--strict")
+	}
+	if m.Config.Verify.ConnectivityOnly {
+		args = append(args, "--connectivity-only")
+	}
+	return m.Git.Fsck(local, args...)
+}
+
+// QuarantinePath returns where the mirror at local is moved when it is
+// quarantined.
+func (m *Mirrorer) QuarantinePath(local string) (string, error) {
+	rel, err := m.relative(local)
+	if err != nil {
+		return "", err
+	}
+	dir := m.Config.Verify.QuarantineDir
+	if dir == "" {
+		dir = filepath.Join(m.Config.Destination, quarantineDir)
+	}
+	return filepath.Join(dir, fmt.Sprintf("%s.%s", rel, time.Now().Format("20060102T150405"))), nil
+}
+
+// Reclone moves the corrupted mirror at local to the quarantine and clones it
+// again from its configured remote, with the clone options Mirror would use.
+// If the clone fails, the quarantined mirror is moved back, so the next run
+// can still update it.
+func (m *Mirrorer) Reclone(local string, logger *slog.Logger) error {
+	unlock, err := m.LockRepo(local)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	locals, err := m.LocalMirrors()
+	if err != nil {
+		return err
+	}
+	dependents, err := Dependents(local, locals)
+	if err != nil {
+		return err
+	}
+	if len(dependents) > 0 {
+		return fmt.Errorf("forks share its objects: %s", strings.Join(dependents, ", "))
+	}
+	url, err := m.Git.ConfigValue(local, "remote.origin.url")
+	if err != nil {
+		return fmt.Errorf("remote url error:'%s'", err)
+	}
+	name := m.RepoName(local)
+	options, err := m.recloneOptions(local, name)
+	if err != nil {
+		return fmt.Errorf("clonemode error:'%s'", err)
+	}
+	quarantine, err := m.QuarantinePath(local)
+	if err != nil {
+		return err
+	}
+	err = os.MkdirAll(filepath.Dir(quarantine), 0755)
+	if err != nil {
+		return err
+	}
+	err = os.Rename(local, quarantine)
+	if err != nil {
+		return err
+	}
+	logger.Warn("Quarantined mirror", "quarantine", quarantine)
+	start := time.Now()
+	err = m.Git.Clone(url, local, options)
+	if err == nil {
+		err = m.configureGC(local, name)
+	}
+	if err == nil {
+		err = touch(local)
+	}
+	if err != nil {
+		Remove(local)
+		if _err := os.Rename(quarantine, local); _err != nil {
+			return fmt.Errorf("clone error:'%s', restore error:'%s'", err, _err)
+		}
+		return fmt.Errorf("clone error:'%s'", err)
+	}
+	logger.Info("Successfully reclone", "duration", time.Since(start))
+	return nil
+}
+
+// recloneOptions returns the clone options of the mirror at local of repo
+// name: those of its source and repo config, as the state or its owner tells
+// the source, with the object format of the mirror and the parent it borrows
+// objects from.
+func (m *Mirrorer) recloneOptions(local, name string) (*CloneOptions, error) {
+	var label string
+	if m.State != nil {
+		if repo, ok := m.State.Repo(name); ok {
+			label = repo.Source
+		}
+	}
+	owner, _, _ := strings.Cut(name, "/")
+	source, err := m.repoSource(owner, label)
+	if err != nil {
+		source = &config.Source{}
+	}
+	options, err := m.CloneOptions(source, name)
+	if err != nil {
+		return nil, err
+	}
+	options.ObjectFormat, err = m.ObjectFormat(local)
+	if err != nil {
+		return nil, err
+	}
+	dirs, err := alternates(local)
+	if err != nil {
+		return nil, err
+	}
+	if len(dirs) > 0 {
+		options.Reference = filepath.Dir(dirs[0])
+	}
+	return options, nil
+}
+
+// VerifyAll runs Verify on every local mirror and, if reclone is set,
+// reclones the corrupted ones. It returns the corrupted mirrors and how many
+// of them were recloned.
+func (m *Mirrorer) VerifyAll(reclone bool) (corrupted []string, recloned int, err error) {
+	locals, err := m.LocalMirrors()
+	if err != nil {
+		return nil, 0, err
+	}
+	for _, local := range locals {
+		logger := m.Logger.With("local", local, "operation", "verify")
+		start := time.Now()
+		err := m.Verify(local)
+		if err == nil {
+			logger.Info("Successfully verify", "duration", time.Since(start))
+			continue
+		}
+		logger.Error("Failed verify", "error", err)
+		corrupted = append(corrupted, local)
+		if !reclone {
+			continue
+		}
+		err = m.Reclone(local, logger)
+		if err != nil {
+			logger.Error("Failed reclone", "error", err)
+			continue
+		}
+		recloned++
+	}
+	return corrupted, recloned, nil
+}
@@ -0,0 +1,179 @@
+// Package notify sends failure notifications and run summaries at the end of
+// a run.
+package notify
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/smtp"
+	"strings"
+	"time"
+
+	"github.com/chamzzzzzz/github-repo-mirror/pkg/config"
+	"github.com/chamzzzzzz/github-repo-mirror/pkg/report"
+	"github.com/chamzzzzzz/github-repo-mirror/pkg/state"
+)
+
+type Notification struct {
+	Text         string         `json:"text"`
+	Failures     []string       `json:"failures"`
+	NewlyFailing []string       `json:"newly_failing"`
+	ForcedRefs   []string       `json:"forced_refs"`
+	Stale        []string       `json:"stale"`
+	Sources      []*report.Stat `json:"sources"`
+}
+
+// Notifier sends notifications when a run has failures or forced ref
+// updates.
+type Notifier struct {
+	Config *config.Notifications
+	// Store holds the repo states with the run being notified already
+	// recorded, so a healthy repo that starts failing is noticed across
+	// runs and restarts.
+	Store *state.Store
+	// MaxStaleness is the age of the last success at which a repo is
+	// stale, zero to not check.
+	MaxStaleness time.Duration
+}
+
+func New(config *config.Notifications, store *state.Store) *Notifier {
+	return &Notifier{
+		Config: config,
+		Store:  store,
+	}
+}
+
+// Notify sends notifications for the failures in stats if they reach the
+// threshold, a repo that succeeded in the previous run failed, or refs were
+// force-updated or deleted upstream, or a repo became stale.
+func (notifier *Notifier) Notify(stats []*report.Stat) {
+	n := notifier.Config
+	if n.Slack == nil && n.Email == nil && n.HTTP == nil {
+		return
+	}
+	notification := &Notification{
+		Sources: stats,
+	}
+	newlyStale := false
+	for _, stat := range stats {
+		notification.Stale = append(notification.Stale, stat.Stale...)
+		for _, name := range stat.Stale {
+			repo, ok := notifier.Store.Repo(name)
+			if !ok {
+				continue
+			}
+			if _, newly := repo.Stale(notifier.MaxStaleness, time.Now()); newly {
+				newlyStale = true
+			}
+		}
+		switch {
+		case stat.Error != "":
+			notification.Failures = append(notification.Failures, fmt.Sprintf("source %s: %s", stat.Name, stat.Error))
+		case stat.DiscoveryError != "":
+			notification.Failures = append(notification.Failures, fmt.Sprintf("source %s: %s, used the cached repo list", stat.Name, stat.DiscoveryError))
+		}
+		for _, result := range stat.Results {
+			for _, ref := range result.ForcedRefs {
+				notification.ForcedRefs = append(notification.ForcedRefs, fmt.Sprintf("%s: %s", result.Repo, ref))
+			}
+			if !result.Outcome.Failed() {
+				continue
+			}
+			notification.Failures = append(notification.Failures, fmt.Sprintf("%s: %s", result.Repo, result.Error))
+			repo, ok := notifier.Store.Repo(result.Repo)
+			if ok && repo.ConsecutiveFailures == 1 && !repo.LastSuccess.IsZero() {
+				notification.NewlyFailing = append(notification.NewlyFailing, result.Repo)
+			}
+		}
+	}
+
+	threshold := n.Threshold
+	if threshold <= 0 {
+		threshold = 1
+	}
+	if len(notification.Failures) < threshold && len(notification.NewlyFailing) == 0 && len(notification.ForcedRefs) == 0 && !newlyStale {
+		return
+	}
+	notification.Text = notificationText(notification)
+
+	if n.Slack != nil {
+		err := postJSON(n.Slack.WebhookURL, nil, map[string]string{"text": notification.Text})
+		if err != nil {
+			slog.Error("Failed to send slack notification", "error", err)
+		}
+	}
+	if n.Email != nil {
+		err := sendEmail(n.Email, "github-repo-mirror: mirroring failures", notification.Text)
+		if err != nil {
+			slog.Error("Failed to send email notification", "error", err)
+		}
+	}
+	if n.HTTP != nil {
+		err := postJSON(n.HTTP.URL, n.HTTP.Headers, notification)
+		if err != nil {
+			slog.Error("Failed to send http notification", "error", err)
+		}
+	}
+}
+
+func notificationText(notification *Notification) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "github-repo-mirror: %d failures", len(notification.Failures))
+	if len(notification.NewlyFailing) > 0 {
+		fmt.Fprintf(&b, ", %d newly failing: %s", len(notification.NewlyFailing), strings.Join(notification.NewlyFailing, ", "))
+	}
+	if len(notification.ForcedRefs) > 0 {
+		fmt.Fprintf(&b, ", %d forced ref updates", len(notification.ForcedRefs))
+	}
+	if len(notification.Stale) > 0 {
+		fmt.Fprintf(&b, ", %d stale: %s", len(notification.Stale), strings.Join(notification.Stale, ", "))
+	}
+	b.WriteString("\n")
+	for _, failure := range notification.Failures {
+		fmt.Fprintf(&b, "- %s\n", failure)
+	}
+	for _, ref := range notification.ForcedRefs {
+		fmt.Fprintf(&b, "- %s\n", ref)
+	}
+	return b.String()
+}
+
+func postJSON(url string, headers map[string]string, v any) error {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest("POST", url, bytes.NewReader(b))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	return nil
+}
+
+func sendEmail(email *config.EmailNotification, subject, body string) error {
+	port := email.Port
+	if port == 0 {
+		port = 587
+	}
+	var auth smtp.Auth
+	if email.Username != "" {
+		auth = smtp.PlainAuth("", email.Username, email.Password, email.Host)
+	}
+	msg := fmt.Sprintf("From: %s\r\nTo: %s\r\nSubject: %s\r\n\r\n%s", email.From, strings.Join(email.To, ", "), subject, strings.ReplaceAll(body, "\n", "\r\n"))
+	return smtp.SendMail(fmt.Sprintf("%s:%d", email.Host, port), auth, email.From, email.To, []byte(msg))
+}
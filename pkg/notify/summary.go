@@ -0,0 +1,102 @@
+package notify
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/chamzzzzzz/github-repo-mirror/pkg/config"
+	"github.com/chamzzzzzz/github-repo-mirror/pkg/report"
+)
+
+// Summarize adds the run that started at start to the summary and emails it
+// if it is due and anything happened. prunable lists the mirrors whose repos
+// are gone upstream.
+func (notifier *Notifier) Summarize(start time.Time, stats []*report.Stat, prunable []string) error {
+	c := notifier.Config.Summary
+	if c == nil {
+		return nil
+	}
+	var digest time.Duration
+	if c.Digest != "" {
+		var err error
+		digest, err = time.ParseDuration(c.Digest)
+		if err != nil {
+			return fmt.Errorf("parse digest: %w", err)
+		}
+	}
+	summary := &report.Summary{}
+	if digest > 0 {
+		if s := notifier.Store.Digest(); s != nil {
+			summary = s
+		}
+	}
+	summary.Add(start, stats, prunable)
+	if digest > 0 && time.Since(summary.Start) < digest {
+		notifier.Store.SetDigest(summary)
+		return notifier.Store.Save()
+	}
+	if summary.Interesting() {
+		body, err := summaryText(c, summary)
+		if err != nil {
+			return err
+		}
+		subject := "github-repo-mirror: run summary"
+		if digest > 0 {
+			subject = "github-repo-mirror: digest since " + summary.Start.Format("2006-01-02 15:04")
+		}
+		err = sendEmail(&c.Email, subject, body)
+		if err != nil {
+			return err
+		}
+	}
+	if digest > 0 {
+		notifier.Store.SetDigest(nil)
+		return notifier.Store.Save()
+	}
+	return nil
+}
+
+func summaryText(c *config.SummaryNotification, summary *report.Summary) (string, error) {
+	if c.Template != "" {
+		b, err := os.ReadFile(c.Template)
+		if err != nil {
+			return "", err
+		}
+		t, err := template.New("summary").Parse(string(b))
+		if err != nil {
+			return "", err
+		}
+		var buf strings.Builder
+		err = t.Execute(&buf, summary)
+		if err != nil {
+			return "", err
+		}
+		return buf.String(), nil
+	}
+	var b strings.Builder
+	fmt.Fprintf(&b, "Runs: %d, from %s to %s\n", summary.Runs, summary.Start.Format(time.RFC3339), summary.End.Format(time.RFC3339))
+	section := func(title string, names []string) {
+		if len(names) == 0 {
+			return
+		}
+		fmt.Fprintf(&b, "\n%s (%d):\n", title, len(names))
+		for _, name := range names {
+			fmt.Fprintf(&b, "- %s\n", name)
+		}
+	}
+	section("New mirrors", summary.Mirrored)
+	section("Updated", summary.Updated)
+	section("Gone upstream, to prune", summary.Prunable)
+	section("Default branch changed", summary.DefaultBranchChanges)
+	section("Unverified tags", summary.UnverifiedTags)
+	if len(summary.Failures) > 0 {
+		fmt.Fprintf(&b, "\nFailures (%d):\n", len(summary.Failures))
+		for _, failure := range summary.Failures {
+			fmt.Fprintf(&b, "- %s: %s\n", failure.Name, failure.Error)
+		}
+	}
+	return b.String(), nil
+}
@@ -0,0 +1,18 @@
+package report
+
+// Diff is what changed since the previous run: the repos newly listed and
+// no longer listed upstream, the repos that started failing or recovered,
+// and the change in size of the synced mirrors in bytes. Sources without a
+// live listing in both runs do not count towards New and Gone.
+type Diff struct {
+	New       []string `json:"new,omitempty"`
+	Gone      []string `json:"gone,omitempty"`
+	Failing   []string `json:"failing,omitempty"`
+	Recovered []string `json:"recovered,omitempty"`
+	SizeDelta int64    `json:"size_delta"`
+}
+
+// Empty reports whether nothing changed.
+func (d *Diff) Empty() bool {
+	return len(d.New) == 0 && len(d.Gone) == 0 && len(d.Failing) == 0 && len(d.Recovered) == 0 && d.SizeDelta == 0
+}
@@ -0,0 +1,71 @@
+package report
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// Failures lists the failed repos of a run with the details of their
+// failure.
+type Failures struct {
+	Time  time.Time     `json:"time"`
+	Repos []*FailedRepo `json:"repos"`
+}
+
+type FailedRepo struct {
+	Repo    string  `json:"repo"`
+	Source  string  `json:"source"`
+	Local   string  `json:"local"`
+	Outcome Outcome `json:"outcome"`
+	// Step is the operation that failed, e.g. clone or update.
+	Step   string `json:"step,omitempty"`
+	Error  string `json:"error"`
+	Stderr string `json:"stderr,omitempty"`
+	// Retries is how many times the operation was retried in the run.
+	Retries int `json:"retries"`
+	// ConsecutiveFailures counts the failed runs since the last success,
+	// including this one.
+	ConsecutiveFailures int `json:"consecutive_failures"`
+}
+
+// WriteFailures atomically writes the failed repos of stats to path as
+// JSON, with their consecutive failures as returned by consecutive.
+func WriteFailures(path string, stats []*Stat, consecutive func(repo string) int) error {
+	failures := &Failures{
+		Time:  time.Now(),
+		Repos: []*FailedRepo{},
+	}
+	for _, stat := range stats {
+		for _, result := range stat.Results {
+			if !result.Outcome.Failed() {
+				continue
+			}
+			failures.Repos = append(failures.Repos, &FailedRepo{
+				Repo:                result.Repo,
+				Source:              stat.Name,
+				Local:               result.Local,
+				Outcome:             result.Outcome,
+				Step:                result.Step,
+				Error:               result.Error,
+				Stderr:              result.Stderr,
+				Retries:             max(result.Attempts-1, 0),
+				ConsecutiveFailures: consecutive(result.Repo),
+			})
+		}
+	}
+	b, err := json.MarshalIndent(failures, "", "  ")
+	if err != nil {
+		return err
+	}
+	err = os.MkdirAll(filepath.Dir(path), 0755)
+	if err != nil {
+		return err
+	}
+	err = os.WriteFile(path+".tmp", b, 0644)
+	if err != nil {
+		return err
+	}
+	return os.Rename(path+".tmp", path)
+}
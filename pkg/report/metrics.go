@@ -0,0 +1,127 @@
+package report
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/chamzzzzzz/github-repo-mirror/pkg/config"
+)
+
+// ExportMetrics writes the metrics of a run that started at start to the
+// textfile and pushes them to the Pushgateway of metrics, if configured.
+func ExportMetrics(metrics *config.Metrics, start time.Time, stats []*Stat) error {
+	if metrics.TextfilePath == "" && metrics.PushgatewayURL == "" {
+		return nil
+	}
+	var b bytes.Buffer
+	writeMetrics(&b, start, time.Now(), stats)
+	if metrics.TextfilePath != "" {
+		// The collector may read the file at any time, so replace it
+		// atomically.
+		err := os.MkdirAll(filepath.Dir(metrics.TextfilePath), 0755)
+		if err != nil {
+			return err
+		}
+		tmp := metrics.TextfilePath + ".tmp"
+		err = os.WriteFile(tmp, b.Bytes(), 0644)
+		if err != nil {
+			return err
+		}
+		err = os.Rename(tmp, metrics.TextfilePath)
+		if err != nil {
+			return err
+		}
+	}
+	if metrics.PushgatewayURL != "" {
+		job := metrics.Job
+		if job == "" {
+			job = "github-repo-mirror"
+		}
+		url := fmt.Sprintf("%s/metrics/job/%s", strings.TrimSuffix(metrics.PushgatewayURL, "/"), url.PathEscape(job))
+		req, err := http.NewRequest(http.MethodPut, url, &b)
+		if err != nil {
+			return err
+		}
+		req.Header.Set("Content-Type", "text/plain; version=0.0.4")
+		client := &http.Client{Timeout: 30 * time.Second}
+		resp, err := client.Do(req)
+		if err != nil {
+			return fmt.Errorf("push metrics: %w", err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode/100 != 2 {
+			return fmt.Errorf("push metrics: unexpected status %s", resp.Status)
+		}
+	}
+	return nil
+}
+
+// writeMetrics writes the metrics of a run from start to end in the
+// Prometheus text format.
+func writeMetrics(b *bytes.Buffer, start, end time.Time, stats []*Stat) {
+	metric := func(name, help string) {
+		fmt.Fprintf(b, "# HELP %s %s\n# TYPE %s gauge\n", name, help, name)
+	}
+	value := func(v float64) string {
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	}
+	metric("github_repo_mirror_last_run_timestamp_seconds", "Time the last run ended.")
+	fmt.Fprintf(b, "github_repo_mirror_last_run_timestamp_seconds %d\n", end.Unix())
+	metric("github_repo_mirror_last_run_duration_seconds", "Duration of the last run.")
+	fmt.Fprintf(b, "github_repo_mirror_last_run_duration_seconds %s\n", value(end.Sub(start).Seconds()))
+	metric("github_repo_mirror_last_run_repos", "Repos of each source in the last run by outcome.")
+	for _, stat := range stats {
+		for _, count := range []struct {
+			outcome Outcome
+			n       int
+		}{
+			{OutcomeSkipped, stat.Skipped},
+			{OutcomeMirrored, stat.Mirrored},
+			{OutcomeUpdated, stat.Updated},
+			{OutcomeUnchanged, stat.Unchanged},
+			{OutcomeArchived, stat.Archived},
+			{OutcomeDeferred, stat.Deferred},
+			{OutcomeQuarantined, stat.Quarantined},
+			{OutcomeFailed, stat.Failed},
+			{OutcomeFailedMirror, stat.FailedMirror},
+			{OutcomeFailedUpdate, stat.FailedUpdate},
+		} {
+			fmt.Fprintf(b, "github_repo_mirror_last_run_repos{source=%q,outcome=%q} %d\n", stat.Name, count.outcome, count.n)
+		}
+	}
+	metric("github_repo_mirror_last_run_source_failed", "Whether the source could not be listed in the last run.")
+	for _, stat := range stats {
+		failed := 0
+		if stat.Error != "" {
+			failed = 1
+		}
+		fmt.Fprintf(b, "github_repo_mirror_last_run_source_failed{source=%q} %d\n", stat.Name, failed)
+	}
+	metric("github_repo_mirror_last_run_source_discovery_failed", "Whether the source could not be listed live in the last run, even if its cached repo list was used.")
+	for _, stat := range stats {
+		failed := 0
+		if stat.DiscoveryError != "" {
+			failed = 1
+		}
+		fmt.Fprintf(b, "github_repo_mirror_last_run_source_discovery_failed{source=%q} %d\n", stat.Name, failed)
+	}
+	metric("github_repo_mirror_last_run_bytes", "Total size of the mirrors of each source synced in the last run.")
+	for _, stat := range stats {
+		fmt.Fprintf(b, "github_repo_mirror_last_run_bytes{source=%q} %d\n", stat.Name, stat.Bytes)
+	}
+	metric("github_repo_mirror_last_run_received_bytes", "Bytes received for each source in the last run.")
+	for _, stat := range stats {
+		fmt.Fprintf(b, "github_repo_mirror_last_run_received_bytes{source=%q} %d\n", stat.Name, stat.Received)
+	}
+	metric("github_repo_mirror_last_run_source_duration_seconds", "Time spent syncing the repos of each source in the last run.")
+	for _, stat := range stats {
+		fmt.Fprintf(b, "github_repo_mirror_last_run_source_duration_seconds{source=%q} %s\n", stat.Name, value(stat.Duration.Seconds()))
+	}
+}
@@ -0,0 +1,43 @@
+package report
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"time"
+)
+
+type Report struct {
+	Time    time.Time `json:"time"`
+	Sources []*Stat   `json:"sources"`
+	// Diff is what changed since the previous run, if known.
+	Diff *Diff `json:"diff,omitempty"`
+}
+
+// Write writes a report of stats and their diff, if any, in format to file,
+// - meaning stdout. An empty format writes nothing.
+func Write(format, file string, stats []*Stat, diff *Diff) error {
+	if format == "" {
+		return nil
+	}
+	if format != "json" {
+		return fmt.Errorf("unknown report format %q", format)
+	}
+	var w io.Writer = os.Stdout
+	if file != "-" {
+		f, err := os.Create(file)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		w = f
+	}
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(&Report{
+		Time:    time.Now(),
+		Sources: stats,
+		Diff:    diff,
+	})
+}
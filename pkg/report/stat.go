@@ -0,0 +1,176 @@
+// Package report collects per-source and per-repo run results.
+package report
+
+import (
+	"time"
+
+	"github.com/chamzzzzzz/github-repo-mirror/pkg/config"
+	"github.com/chamzzzzzz/github-repo-mirror/pkg/github"
+)
+
+type Stat struct {
+	Source       *config.Source `json:"-"`
+	Name         string         `json:"source"`
+	Repos        []*github.Repo `json:"-"`
+	Results      []*Result      `json:"results"`
+	Skipped      int            `json:"skipped"`
+	Mirrored     int            `json:"mirrored"`
+	Updated      int            `json:"updated"`
+	Failed       int            `json:"failed"`
+	FailedMirror int            `json:"failed_mirror"`
+	FailedUpdate int            `json:"failed_update"`
+	Archived     int            `json:"archived"`
+	Deferred     int            `json:"deferred"`
+	Unchanged    int            `json:"unchanged"`
+	Quarantined  int            `json:"quarantined"`
+	// Error is why the repos could not be listed, if they were not.
+	Error string `json:"error,omitempty"`
+	// DiscoveryError is why the repos could not be listed live. Unlike
+	// Error it is also set when the discovery cache stood in.
+	DiscoveryError string `json:"discovery_error,omitempty"`
+	// CachedAt is when the repos were listed, if they are from the
+	// discovery cache.
+	CachedAt *time.Time `json:"cached_at,omitempty"`
+	// Duration, TransferDuration, Received and Bytes are the sums of the
+	// results'.
+	Duration         time.Duration `json:"duration"`
+	TransferDuration time.Duration `json:"transfer_duration"`
+	Received         int64         `json:"received"`
+	Bytes            int64         `json:"bytes"`
+	// Stale lists the repos that have not synced successfully within the
+	// config's MaxStaleness.
+	Stale []string `json:"stale,omitempty"`
+	// Tokens is the API usage of the source's tokens.
+	Tokens []*github.TokenUsage `json:"tokens,omitempty"`
+	// Partial marks a stat of single repos synced outside a run, e.g. by
+	// the add command. It says nothing about the source's other repos.
+	Partial bool `json:"partial,omitempty"`
+}
+
+type Outcome string
+
+const (
+	OutcomeSkipped      Outcome = "skipped"
+	OutcomeMirrored     Outcome = "mirrored"
+	OutcomeUpdated      Outcome = "updated"
+	OutcomeFailed       Outcome = "failed"
+	OutcomeFailedMirror Outcome = "failed_mirror"
+	OutcomeFailedUpdate Outcome = "failed_update"
+	OutcomeArchived     Outcome = "archived"
+	// OutcomeDeferred means the repo was not attempted this run.
+	OutcomeDeferred Outcome = "deferred"
+	// OutcomeUnchanged means the mirror was not fetched because upstream was
+	// not pushed to since its last successful sync.
+	OutcomeUnchanged Outcome = "unchanged"
+	// OutcomeQuarantined means the repo was not attempted because it kept
+	// failing and its next retry is not due yet.
+	OutcomeQuarantined Outcome = "quarantined"
+	// OutcomeAdopted means an existing bare repo was taken over as the
+	// repo's mirror.
+	OutcomeAdopted Outcome = "adopted"
+)
+
+// Failed reports whether the outcome is a failure.
+func (o Outcome) Failed() bool {
+	return o == OutcomeFailed || o == OutcomeFailedMirror || o == OutcomeFailedUpdate
+}
+
+type Result struct {
+	Repo     string           `json:"repo"`
+	Remote   string           `json:"remote"`
+	Local    string           `json:"local"`
+	Outcome  Outcome          `json:"outcome"`
+	Reason   string           `json:"reason,omitempty"`
+	DryRun   bool             `json:"dry_run,omitempty"`
+	Archive  string           `json:"archive,omitempty"`
+	Error    string           `json:"error,omitempty"`
+	Duration time.Duration    `json:"duration"`
+	Bytes    int64            `json:"bytes"`
+	Replicas []*ReplicaResult `json:"replicas,omitempty"`
+	// RefMismatches lists the refs that differ from upstream after the fetch.
+	RefMismatches []string `json:"ref_mismatches,omitempty"`
+	// ForcedRefs lists the refs the update force-moved or deleted.
+	ForcedRefs []string `json:"forced_refs,omitempty"`
+	// Bundle is the bundle exported after the fetch, if any.
+	Bundle      string `json:"bundle,omitempty"`
+	BundleError string `json:"bundle_error,omitempty"`
+	// TransferDuration is the part of Duration spent cloning and fetching.
+	TransferDuration time.Duration `json:"transfer_duration"`
+	// Received is how much the mirror grew on disk with the sync, which
+	// approximates the bytes received from upstream. Bytes is its disk size
+	// after the sync.
+	Received int64 `json:"received"`
+	// Objects describes the mirror's object storage after the sync.
+	Objects *ObjectStats `json:"objects,omitempty"`
+	// Step is the step a failed sync failed at, e.g. clone or update.
+	Step string `json:"step,omitempty"`
+	// Stderr is the end of the standard error of the failed git command.
+	Stderr string `json:"stderr,omitempty"`
+	// Attempts is how many times a new mirror was cloned.
+	Attempts int `json:"attempts,omitempty"`
+	// DefaultBranchChange is set when the upstream default branch changed
+	// since the last sync and HEAD of the mirror was moved to it.
+	DefaultBranchChange *BranchChange `json:"default_branch_change,omitempty"`
+	// UnverifiedTags lists the tags matching the repo's VerifyTags whose
+	// signatures failed verification.
+	UnverifiedTags []string `json:"unverified_tags,omitempty"`
+}
+
+// BranchChange is a change of the default branch, e.g. from master to main.
+type BranchChange struct {
+	From string `json:"from"`
+	To   string `json:"to"`
+}
+
+// ObjectStats describes the object storage of a mirror, as reported by git
+// count-objects. Sizes are in bytes.
+type ObjectStats struct {
+	LooseObjects int64 `json:"loose_objects"`
+	LooseSize    int64 `json:"loose_size"`
+	InPack       int64 `json:"in_pack"`
+	Packs        int64 `json:"packs"`
+	PackSize     int64 `json:"pack_size"`
+	LargestPack  int64 `json:"largest_pack"`
+	GarbageSize  int64 `json:"garbage_size"`
+}
+
+// Size is the disk size of the objects.
+func (o *ObjectStats) Size() int64 {
+	return o.LooseSize + o.PackSize + o.GarbageSize
+}
+
+type ReplicaResult struct {
+	Name     string        `json:"name"`
+	Error    string        `json:"error,omitempty"`
+	Duration time.Duration `json:"duration"`
+}
+
+func (stat *Stat) Add(result *Result) {
+	stat.Results = append(stat.Results, result)
+	stat.Duration += result.Duration
+	stat.TransferDuration += result.TransferDuration
+	stat.Received += result.Received
+	stat.Bytes += result.Bytes
+	switch result.Outcome {
+	case OutcomeSkipped:
+		stat.Skipped++
+	case OutcomeMirrored:
+		stat.Mirrored++
+	case OutcomeUpdated:
+		stat.Updated++
+	case OutcomeFailed:
+		stat.Failed++
+	case OutcomeFailedMirror:
+		stat.FailedMirror++
+	case OutcomeFailedUpdate:
+		stat.FailedUpdate++
+	case OutcomeArchived:
+		stat.Archived++
+	case OutcomeDeferred:
+		stat.Deferred++
+	case OutcomeUnchanged:
+		stat.Unchanged++
+	case OutcomeQuarantined:
+		stat.Quarantined++
+	}
+}
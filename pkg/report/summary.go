@@ -0,0 +1,106 @@
+package report
+
+import (
+	"fmt"
+	"sort"
+	"time"
+)
+
+// Summary collects what happened in one or more runs: the new mirrors, the
+// updated repos, the mirrors whose repos are gone upstream and the failures.
+type Summary struct {
+	Start    time.Time `json:"start"`
+	End      time.Time `json:"end"`
+	Runs     int       `json:"runs"`
+	Mirrored []string  `json:"mirrored,omitempty"`
+	Updated  []string  `json:"updated,omitempty"`
+	// Prunable lists the local mirrors the prune command would remove.
+	Prunable []string   `json:"prunable,omitempty"`
+	Failures []*Failure `json:"failures,omitempty"`
+	// DefaultBranchChanges lists the repos whose default branch changed,
+	// as "owner/repo: from -> to".
+	DefaultBranchChanges []string `json:"default_branch_changes,omitempty"`
+	// UnverifiedTags lists the tags that failed signature verification, as
+	// "owner/repo: tag".
+	UnverifiedTags []string `json:"unverified_tags,omitempty"`
+}
+
+// Failure is the latest error of a repo or source.
+type Failure struct {
+	Name  string `json:"name"`
+	Error string `json:"error"`
+}
+
+// maxExcerpt is the length at which failure errors are cut.
+const maxExcerpt = 300
+
+// Add merges a run that started at start into the summary. A repo is listed
+// once, under the latest failure if it failed more than once.
+func (s *Summary) Add(start time.Time, stats []*Stat, prunable []string) {
+	if s.Runs == 0 {
+		s.Start = start
+	}
+	s.Runs++
+	s.End = time.Now()
+	mirrored := make(map[string]bool)
+	for _, name := range s.Mirrored {
+		mirrored[name] = true
+	}
+	for _, stat := range stats {
+		if stat.DiscoveryError != "" {
+			s.fail("source "+stat.Name, stat.DiscoveryError)
+		}
+		for _, result := range stat.Results {
+			switch {
+			case result.Outcome == OutcomeMirrored:
+				mirrored[result.Repo] = true
+				s.Mirrored = appendUnique(s.Mirrored, result.Repo)
+			case result.Outcome == OutcomeUpdated && !mirrored[result.Repo]:
+				s.Updated = appendUnique(s.Updated, result.Repo)
+			case result.Outcome.Failed():
+				s.fail(result.Repo, result.Error)
+			}
+			if change := result.DefaultBranchChange; change != nil {
+				s.DefaultBranchChanges = appendUnique(s.DefaultBranchChanges, fmt.Sprintf("%s: %s -> %s", result.Repo, change.From, change.To))
+			}
+			for _, tag := range result.UnverifiedTags {
+				s.UnverifiedTags = appendUnique(s.UnverifiedTags, result.Repo+": "+tag)
+			}
+		}
+	}
+	for _, local := range prunable {
+		s.Prunable = appendUnique(s.Prunable, local)
+	}
+	sort.Slice(s.Failures, func(i, j int) bool { return s.Failures[i].Name < s.Failures[j].Name })
+}
+
+func (s *Summary) fail(name, err string) {
+	if r := []rune(err); len(r) > maxExcerpt {
+		err = string(r[:maxExcerpt]) + "..."
+	}
+	for _, failure := range s.Failures {
+		if failure.Name == name {
+			failure.Error = err
+			return
+		}
+	}
+	s.Failures = append(s.Failures, &Failure{Name: name, Error: err})
+}
+
+// Interesting reports whether anything other than unchanged or skipped
+// repos happened.
+func (s *Summary) Interesting() bool {
+	return len(s.Mirrored) > 0 || len(s.Updated) > 0 || len(s.Prunable) > 0 || len(s.Failures) > 0 || len(s.DefaultBranchChanges) > 0 || len(s.UnverifiedTags) > 0
+}
+
+// appendUnique inserts name into the sorted names unless it is there.
+func appendUnique(names []string, name string) []string {
+	i := sort.SearchStrings(names, name)
+	if i < len(names) && names[i] == name {
+		return names
+	}
+	names = append(names, "")
+	copy(names[i+1:], names[i:])
+	names[i] = name
+	return names
+}
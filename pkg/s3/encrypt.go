@@ -0,0 +1,141 @@
+package s3
+
+import (
+	"bufio"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// Encrypted objects start with encryptionMagic and a random nonce prefix,
+// followed by chunks of at most chunkSize plaintext bytes, each sealed with
+// AES-256-GCM and prefixed with its length. The nonce of a chunk is the
+// prefix and the chunk's index; the last chunk is authenticated as such, so
+// truncation is detected.
+const (
+	encryptionMagic = "GRM1"
+	chunkSize       = 64 * 1024
+)
+
+// ParseKey decodes a base64 encoded 32 byte AES-256 key.
+func ParseKey(s string) ([]byte, error) {
+	key, err := base64.StdEncoding.DecodeString(s)
+	if err != nil {
+		return nil, err
+	}
+	if len(key) != 32 {
+		return nil, fmt.Errorf("encryption key has %d bytes, want 32", len(key))
+	}
+	return key, nil
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+func chunkNonce(prefix []byte, i uint32) []byte {
+	return binary.BigEndian.AppendUint32(append([]byte(nil), prefix...), i)
+}
+
+// Encrypt writes r encrypted with key to w.
+func Encrypt(w io.Writer, r io.Reader, key []byte) error {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return err
+	}
+	prefix := make([]byte, gcm.NonceSize()-4)
+	_, err = rand.Read(prefix)
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(append([]byte(encryptionMagic), prefix...))
+	if err != nil {
+		return err
+	}
+	br := bufio.NewReader(r)
+	buf := make([]byte, chunkSize)
+	for i := uint32(0); ; i++ {
+		n, err := io.ReadFull(br, buf)
+		if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+			return err
+		}
+		last := n < chunkSize
+		if !last {
+			_, err := br.Peek(1)
+			if err != nil && err != io.EOF {
+				return err
+			}
+			last = err == io.EOF
+		}
+		sealed := gcm.Seal(nil, chunkNonce(prefix, i), buf[:n], lastData(last))
+		_, err = w.Write(binary.BigEndian.AppendUint32(nil, uint32(len(sealed))))
+		if err == nil {
+			_, err = w.Write(sealed)
+		}
+		if err != nil || last {
+			return err
+		}
+	}
+}
+
+// Decrypt writes r, encrypted by Encrypt with key, decrypted to w.
+func Decrypt(w io.Writer, r io.Reader, key []byte) error {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return err
+	}
+	header := make([]byte, len(encryptionMagic)+gcm.NonceSize()-4)
+	_, err = io.ReadFull(r, header)
+	if err != nil {
+		return err
+	}
+	if string(header[:len(encryptionMagic)]) != encryptionMagic {
+		return errors.New("not an encrypted object")
+	}
+	prefix := header[len(encryptionMagic):]
+	size := make([]byte, 4)
+	buf := make([]byte, chunkSize+gcm.Overhead())
+	for i := uint32(0); ; i++ {
+		_, err := io.ReadFull(r, size)
+		if err != nil {
+			return fmt.Errorf("truncated object: %w", err)
+		}
+		n := binary.BigEndian.Uint32(size)
+		if int(n) > len(buf) {
+			return errors.New("invalid chunk size")
+		}
+		_, err = io.ReadFull(r, buf[:n])
+		if err != nil {
+			return fmt.Errorf("truncated object: %w", err)
+		}
+		plain, err := gcm.Open(nil, chunkNonce(prefix, i), buf[:n], lastData(false))
+		last := false
+		if err != nil {
+			plain, err = gcm.Open(nil, chunkNonce(prefix, i), buf[:n], lastData(true))
+			last = true
+		}
+		if err != nil {
+			return err
+		}
+		_, err = w.Write(plain)
+		if err != nil || last {
+			return err
+		}
+	}
+}
+
+func lastData(last bool) []byte {
+	if last {
+		return []byte{1}
+	}
+	return []byte{0}
+}
@@ -0,0 +1,91 @@
+package s3
+
+import (
+	"bytes"
+	"crypto/rand"
+	"testing"
+)
+
+func testKey(t *testing.T) []byte {
+	key := make([]byte, 32)
+	_, err := rand.Read(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
+
+func TestEncryptRoundTrip(t *testing.T) {
+	key := testKey(t)
+	for _, size := range []int{0, 1, chunkSize - 1, chunkSize, chunkSize + 1, 3 * chunkSize, 3*chunkSize + 17} {
+		plain := make([]byte, size)
+		rand.Read(plain)
+		var sealed, opened bytes.Buffer
+		err := Encrypt(&sealed, bytes.NewReader(plain), key)
+		if err != nil {
+			t.Fatalf("size %d: Encrypt() = %v", size, err)
+		}
+		err = Decrypt(&opened, bytes.NewReader(sealed.Bytes()), key)
+		if err != nil {
+			t.Fatalf("size %d: Decrypt() = %v", size, err)
+		}
+		if !bytes.Equal(opened.Bytes(), plain) {
+			t.Errorf("size %d: round trip changed the data", size)
+		}
+	}
+}
+
+func TestDecryptRejects(t *testing.T) {
+	key := testKey(t)
+	plain := make([]byte, 2*chunkSize+5)
+	rand.Read(plain)
+	var sealed bytes.Buffer
+	err := Encrypt(&sealed, bytes.NewReader(plain), key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b := sealed.Bytes()
+	header := len(encryptionMagic) + 8
+	// The first chunk is chunkSize bytes sealed with a 16 byte tag.
+	firstChunk := 4 + chunkSize + 16
+	flipped := append([]byte(nil), b...)
+	flipped[header+10] ^= 1
+	tests := []struct {
+		name string
+		data []byte
+		key  []byte
+	}{
+		{"wrong key", b, testKey(t)},
+		{"not encrypted", []byte("plain text object"), key},
+		{"truncated in a chunk", b[:len(b)-3], key},
+		{"truncated at a chunk boundary", b[:header+firstChunk], key},
+		{"modified", flipped, key},
+		{"empty", nil, key},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var opened bytes.Buffer
+			err := Decrypt(&opened, bytes.NewReader(test.data), test.key)
+			if err == nil {
+				t.Errorf("Decrypt() succeeded, want an error")
+			}
+		})
+	}
+}
+
+func TestParseKey(t *testing.T) {
+	tests := []struct {
+		key string
+		ok  bool
+	}{
+		{"AAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAA=", true},
+		{"AAAA", false},
+		{"not base64!", false},
+	}
+	for _, test := range tests {
+		_, err := ParseKey(test.key)
+		if (err == nil) != test.ok {
+			t.Errorf("ParseKey(%q) = %v, want ok %v", test.key, err, test.ok)
+		}
+	}
+}
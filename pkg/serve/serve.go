@@ -0,0 +1,165 @@
+// Package serve serves mirrors read-only over git's smart HTTP protocol,
+// optionally as a read-through cache that mirrors repos on demand.
+package serve
+
+import (
+	"crypto/subtle"
+	"log/slog"
+	"net/http"
+	"net/http/cgi"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/chamzzzzzz/github-repo-mirror/pkg/gitmirror"
+	"github.com/chamzzzzzz/github-repo-mirror/pkg/report"
+)
+
+// Handler serves the mirror of owner/repo at /owner/repo.git with git
+// http-backend, so it can be cloned and fetched but not pushed to. If
+// Username is set, requests need basic auth.
+type Handler struct {
+	// Mirrorer returns the mirrorer to serve with, which a config reload
+	// may replace.
+	Mirrorer func() *gitmirror.Mirrorer
+	Username string
+	Password string
+	// GitPath is the git binary, looked up in PATH if empty.
+	GitPath string
+	// GitArgs are put before the http-backend subcommand, e.g. -c flags.
+	GitArgs []string
+	// Proxy mirrors repos on their first request, and updates mirrors last
+	// fetched more than TTL ago when a clone or fetch starts.
+	Proxy bool
+	TTL   time.Duration
+
+	locks sync.Map
+}
+
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if h.Username != "" && !h.authorized(r) {
+		w.Header().Set("WWW-Authenticate", `Basic realm="github-repo-mirror"`)
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+	if r.Method != http.MethodGet && r.Method != http.MethodHead && r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if strings.HasSuffix(r.URL.Path, "/git-receive-pack") || r.URL.Query().Get("service") == "git-receive-pack" {
+		http.Error(w, "mirrors are read-only", http.StatusForbidden)
+		return
+	}
+	fullName, rest, ok := splitPath(r.URL.Path)
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	var local string
+	if h.Proxy {
+		// Every clone and fetch starts with a ref advertisement.
+		local, ok = h.proxy(fullName, rest == "/info/refs")
+	} else {
+		local, ok = h.Mirrorer().FindLocal(fullName)
+	}
+	if !ok {
+		http.Error(w, "repo not mirrored", http.StatusNotFound)
+		return
+	}
+	var err error
+	path := h.GitPath
+	if path == "" {
+		path, err = exec.LookPath("git")
+		if err != nil {
+			slog.Error("Failed to find git", "error", err)
+			http.Error(w, "internal server error", http.StatusInternalServerError)
+			return
+		}
+	}
+	// http-backend resolves PATH_INFO against GIT_PROJECT_ROOT.
+	r = r.Clone(r.Context())
+	r.URL.Path = "/" + filepath.Base(local) + rest
+	handler := &cgi.Handler{
+		Path: path,
+		Args: append(append([]string{}, h.GitArgs...), "http-backend"),
+		Env: []string{
+			"GIT_PROJECT_ROOT=" + filepath.Dir(local),
+			"GIT_HTTP_EXPORT_ALL=1",
+		},
+	}
+	handler.ServeHTTP(w, r)
+}
+
+// proxy returns the mirror of fullName, mirroring it first if it does not
+// exist yet. If refresh is set, a mirror older than TTL is updated; if the
+// update fails, the stale mirror is served. Only repos a source includes
+// are synced, and private ones only if requests need auth.
+func (h *Handler) proxy(fullName string, refresh bool) (string, bool) {
+	lock, _ := h.locks.LoadOrStore(fullName, &sync.Mutex{})
+	lock.(*sync.Mutex).Lock()
+	defer lock.(*sync.Mutex).Unlock()
+
+	mirrorer := h.Mirrorer()
+	logger := mirrorer.Logger.With("repo", fullName, "operation", "proxy")
+	local, ok := mirrorer.FindLocal(fullName)
+	if ok {
+		if !refresh {
+			return local, true
+		}
+		last, err := gitmirror.LastFetch(local)
+		if err == nil && time.Since(last) < h.TTL {
+			return local, true
+		}
+		logger.Info("Found stale mirror", "local", local, "last", last)
+	}
+	source, repo, err := mirrorer.Lookup(fullName)
+	if err != nil {
+		logger.Warn("Failed to look up repo", "error", err)
+		return local, ok
+	}
+	// Anyone who reaches the server could read it with the source's token.
+	if repo.Private && h.Username == "" {
+		logger.Warn("Refused to sync private repo on demand without serve credentials")
+		return local, ok
+	}
+	stat, err := mirrorer.Update(source, repo)
+	if err != nil {
+		logger.Warn("Failed to sync on demand", "error", err)
+	}
+	if stat == nil {
+		return local, ok
+	}
+	result := stat.Results[0]
+	switch result.Outcome {
+	case report.OutcomeMirrored, report.OutcomeUpdated, report.OutcomeUnchanged:
+		return result.Local, true
+	}
+	logger.Warn("Did not sync on demand", "outcome", result.Outcome, "reason", result.Reason, "error", result.Error)
+	return local, ok
+}
+
+func (h *Handler) authorized(r *http.Request) bool {
+	username, password, ok := r.BasicAuth()
+	if !ok {
+		return false
+	}
+	userOK := subtle.ConstantTimeCompare([]byte(username), []byte(h.Username)) == 1
+	passwordOK := subtle.ConstantTimeCompare([]byte(password), []byte(h.Password)) == 1
+	return userOK && passwordOK
+}
+
+// splitPath splits a request path like /owner/repo.git/info/refs into the
+// repo's full name and the path within the repo.
+func splitPath(path string) (fullName, rest string, ok bool) {
+	parts := strings.SplitN(strings.TrimPrefix(path, "/"), "/", 3)
+	if len(parts) < 3 {
+		return "", "", false
+	}
+	owner, name := parts[0], strings.TrimSuffix(parts[1], ".git")
+	if owner == "" || name == "" || strings.HasPrefix(owner, ".") || strings.HasPrefix(name, ".") {
+		return "", "", false
+	}
+	return owner + "/" + name, "/" + parts[2], true
+}
@@ -0,0 +1,134 @@
+package serve
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/chamzzzzzz/github-repo-mirror/pkg/config"
+	"github.com/chamzzzzzz/github-repo-mirror/pkg/github"
+	"github.com/chamzzzzzz/github-repo-mirror/pkg/gitmirror"
+)
+
+// fakeAPI answers GitHub's single repo API from its repos, by full name.
+type fakeAPI map[string]*github.Repo
+
+func (a fakeAPI) RoundTrip(req *http.Request) (*http.Response, error) {
+	resp := &http.Response{
+		StatusCode: http.StatusNotFound,
+		Header:     make(http.Header),
+		Body:       io.NopCloser(strings.NewReader(`{"message": "Not Found"}`)),
+		Request:    req,
+	}
+	if repo, ok := a[strings.TrimPrefix(req.URL.Path, "/repos/")]; ok {
+		b, err := json.Marshal(repo)
+		if err != nil {
+			return nil, err
+		}
+		resp.StatusCode = http.StatusOK
+		resp.Body = io.NopCloser(bytes.NewReader(b))
+	}
+	return resp, nil
+}
+
+// upstream creates bare repos with one commit under dir, as
+// dir/owner/name.git, and returns a fake API listing them.
+func upstream(t *testing.T, dir string, private map[string]bool) fakeAPI {
+	api := make(fakeAPI)
+	for fullName, private := range private {
+		bare := filepath.Join(dir, fullName+".git")
+		for _, args := range [][]string{
+			{"init", "-q", "--bare", bare},
+			{"-C", bare, "-c", "user.name=test", "-c", "user.email=test@example.com", "commit-tree", "-m", "commit", "4b825dc642cb6eb9a060e54bf8d69288fbee4904"},
+		} {
+			out, err := exec.Command("git", args...).CombinedOutput()
+			if err != nil {
+				t.Fatalf("git %s: %s: %s", strings.Join(args, " "), err, out)
+			}
+			if args[0] == "-C" {
+				out, err = exec.Command("git", "-C", bare, "update-ref", "refs/heads/main", strings.TrimSpace(string(out))).CombinedOutput()
+				if err != nil {
+					t.Fatalf("git update-ref: %s: %s", err, out)
+				}
+			}
+		}
+		owner, name, _ := strings.Cut(fullName, "/")
+		repo := &github.Repo{Name: name, FullName: fullName, Private: private, DefaultBranch: "main", PushedAt: time.Now()}
+		repo.Owner.Login = owner
+		api[fullName] = repo
+	}
+	return api
+}
+
+func TestProxy(t *testing.T) {
+	// The repos are created without the user's git config.
+	t.Setenv("GIT_CONFIG_GLOBAL", os.DevNull)
+	t.Setenv("GIT_CONFIG_NOSYSTEM", "1")
+	dir := t.TempDir()
+	api := upstream(t, filepath.Join(dir, "upstream"), map[string]bool{
+		"alice/public":   false,
+		"alice/secret":   true,
+		"alice/excluded": false,
+	})
+	tests := []struct {
+		name     string
+		repo     string
+		username string
+		status   int
+	}{
+		{"public", "alice/public", "", http.StatusOK},
+		{"private without auth", "alice/secret", "", http.StatusNotFound},
+		{"private with auth", "alice/secret", "reader", http.StatusOK},
+		{"excluded", "alice/excluded", "reader", http.StatusNotFound},
+		{"unknown", "alice/missing", "", http.StatusNotFound},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			c := &config.Config{
+				Destination: filepath.Join(t.TempDir(), "mirrors"),
+				Sources:     []*config.Source{{Username: "alice", Token: "token", Exclude: []string{"alice/excluded"}}},
+				Rewrites:    []*config.Rewrite{{From: "https://github.com/", To: "file://" + filepath.Join(dir, "upstream") + "/"}},
+			}
+			m, err := gitmirror.New(c)
+			if err != nil {
+				t.Fatal(err)
+			}
+			m.Logger = slog.New(slog.NewTextHandler(io.Discard, nil))
+			m.Client.HTTP = &http.Client{Transport: api}
+			h := &Handler{Mirrorer: func() *gitmirror.Mirrorer { return m }, Username: test.username, Password: "password", Proxy: true, TTL: time.Minute}
+			req := httptest.NewRequest(http.MethodGet, "/"+test.repo+".git/info/refs?service=git-upload-pack", nil)
+			if test.username != "" {
+				req.SetBasicAuth(test.username, "password")
+			}
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, req)
+			if w.Code != test.status {
+				t.Errorf("ServeHTTP() = %d, want %d: %s", w.Code, test.status, w.Body)
+			}
+			_, mirrored := m.FindLocal(test.repo)
+			if mirrored != (test.status == http.StatusOK) {
+				t.Errorf("mirrored = %v, want %v", mirrored, !mirrored)
+			}
+		})
+	}
+}
+
+func TestProxyNeedsAuth(t *testing.T) {
+	h := &Handler{Username: "reader", Password: "password", Proxy: true}
+	req := httptest.NewRequest(http.MethodGet, "/alice/secret.git/info/refs?service=git-upload-pack", nil)
+	req.SetBasicAuth("reader", "wrong")
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("ServeHTTP() = %d, want %d", w.Code, http.StatusUnauthorized)
+	}
+}
@@ -0,0 +1,109 @@
+package state
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// Carryover is a journal of the repos of the current run, next to the state
+// file. Each repo is marked done once attempted, so the repos a run did not
+// get to, because it hit its deadline, crashed or was killed, can be synced
+// first by the next run.
+type Carryover struct {
+	path string
+	mu   sync.Mutex
+	f    *os.File
+}
+
+// Carryover returns the carryover journal of the store.
+func (s *Store) Carryover() *Carryover {
+	return &Carryover{
+		path: s.path + ".carryover",
+	}
+}
+
+// Pending returns the repos the last run did not mark done.
+func (c *Carryover) Pending() (map[string]bool, error) {
+	pending := make(map[string]bool)
+	f, err := os.Open(c.path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return pending, nil
+		}
+		return nil, err
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if name, ok := strings.CutPrefix(line, "+"); ok {
+			pending[name] = true
+		} else if name, ok := strings.CutPrefix(line, "-"); ok {
+			delete(pending, name)
+		}
+	}
+	return pending, scanner.Err()
+}
+
+// Begin starts the journal of a run of repos, replacing the last run's.
+func (c *Carryover) Begin(repos []string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.f != nil {
+		c.f.Close()
+	}
+	err := os.MkdirAll(filepath.Dir(c.path), 0755)
+	if err != nil {
+		return err
+	}
+	f, err := os.OpenFile(c.path, os.O_CREATE|os.O_TRUNC|os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriter(f)
+	for _, repo := range repos {
+		fmt.Fprintf(w, "+%s\n", repo)
+	}
+	err = w.Flush()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	c.f = f
+	return nil
+}
+
+// Done marks a repo of the run as attempted. A nil Carryover records
+// nothing.
+func (c *Carryover) Done(repo string) error {
+	if c == nil {
+		return nil
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.f == nil {
+		return nil
+	}
+	_, err := fmt.Fprintf(c.f, "-%s\n", repo)
+	return err
+}
+
+// End closes the journal, keeping the repos not marked done for the next
+// run.
+func (c *Carryover) End() error {
+	if c == nil {
+		return nil
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.f == nil {
+		return nil
+	}
+	err := c.f.Close()
+	c.f = nil
+	return err
+}
@@ -0,0 +1,654 @@
+// Package state persists run history and the latest state of each repo and
+// source across runs.
+package state
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/chamzzzzzz/github-repo-mirror/pkg/config"
+	"github.com/chamzzzzzz/github-repo-mirror/pkg/filelock"
+	"github.com/chamzzzzzz/github-repo-mirror/pkg/report"
+)
+
+// Store is a JSON file holding the history of runs. Recent runs are kept
+// individually and aggregated into daily and weekly rollups, each pruned
+// after its own retention.
+//
+// Other processes, e.g. add next to a daemon, may save the same file, so a
+// store tracks what it changed since it was loaded and Save merges only that
+// into the file as it is then.
+type Store struct {
+	path string
+	mu   sync.Mutex
+	data data
+	// repos and sources are the names of the entries changed, runs and
+	// digest whether the run history and the digest changed.
+	repos   map[string]bool
+	sources map[string]bool
+	runs    bool
+	digest  bool
+}
+
+type data struct {
+	Runs    []*Run             `json:"runs"`
+	Daily   []*Rollup          `json:"daily"`
+	Weekly  []*Rollup          `json:"weekly"`
+	Repos   map[string]*Repo   `json:"repos"`
+	Sources map[string]*Source `json:"sources"`
+	// Digest collects the runs not yet sent in a summary digest.
+	Digest *report.Summary `json:"digest,omitempty"`
+}
+
+// Repo is the latest state of a repo.
+type Repo struct {
+	Repo          string         `json:"repo"`
+	Source        string         `json:"source"`
+	Local         string         `json:"local"`
+	Outcome       report.Outcome `json:"outcome"`
+	LastRun       time.Time      `json:"last_run"`
+	LastSuccess   time.Time      `json:"last_success"`
+	LastError     string         `json:"last_error,omitempty"`
+	LastErrorTime time.Time      `json:"last_error_time"`
+	Bytes         int64          `json:"bytes"`
+	Duration      time.Duration  `json:"duration"`
+	// ConsecutiveFailures counts the failed attempts since the last success.
+	ConsecutiveFailures int `json:"consecutive_failures"`
+	// PushedAt is the upstream push time as of the last success.
+	PushedAt time.Time `json:"pushed_at"`
+	// History holds the latest attempts, oldest first.
+	History []*Attempt `json:"history,omitempty"`
+}
+
+// Attempt is the outcome of a repo in a single run.
+type Attempt struct {
+	Time     time.Time      `json:"time"`
+	Outcome  report.Outcome `json:"outcome"`
+	Duration time.Duration  `json:"duration"`
+	Bytes    int64          `json:"bytes,omitempty"`
+	Error    string         `json:"error,omitempty"`
+}
+
+// Source is the latest state of a source.
+type Source struct {
+	Name    string    `json:"name"`
+	LastRun time.Time `json:"last_run"`
+	Error   string    `json:"error,omitempty"`
+	// Discovered lists the repos of the latest live listing, by full name.
+	Discovered []string `json:"discovered,omitempty"`
+	Counts
+}
+
+// Run is the summary of a single run.
+type Run struct {
+	Time     time.Time     `json:"time"`
+	Duration time.Duration `json:"duration"`
+	Counts
+}
+
+// Rollup aggregates the runs of a day or an ISO week.
+type Rollup struct {
+	Period   string        `json:"period"`
+	Start    time.Time     `json:"start"`
+	Runs     int           `json:"runs"`
+	Duration time.Duration `json:"duration"`
+	Counts
+}
+
+type Counts struct {
+	Repos        int   `json:"repos"`
+	Skipped      int   `json:"skipped"`
+	Mirrored     int   `json:"mirrored"`
+	Updated      int   `json:"updated"`
+	Failed       int   `json:"failed"`
+	FailedMirror int   `json:"failed_mirror"`
+	FailedUpdate int   `json:"failed_update"`
+	Archived     int   `json:"archived"`
+	Unchanged    int   `json:"unchanged"`
+	Quarantined  int   `json:"quarantined"`
+	Sources      int   `json:"sources"`
+	FailedSource int   `json:"failed_source"`
+	Bytes        int64 `json:"bytes"`
+}
+
+func (c *Counts) add(o *Counts) {
+	c.Repos += o.Repos
+	c.Skipped += o.Skipped
+	c.Mirrored += o.Mirrored
+	c.Updated += o.Updated
+	c.Failed += o.Failed
+	c.FailedMirror += o.FailedMirror
+	c.FailedUpdate += o.FailedUpdate
+	c.Archived += o.Archived
+	c.Unchanged += o.Unchanged
+	c.Quarantined += o.Quarantined
+	c.Sources += o.Sources
+	c.FailedSource += o.FailedSource
+	c.Bytes += o.Bytes
+}
+
+// Path returns the state file path of the config.
+func Path(config *config.Config) string {
+	if config.State.Path != "" {
+		return config.State.Path
+	}
+	return filepath.Join(config.Destination, "state.json")
+}
+
+// Open loads the store at path, which need not exist yet.
+func Open(path string) (*Store, error) {
+	s := &Store{
+		path:    path,
+		repos:   make(map[string]bool),
+		sources: make(map[string]bool),
+	}
+	d, err := load(path)
+	if err != nil {
+		return nil, err
+	}
+	s.data = *d
+	return s, nil
+}
+
+// load reads the data at path, empty if the file does not exist.
+func load(path string) (*data, error) {
+	d := &data{}
+	b, err := os.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return d, nil
+		}
+		return nil, err
+	}
+	err = json.Unmarshal(b, d)
+	if err != nil {
+		return nil, err
+	}
+	return d, nil
+}
+
+// Save atomically writes the changes of the store to its file. Under a lock
+// on the file, it reloads the file and replaces only the repos, sources, run
+// history and digest the store changed, keeping what other processes saved
+// meanwhile, then continues from the merged state.
+func (s *Store) Save() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	err := os.MkdirAll(filepath.Dir(s.path), 0755)
+	if err != nil {
+		return err
+	}
+	lock, err := filelock.Acquire(s.path + ".lock")
+	if err != nil {
+		return err
+	}
+	defer lock.Release()
+	d, err := load(s.path)
+	if err != nil {
+		return err
+	}
+	if d.Repos == nil {
+		d.Repos = make(map[string]*Repo)
+	}
+	if d.Sources == nil {
+		d.Sources = make(map[string]*Source)
+	}
+	for name := range s.repos {
+		d.Repos[name] = s.data.Repos[name]
+	}
+	for name := range s.sources {
+		d.Sources[name] = s.data.Sources[name]
+	}
+	if s.runs {
+		d.Runs = s.data.Runs
+		d.Daily = s.data.Daily
+		d.Weekly = s.data.Weekly
+	}
+	if s.digest {
+		d.Digest = s.data.Digest
+	}
+	b, err := json.MarshalIndent(d, "", "  ")
+	if err != nil {
+		return err
+	}
+	err = os.WriteFile(s.path+".tmp", b, 0600)
+	if err != nil {
+		return err
+	}
+	err = os.Rename(s.path+".tmp", s.path)
+	if err != nil {
+		return err
+	}
+	s.data = *d
+	s.repos = make(map[string]bool)
+	s.sources = make(map[string]bool)
+	s.runs = false
+	s.digest = false
+	return nil
+}
+
+// RecordRun adds a run to the history and its rollups, then prunes entries
+// older than their retention. Partial stats only update their repos; if all
+// stats are partial, no run is recorded.
+func (s *Store) RecordRun(start time.Time, stats []*report.Stat, retention config.State) error {
+	run := &Run{
+		Time:     start,
+		Duration: time.Since(start),
+	}
+	partial := true
+	for _, stat := range stats {
+		if stat.Partial {
+			continue
+		}
+		partial = false
+		run.Sources++
+		if stat.Error != "" {
+			run.FailedSource++
+		}
+		run.Repos += len(stat.Repos)
+		run.Skipped += stat.Skipped
+		run.Mirrored += stat.Mirrored
+		run.Updated += stat.Updated
+		run.Failed += stat.Failed
+		run.FailedMirror += stat.FailedMirror
+		run.FailedUpdate += stat.FailedUpdate
+		run.Archived += stat.Archived
+		run.Unchanged += stat.Unchanged
+		run.Quarantined += stat.Quarantined
+		for _, result := range stat.Results {
+			run.Bytes += result.Bytes
+		}
+	}
+
+	runRetention, err := parseRetention(retention.RunRetention, 30*24*time.Hour)
+	if err != nil {
+		return err
+	}
+	dailyRetention, err := parseRetention(retention.DailyRetention, 365*24*time.Hour)
+	if err != nil {
+		return err
+	}
+	weeklyRetention, err := parseRetention(retention.WeeklyRetention, 0)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	history := retention.RepoHistory
+	if history <= 0 {
+		history = 10
+	}
+	s.recordRepos(start, stats, history)
+	if partial {
+		return nil
+	}
+	s.runs = true
+	s.data.Runs = append(s.data.Runs, run)
+	day := time.Date(start.Year(), start.Month(), start.Day(), 0, 0, 0, 0, time.UTC)
+	s.data.Daily = rollup(s.data.Daily, day.Format("2006-01-02"), day, run)
+	year, week := start.ISOWeek()
+	monday := day.AddDate(0, 0, -(int(day.Weekday())+6)%7)
+	s.data.Weekly = rollup(s.data.Weekly, fmtWeek(year, week), monday, run)
+
+	now := time.Now()
+	s.data.Runs = prune(s.data.Runs, func(r *Run) time.Time { return r.Time }, now, runRetention)
+	s.data.Daily = prune(s.data.Daily, func(r *Rollup) time.Time { return r.Start }, now, dailyRetention)
+	s.data.Weekly = prune(s.data.Weekly, func(r *Rollup) time.Time { return r.Start }, now, weeklyRetention)
+	return nil
+}
+
+// recordRepos updates the state of the sources and repos in stats, keeping
+// the latest history attempts of each repo. Partial stats leave the state of
+// their source as it was.
+func (s *Store) recordRepos(start time.Time, stats []*report.Stat, history int) {
+	if s.data.Repos == nil {
+		s.data.Repos = make(map[string]*Repo)
+	}
+	if s.data.Sources == nil {
+		s.data.Sources = make(map[string]*Source)
+	}
+	for _, stat := range stats {
+		source := &Source{
+			Name:    stat.Name,
+			LastRun: start,
+			Error:   stat.Error,
+			Counts: Counts{
+				Repos:        len(stat.Repos),
+				Skipped:      stat.Skipped,
+				Mirrored:     stat.Mirrored,
+				Updated:      stat.Updated,
+				Failed:       stat.Failed,
+				FailedMirror: stat.FailedMirror,
+				FailedUpdate: stat.FailedUpdate,
+				Archived:     stat.Archived,
+				Unchanged:    stat.Unchanged,
+				Quarantined:  stat.Quarantined,
+			},
+		}
+		if listed(stat) {
+			source.Discovered = discovered(stat)
+		} else if previous, ok := s.data.Sources[stat.Name]; ok {
+			source.Discovered = previous.Discovered
+		}
+		if stat.Partial {
+			source = nil
+		}
+		pushedAt := make(map[string]time.Time)
+		for _, repo := range stat.Repos {
+			pushedAt[repo.FullName] = repo.PushedAt
+		}
+		for _, result := range stat.Results {
+			if source != nil {
+				source.Bytes += result.Bytes
+			}
+			// A quarantined repo keeps the state of its last attempt.
+			if result.Outcome == report.OutcomeSkipped || result.Outcome == report.OutcomeQuarantined {
+				continue
+			}
+			repo, ok := s.data.Repos[result.Repo]
+			if !ok {
+				repo = &Repo{
+					Repo: result.Repo,
+				}
+				s.data.Repos[result.Repo] = repo
+			}
+			s.repos[result.Repo] = true
+			repo.Source = stat.Name
+			repo.Local = result.Local
+			repo.Outcome = result.Outcome
+			repo.LastRun = start
+			repo.Duration = result.Duration
+			repo.History = append(repo.History, &Attempt{
+				Time:     start,
+				Outcome:  result.Outcome,
+				Duration: result.Duration,
+				Bytes:    result.Bytes,
+				Error:    result.Error,
+			})
+			if len(repo.History) > history {
+				repo.History = repo.History[len(repo.History)-history:]
+			}
+			switch {
+			case result.Outcome.Failed():
+				repo.LastError = result.Error
+				repo.LastErrorTime = start
+				repo.ConsecutiveFailures++
+			case result.Outcome == report.OutcomeMirrored || result.Outcome == report.OutcomeUpdated:
+				repo.LastSuccess = start
+				repo.Bytes = result.Bytes
+				repo.PushedAt = pushedAt[result.Repo]
+				repo.ConsecutiveFailures = 0
+			case result.Outcome == report.OutcomeUnchanged:
+				// The mirror is known to be current without a fetch.
+				repo.LastSuccess = start
+			}
+		}
+		if source != nil {
+			s.data.Sources[stat.Name] = source
+			s.sources[stat.Name] = true
+		}
+	}
+}
+
+// listed reports whether all repos of stat were listed live from upstream.
+func listed(stat *report.Stat) bool {
+	return stat.Error == "" && stat.CachedAt == nil && !stat.Partial
+}
+
+// discovered returns the sorted full names of the repos of stat.
+func discovered(stat *report.Stat) []string {
+	names := make([]string, 0, len(stat.Repos))
+	for _, repo := range stat.Repos {
+		names = append(names, repo.FullName)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Diff returns what changed in stats since the previous run recorded in the
+// store. Call it before RecordRun records stats.
+func (s *Store) Diff(stats []*report.Stat) *report.Diff {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	diff := &report.Diff{}
+	for _, stat := range stats {
+		if previous, ok := s.data.Sources[stat.Name]; ok && previous.Discovered != nil && listed(stat) {
+			before := make(map[string]bool)
+			for _, name := range previous.Discovered {
+				before[name] = true
+			}
+			for _, name := range discovered(stat) {
+				if !before[name] {
+					diff.New = append(diff.New, name)
+				}
+				delete(before, name)
+			}
+			for _, name := range previous.Discovered {
+				if before[name] {
+					diff.Gone = append(diff.Gone, name)
+				}
+			}
+		}
+		for _, result := range stat.Results {
+			repo, ok := s.data.Repos[result.Repo]
+			failed := ok && repo.ConsecutiveFailures > 0
+			switch result.Outcome {
+			case report.OutcomeFailed, report.OutcomeFailedMirror, report.OutcomeFailedUpdate:
+				if !failed {
+					diff.Failing = append(diff.Failing, result.Repo)
+				}
+			case report.OutcomeMirrored, report.OutcomeUpdated, report.OutcomeUnchanged, report.OutcomeArchived:
+				// Deferred, skipped and quarantined repos were not
+				// attempted and keep their failure state.
+				if failed {
+					diff.Recovered = append(diff.Recovered, result.Repo)
+				}
+			}
+			if result.Outcome == report.OutcomeMirrored || result.Outcome == report.OutcomeUpdated {
+				diff.SizeDelta += result.Bytes
+				if ok {
+					diff.SizeDelta -= repo.Bytes
+				}
+			}
+		}
+	}
+	sort.Strings(diff.New)
+	sort.Strings(diff.Gone)
+	sort.Strings(diff.Failing)
+	sort.Strings(diff.Recovered)
+	return diff
+}
+
+// Repos returns the latest state of every repo that was not skipped, by
+// repo name.
+func (s *Store) Repos() []*Repo {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	repos := make([]*Repo, 0, len(s.data.Repos))
+	for _, repo := range s.data.Repos {
+		repos = append(repos, repo.copy())
+	}
+	sort.Slice(repos, func(i, j int) bool { return repos[i].Repo < repos[j].Repo })
+	return repos
+}
+
+// Adopt records the bare repo at local, adopted as the mirror of repo, unless
+// the repo already has a state at that path.
+func (s *Store) Adopt(repo, source, local string, bytes int64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.data.Repos == nil {
+		s.data.Repos = make(map[string]*Repo)
+	}
+	if r, ok := s.data.Repos[repo]; ok && r.Local == local {
+		return
+	}
+	s.repos[repo] = true
+	s.data.Repos[repo] = &Repo{
+		Repo:    repo,
+		Source:  source,
+		Local:   local,
+		Outcome: report.OutcomeAdopted,
+		LastRun: time.Now(),
+		Bytes:   bytes,
+	}
+}
+
+// Repo returns the latest state of the repo with full name name.
+func (s *Store) Repo(name string) (*Repo, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	repo, ok := s.data.Repos[name]
+	if !ok {
+		return nil, false
+	}
+	return repo.copy(), true
+}
+
+// Stale reports whether the repo has not synced successfully for longer
+// than maxAge at now, and whether it only became stale since its previous
+// attempt. A repo that never succeeded counts from its oldest recorded
+// attempt.
+func (r *Repo) Stale(maxAge time.Duration, now time.Time) (stale, newly bool) {
+	since := r.LastSuccess
+	if since.IsZero() {
+		since = r.LastRun
+		if len(r.History) > 0 {
+			since = r.History[0].Time
+		}
+	}
+	if now.Sub(since) <= maxAge {
+		return false, false
+	}
+	if len(r.History) < 2 {
+		return true, true
+	}
+	previous := r.History[len(r.History)-2].Time
+	return true, previous.Sub(since) <= maxAge
+}
+
+// MarkStale lists the repos of stats that are stale at now in their
+// stat's Stale.
+func (s *Store) MarkStale(stats []*report.Stat, maxAge time.Duration, now time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, stat := range stats {
+		stat.Stale = nil
+		for _, result := range stat.Results {
+			repo, ok := s.data.Repos[result.Repo]
+			if !ok {
+				continue
+			}
+			if stale, _ := repo.Stale(maxAge, now); stale {
+				stat.Stale = append(stat.Stale, result.Repo)
+			}
+		}
+	}
+}
+
+func (r *Repo) copy() *Repo {
+	c := *r
+	c.History = append([]*Attempt(nil), r.History...)
+	return &c
+}
+
+// Sources returns the latest state of every source, by name.
+func (s *Store) Sources() []*Source {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	sources := make([]*Source, 0, len(s.data.Sources))
+	for _, source := range s.data.Sources {
+		src := *source
+		sources = append(sources, &src)
+	}
+	sort.Slice(sources, func(i, j int) bool { return sources[i].Name < sources[j].Name })
+	return sources
+}
+
+// Digest returns the runs collected for the next summary digest, nil if
+// there are none.
+func (s *Store) Digest() *report.Summary {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.data.Digest
+}
+
+// SetDigest replaces the runs collected for the next summary digest.
+func (s *Store) SetDigest(digest *report.Summary) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.data.Digest = digest
+	s.digest = true
+}
+
+// Runs returns the individually kept runs, oldest first.
+func (s *Store) Runs() []*Run {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]*Run(nil), s.data.Runs...)
+}
+
+// Daily returns the daily rollups, oldest first.
+func (s *Store) Daily() []*Rollup {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]*Rollup(nil), s.data.Daily...)
+}
+
+// Weekly returns the weekly rollups, oldest first.
+func (s *Store) Weekly() []*Rollup {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]*Rollup(nil), s.data.Weekly...)
+}
+
+func rollup(rollups []*Rollup, period string, start time.Time, run *Run) []*Rollup {
+	for _, r := range rollups {
+		if r.Period == period {
+			r.Runs++
+			r.Duration += run.Duration
+			r.Counts.add(&run.Counts)
+			return rollups
+		}
+	}
+	r := &Rollup{
+		Period:   period,
+		Start:    start,
+		Runs:     1,
+		Duration: run.Duration,
+		Counts:   run.Counts,
+	}
+	rollups = append(rollups, r)
+	sort.Slice(rollups, func(i, j int) bool { return rollups[i].Start.Before(rollups[j].Start) })
+	return rollups
+}
+
+func prune[T any](entries []T, at func(T) time.Time, now time.Time, retention time.Duration) []T {
+	if retention <= 0 {
+		return entries
+	}
+	kept := entries[:0]
+	for _, e := range entries {
+		if now.Sub(at(e)) <= retention {
+			kept = append(kept, e)
+		}
+	}
+	return kept
+}
+
+func parseRetention(s string, def time.Duration) (time.Duration, error) {
+	if s == "" {
+		return def, nil
+	}
+	return time.ParseDuration(s)
+}
+
+func fmtWeek(year, week int) string {
+	return fmt.Sprintf("%d-W%02d", year, week)
+}
@@ -0,0 +1,132 @@
+package state
+
+import (
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/chamzzzzzz/github-repo-mirror/pkg/config"
+	"github.com/chamzzzzzz/github-repo-mirror/pkg/github"
+	"github.com/chamzzzzzz/github-repo-mirror/pkg/report"
+)
+
+// stat returns a stat of source listing repos, with a result of outcome and
+// bytes for each.
+func stat(source string, outcome report.Outcome, bytes int64, repos ...string) *report.Stat {
+	s := &report.Stat{Name: source}
+	for _, repo := range repos {
+		s.Repos = append(s.Repos, &github.Repo{FullName: repo})
+		s.Results = append(s.Results, &report.Result{Repo: repo, Outcome: outcome, Bytes: bytes})
+	}
+	return s
+}
+
+func TestDiff(t *testing.T) {
+	cachedAt := time.Now()
+	tests := []struct {
+		name  string
+		stats []*report.Stat
+		want  *report.Diff
+	}{
+		// alice/broken failed before and has no size recorded.
+		{
+			"unchanged",
+			[]*report.Stat{stat("alice", report.OutcomeUpdated, 10, "alice/a", "alice/b", "alice/broken")},
+			&report.Diff{Recovered: []string{"alice/broken"}, SizeDelta: 10},
+		},
+		{
+			"new and gone",
+			[]*report.Stat{stat("alice", report.OutcomeUpdated, 10, "alice/a", "alice/c", "alice/broken")},
+			&report.Diff{New: []string{"alice/c"}, Gone: []string{"alice/b"}, Recovered: []string{"alice/broken"}, SizeDelta: 20},
+		},
+		{
+			"failing",
+			[]*report.Stat{stat("alice", report.OutcomeFailedUpdate, 0, "alice/a", "alice/b")},
+			&report.Diff{Gone: []string{"alice/broken"}, Failing: []string{"alice/a", "alice/b"}},
+		},
+		{
+			"still failing",
+			[]*report.Stat{stat("alice", report.OutcomeFailedUpdate, 0, "alice/a", "alice/b", "alice/broken")},
+			&report.Diff{Failing: []string{"alice/a", "alice/b"}},
+		},
+		{
+			"size",
+			[]*report.Stat{stat("alice", report.OutcomeUpdated, 15, "alice/a", "alice/b"), stat("bob", report.OutcomeMirrored, 7, "bob/x")},
+			&report.Diff{Gone: []string{"alice/broken"}, SizeDelta: 2*5 + 7},
+		},
+		{
+			"listing failed",
+			[]*report.Stat{{Name: "alice", Error: "rate limited"}},
+			&report.Diff{},
+		},
+		{
+			"listed from the cache",
+			[]*report.Stat{func() *report.Stat {
+				s := stat("alice", report.OutcomeSkipped, 0, "alice/a")
+				s.CachedAt = &cachedAt
+				return s
+			}()},
+			&report.Diff{},
+		},
+		{
+			"partial",
+			[]*report.Stat{func() *report.Stat {
+				s := stat("alice", report.OutcomeMirrored, 10, "alice/c")
+				s.Partial = true
+				return s
+			}()},
+			&report.Diff{SizeDelta: 10},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			s, err := Open(filepath.Join(t.TempDir(), "state.json"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			previous := stat("alice", report.OutcomeUpdated, 10, "alice/a", "alice/b", "alice/broken")
+			previous.Results[2].Outcome = report.OutcomeFailedUpdate
+			err = s.RecordRun(time.Now(), []*report.Stat{previous}, config.State{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			got := s.Diff(test.stats)
+			if !reflect.DeepEqual(got, test.want) {
+				t.Errorf("Diff() = %+v, want %+v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestRecordPartial(t *testing.T) {
+	s, err := Open(filepath.Join(t.TempDir(), "state.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = s.RecordRun(time.Now(), []*report.Stat{stat("alice", report.OutcomeUpdated, 10, "alice/a", "alice/b")}, config.State{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	added := stat("alice", report.OutcomeMirrored, 5, "alice/c")
+	added.Partial = true
+	err = s.RecordRun(time.Now(), []*report.Stat{added}, config.State{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if runs := s.Runs(); len(runs) != 1 {
+		t.Errorf("Runs() = %d runs, want only the full run", len(runs))
+	}
+	if _, ok := s.Repo("alice/c"); !ok {
+		t.Errorf("Repo() misses the added repo")
+	}
+	sources := s.Sources()
+	if len(sources) != 1 || sources[0].Repos != 2 || !reflect.DeepEqual(sources[0].Discovered, []string{"alice/a", "alice/b"}) {
+		t.Errorf("Sources() = %+v, want the full run's source", sources[0])
+	}
+	// The next full run reports only the added repo as new.
+	diff := s.Diff([]*report.Stat{stat("alice", report.OutcomeUpdated, 10, "alice/a", "alice/b", "alice/c")})
+	if !reflect.DeepEqual(diff.New, []string{"alice/c"}) || diff.Gone != nil {
+		t.Errorf("Diff() = %+v, want only alice/c new", diff)
+	}
+}
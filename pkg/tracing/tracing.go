@@ -0,0 +1,196 @@
+// Package tracing records spans of runs and exports them to an
+// OpenTelemetry collector with OTLP over HTTP, in its JSON encoding.
+package tracing
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/chamzzzzzz/github-repo-mirror/pkg/config"
+)
+
+// Tracer buffers ended spans until they are exported with Flush. A nil
+// Tracer records nothing.
+type Tracer struct {
+	Config *config.Tracing
+	HTTP   *http.Client
+
+	mu    sync.Mutex
+	spans []*Span
+}
+
+// Span is a timed operation. The methods of a nil Span do nothing, so
+// callers need not check whether tracing is enabled.
+type Span struct {
+	tracer  *Tracer
+	traceID [16]byte
+	spanID  [8]byte
+	parent  [8]byte
+	name    string
+	start   time.Time
+	end     time.Time
+	attrs   []any
+	err     error
+}
+
+// New returns a Tracer for config, nil if it has no Endpoint.
+func New(config *config.Tracing) *Tracer {
+	if config.Endpoint == "" {
+		return nil
+	}
+	return &Tracer{
+		Config: config,
+		HTTP:   &http.Client{Timeout: 30 * time.Second},
+	}
+}
+
+// Start starts a span named name, a child of parent or a new trace's root
+// if parent is nil, with attributes given as key value pairs.
+func (t *Tracer) Start(parent *Span, name string, attrs ...any) *Span {
+	if t == nil {
+		return nil
+	}
+	s := &Span{
+		tracer: t,
+		name:   name,
+		start:  time.Now(),
+		attrs:  attrs,
+	}
+	rand.Read(s.spanID[:])
+	if parent != nil {
+		s.traceID = parent.traceID
+		s.parent = parent.spanID
+	} else {
+		rand.Read(s.traceID[:])
+	}
+	return s
+}
+
+// Start starts a child span of s.
+func (s *Span) Start(name string, attrs ...any) *Span {
+	if s == nil {
+		return nil
+	}
+	return s.tracer.Start(s, name, attrs...)
+}
+
+// Set adds attributes given as key value pairs.
+func (s *Span) Set(attrs ...any) {
+	if s == nil {
+		return
+	}
+	s.attrs = append(s.attrs, attrs...)
+}
+
+// End ends the span, with an error status if err is not nil.
+func (s *Span) End(err error) {
+	if s == nil {
+		return
+	}
+	s.end = time.Now()
+	s.err = err
+	s.tracer.mu.Lock()
+	s.tracer.spans = append(s.tracer.spans, s)
+	s.tracer.mu.Unlock()
+}
+
+// Flush exports the ended spans.
+func (t *Tracer) Flush() error {
+	if t == nil {
+		return nil
+	}
+	t.mu.Lock()
+	spans := t.spans
+	t.spans = nil
+	t.mu.Unlock()
+	if len(spans) == 0 {
+		return nil
+	}
+	service := t.Config.ServiceName
+	if service == "" {
+		service = "github-repo-mirror"
+	}
+	var otlpSpans []map[string]any
+	for _, s := range spans {
+		span := map[string]any{
+			"traceId":           hex.EncodeToString(s.traceID[:]),
+			"spanId":            hex.EncodeToString(s.spanID[:]),
+			"name":              s.name,
+			"kind":              1,
+			"startTimeUnixNano": strconv.FormatInt(s.start.UnixNano(), 10),
+			"endTimeUnixNano":   strconv.FormatInt(s.end.UnixNano(), 10),
+			"attributes":        attributes(s.attrs),
+		}
+		if s.parent != [8]byte{} {
+			span["parentSpanId"] = hex.EncodeToString(s.parent[:])
+		}
+		if s.err != nil {
+			span["status"] = map[string]any{"code": 2, "message": s.err.Error()}
+		}
+		otlpSpans = append(otlpSpans, span)
+	}
+	body, err := json.Marshal(map[string]any{
+		"resourceSpans": []any{map[string]any{
+			"resource": map[string]any{
+				"attributes": attributes([]any{"service.name", service}),
+			},
+			"scopeSpans": []any{map[string]any{
+				"scope": map[string]any{"name": "github-repo-mirror"},
+				"spans": otlpSpans,
+			}},
+		}},
+	})
+	if err != nil {
+		return err
+	}
+	url := strings.TrimSuffix(t.Config.Endpoint, "/") + "/v1/traces"
+	req, err := http.NewRequest("POST", url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for k, v := range t.Config.Headers {
+		req.Header.Set(k, v)
+	}
+	resp, err := t.HTTP.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	return nil
+}
+
+// attributes encodes key value pairs as OTLP attributes.
+func attributes(kv []any) []map[string]any {
+	attrs := []map[string]any{}
+	for i := 0; i+1 < len(kv); i += 2 {
+		var value map[string]any
+		switch v := kv[i+1].(type) {
+		case string:
+			value = map[string]any{"stringValue": v}
+		case bool:
+			value = map[string]any{"boolValue": v}
+		case int:
+			value = map[string]any{"intValue": strconv.Itoa(v)}
+		case int64:
+			value = map[string]any{"intValue": strconv.FormatInt(v, 10)}
+		case float64:
+			value = map[string]any{"doubleValue": v}
+		default:
+			value = map[string]any{"stringValue": fmt.Sprint(v)}
+		}
+		attrs = append(attrs, map[string]any{"key": fmt.Sprint(kv[i]), "value": value})
+	}
+	return attrs
+}
@@ -0,0 +1,97 @@
+// Package webhook updates mirrors when GitHub push webhooks arrive.
+package webhook
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http"
+	"strings"
+
+	"github.com/chamzzzzzz/github-repo-mirror/pkg/gitmirror"
+)
+
+type pushEvent struct {
+	Repository struct {
+		FullName string `json:"full_name"`
+	} `json:"repository"`
+}
+
+// Handler verifies push webhooks and updates the pushed repo's mirror.
+type Handler struct {
+	Secret string
+	// Mirrorer returns the mirrorer to update with, which a config reload
+	// may replace.
+	Mirrorer func() *gitmirror.Mirrorer
+}
+
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	body, err := io.ReadAll(io.LimitReader(r.Body, 25<<20))
+	if err != nil {
+		http.Error(w, "bad request", http.StatusBadRequest)
+		return
+	}
+	if !verifySignature(h.Secret, r.Header.Get("X-Hub-Signature-256"), body) {
+		slog.Warn("Rejected webhook with invalid signature", "remote", r.RemoteAddr)
+		http.Error(w, "invalid signature", http.StatusUnauthorized)
+		return
+	}
+	switch r.Header.Get("X-GitHub-Event") {
+	case "ping":
+		w.WriteHeader(http.StatusNoContent)
+		return
+	case "push":
+	default:
+		http.Error(w, "unsupported event", http.StatusBadRequest)
+		return
+	}
+	var event pushEvent
+	err = json.Unmarshal(body, &event)
+	if err != nil || event.Repository.FullName == "" || strings.Contains(event.Repository.FullName, "..") {
+		http.Error(w, "bad payload", http.StatusBadRequest)
+		return
+	}
+	mirrorer := h.Mirrorer()
+	// Only repos mirrored already are updated, new ones wait for a run.
+	if _, ok := mirrorer.FindLocal(event.Repository.FullName); !ok {
+		http.Error(w, "repo not mirrored", http.StatusNotFound)
+		return
+	}
+	w.WriteHeader(http.StatusAccepted)
+	go func() {
+		logger := mirrorer.Logger.With("repo", event.Repository.FullName, "operation", "webhook")
+		source, repo, err := mirrorer.Lookup(event.Repository.FullName)
+		if err != nil {
+			logger.Error("Failed to look up repo", "error", err)
+			return
+		}
+		_, err = mirrorer.Update(source, repo)
+		if err != nil {
+			logger.Error("Failed update", "error", err)
+		}
+	}()
+}
+
+func verifySignature(secret, signature string, body []byte) bool {
+	if secret == "" {
+		return false
+	}
+	sig, found := strings.CutPrefix(signature, "sha256=")
+	if !found {
+		return false
+	}
+	got, err := hex.DecodeString(sig)
+	if err != nil {
+		return false
+	}
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return hmac.Equal(got, mac.Sum(nil))
+}
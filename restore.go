@@ -0,0 +1,195 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"text/template"
+	"time"
+
+	"github.com/chamzzzzzz/github-repo-mirror/pkg/config"
+	"github.com/chamzzzzzz/github-repo-mirror/pkg/github"
+	"github.com/chamzzzzzz/github-repo-mirror/pkg/gitmirror"
+)
+
+// runRestore pushes local mirrors to a new origin, given by a target
+// organization, a URL template or a mapping of repo names to URLs.
+func runRestore(args []string) int {
+	fs, g := newFlagSet("restore")
+	org := fs.String("org", "", "push every mirror to the GitHub organization or user with this name")
+	urlTemplate := fs.String("url-template", "", "push every mirror to the URL of this template, e.g. https://git.example.com/{{.Owner}}/{{.Name}}.git")
+	mappingFile := fs.String("mapping", "", "JSON file mapping repo names like owner/repo to push URLs; repos missing from it are skipped")
+	create := fs.Bool("create", false, "create the repos in the -org organization before pushing")
+	token := fs.String("token", "", "token to push to https URLs and to create repos, defaults to $GITHUB_TOKEN")
+	allRefs := fs.Bool("all-refs", false, "push all refs with --mirror instead of branches and tags")
+	rate := fs.Int("rate", 0, "push at most this many repos per hour, 0 for no limit")
+	logFile := fs.String("log", "", "restore log recording the pushed repos, defaults to restore.json in the destination")
+	dryRun := fs.Bool("dry-run", false, "only list the mirrors and where they would be pushed")
+	_, mirrorer := setup(fs, g, args)
+	// Read after parsing, so usage messages do not print the token.
+	if *token == "" {
+		*token = os.Getenv("GITHUB_TOKEN")
+	}
+
+	var target func(name string) (string, bool)
+	switch {
+	case *mappingFile != "":
+		b, err := os.ReadFile(*mappingFile)
+		if err != nil {
+			fatal("Failed to read mapping", "error", err)
+		}
+		var mapping map[string]string
+		err = json.Unmarshal(b, &mapping)
+		if err != nil {
+			fatal("Failed to parse mapping", "error", err)
+		}
+		target = func(name string) (string, bool) {
+			url, ok := mapping[name]
+			return url, ok
+		}
+	case *org != "" || *urlTemplate != "":
+		text := *urlTemplate
+		if text == "" {
+			text = "https://github.com/" + *org + "/{{.Name}}.git"
+		}
+		t, err := template.New("url").Option("missingkey=error").Parse(text)
+		if err != nil {
+			fatal("Failed to parse URL template", "error", err)
+		}
+		target = func(name string) (string, bool) {
+			owner, repo, _ := strings.Cut(name, "/")
+			var b strings.Builder
+			err := t.Execute(&b, &gitmirror.PathData{Host: "github.com", Owner: owner, Name: repo, FullName: name})
+			return b.String(), err == nil
+		}
+	default:
+		fatal("One of -org, -url-template or -mapping is required")
+	}
+	if *create && *org == "" {
+		fatal("-create requires -org")
+	}
+
+	locals, err := mirrorer.LocalMirrors()
+	if err != nil {
+		fatal("Failed to scan destination", "error", err)
+	}
+	if *logFile == "" {
+		*logFile = mirrorer.RestoreLogPath()
+	}
+	restoreLog, err := gitmirror.OpenRestoreLog(*logFile)
+	if err != nil {
+		fatal("Failed to open restore log", "error", err)
+	}
+
+	type push struct {
+		name, local, url, digest string
+	}
+	var pushes []*push
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "REPO\tACTION\tLOCAL\tURL")
+	for _, local := range locals {
+		name := mirrorer.RepoName(local)
+		url, ok := target(name)
+		if !ok {
+			fmt.Fprintf(w, "%s\tskip\t%s\t\n", name, local)
+			continue
+		}
+		digest, err := mirrorer.RefsDigest(local)
+		if err != nil {
+			slog.Error("Failed to read refs", "local", local, "error", err)
+			continue
+		}
+		if restoreLog.Done(name, url, digest) {
+			fmt.Fprintf(w, "%s\tdone\t%s\t%s\n", name, local, url)
+			continue
+		}
+		fmt.Fprintf(w, "%s\tpush\t%s\t%s\n", name, local, url)
+		pushes = append(pushes, &push{name, local, url, digest})
+	}
+	w.Flush()
+	if *dryRun {
+		return ExitOK
+	}
+	// Pushes are forced, so they overwrite the refs the targets have.
+	targets := make([]string, len(pushes))
+	for i, p := range pushes {
+		targets[i] = p.name + " -> " + p.url
+	}
+	if !confirmDestructive(mirrorer.Config, "restore", fmt.Sprintf("force-push %d mirrors, overwriting the refs of their targets", len(pushes)), targets) {
+		return ExitError
+	}
+
+	var budget *github.Budget
+	if *rate > 0 {
+		budget = github.NewBudget(*rate, time.Hour)
+	}
+	source := &config.Source{Username: *org, Token: *token}
+	auditLog := openAudit(mirrorer.Config)
+	code := ExitOK
+	for _, p := range pushes {
+		budget.Wait()
+		logger := slog.With("repo", p.name, "operation", "restore", "local", p.local, "url", p.url)
+		logger.Info("Restoring")
+		start := time.Now()
+		digest, err := restore(mirrorer, source, p.name, p.local, p.url, *create, *allRefs)
+		appendAudit(auditLog, "restore", p.name, p.local, start, err)
+		entry := &gitmirror.RestoreEntry{
+			URL:        p.url,
+			RefsDigest: digest,
+			Time:       time.Now(),
+		}
+		if err != nil {
+			logger.Error("Failed restore", "error", err)
+			entry.Error = err.Error()
+			code = ExitPartial
+		} else {
+			logger.Info("Successfully restore", "duration", time.Since(start))
+		}
+		err = restoreLog.Record(p.name, entry)
+		if err != nil {
+			fatal("Failed to write restore log", "error", err)
+		}
+	}
+	return code
+}
+
+// restore pushes the mirror at local to url, creating the repo first if
+// create is set, and returns the digest of the refs it pushed. A run or a
+// webhook update may be syncing the mirror, so it holds the repo lock.
+func restore(mirrorer *gitmirror.Mirrorer, source *config.Source, name, local, url string, create, allRefs bool) (string, error) {
+	unlock, err := mirrorer.LockRepo(local)
+	if err != nil {
+		return "", fmt.Errorf("lock error:'%s'", err)
+	}
+	defer unlock()
+	// The mirror may have been updated since it was listed.
+	digest, err := mirrorer.RefsDigest(local)
+	if err != nil {
+		return "", fmt.Errorf("refs error:'%s'", err)
+	}
+	if create {
+		var description string
+		private := true
+		metadata, err := gitmirror.ReadMetadata(local)
+		if err == nil {
+			description, private = metadata.Description, metadata.Private
+		}
+		_, repo, _ := strings.Cut(name, "/")
+		err = mirrorer.Client.CreateRepo(source, source.Username, repo, description, private)
+		if err != nil {
+			return "", fmt.Errorf("create error:'%s'", err)
+		}
+	}
+	var token string
+	if strings.HasPrefix(url, "https://") && !strings.Contains(url, "@") {
+		token = source.Token
+	}
+	err = mirrorer.Restore(local, url, token, allRefs)
+	if err != nil {
+		return "", fmt.Errorf("push error:'%s'", err)
+	}
+	return digest, nil
+}
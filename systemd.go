@@ -0,0 +1,61 @@
+package main
+
+import (
+	"log/slog"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/chamzzzzzz/github-repo-mirror/pkg/dashboard"
+)
+
+// sdNotify sends state to systemd's notification socket, for units with
+// Type=notify. It does nothing outside of such a unit.
+func sdNotify(state string) {
+	socket := os.Getenv("NOTIFY_SOCKET")
+	if socket == "" {
+		return
+	}
+	if strings.HasPrefix(socket, "@") {
+		// Abstract namespace socket.
+		socket = "\x00" + socket[1:]
+	}
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: socket, Net: "unixgram"})
+	if err != nil {
+		slog.Warn("Failed to notify systemd", "error", err)
+		return
+	}
+	defer conn.Close()
+	_, err = conn.Write([]byte(state))
+	if err != nil {
+		slog.Warn("Failed to notify systemd", "error", err)
+	}
+}
+
+// sdWatchdog pings systemd's watchdog at half its WatchdogSec while the run
+// loop is alive, so systemd restarts a daemon whose runs stopped.
+func sdWatchdog(health *dashboard.Health) {
+	usec, err := strconv.ParseInt(os.Getenv("WATCHDOG_USEC"), 10, 64)
+	if err != nil || usec <= 0 {
+		return
+	}
+	if pid := os.Getenv("WATCHDOG_PID"); pid != "" && pid != strconv.Itoa(os.Getpid()) {
+		return
+	}
+	for range time.Tick(time.Duration(usec) * time.Microsecond / 2) {
+		if health.Status().Alive {
+			sdNotify("WATCHDOG=1")
+		}
+	}
+}
+
+// healthText is the systemd STATUS of the daemon after a run.
+func healthText(status *dashboard.HealthStatus) string {
+	text := "STATUS=Last run " + status.LastRun.Format(time.RFC3339)
+	if status.LastError != "" {
+		return text + " failed: " + status.LastError
+	}
+	return text + ", " + strconv.Itoa(status.Failed) + " failures, " + strconv.Itoa(len(status.Stale)) + " stale"
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/chamzzzzzz/github-repo-mirror/pkg/config"
+	"github.com/chamzzzzzz/github-repo-mirror/pkg/gitmirror"
+)
+
+// runValidate checks the config, the sources' tokens and that the
+// destinations are writable, without mirroring anything.
+func runValidate(args []string) int {
+	fs, g := newFlagSet("validate")
+	config, mirrorer := setup(fs, g, args)
+
+	code := ExitOK
+	_, err := parseIntervals(config)
+	if err == nil {
+		_, err = parseMaxStaleness(config)
+	}
+	if summary := config.Notifications.Summary; err == nil && summary != nil && summary.Digest != "" {
+		_, err = time.ParseDuration(summary.Digest)
+		if err != nil {
+			err = fmt.Errorf("parse summary digest: %w", err)
+		}
+	}
+	if err != nil {
+		slog.Error("Invalid config", "error", err)
+		code = ExitError
+	}
+	if !checkTokens(config, mirrorer) {
+		code = ExitError
+	}
+	for _, destination := range mirrorer.Destinations() {
+		err := checkWritable(destination)
+		if err != nil {
+			slog.Error("Destination not writable", "destination", destination, "error", err)
+			code = ExitError
+			continue
+		}
+		slog.Info("Destination writable", "destination", destination)
+	}
+	if code == ExitOK {
+		slog.Info("Config valid")
+	}
+	return code
+}
+
+// checkTokens checks that every token of the sources is valid and can read
+// the source's repos, logging what each token is missing. Only the tokens of
+// the mirrorer's OnlySource are checked if it has one.
+func checkTokens(config *config.Config, mirrorer *gitmirror.Mirrorer) bool {
+	ok := true
+	for _, source := range config.Sources {
+		if mirrorer.OnlySource != "" && !strings.EqualFold(source.Label(), mirrorer.OnlySource) {
+			continue
+		}
+		tokens := append([]string{source.Token}, source.Tokens...)
+		for _, token := range tokens {
+			if token == "" {
+				continue
+			}
+			// Check each token on its own, not the source's rotation.
+			single := *source
+			single.Token, single.Tokens = token, nil
+			logger := slog.With("source", source.Label(), "token", redactToken(token))
+			if source.Type == gitmirror.SourceAzureDevOps {
+				login, err := mirrorer.Azure.User(&single)
+				if err != nil {
+					logger.Error("Invalid token", "error", err)
+					ok = false
+					continue
+				}
+				logger.Info("Valid token", "login", login)
+				continue
+			}
+			preflight, err := mirrorer.Client.CheckToken(&single)
+			if err != nil {
+				logger.Error("Invalid token", "error", err)
+				ok = false
+				continue
+			}
+			for _, warning := range preflight.Warnings {
+				logger.Warn("Limited token", "login", preflight.Login, "warning", warning)
+			}
+			logger.Info("Valid token", "login", preflight.Login, "kind", preflight.Kind, "scopes", preflight.Scopes)
+		}
+	}
+	return ok
+}
+
+// checkWritable creates and removes a file in dir, creating dir if needed.
+func checkWritable(dir string) error {
+	err := os.MkdirAll(dir, 0755)
+	if err != nil {
+		return err
+	}
+	f, err := os.CreateTemp(dir, ".validate-*")
+	if err != nil {
+		return err
+	}
+	f.Close()
+	return os.Remove(f.Name())
+}
+
+func redactToken(token string) string {
+	if len(token) <= 4 {
+		return "****"
+	}
+	return "..." + token[len(token)-4:]
+}